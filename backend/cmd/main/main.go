@@ -9,6 +9,7 @@ import (
 	"github.com/Valkqs/image-management-app/backend/internal/database"
 	"github.com/Valkqs/image-management-app/backend/internal/handler"
 	"github.com/Valkqs/image-management-app/backend/internal/middleware"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
 )
 
 func main() {
@@ -19,11 +20,48 @@ func main() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
+	// 可选：OIDC 单点登录（未设置 OIDC_ISSUER 时为 nil）
+	oidcProvider, err := service.NewOIDCProviderFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid OIDC configuration: %v", err)
+	}
+
+	// 可选：AI 服务（由 AI_PROVIDER 选择提供方；配置不完整时 AI 功能不可用，但不影响其他接口）
+	aiService, err := service.NewAIService()
+	if err != nil {
+		log.Printf("AI service is not available: %v", err)
+	} else {
+		log.Printf("AI provider: %s (vision model: %s)", aiService.ProviderName(), aiService.VisionModel())
+	}
+
+	// 可选：语义搜索的向量提供方（由 EMBEDDING_PROVIDER 选择，未配置时语义搜索不可用）
+	embedder, err := service.NewEmbeddingProviderFromEnv()
+	if err != nil {
+		log.Printf("Semantic search is not available: %v", err)
+	} else if embedder != nil {
+		log.Printf("Embedding provider: %s (model: %s)", embedder.Name(), embedder.Model())
+	}
+
 	// 2. 创建 Handler 实例，并注入数据库连接
-	h := &handler.Handler{DB: db}
+	h := &handler.Handler{DB: db, OIDC: oidcProvider, AI: aiService, Scheduler: service.NewAISchedulerFromEnv()}
+	if embedder != nil {
+		h.Embedder = embedder
+		h.Vectors = h.NewImageVectorIndex()
+	}
+	if aiService != nil {
+		aiService.Prompts().Prepend(h.NewPromptStore()) // 数据库中启用的提示词模板优先于文件和内置模板
+		aiService.SetUsageTracker(h.NewUsageTracker())  // 记录每次调用的用量并检查预算
+	}
+	h.MCP = h.NewMCPServer()
+	h.RecoverAnalysisBatches() // 上次运行中断的批量分析任务标记为失败
+	h.RecoverModeration()      // 上次运行中断时仍在等待审核的图片重新审核
 
 	// 3. 初始化 Gin 引擎
 	r := gin.Default()
+	// 只信任 TRUSTED_PROXIES 中的反向代理转发的客户端 IP，否则限流键可以通过 X-Forwarded-For 伪造
+	if err := r.SetTrustedProxies(middleware.TrustedProxiesFromEnv()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	// 4. 配置 CORS 中间件 (非常重要!)
 	// 允许所有来源的跨域请求，这在前后端分离开发中是必需的
@@ -47,14 +85,8 @@ func main() {
 	r.Use(cors.New(config))
 	
 	// 静态文件服务 - 使用自定义路由确保CORS头（必须在API路由之前）
-	r.GET("/uploads/*filepath", func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept")
-		// c.Param("filepath") 返回的是 /images/xxx.jpg，需要加上 uploads 前缀
-		filepath := c.Param("filepath")
-		c.File("./uploads" + filepath)
-	})
+	// 被内容审核隔离或屏蔽的图片不能通过公开链接访问
+	r.GET("/uploads/*filepath", h.ServeUpload)
 	r.OPTIONS("/uploads/*filepath", func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, OPTIONS")
@@ -62,42 +94,131 @@ func main() {
 		c.Status(204)
 	})
 
+	// 认证接口限流：按 IP 和按账号分别维护令牌桶，防止暴力破解和 bcrypt 计算被滥用
+	registerIPLimiter := middleware.NewLimiterFromEnv("register_ip", 5.0/3600, 5) // 每个 IP 每小时 5 次
+	loginIPLimiter := middleware.NewLimiterFromEnv("login_ip", 20.0/60, 20)        // 每个 IP 每分钟 20 次
+	loginAccountLimiter := middleware.NewLimiterFromEnv("login_account", 5.0/60, 5) // 每个账号每分钟 5 次
+
 	// 5. 设置路由组
 	api := r.Group("/api/v1")
 	{
 		// 公开路由 (无需认证)
-		api.POST("/users/register", h.Register)
-		api.POST("/users/login", h.Login)
+		api.POST("/users/register",
+			middleware.RateLimit(registerIPLimiter, middleware.ClientIPKey),
+			h.Register)
+		api.POST("/users/login",
+			middleware.RateLimit(loginIPLimiter, middleware.ClientIPKey),
+			middleware.RateLimit(loginAccountLimiter, middleware.JSONFieldKey("email")),
+			h.Login)
+		// OIDC 单点登录
+		api.GET("/auth/oidc/config", h.OIDCConfig)
+		api.GET("/auth/oidc/login", middleware.RateLimit(loginIPLimiter, middleware.ClientIPKey), h.OIDCLogin)
+		api.GET("/auth/oidc/callback", h.OIDCCallback)
 
 		// 受保护的路由组
 		authorized := api.Group("/")
 		authorized.Use(middleware.AuthMiddleware()) // 应用JWT认证中间件
+		authorized.Use(middleware.ActiveUserMiddleware(db)) // 拒绝已删除或被禁用的账号
 		{
 			// 在这里定义所有需要登录才能访问的API
-			// 例如: 获取当前用户信息的示例
-			authorized.GET("/users/me", func(c *gin.Context) {
-				userID, _ := c.Get("userID")
-				c.JSON(200, gin.H{"message": "You are authorized", "user_id": userID})
-			})
+			// 个人资料与偏好设置
+			authorized.GET("/users/me", h.GetProfile)
+			authorized.PUT("/users/me", h.UpdateProfile)
+			authorized.POST("/auth/oidc/link", h.OIDCLink) // 将单点登录身份关联到当前账号，返回身份提供方的授权地址
+			authorized.GET("/users/me/preferences", h.GetPreferences)
+			authorized.PUT("/users/me/preferences", h.UpdatePreferences)
+			authorized.GET("/users/me/usage", h.GetMyUsage) // 存储用量和配额
+			authorized.GET("/users/me/ai-usage", h.GetMyAIUsage) // AI 调用用量报告和预算
             authorized.POST("/images", h.UploadImage)
             authorized.GET("/images", h.GetUserImages)
 			// 其他需要保护的路由，例如图片上传
 			// authorized.POST("/images", h.UploadImage) 
 			// 批量操作路由必须在单个资源路由之前
 			authorized.POST("/images/batch/delete", h.DeleteImagesBatch) // 批量删除图片
+			authorized.POST("/images/analyze", h.StartBatchAnalysis) // 批量（重新）分析，按并发和速率限制在后台执行
+			authorized.GET("/images/analyze/batches", h.ListBatchAnalyses)
+			authorized.GET("/images/analyze/batches/:id", h.GetBatchAnalysis) // 进度和失败原因
+			authorized.DELETE("/images/analyze/batches/:id", h.CancelBatchAnalysis)
+			authorized.POST("/images/colors/reindex", h.ReindexColors) // 为已有图片提取主色调
+			authorized.POST("/images/tags/bulk", h.BulkUpdateTags) // 在一个事务中为多张图片添加和移除多个标签
 			// 单个资源路由
 			authorized.POST("/images/:id/tags", h.AddTagToImage)
 			authorized.DELETE("/images/:id/tags/:tagID", h.RemoveTagFromImage)
+			authorized.GET("/images/:id/tags/recommended", h.GetTagRecommendations) // 根据标签共现、拍摄时间、地点和相机推荐标签
 			authorized.GET("/images/:id/file", h.GetImageFile) // 获取图片文件（用于编辑）
 			authorized.GET("/images/:id", h.GetImageByID)
+			authorized.PATCH("/images/:id", h.UpdateImageDetails) // 编辑描述和替代文本
 			authorized.DELETE("/images/:id", h.DeleteImage) // 删除单张图片
 			authorized.PUT("/images/:id/edit", h.EditImage) // 编辑图片
 			// AI 标签分析
 			authorized.POST("/images/:id/analyze", h.AnalyzeImage) // 手动触发 AI 分析
-			// 获取所有使用中的标签
+			// AI 标签建议审核队列（开启 aiReviewTags 偏好后 AI 标签先进入队列）
+			authorized.GET("/suggestions", h.ListSuggestions)
+			authorized.POST("/suggestions/review", h.ReviewSuggestions) // 按ID、标签或图片批量接受/拒绝
+			authorized.POST("/suggestions/:id/accept", h.AcceptSuggestion)
+			authorized.POST("/suggestions/:id/reject", h.RejectSuggestion)
+			// 受控词表：设置后 AI 标签必须映射到词表中的词条
+			authorized.GET("/vocabulary", h.GetVocabulary)
+			authorized.PUT("/vocabulary", h.ReplaceVocabulary)
+			// 获取所有使用中的标签（包含使用次数和最近使用时间）
 			authorized.GET("/tags", h.GetAllUsedTags)
+			authorized.GET("/tags/autocomplete", h.AutocompleteTags) // 按名称或同义词的前缀补全
+			authorized.DELETE("/tags/:id", h.DeleteTagEverywhere) // 从当前用户的所有图片上移除
+			authorized.POST("/tags/cooccurrence/rebuild", h.RebuildTagCooccurrence) // 重新计算标签推荐使用的共现统计
+			// 语义搜索
+			authorized.GET("/search/semantic", h.SemanticSearch)
+			authorized.POST("/search/semantic/reindex", h.ReindexEmbeddings) // 为已有图片计算向量
 			// MCP 大模型对话接口
 			authorized.POST("/mcp/query", h.MCPQuery) // 通过自然语言查询图片
+			// 多轮对话助手（消息以 Server-Sent Events 流式返回）
+			authorized.POST("/chat/sessions", h.CreateChatSession)
+			authorized.GET("/chat/sessions", h.ListChatSessions)
+			authorized.GET("/chat/sessions/:id", h.GetChatSession)
+			authorized.DELETE("/chat/sessions/:id", h.DeleteChatSession)
+			authorized.POST("/chat/sessions/:id/messages", h.SendChatMessage)
+			// AI 提议的批量操作：先生成计划和差异预览，确认后在事务中执行
+			authorized.POST("/actions/plan", h.ProposeActionPlan)
+			authorized.GET("/actions/plans/:id", h.GetActionPlan)
+			authorized.POST("/actions/plans/:id/confirm", h.ConfirmActionPlan)
+			authorized.DELETE("/actions/plans/:id", h.CancelActionPlan)
+			// 相册
+			authorized.GET("/albums", h.ListAlbums)
+			authorized.POST("/albums", h.CreateAlbum)
+			authorized.DELETE("/albums/:id", h.DeleteAlbum)
+			// MCP 服务端（streamable HTTP 传输），供大模型客户端以工具方式浏览和整理图片库
+			authorized.POST("/mcp", h.MCPServe)
+			authorized.GET("/mcp", h.MCPServe)
+
+			// 管理员接口
+			admin := authorized.Group("/admin")
+			admin.Use(middleware.AdminMiddleware())
+			{
+				admin.GET("/users", h.AdminListUsers)
+				admin.PATCH("/users/:id", h.AdminUpdateUser) // 禁用/启用用户、修改角色
+				admin.DELETE("/users/:id", h.AdminDeleteUser)
+				admin.GET("/users/:id/usage", h.AdminGetUserUsage)
+				admin.PUT("/users/:id/quota", h.AdminSetUserQuota) // 设置配额覆盖值
+				admin.PUT("/users/:id/ai-budget", h.AdminSetUserAIBudget) // 设置 AI token 预算覆盖值
+				admin.GET("/ai-usage", h.AdminGetAIUsage)
+				admin.POST("/images/:id/reanalyze", h.AdminReanalyzeImage)
+				admin.GET("/ai-jobs", h.AdminListAIJobs) // 默认返回失败的 AI 任务
+				admin.GET("/ai/health", h.AdminAIHealth) // 提供方熔断状态和降级链
+				admin.GET("/moderation", h.AdminListModeration) // 默认返回待复核的图片
+				admin.POST("/moderation/:id/approve", h.AdminApproveImage)
+				admin.POST("/moderation/:id/block", h.AdminBlockImage)
+				// 标签层级、同义词、重命名和合并（标签在所有用户之间共享）
+				admin.GET("/tags/:id", h.AdminGetTag)
+				admin.PATCH("/tags/:id", h.AdminRenameTag) // 旧名称保留为同义词
+				admin.PUT("/tags/:id/parent", h.AdminSetTagParent)
+				admin.POST("/tags/:id/aliases", h.AdminAddTagAlias)
+				admin.DELETE("/tags/:id/aliases/:aliasID", h.AdminDeleteTagAlias)
+				admin.POST("/tags/:id/merge", h.AdminMergeTags) // 将 sourceIDs 合并到该标签
+				// 提示词模板：每次保存为新版本，启用某个版本即可切换或回滚
+				admin.GET("/prompts", h.AdminListPrompts)
+				admin.POST("/prompts", h.AdminCreatePrompt)
+				admin.POST("/prompts/:id/activate", h.AdminActivatePrompt)
+				admin.POST("/prompts/:id/deactivate", h.AdminDeactivatePrompt)
+			}
 		}
 	}
 
@@ -0,0 +1,88 @@
+// mcp_server 以 stdio 传输运行图片库的 MCP 服务，供桌面端大模型客户端（如 Claude Desktop、Cursor）使用
+// 客户端以子进程方式启动本程序，通过 stdin/stdout 交换 JSON-RPC 消息
+//
+// 用户身份通过登录接口返回的 JWT 认证（MCP_TOKEN 环境变量或 -token 参数），所有工具都以该用户的身份执行
+// 令牌过期或账号被禁用后，之后的请求都会以错误回复
+// 数据库、JWT_SECRET 和 AI 提供方的环境变量与后端服务相同
+//
+// 客户端配置示例：
+//
+//	{
+//	  "mcpServers": {
+//	    "images": {
+//	      "command": "/path/to/mcp_server",
+//	      "env": {"MCP_TOKEN": "<token>", "DB_PASSWORD": "...", "JWT_SECRET": "..."}
+//	    }
+//	  }
+//	}
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm/logger"
+	"github.com/Valkqs/image-management-app/backend/internal/database"
+	"github.com/Valkqs/image-management-app/backend/internal/handler"
+	"github.com/Valkqs/image-management-app/backend/internal/mcp"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+	"github.com/Valkqs/image-management-app/backend/internal/utils"
+)
+
+func main() {
+	token := flag.String("token", os.Getenv("MCP_TOKEN"), "JWT returned by /api/v1/users/login (defaults to $MCP_TOKEN)")
+	flag.Parse()
+
+	// stdout 只能输出协议消息，日志统一写到 stderr
+	log.SetOutput(os.Stderr)
+	protocolOut := os.Stdout
+	os.Stdout = os.Stderr
+
+	if *token == "" {
+		log.Fatal("MCP_TOKEN is required: log in via /api/v1/users/login and pass the returned token")
+	}
+	if _, err := utils.ParseJWT(*token); err != nil {
+		log.Fatalf("Invalid MCP_TOKEN: %v", err)
+	}
+
+	db, err := database.InitDB()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	db.Logger = logger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), logger.Config{
+		SlowThreshold: 200 * time.Millisecond,
+		LogLevel:      logger.Warn,
+	})
+
+	aiService, err := service.NewAIService()
+	if err != nil {
+		log.Printf("AI service is not available, analyze_image will fail: %v", err)
+	}
+
+	h := &handler.Handler{DB: db, AI: aiService}
+	if aiService != nil {
+		aiService.Prompts().Prepend(h.NewPromptStore())
+		aiService.SetUsageTracker(h.NewUsageTracker())
+	}
+	server := h.NewMCPServer()
+
+	// 令牌的有效期和账号状态在每条消息处理前重新检查，启动时先检查一次以便尽早报错
+	authenticate := h.MCPTokenAuthenticator(*token)
+	userID, err := authenticate(context.Background())
+	if err != nil {
+		log.Fatalf("Invalid MCP_TOKEN: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("MCP stdio server ready for user %d", userID)
+	if err := mcp.ServeStdio(ctx, server, authenticate, os.Stdin, protocolOut); err != nil {
+		log.Fatalf("MCP server stopped: %v", err)
+	}
+}
@@ -23,7 +23,17 @@ CREATE TABLE IF NOT EXISTS `users` (
     `deleted_at` DATETIME(3) NULL DEFAULT NULL COMMENT '删除时间（软删除）',
     `username` VARCHAR(255) NOT NULL COMMENT '用户名',
     `email` VARCHAR(255) NOT NULL COMMENT '邮箱地址',
+    `email_verified` TINYINT(1) NOT NULL DEFAULT 0 COMMENT '邮箱是否经过验证（身份提供方验证过的邮箱），修改邮箱后重置',
     `password` VARCHAR(255) NOT NULL COMMENT '密码（哈希值）',
+    `avatar_image_id` BIGINT UNSIGNED NULL DEFAULT NULL COMMENT '头像图片ID',
+    `role` VARCHAR(20) NOT NULL DEFAULT 'user' COMMENT '角色：user（普通用户）或 admin（管理员）',
+    `disabled` TINYINT(1) NOT NULL DEFAULT 0 COMMENT '是否被管理员禁用',
+    `storage_quota_bytes` BIGINT NULL DEFAULT NULL COMMENT '存储配额覆盖值（字节），为空时使用默认配额',
+    `image_quota` BIGINT NULL DEFAULT NULL COMMENT '图片数量配额覆盖值，为空时使用默认配额',
+    `failed_login_attempts` BIGINT NOT NULL DEFAULT 0 COMMENT '连续登录失败次数',
+    `locked_until` DATETIME(3) NULL DEFAULT NULL COMMENT '账号锁定截止时间',
+    `ai_daily_token_budget` BIGINT NULL DEFAULT NULL COMMENT '每日 AI token 预算覆盖值，为空时使用默认预算，0 表示不限制',
+    `ai_monthly_token_budget` BIGINT NULL DEFAULT NULL COMMENT '每月 AI token 预算覆盖值，为空时使用默认预算，0 表示不限制',
     PRIMARY KEY (`id`),
     UNIQUE KEY `idx_users_username` (`username`),
     UNIQUE KEY `idx_users_email` (`email`),
@@ -41,18 +51,32 @@ CREATE TABLE IF NOT EXISTS `images` (
     `filename` VARCHAR(255) NOT NULL COMMENT '文件名',
     `file_path` VARCHAR(255) NOT NULL COMMENT '文件路径',
     `thumbnail_path` VARCHAR(255) NOT NULL COMMENT '缩略图路径',
+    `file_size` BIGINT NOT NULL DEFAULT 0 COMMENT '原始文件大小（字节）',
     `user_id` BIGINT UNSIGNED NOT NULL COMMENT '用户ID（外键）',
     `camera_make` VARCHAR(100) NULL DEFAULT NULL COMMENT '相机制造商',
     `camera_model` VARCHAR(100) NULL DEFAULT NULL COMMENT '相机型号',
     `resolution` VARCHAR(50) NULL DEFAULT NULL COMMENT '分辨率',
+    `width` INT NOT NULL DEFAULT 0 COMMENT '宽度（像素），用于按方向和尺寸筛选',
+    `height` INT NOT NULL DEFAULT 0 COMMENT '高度（像素）',
     `taken_at` DATETIME(3) NULL DEFAULT NULL COMMENT '拍摄时间',
     `latitude` DOUBLE NULL DEFAULT NULL COMMENT '纬度',
     `longitude` DOUBLE NULL DEFAULT NULL COMMENT '经度',
+    `description` TEXT NULL COMMENT '图片的自然语言描述',
+    `alt_text` VARCHAR(500) NULL DEFAULT NULL COMMENT '无障碍替代文本',
+    `description_source` VARCHAR(20) NULL DEFAULT NULL COMMENT '描述来源：ai 或 user（用户编辑过的描述不会被 AI 覆盖）',
+    `ocr_text` TEXT NULL COMMENT '图片中识别出的文字（OCR）',
+    `album_id` BIGINT UNSIGNED NULL DEFAULT NULL COMMENT '所属相册ID（可为空）',
+    `monochrome` TINYINT(1) NOT NULL DEFAULT 0 COMMENT '是否基本是黑白（灰度）的图片',
+    `moderation_status` VARCHAR(20) NOT NULL DEFAULT '' COMMENT '内容审核状态：queued、clean、flagged、quarantined、approved 或 blocked（未审核为空）',
     PRIMARY KEY (`id`),
     KEY `idx_images_user_id` (`user_id`),
     KEY `idx_images_deleted_at` (`deleted_at`),
     KEY `idx_images_taken_at` (`taken_at`),
     KEY `idx_images_camera_make` (`camera_make`),
+    KEY `idx_images_album_id` (`album_id`),
+    KEY `idx_images_monochrome` (`monochrome`),
+    KEY `idx_images_moderation_status` (`moderation_status`),
+    FULLTEXT KEY `idx_images_fulltext` (`filename`, `description`, `alt_text`, `ocr_text`) WITH PARSER ngram,
     CONSTRAINT `fk_images_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='图片表';
 
@@ -66,10 +90,13 @@ CREATE TABLE IF NOT EXISTS `tags` (
     `deleted_at` DATETIME(3) NULL DEFAULT NULL COMMENT '删除时间（软删除）',
     `name` VARCHAR(100) NOT NULL COMMENT '标签名称',
     `source` VARCHAR(20) NOT NULL DEFAULT 'user' COMMENT '标签来源：user（用户）或 ai（AI生成）',
+    `category` VARCHAR(20) DEFAULT NULL COMMENT 'AI 给出的标签类别：scene、object、person、animal、activity、style、mood、color、other',
+    `parent_id` BIGINT UNSIGNED NULL DEFAULT NULL COMMENT '父标签ID；按父标签筛选时同时匹配所有子标签',
     PRIMARY KEY (`id`),
     UNIQUE KEY `idx_tags_name` (`name`),
     KEY `idx_tags_deleted_at` (`deleted_at`),
-    KEY `idx_tags_source` (`source`)
+    KEY `idx_tags_source` (`source`),
+    KEY `idx_tags_parent_id` (`parent_id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='标签表';
 
 -- ============================================
@@ -78,12 +105,325 @@ CREATE TABLE IF NOT EXISTS `tags` (
 CREATE TABLE IF NOT EXISTS `image_tags` (
     `image_id` BIGINT UNSIGNED NOT NULL COMMENT '图片ID（外键）',
     `tag_id` BIGINT UNSIGNED NOT NULL COMMENT '标签ID（外键）',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '关联时间，用于统计标签最近的使用时间（旧关联为空）',
     PRIMARY KEY (`image_id`, `tag_id`),
     KEY `idx_image_tags_tag_id` (`tag_id`),
     CONSTRAINT `fk_image_tags_image` FOREIGN KEY (`image_id`) REFERENCES `images` (`id`) ON DELETE CASCADE ON UPDATE CASCADE,
     CONSTRAINT `fk_image_tags_tag` FOREIGN KEY (`tag_id`) REFERENCES `tags` (`id`) ON DELETE CASCADE ON UPDATE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='图片标签关联表';
 
+-- ============================================
+-- 5. AI 分析任务表 (ai_jobs)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `ai_jobs` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '任务ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `deleted_at` DATETIME(3) NULL DEFAULT NULL COMMENT '删除时间（软删除）',
+    `image_id` BIGINT UNSIGNED NOT NULL COMMENT '图片ID',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '图片所属用户ID',
+    `trigger` VARCHAR(20) NULL DEFAULT NULL COMMENT '触发来源：manual、upload、edit、admin、mcp、plan、batch',
+    `batch_id` BIGINT UNSIGNED NULL DEFAULT NULL COMMENT '所属批量分析任务ID，不属于批量任务时为 0',
+    `vision_model` VARCHAR(100) NULL DEFAULT NULL COMMENT '使用的视觉模型',
+    `prompt_version` VARCHAR(100) NULL DEFAULT NULL COMMENT '使用的提示词模板，例如 tag/zh@db-v3',
+    `status` VARCHAR(20) NOT NULL COMMENT '状态：running、succeeded、failed',
+    `error` TEXT NULL COMMENT '失败时的错误信息',
+    `tag_count` BIGINT NULL DEFAULT NULL COMMENT '分析得到的标签数量',
+    `finished_at` DATETIME(3) NULL DEFAULT NULL COMMENT '完成时间',
+    PRIMARY KEY (`id`),
+    KEY `idx_ai_jobs_image_id` (`image_id`),
+    KEY `idx_ai_jobs_user_id` (`user_id`),
+    KEY `idx_ai_jobs_status` (`status`),
+    KEY `idx_ai_jobs_batch_id` (`batch_id`),
+    KEY `idx_ai_jobs_vision_model` (`vision_model`),
+    KEY `idx_ai_jobs_deleted_at` (`deleted_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='AI 分析任务表';
+
+-- ============================================
+-- 6. 用户偏好表 (user_preferences)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `user_preferences` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '偏好ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `deleted_at` DATETIME(3) NULL DEFAULT NULL COMMENT '删除时间（软删除）',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '用户ID',
+    `auto_analyze` TINYINT(1) NOT NULL DEFAULT 0 COMMENT '上传后默认是否自动 AI 分析',
+    `ai_language` VARCHAR(10) NOT NULL DEFAULT 'zh' COMMENT 'AI 标签语言：zh 或 en',
+    `ai_describe` TINYINT(1) NOT NULL DEFAULT 0 COMMENT 'AI 分析时是否同时生成描述和替代文本',
+    `ai_review_tags` TINYINT(1) NOT NULL DEFAULT 0 COMMENT 'AI 标签是否先进入待审核队列',
+    `thumbnail_size` BIGINT NOT NULL DEFAULT 400 COMMENT '缩略图宽度（像素）',
+    `timezone` VARCHAR(64) NOT NULL DEFAULT 'Local' COMMENT 'IANA 时区名',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_user_preferences_user_id` (`user_id`),
+    KEY `idx_user_preferences_deleted_at` (`deleted_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='用户偏好表';
+
+-- ============================================
+-- 7. 外部身份关联表 (user_identities)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `user_identities` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '关联ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `deleted_at` DATETIME(3) NULL DEFAULT NULL COMMENT '删除时间（软删除）',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '本地用户ID',
+    `issuer` VARCHAR(255) NOT NULL COMMENT 'OIDC 身份提供方',
+    `subject` VARCHAR(255) NOT NULL COMMENT '身份提供方中的用户标识（sub）',
+    `email` VARCHAR(255) NULL DEFAULT NULL COMMENT '身份提供方返回的邮箱',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_identity_issuer_subject` (`issuer`, `subject`),
+    KEY `idx_user_identities_user_id` (`user_id`),
+    KEY `idx_user_identities_deleted_at` (`deleted_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='外部身份关联表';
+
+-- ============================================
+-- 8. 图片语义向量表 (image_embeddings)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `image_embeddings` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '向量ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `image_id` BIGINT UNSIGNED NOT NULL COMMENT '图片ID',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '图片所属用户ID',
+    `model` VARCHAR(100) NOT NULL COMMENT '计算向量使用的模型',
+    `kind` VARCHAR(10) NOT NULL COMMENT '向量类型：text（由标签、描述和识别出的文字计算）或 image（多模态模型直接计算）',
+    `dimensions` BIGINT NOT NULL COMMENT '向量维度',
+    `vector` BLOB NOT NULL COMMENT '向量（小端序 float32）',
+    `content_hash` VARCHAR(64) NOT NULL COMMENT '输入内容的哈希，内容未变化时跳过重新计算',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_image_embeddings_image_id` (`image_id`),
+    KEY `idx_image_embeddings_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='图片语义向量表';
+
+-- ============================================
+-- 9. 对话会话表 (chat_sessions)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `chat_sessions` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '会话ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '用户ID',
+    `title` VARCHAR(200) NULL DEFAULT NULL COMMENT '会话标题，默认取第一条消息的开头',
+    `last_condition` TEXT NULL COMMENT '最近一轮的查询条件（JSON）',
+    `last_image_ids` TEXT NULL COMMENT '最近一轮结果中的图片ID（JSON 数组）',
+    PRIMARY KEY (`id`),
+    KEY `idx_chat_sessions_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='对话会话表';
+
+-- ============================================
+-- 10. 对话消息表 (chat_messages)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `chat_messages` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '消息ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `session_id` BIGINT UNSIGNED NOT NULL COMMENT '会话ID',
+    `role` VARCHAR(20) NOT NULL COMMENT '角色：user 或 assistant',
+    `content` TEXT NULL COMMENT '消息内容',
+    `action` VARCHAR(20) NULL DEFAULT NULL COMMENT '助手消息的意图：search、refine、tag、answer',
+    `condition` TEXT NULL COMMENT '助手消息执行的查询条件（JSON）',
+    `image_ids` TEXT NULL COMMENT '助手消息返回或操作的图片ID（JSON 数组）',
+    `image_count` BIGINT NULL DEFAULT NULL COMMENT '图片数量',
+    PRIMARY KEY (`id`),
+    KEY `idx_chat_messages_session_id` (`session_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='对话消息表';
+
+-- ============================================
+-- 11. 相册表 (albums)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `albums` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '相册ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '用户ID',
+    `name` VARCHAR(100) NOT NULL COMMENT '相册名称',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_album_user_name` (`user_id`, `name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='相册表';
+
+-- ============================================
+-- 12. 批量操作计划表 (action_plans)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `action_plans` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '计划ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '用户ID',
+    `instruction` TEXT NULL COMMENT '用户的原始指令',
+    `actions` TEXT NULL COMMENT '计划执行的操作（JSON 数组）：add_tag、remove_tag、move_to_album、delete、reanalyze',
+    `image_ids` TEXT NULL COMMENT '操作的目标图片ID（JSON 数组）',
+    `status` VARCHAR(20) NOT NULL COMMENT '状态：pending、executed、cancelled、failed',
+    `reasoning` TEXT NULL COMMENT 'AI 对指令的理解',
+    `result` TEXT NULL COMMENT '执行结果（JSON）',
+    `error` TEXT NULL COMMENT '执行失败时的错误信息',
+    `expires_at` DATETIME(3) NULL DEFAULT NULL COMMENT '过期时间，过期后不能再确认',
+    `executed_at` DATETIME(3) NULL DEFAULT NULL COMMENT '执行时间',
+    PRIMARY KEY (`id`),
+    KEY `idx_action_plans_user_id` (`user_id`),
+    KEY `idx_action_plans_status` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='批量操作计划表';
+
+-- ============================================
+-- 13. AI 标签建议表 (tag_suggestions)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `tag_suggestions` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '建议ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `image_id` BIGINT UNSIGNED NOT NULL COMMENT '图片ID',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '用户ID',
+    `tag_name` VARCHAR(100) NOT NULL COMMENT '建议的标签名称',
+    `status` VARCHAR(20) NOT NULL COMMENT '状态：pending、accepted、rejected（被拒绝的标签作为之后分析的负反馈）',
+    `category` VARCHAR(20) DEFAULT NULL COMMENT 'AI 给出的标签类别',
+    `confidence` DOUBLE NOT NULL DEFAULT 0 COMMENT 'AI 给出的置信度（0-1）',
+    `job_id` BIGINT UNSIGNED NOT NULL DEFAULT 0 COMMENT '产生该建议的 AI 任务ID',
+    `reviewed_at` DATETIME(3) NULL DEFAULT NULL COMMENT '审核时间',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_suggestion_image_tag` (`image_id`, `tag_name`),
+    KEY `idx_tag_suggestions_user_id` (`user_id`),
+    KEY `idx_tag_suggestions_tag_name` (`tag_name`),
+    KEY `idx_tag_suggestions_status` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='AI 标签建议表';
+
+-- ============================================
+-- 14. 批量分析任务表 (analysis_batches)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `analysis_batches` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '任务ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '用户ID',
+    `filter` VARCHAR(20) NOT NULL COMMENT '图片筛选方式：ids、unanalyzed、outdated、all',
+    `vision_model` VARCHAR(100) NULL DEFAULT NULL COMMENT '创建任务时的视觉模型',
+    `status` VARCHAR(20) NOT NULL COMMENT '状态：running、completed、cancelled、failed',
+    `image_ids` TEXT NULL COMMENT '待分析的图片ID（JSON 数组）',
+    `total` BIGINT NULL DEFAULT NULL COMMENT '图片总数',
+    `processed` BIGINT NULL DEFAULT NULL COMMENT '已处理数量',
+    `succeeded` BIGINT NULL DEFAULT NULL COMMENT '分析成功数量',
+    `failed` BIGINT NULL DEFAULT NULL COMMENT '分析失败数量',
+    `error` TEXT NULL COMMENT '任务整体失败的原因',
+    `finished_at` DATETIME(3) NULL DEFAULT NULL COMMENT '结束时间',
+    PRIMARY KEY (`id`),
+    KEY `idx_analysis_batches_user_id` (`user_id`),
+    KEY `idx_analysis_batches_status` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='批量分析任务表';
+
+-- ============================================
+-- 15. 提示词模板表 (prompt_templates)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `prompt_templates` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '模板ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `name` VARCHAR(50) NOT NULL COMMENT '模板名称：tag、describe、query',
+    `language` VARCHAR(20) NOT NULL COMMENT '语言，例如 zh、en、ja',
+    `version` BIGINT NOT NULL COMMENT '版本号，同一名称和语言内递增',
+    `body` TEXT NOT NULL COMMENT '模板内容（Go text/template 语法）',
+    `active` TINYINT(1) NOT NULL DEFAULT 0 COMMENT '是否启用，同一名称和语言只有一个启用的版本',
+    `created_by` BIGINT UNSIGNED NULL DEFAULT NULL COMMENT '创建模板的管理员ID',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_prompt_version` (`name`, `language`, `version`),
+    KEY `idx_prompt_templates_active` (`active`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='提示词模板表';
+
+-- ============================================
+-- 16. 受控词表 (vocabulary_terms)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `vocabulary_terms` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '词条ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '用户ID',
+    `name` VARCHAR(100) NOT NULL COMMENT '词条名称（AI 标签映射后的名称）',
+    `aliases` TEXT NULL COMMENT '映射到该词条的别名（JSON 数组）',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_vocabulary_user_name` (`user_id`, `name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='受控词表';
+
+-- ============================================
+-- 17. AI 用量记录表 (ai_usages)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `ai_usages` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '记录ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '调用时间',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '发起调用的用户ID',
+    `operation` VARCHAR(20) NOT NULL COMMENT '操作：analyze、query、assistant 或 plan',
+    `provider` VARCHAR(50) NULL DEFAULT NULL COMMENT 'AI 提供方',
+    `model` VARCHAR(100) NULL DEFAULT NULL COMMENT '模型名称',
+    `prompt_version` VARCHAR(100) NULL DEFAULT NULL COMMENT '提示词模板版本',
+    `prompt_tokens` BIGINT NULL DEFAULT NULL COMMENT '输入 token 数',
+    `completion_tokens` BIGINT NULL DEFAULT NULL COMMENT '输出 token 数',
+    `total_tokens` BIGINT NULL DEFAULT NULL COMMENT '总 token 数',
+    `estimated` TINYINT(1) NOT NULL DEFAULT 0 COMMENT 'token 数是否为按文本长度估算',
+    `latency_ms` BIGINT NULL DEFAULT NULL COMMENT '调用耗时（毫秒）',
+    `outcome` VARCHAR(20) NOT NULL COMMENT '结果：success、error、cached 或 budget_exceeded',
+    `error` TEXT NULL COMMENT '错误信息',
+    PRIMARY KEY (`id`),
+    KEY `idx_ai_usages_created_at` (`created_at`),
+    KEY `idx_ai_usages_user_id` (`user_id`),
+    KEY `idx_ai_usages_outcome` (`outcome`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='AI 用量记录表';
+
+-- ============================================
+-- 18. 图片主色调表 (image_colors)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `image_colors` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '记录ID',
+    `image_id` BIGINT UNSIGNED NOT NULL COMMENT '图片ID',
+    `hex` VARCHAR(7) NOT NULL COMMENT '颜色（#rrggbb）',
+    `r` BIGINT NOT NULL COMMENT '红色分量（0-255），用于按颜色距离筛选',
+    `g` BIGINT NOT NULL COMMENT '绿色分量（0-255）',
+    `b` BIGINT NOT NULL COMMENT '蓝色分量（0-255）',
+    `percentage` DOUBLE NOT NULL COMMENT '占图片像素的比例（0-100）',
+    PRIMARY KEY (`id`),
+    KEY `idx_image_colors_image_id` (`image_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='图片主色调表（上传时用中位切分算法从缩略图中提取）';
+
+-- ============================================
+-- 19. 图片内容审核表 (image_moderations)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `image_moderations` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '记录ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `updated_at` DATETIME(3) NULL DEFAULT NULL COMMENT '更新时间',
+    `image_id` BIGINT UNSIGNED NOT NULL COMMENT '图片ID（每张图片只保留最近一次的审核结果）',
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '图片所有者ID',
+    `engine` VARCHAR(100) NULL DEFAULT NULL COMMENT '审核引擎，例如 vision:openai、http；管理员直接复核时为 manual',
+    `scores` TEXT NULL COMMENT '每个安全类别的得分（JSON 对象，0-1）',
+    `flagged` TEXT NULL COMMENT '得分达到阈值的类别（JSON 数组）',
+    `reviewed_by` BIGINT UNSIGNED NULL DEFAULT NULL COMMENT '复核的管理员ID',
+    `reviewed_at` DATETIME(3) NULL DEFAULT NULL COMMENT '复核时间',
+    `note` VARCHAR(500) NULL DEFAULT NULL COMMENT '管理员复核时的备注',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_image_moderations_image_id` (`image_id`),
+    KEY `idx_image_moderations_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='图片内容审核表';
+
+-- ============================================
+-- 20. 标签同义词表 (tag_aliases)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `tag_aliases` (
+    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT COMMENT '同义词ID',
+    `created_at` DATETIME(3) NULL DEFAULT NULL COMMENT '创建时间',
+    `name` VARCHAR(100) NOT NULL COMMENT '同义词（不能与标签名称相同）',
+    `tag_id` BIGINT UNSIGNED NOT NULL COMMENT '规范标签ID',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_tag_aliases_name` (`name`),
+    KEY `idx_tag_aliases_tag_id` (`tag_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='标签同义词表（添加标签、AI 标签和按标签筛选时解析为规范标签）';
+
+-- ============================================
+-- 21. 标签共现统计表 (tag_cooccurrences)
+-- ============================================
+CREATE TABLE IF NOT EXISTS `tag_cooccurrences` (
+    `user_id` BIGINT UNSIGNED NOT NULL COMMENT '用户ID',
+    `tag_id` BIGINT UNSIGNED NOT NULL COMMENT '标签ID',
+    `other_tag_id` BIGINT UNSIGNED NOT NULL COMMENT '同时出现的标签ID（与 tag_id 相同时为带有该标签的图片数量）',
+    `count` BIGINT NOT NULL DEFAULT 0 COMMENT '两个标签同时出现在该用户同一张图片上的次数',
+    PRIMARY KEY (`user_id`, `tag_id`, `other_tag_id`),
+    KEY `idx_tag_cooccurrences_other_tag_id` (`other_tag_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='标签共现统计表（添加和移除标签时增量更新，用于标签推荐）';
+
 -- ============================================
 -- 索引说明
 -- ============================================
@@ -97,15 +437,21 @@ CREATE TABLE IF NOT EXISTS `image_tags` (
 --   - idx_images_deleted_at: 软删除索引
 --   - idx_images_taken_at: 拍摄时间索引，用于按时间查询
 --   - idx_images_camera_make: 相机制造商索引，用于按相机查询
+--   - idx_images_fulltext: 全文索引（ngram 解析器，支持中文），用于按文件名、描述、替代文本和识别出的文字搜索
 --
 -- tags 表：
 --   - idx_tags_name: 标签名唯一索引，用于快速查找和唯一性约束
 --   - idx_tags_deleted_at: 软删除索引
 --   - idx_tags_source: 标签来源索引，用于区分用户标签和AI标签
+--   - idx_tags_parent_id: 父标签索引，用于展开子标签
 --
 -- image_tags 表：
 --   - 联合主键 (image_id, tag_id): 确保同一图片不会重复关联同一标签
 --   - idx_image_tags_tag_id: 标签ID索引，用于反向查找（通过标签找图片）
+--
+-- tag_cooccurrences 表：
+--   - 联合主键 (user_id, tag_id, other_tag_id): 每个用户的每对标签一条统计，增量更新时按主键累加
+--   - idx_tag_cooccurrences_other_tag_id: 删除标签时清理以该标签为 other_tag_id 的统计
 
 -- ============================================
 -- 外键约束说明
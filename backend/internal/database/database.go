@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -32,15 +33,97 @@ func InitDB() (*gorm.DB, error) {
 
 	log.Println("Database connection established.")
 
+	if err := Migrate(db); err != nil {
+		return nil, err
+	}
+	log.Println("Database migrated.")
+
+	promoteAdmins(db)
+	backfillImageSizes(db)
+	backfillFileSizes(db)
+
+	return db, nil
+}
+
+// Migrate 设置自定义关联表并自动迁移所有模型的表结构
+func Migrate(db *gorm.DB) error {
+	// image_tags 使用自定义的关联模型，通过关联添加标签时自动记录关联时间
+	if err := db.SetupJoinTable(&model.Image{}, "Tags", &model.ImageTag{}); err != nil {
+		return fmt.Errorf("failed to set up image_tags join table: %w", err)
+	}
+	if err := db.SetupJoinTable(&model.Tag{}, "Images", &model.ImageTag{}); err != nil {
+		return fmt.Errorf("failed to set up image_tags join table: %w", err)
+	}
+
 	// 自动迁移模式，GORM会自动创建或更新表结构
 	// 这对于开发非常方便
-	err = db.AutoMigrate(&model.User{}, &model.Image{}, &model.Tag{})
+	err := db.AutoMigrate(&model.User{}, &model.Image{}, &model.Tag{}, &model.AIJob{}, &model.UserPreference{}, &model.UserIdentity{}, &model.ImageEmbedding{}, &model.ChatSession{}, &model.ChatMessage{}, &model.Album{}, &model.ActionPlan{}, &model.TagSuggestion{}, &model.AnalysisBatch{}, &model.PromptTemplate{}, &model.VocabularyTerm{}, &model.AIUsage{}, &model.ImageColor{}, &model.ImageModeration{}, &model.TagAlias{}, &model.TagCooccurrence{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to auto migrate database: %w", err)
+		return fmt.Errorf("failed to auto migrate database: %w", err)
 	}
-	log.Println("Database migrated.")
+	return nil
+}
 
-	return db, nil
+// backfillImageSizes 为添加宽高字段之前上传的图片从分辨率字符串（宽度x高度）中补全宽高
+func backfillImageSizes(db *gorm.DB) {
+	result := db.Exec("UPDATE images SET width = CAST(SUBSTRING_INDEX(resolution, 'x', 1) AS UNSIGNED), " +
+		"height = CAST(SUBSTRING_INDEX(resolution, 'x', -1) AS UNSIGNED) " +
+		"WHERE width = 0 AND resolution LIKE '%x%'")
+	if result.Error != nil {
+		log.Printf("Failed to backfill image sizes: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Backfilled width and height for %d images", result.RowsAffected)
+	}
+}
+
+// backfillFileSizes 启动时为添加文件大小字段之前上传的图片补齐 file_size
+// 原始文件已不存在的图片标记为 FileSizeMissing，之后不再重复检查
+func backfillFileSizes(db *gorm.DB) {
+	var images []model.Image
+	if err := db.Model(&model.Image{}).Where("file_size = 0").Select("id", "file_path").Find(&images).Error; err != nil {
+		log.Printf("Failed to query images for file size backfill: %v", err)
+		return
+	}
+	missing := 0
+	for _, img := range images {
+		size := model.FileSizeMissing
+		if info, err := os.Stat(img.FilePath); err == nil {
+			size = info.Size()
+		} else {
+			missing++
+		}
+		db.Model(&model.Image{}).Where("id = ?", img.ID).Update("file_size", size)
+	}
+	if len(images) > 0 {
+		log.Printf("Backfilled file size for %d images (%d missing files)", len(images), missing)
+	}
+}
+
+// promoteAdmins 将 ADMIN_EMAILS（逗号分隔）中列出的已注册用户设置为管理员
+func promoteAdmins(db *gorm.DB) {
+	adminEmails := os.Getenv("ADMIN_EMAILS")
+	if adminEmails == "" {
+		return
+	}
+
+	emails := make([]string, 0)
+	for _, email := range strings.Split(adminEmails, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	if len(emails) == 0 {
+		return
+	}
+
+	result := db.Model(&model.User{}).Where("email IN ?", emails).Update("role", model.RoleAdmin)
+	if result.Error != nil {
+		log.Printf("Failed to promote admin users: %v", result.Error)
+		return
+	}
+	log.Printf("Promoted %d user(s) to admin from ADMIN_EMAILS.", result.RowsAffected)
 }
 
 // getEnv 获取环境变量，如果不存在则返回默认值
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+)
+
+func TestBackfillFileSizes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1) // 每个连接都是独立的内存数据库
+	// images 表的全文索引只支持 MySQL，这里只建立补齐用到的列
+	if err := db.Exec("CREATE TABLE images (id INTEGER PRIMARY KEY, file_path TEXT, file_size INTEGER NOT NULL DEFAULT 0, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	existing := filepath.Join(t.TempDir(), "a.jpg")
+	if err := os.WriteFile(existing, make([]byte, 42), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("INSERT INTO images (id, file_path, file_size) VALUES (1, ?, 0), (2, ?, 0), (3, ?, 7)",
+		existing, filepath.Join(t.TempDir(), "missing.jpg"), existing)
+
+	backfillFileSizes(db)
+
+	want := map[uint]int64{1: 42, 2: model.FileSizeMissing, 3: 7}
+	for id, size := range want {
+		var got int64
+		db.Raw("SELECT file_size FROM images WHERE id = ?", id).Scan(&got)
+		if got != size {
+			t.Errorf("image %d: file_size %d, want %d", id, got, size)
+		}
+	}
+
+	// 已标记的缺失文件不会被再次检查
+	var pending int64
+	db.Table("images").Where("file_size = 0").Count(&pending)
+	if pending != 0 {
+		t.Errorf("%d images still pending backfill", pending)
+	}
+}
@@ -0,0 +1,502 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+// 批量操作计划参数
+const (
+	maxPlanImages = 500              // 一个计划最多操作的图片数量
+	actionPlanTTL = 30 * time.Minute // 计划的有效期，过期后需要重新生成
+)
+
+// errPlanNotPending 计划已执行、已取消或已过期
+var errPlanNotPending = errors.New("plan is no longer pending")
+
+// ActionPlanRequest 生成操作计划的请求
+// 目标图片的优先级：imageIDs > sessionID（对话助手上一轮的结果）> 指令中描述的查询条件
+type ActionPlanRequest struct {
+	Instruction string                  `json:"instruction"`
+	Actions     []service.PlannedAction `json:"actions"`   // 直接指定操作时不调用 AI 解析
+	ImageIDs    []uint                  `json:"imageIDs"`  // 直接指定目标图片
+	SessionID   uint                    `json:"sessionID"` // 使用对话会话上一轮的结果作为目标图片
+}
+
+// ActionPreview 单张图片执行计划前后的差异
+type ActionPreview struct {
+	ImageID     uint     `json:"imageID"`
+	Filename    string   `json:"filename"`
+	TagsBefore  []string `json:"tagsBefore"`
+	TagsAfter   []string `json:"tagsAfter"`
+	AddedTags   []string `json:"addedTags"`
+	RemovedTags []string `json:"removedTags"`
+	AlbumBefore string   `json:"albumBefore"`
+	AlbumAfter  string   `json:"albumAfter"`
+	Delete      bool     `json:"delete"`
+	Reanalyze   bool     `json:"reanalyze"`
+	Changed     bool     `json:"changed"` // 计划是否会改变这张图片
+}
+
+// ActionSummary 每个操作会影响的图片数量
+type ActionSummary struct {
+	service.PlannedAction
+	Affected int `json:"affected"`
+}
+
+// ProposeActionPlan 根据自然语言指令生成批量操作计划和差异预览，计划需要调用确认接口后才会执行
+func (h *Handler) ProposeActionPlan(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var input ActionPlanRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.Instruction = strings.TrimSpace(input.Instruction)
+	if input.Instruction == "" && len(input.Actions) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "instruction or actions is required"})
+		return
+	}
+
+	// 1. 确定操作
+	var actions []service.PlannedAction
+	var target *service.QueryCondition
+	reasoning := ""
+	if len(input.Actions) > 0 {
+		normalized, err := service.NormalizeActions(input.Actions)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		actions = normalized
+	} else {
+		aiService, err := h.aiService()
+		if err != nil {
+			log.Printf("Failed to create AI service: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "AI service is not available. Please check the AI provider configuration (AI_PROVIDER).",
+			})
+			return
+		}
+
+		tags, _ := h.usedTags(userID)
+		tagNames := make([]string, len(tags))
+		for i, tag := range tags {
+			tagNames[i] = tag.Name
+		}
+		var albumNames []string
+		h.DB.Model(&model.Album{}).Where("user_id = ?", userID).Order("name ASC").Pluck("name", &albumNames)
+
+		proposal, err := aiService.ProposeActions(service.WithUserID(c.Request.Context(), userID), input.Instruction, tagNames, albumNames)
+		if err != nil {
+			log.Printf("Failed to propose actions: %v", err)
+			c.JSON(aiErrorStatus(err), gin.H{"error": "Failed to understand the instruction", "details": err.Error()})
+			return
+		}
+		actions = proposal.Actions
+		target = &proposal.Target
+		reasoning = proposal.Reasoning
+	}
+
+	// 2. 确定目标图片
+	var targetIDs []uint
+	switch {
+	case len(input.ImageIDs) > 0:
+		targetIDs = input.ImageIDs
+	case input.SessionID != 0:
+		var session model.ChatSession
+		if err := h.DB.Where("id = ? AND user_id = ?", input.SessionID, userID).First(&session).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat session not found"})
+			return
+		}
+		targetIDs = session.LastImageIDs
+	case target != nil:
+		if err := h.conditionQuery(userID, target).Limit(maxPlanImages+1).Pluck("images.id", &targetIDs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "imageIDs or sessionID is required when actions are given directly"})
+		return
+	}
+	if len(targetIDs) > maxPlanImages {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A plan can affect at most %d images, please narrow the selection", maxPlanImages)})
+		return
+	}
+
+	images, err := h.planImages(userID, targetIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+		return
+	}
+	if len(images) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No images match the instruction", "reasoning": reasoning})
+		return
+	}
+
+	// 3. 保存计划并返回预览
+	actionsJSON, _ := json.Marshal(actions)
+	plan := model.ActionPlan{
+		UserID:      userID,
+		Instruction: input.Instruction,
+		Actions:     actionsJSON,
+		ImageIDs:    imageIDs(images),
+		Status:      model.ActionPlanPending,
+		Reasoning:   reasoning,
+		ExpiresAt:   time.Now().Add(actionPlanTTL),
+	}
+	if err := h.DB.Create(&plan).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save plan"})
+		return
+	}
+
+	preview, summary := h.previewActions(userID, images, actions)
+	c.JSON(http.StatusCreated, gin.H{
+		"plan":    plan,
+		"preview": preview,
+		"summary": summary,
+	})
+}
+
+// GetActionPlan 获取计划；计划仍待确认时按当前数据重新计算差异预览
+func (h *Handler) GetActionPlan(c *gin.Context) {
+	plan, ok := h.findActionPlan(c)
+	if !ok {
+		return
+	}
+
+	response := gin.H{"plan": plan}
+	if plan.Status == model.ActionPlanPending {
+		var actions []service.PlannedAction
+		json.Unmarshal(plan.Actions, &actions)
+		images, err := h.planImages(plan.UserID, plan.ImageIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+			return
+		}
+		response["preview"], response["summary"] = h.previewActions(plan.UserID, images, actions)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CancelActionPlan 取消待确认的计划
+func (h *Handler) CancelActionPlan(c *gin.Context) {
+	plan, ok := h.findActionPlan(c)
+	if !ok {
+		return
+	}
+
+	result := h.DB.Model(&model.ActionPlan{}).
+		Where("id = ? AND status = ?", plan.ID, model.ActionPlanPending).
+		Update("status", model.ActionPlanCancelled)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel plan"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Plan is not pending", "status": plan.Status})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Plan cancelled", "planID": plan.ID})
+}
+
+// ConfirmActionPlan 确认并执行计划：所有数据库修改在一个事务中完成，任一操作失败时全部回滚
+// 删除图片文件和重新分析在事务提交后进行
+func (h *Handler) ConfirmActionPlan(c *gin.Context) {
+	plan, ok := h.findActionPlan(c)
+	if !ok {
+		return
+	}
+	if plan.Status != model.ActionPlanPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Plan is not pending", "status": plan.Status})
+		return
+	}
+	if time.Now().After(plan.ExpiresAt) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Plan has expired, please create a new one"})
+		return
+	}
+
+	var actions []service.PlannedAction
+	if err := json.Unmarshal(plan.Actions, &actions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid plan actions"})
+		return
+	}
+
+	var images []model.Image
+	var results []ActionSummary
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// 先把计划标记为已执行，防止并发的重复确认
+		now := time.Now()
+		claim := tx.Model(&model.ActionPlan{}).
+			Where("id = ? AND status = ?", plan.ID, model.ActionPlanPending).
+			Updates(map[string]interface{}{"status": model.ActionPlanExecuted, "executed_at": now})
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			return errPlanNotPending
+		}
+
+		if err := tx.Preload("Tags").Where("id IN ? AND user_id = ?", plan.ImageIDs, plan.UserID).Find(&images).Error; err != nil {
+			return err
+		}
+		var err error
+		results, err = executeActions(tx, plan.UserID, images, actions)
+		if err != nil {
+			return err
+		}
+
+		resultJSON, _ := json.Marshal(gin.H{"actions": results, "images": len(images), "skipped": len(plan.ImageIDs) - len(images)})
+		plan.Result = resultJSON
+		plan.Status = model.ActionPlanExecuted
+		plan.ExecutedAt = &now
+		return tx.Model(plan).Update("result", plan.Result).Error
+	})
+	if errors.Is(err, errPlanNotPending) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Plan is not pending"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to execute action plan %d: %v", plan.ID, err)
+		h.DB.Model(plan).Where("status = ?", model.ActionPlanPending).
+			Updates(map[string]interface{}{"status": model.ActionPlanFailed, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to execute plan, no changes were made", "details": err.Error()})
+		return
+	}
+
+	// 事务提交后的后续处理
+	ids := imageIDs(images)
+	for _, action := range actions {
+		switch action.Type {
+		case service.ActionDelete:
+			for i := range images {
+				removeImageFiles(&images[i])
+			}
+			h.deleteImageEmbeddings(plan.UserID, ids)
+		case service.ActionReanalyze:
+			for _, id := range ids {
+				h.AnalyzeImageAsync(id, "plan")
+			}
+		case service.ActionAddTag, service.ActionRemoveTag:
+			for _, id := range ids {
+				h.indexImageEmbeddingAsync(id)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Plan executed",
+		"plan":    plan,
+		"results": results,
+	})
+}
+
+// findActionPlan 按 URL 中的 id 查找当前用户的计划，找不到时直接写入错误响应
+func (h *Handler) findActionPlan(c *gin.Context) (*model.ActionPlan, bool) {
+	planID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return nil, false
+	}
+
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var plan model.ActionPlan
+	if err := h.DB.Where("id = ? AND user_id = ?", planID, userID).First(&plan).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+		return nil, false
+	}
+	return &plan, true
+}
+
+// planImages 查询计划涉及的、仍属于当前用户的图片（包含标签）
+func (h *Handler) planImages(userID uint, ids []uint) ([]model.Image, error) {
+	images := make([]model.Image, 0)
+	if len(ids) == 0 {
+		return images, nil
+	}
+	err := h.DB.Preload("Tags").Where("id IN ? AND user_id = ?", ids, userID).Order("created_at DESC").Find(&images).Error
+	return images, err
+}
+
+// previewActions 计算计划对每张图片的影响，不修改数据
+func (h *Handler) previewActions(userID uint, images []model.Image, actions []service.PlannedAction) ([]ActionPreview, []ActionSummary) {
+	albumNames := make(map[uint]string)
+	var albums []model.Album
+	h.DB.Where("user_id = ?", userID).Find(&albums)
+	for _, album := range albums {
+		albumNames[album.ID] = album.Name
+	}
+
+	summary := make([]ActionSummary, len(actions))
+	for i, action := range actions {
+		summary[i].PlannedAction = action
+	}
+
+	previews := make([]ActionPreview, 0, len(images))
+	for _, image := range images {
+		preview := ActionPreview{
+			ImageID:     image.ID,
+			Filename:    image.Filename,
+			TagsBefore:  make([]string, 0, len(image.Tags)),
+			AddedTags:   make([]string, 0),
+			RemovedTags: make([]string, 0),
+		}
+		current := make(map[string]bool)
+		for _, tag := range image.Tags {
+			preview.TagsBefore = append(preview.TagsBefore, tag.Name)
+			current[tag.Name] = true
+		}
+		if image.AlbumID != nil {
+			preview.AlbumBefore = albumNames[*image.AlbumID]
+		}
+		preview.AlbumAfter = preview.AlbumBefore
+
+		for i, action := range actions {
+			switch action.Type {
+			case service.ActionAddTag:
+				if !current[action.Tag] {
+					current[action.Tag] = true
+					preview.AddedTags = append(preview.AddedTags, action.Tag)
+					summary[i].Affected++
+				}
+			case service.ActionRemoveTag:
+				if current[action.Tag] {
+					delete(current, action.Tag)
+					preview.RemovedTags = append(preview.RemovedTags, action.Tag)
+					summary[i].Affected++
+				}
+			case service.ActionMoveToAlbum:
+				if preview.AlbumAfter != action.Album {
+					preview.AlbumAfter = action.Album
+					summary[i].Affected++
+				}
+			case service.ActionDelete:
+				preview.Delete = true
+				summary[i].Affected++
+			case service.ActionReanalyze:
+				preview.Reanalyze = true
+				summary[i].Affected++
+			}
+		}
+
+		preview.TagsAfter = make([]string, 0, len(current))
+		for _, name := range preview.TagsBefore {
+			if current[name] {
+				preview.TagsAfter = append(preview.TagsAfter, name)
+			}
+		}
+		preview.TagsAfter = append(preview.TagsAfter, preview.AddedTags...)
+		preview.Changed = preview.Delete || preview.Reanalyze || len(preview.AddedTags) > 0 ||
+			len(preview.RemovedTags) > 0 || preview.AlbumAfter != preview.AlbumBefore
+		previews = append(previews, preview)
+	}
+	return previews, summary
+}
+
+// executeActions 在事务中依次执行操作，返回每个操作实际影响的图片数量
+func executeActions(tx *gorm.DB, userID uint, images []model.Image, actions []service.PlannedAction) ([]ActionSummary, error) {
+	results := make([]ActionSummary, 0, len(actions))
+	ids := imageIDs(images)
+	if len(ids) == 0 {
+		for _, action := range actions {
+			results = append(results, ActionSummary{PlannedAction: action})
+		}
+		return results, nil
+	}
+
+	for _, action := range actions {
+		result := ActionSummary{PlannedAction: action}
+		switch action.Type {
+		case service.ActionAddTag:
+			var tag model.Tag
+			if err := tx.FirstOrCreate(&tag, model.Tag{Name: canonicalTagName(tx, action.Tag)}).Error; err != nil {
+				return nil, fmt.Errorf("add_tag %q: %w", action.Tag, err)
+			}
+			for i := range images {
+				var count int64
+				tx.Table("image_tags").Where("image_id = ? AND tag_id = ?", images[i].ID, tag.ID).Count(&count)
+				if count > 0 {
+					continue
+				}
+				if err := tx.Model(&images[i]).Association("Tags").Append(&tag); err != nil {
+					return nil, fmt.Errorf("add_tag %q to image %d: %w", action.Tag, images[i].ID, err)
+				}
+				updateTagCooccurrence(tx, userID, images[i].ID, []uint{tag.ID}, 1)
+				result.Affected++
+			}
+
+		case service.ActionRemoveTag:
+			var tag model.Tag
+			if err := tx.Where("name = ?", canonicalTagName(tx, action.Tag)).First(&tag).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					break // 标签不存在，没有需要移除的关联
+				}
+				return nil, fmt.Errorf("remove_tag %q: %w", action.Tag, err)
+			}
+			var tagged []uint
+			tx.Table("image_tags").Where("tag_id = ? AND image_id IN ?", tag.ID, ids).Pluck("image_id", &tagged)
+			for _, id := range tagged {
+				updateTagCooccurrence(tx, userID, id, []uint{tag.ID}, -1)
+			}
+			deleted := tx.Exec("DELETE FROM image_tags WHERE tag_id = ? AND image_id IN ?", tag.ID, ids)
+			if deleted.Error != nil {
+				return nil, fmt.Errorf("remove_tag %q: %w", action.Tag, deleted.Error)
+			}
+			result.Affected = int(deleted.RowsAffected)
+
+		case service.ActionMoveToAlbum:
+			album, err := findOrCreateAlbum(tx, userID, action.Album)
+			if err != nil {
+				return nil, fmt.Errorf("move_to_album %q: %w", action.Album, err)
+			}
+			moved := tx.Model(&model.Image{}).
+				Where("id IN ? AND (album_id IS NULL OR album_id <> ?)", ids, album.ID).
+				Update("album_id", album.ID)
+			if moved.Error != nil {
+				return nil, fmt.Errorf("move_to_album %q: %w", action.Album, moved.Error)
+			}
+			result.Affected = int(moved.RowsAffected)
+
+		case service.ActionDelete:
+			for i := range images {
+				forgetImageCooccurrence(tx, &images[i])
+			}
+			if err := tx.Exec("DELETE FROM image_tags WHERE image_id IN ?", ids).Error; err != nil {
+				return nil, fmt.Errorf("delete: %w", err)
+			}
+			if err := tx.Where("image_id IN ?", ids).Delete(&model.ImageColor{}).Error; err != nil {
+				return nil, fmt.Errorf("delete: %w", err)
+			}
+			if err := tx.Where("image_id IN ?", ids).Delete(&model.ImageModeration{}).Error; err != nil {
+				return nil, fmt.Errorf("delete: %w", err)
+			}
+			deleted := tx.Where("id IN ?", ids).Delete(&model.Image{})
+			if deleted.Error != nil {
+				return nil, fmt.Errorf("delete: %w", deleted.Error)
+			}
+			result.Affected = int(deleted.RowsAffected)
+
+		case service.ActionReanalyze:
+			result.Affected = len(ids) // 事务提交后异步执行
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+func TestProposeActionPlanWithConditionTarget(t *testing.T) {
+	h := newTestHandler(t)
+	mock := service.NewMockProvider()
+	mock.ChatFunc = func(req service.ChatRequest) (string, error) {
+		return `{"target": {"tags": ["beach"]}, "actions": [{"type": "add_tag", "tag": "travel"}], "reasoning": "beach photos"}`, nil
+	}
+	h.AI = service.NewAIServiceWithProviders(mock, mock)
+
+	alice := createTestUser(t, h, "alice")
+	bob := createTestUser(t, h, "bob")
+	beach := createTestImage(t, h, alice.ID, "beach.jpg", "beach")
+	sunset := createTestImage(t, h, alice.ID, "sunset.jpg", "beach", "sunset")
+	createTestImage(t, h, alice.ID, "cat.jpg", "cat")
+	createTestImage(t, h, bob.ID, "bob-beach.jpg", "beach")
+
+	w := performRequest(h.ProposeActionPlan, http.MethodPost, "/actions/plan", "/actions/plan", alice.ID,
+		gin.H{"instruction": "把海滩照片都标记为旅行"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Plan model.ActionPlan `json:"plan"`
+	}
+	decodeJSON(t, w, &resp)
+	got := append([]uint{}, resp.Plan.ImageIDs...)
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	if want := []uint{beach.ID, sunset.ID}; !equalIDs(got, want) {
+		t.Fatalf("plan images %v, want %v", got, want)
+	}
+
+	w = performRequest(h.ConfirmActionPlan, http.MethodPost, "/actions/plans/:id/confirm",
+		fmt.Sprintf("/actions/plans/%d/confirm", resp.Plan.ID), alice.ID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm status %d: %s", w.Code, w.Body.String())
+	}
+	var tagged int64
+	h.DB.Table("image_tags").Joins("JOIN tags ON tags.id = image_tags.tag_id").
+		Where("tags.name = ? AND image_tags.image_id IN ?", "travel", []uint{beach.ID, sunset.ID}).Count(&tagged)
+	if tagged != 2 {
+		t.Errorf("tagged %d images with travel, want 2", tagged)
+	}
+}
@@ -0,0 +1,337 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+)
+
+// AdminUserInfo 管理员视角的用户信息（包含存储统计）
+type AdminUserInfo struct {
+	model.User
+	ImageCount   int64 `json:"imageCount"`
+	StorageBytes int64 `json:"storageBytes"`
+}
+
+// userUsageRow 按用户聚合的存储统计
+type userUsageRow struct {
+	UserID       uint
+	ImageCount   int64
+	StorageBytes int64
+}
+
+// storageBytesExpr 累加图片占用的存储空间，忽略标记为 FileSizeMissing 的图片
+const storageBytesExpr = "COALESCE(SUM(CASE WHEN file_size > 0 THEN file_size ELSE 0 END), 0) AS storage_bytes"
+
+// userStorageUsage 统计用户的图片数量和占用的存储空间（字节）
+func (h *Handler) userStorageUsage(userID uint) (imageCount int64, storageBytes int64, err error) {
+	var row userUsageRow
+	err = h.DB.Model(&model.Image{}).
+		Select("COUNT(*) AS image_count, " + storageBytesExpr).
+		Where("user_id = ?", userID).
+		Scan(&row).Error
+	return row.ImageCount, row.StorageBytes, err
+}
+
+// parseUserIDParam 解析 URL 中的用户 ID
+func parseUserIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// AdminListUsers 列出所有用户及其图片数量和存储用量
+func (h *Handler) AdminListUsers(c *gin.Context) {
+	var users []model.User
+	if err := h.DB.Order("id ASC").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	var rows []userUsageRow
+	if err := h.DB.Model(&model.Image{}).
+		Select("user_id, COUNT(*) AS image_count, " + storageBytesExpr).
+		Group("user_id").
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch storage usage"})
+		return
+	}
+	usage := make(map[uint]userUsageRow, len(rows))
+	for _, row := range rows {
+		usage[row.UserID] = row
+	}
+
+	result := make([]AdminUserInfo, len(users))
+	for i, user := range users {
+		result[i] = AdminUserInfo{
+			User:         user,
+			ImageCount:   usage[user.ID].ImageCount,
+			StorageBytes: usage[user.ID].StorageBytes,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": result,
+		"count": len(result),
+	})
+}
+
+// AdminUpdateUser 禁用/启用用户或修改用户角色
+func (h *Handler) AdminUpdateUser(c *gin.Context) {
+	targetID, ok := parseUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Disabled *bool   `json:"disabled"`
+		Role     *string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+	if targetID == userID {
+		// 防止管理员把自己禁用或降级，导致系统没有可用的管理员
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot modify your own account status or role"})
+		return
+	}
+
+	var user model.User
+	if err := h.DB.First(&user, targetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.Disabled != nil {
+		updates["disabled"] = *input.Disabled
+	}
+	if input.Role != nil {
+		if *input.Role != model.RoleUser && *input.Role != model.RoleAdmin {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be 'user' or 'admin'"})
+			return
+		}
+		updates["role"] = *input.Role
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	if err := h.DB.Model(&user).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// AdminDeleteUser 彻底删除用户及其所有图片、标签关联和文件
+func (h *Handler) AdminDeleteUser(c *gin.Context) {
+	targetID, ok := parseUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	userID_i, _ := c.Get("userID")
+	if targetID == userID_i.(uint) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete your own account"})
+		return
+	}
+
+	var user model.User
+	if err := h.DB.First(&user, targetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// 包括已软删除的图片，确保文件也被清理
+	var images []model.Image
+	if err := h.DB.Unscoped().Where("user_id = ?", targetID).Find(&images).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query user images"})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		imageIDs := tx.Unscoped().Model(&model.Image{}).Select("id").Where("user_id = ?", targetID)
+		if err := tx.Exec("DELETE FROM image_tags WHERE image_id IN (?)", imageIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("image_id IN (?)", imageIDs).Delete(&model.ImageColor{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("image_id IN (?)", imageIDs).Delete(&model.ImageModeration{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", targetID).Delete(&model.AIJob{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", targetID).Delete(&model.ImageEmbedding{}).Error; err != nil {
+			return err
+		}
+		sessionIDs := tx.Model(&model.ChatSession{}).Select("id").Where("user_id = ?", targetID)
+		if err := tx.Where("session_id IN (?)", sessionIDs).Delete(&model.ChatMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", targetID).Delete(&model.ChatSession{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", targetID).Delete(&model.ActionPlan{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", targetID).Delete(&model.Album{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", targetID).Delete(&model.TagSuggestion{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", targetID).Delete(&model.TagCooccurrence{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", targetID).Delete(&model.AnalysisBatch{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", targetID).Delete(&model.VocabularyTerm{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", targetID).Delete(&model.AIUsage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", targetID).Delete(&model.UserPreference{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", targetID).Delete(&model.UserIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", targetID).Delete(&model.Image{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&user).Error
+	})
+	if err != nil {
+		log.Printf("Failed to delete user %d: %v", targetID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	// 数据库删除成功后再清理文件
+	for i := range images {
+		removeImageFiles(&images[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       fmt.Sprintf("用户 %s 及其 %d 张图片已删除", user.Username, len(images)),
+		"userID":        targetID,
+		"deletedImages": len(images),
+	})
+}
+
+// AdminGetUserUsage 查看指定用户的图片数量和存储用量
+func (h *Handler) AdminGetUserUsage(c *gin.Context) {
+	targetID, ok := parseUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	var user model.User
+	if err := h.DB.First(&user, targetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	imageCount, storageBytes, err := h.userStorageUsage(targetID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch storage usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"userID":       targetID,
+		"imageCount":   imageCount,
+		"storageBytes": storageBytes,
+	})
+}
+
+// AdminReanalyzeImage 强制对任意用户的图片重新进行 AI 分析（异步执行）
+func (h *Handler) AdminReanalyzeImage(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	var image model.Image
+	if err := h.DB.First(&image, imageID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	h.AnalyzeImageAsync(image.ID, "admin")
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Re-analysis scheduled",
+		"imageID": image.ID,
+	})
+}
+
+// AdminListAIJobs 查看 AI 分析任务记录，默认只返回失败的任务
+// 支持 ?status=failed|succeeded|running|all、?userID=、?limit=
+func (h *Handler) AdminListAIJobs(c *gin.Context) {
+	status := c.DefaultQuery("status", model.AIJobFailed)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	query := h.DB.Model(&model.AIJob{})
+	if status != "all" {
+		query = query.Where("status = ?", status)
+	}
+	if userID := c.Query("userID"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var jobs []model.AIJob
+	if err := query.Order("created_at DESC").Limit(limit).Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch AI jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":  jobs,
+		"count": len(jobs),
+	})
+}
+
+// AdminAIHealth 返回 AI 提供方的熔断状态、降级链和重试策略，所有降级模型都不可用时返回 503
+func (h *Handler) AdminAIHealth(c *gin.Context) {
+	aiService, err := h.aiService()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": err.Error()})
+		return
+	}
+
+	health := aiService.Health()
+	if health == nil {
+		// 直接注入的提供方没有重试和熔断
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "provider": aiService.ProviderName()})
+		return
+	}
+	status := http.StatusOK
+	if health.Status == "unavailable" {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, health)
+}
@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"github.com/Valkqs/image-management-app/backend/internal/model"
 	"github.com/Valkqs/image-management-app/backend/internal/service"
 )
@@ -30,65 +33,27 @@ func (h *Handler) AnalyzeImage(c *gin.Context) {
 	}
 
 	// 创建 AI 服务
-	aiService, err := service.NewAIService()
+	aiService, err := h.aiService()
 	if err != nil {
 		log.Printf("Failed to create AI service: %v", err)
+		h.recordFailedAIJob(&image, "manual", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "AI service is not available. Please check MODELSCOPE_ACCESS_TOKEN environment variable.",
+			"error": "AI service is not available. Please check the AI provider configuration (AI_PROVIDER).",
 		})
 		return
 	}
 
-	// 分析图片
-	tagNames, err := aiService.AnalyzeImage(image.FilePath)
+	// 分析图片并添加标签
+	addedTags, err := h.analyzeAndTagImage(aiService, &image, "manual")
 	if err != nil {
 		log.Printf("Failed to analyze image %d: %v", imageID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(aiErrorStatus(err), gin.H{
 			"error": "Failed to analyze image",
 			"details": err.Error(),
 		})
 		return
 	}
 
-	// 为图片添加 AI 标签
-	addedTags := make([]model.Tag, 0)
-	for _, tagName := range tagNames {
-		// 查找或创建标签（来源为 AI）
-		var tag model.Tag
-		result := h.DB.Where("name = ?", tagName).First(&tag)
-		
-		if result.Error != nil {
-			// 标签不存在，创建新标签
-			tag = model.Tag{
-				Name:   tagName,
-				Source: "ai",
-			}
-			if err := h.DB.Create(&tag).Error; err != nil {
-				log.Printf("Failed to create tag %s: %v", tagName, err)
-				continue
-			}
-		} else {
-			// 标签已存在，如果来源不是 AI，更新为 AI（允许用户标签转为 AI 标签）
-			if tag.Source != "ai" {
-				tag.Source = "ai"
-				h.DB.Save(&tag)
-			}
-		}
-
-		// 检查图片是否已有此标签
-		var count int64
-		h.DB.Model(&image).Association("Tags").Count()
-		h.DB.Table("image_tags").Where("image_id = ? AND tag_id = ?", imageID, tag.ID).Count(&count)
-		if count == 0 {
-			// 关联标签到图片
-			if err := h.DB.Model(&image).Association("Tags").Append(&tag); err != nil {
-				log.Printf("Failed to associate tag %s with image: %v", tagName, err)
-				continue
-			}
-			addedTags = append(addedTags, tag)
-		}
-	}
-
 	// 重新加载图片信息（包含所有标签）
 	h.DB.Preload("Tags").First(&image, imageID)
 
@@ -99,62 +64,226 @@ func (h *Handler) AnalyzeImage(c *gin.Context) {
 	})
 }
 
+// aiService 返回启动时注入的 AI 服务；未注入时根据当前环境变量创建（同样使用数据库中的提示词模板）
+func (h *Handler) aiService() (*service.AIService, error) {
+	if h.AI != nil {
+		return h.AI, nil
+	}
+	aiService, err := service.NewAIService()
+	if err != nil {
+		return nil, err
+	}
+	aiService.Prompts().Prepend(h.NewPromptStore())
+	aiService.SetUsageTracker(h.NewUsageTracker())
+	return aiService, nil
+}
+
 // AnalyzeImageAsync 异步分析图片（不阻塞响应）
-func (h *Handler) AnalyzeImageAsync(imageID uint, imagePath string) {
+// trigger 标识触发来源（'upload'、'edit'、'admin'），记录在 AI 任务中
+func (h *Handler) AnalyzeImageAsync(imageID uint, trigger string) {
 	go func() {
+		// 查询图片
+		var image model.Image
+		if err := h.DB.First(&image, imageID).Error; err != nil {
+			log.Printf("Image %d not found for async analysis: %v", imageID, err)
+			return
+		}
+
 		// 创建 AI 服务
-		aiService, err := service.NewAIService()
+		aiService, err := h.aiService()
 		if err != nil {
 			log.Printf("AI service not available for async analysis: %v", err)
+			h.recordFailedAIJob(&image, trigger, err)
 			return
 		}
 
-		// 分析图片
-		tagNames, err := aiService.AnalyzeImage(imagePath)
+		addedTags, err := h.analyzeAndTagImage(aiService, &image, trigger)
 		if err != nil {
 			log.Printf("Async analysis failed for image %d: %v", imageID, err)
 			return
 		}
 
-		// 查询图片
-		var image model.Image
-		if err := h.DB.First(&image, imageID).Error; err != nil {
-			log.Printf("Image %d not found for async analysis: %v", imageID, err)
-			return
-		}
+		log.Printf("Async AI analysis completed for image %d, added %d tags", imageID, len(addedTags))
+	}()
+}
 
-		// 为图片添加 AI 标签
-		for _, tagName := range tagNames {
-			var tag model.Tag
-			result := h.DB.Where("name = ?", tagName).First(&tag)
-			
-			if result.Error != nil {
-				// 创建新标签
-				tag = model.Tag{
-					Name:   tagName,
-					Source: "ai",
-				}
-				if err := h.DB.Create(&tag).Error; err != nil {
-					log.Printf("Failed to create tag %s: %v", tagName, err)
-					continue
-				}
-			} else {
-				// 更新标签来源
-				if tag.Source != "ai" {
-					tag.Source = "ai"
-					h.DB.Save(&tag)
+// analyzeAndTagImage 调用 AI 服务分析图片，并将得到的标签关联到图片上
+// 整个过程记录为一条 AIJob，返回新关联的标签
+func (h *Handler) analyzeAndTagImage(aiService *service.AIService, image *model.Image, trigger string) ([]model.Tag, error) {
+	return h.analyzeImageInBatch(aiService, image, trigger, 0)
+}
+
+// analyzeImageInBatch 与 analyzeAndTagImage 相同，AIJob 额外记录所属的批量分析任务
+func (h *Handler) analyzeImageInBatch(aiService *service.AIService, image *model.Image, trigger string, batchID uint) ([]model.Tag, error) {
+	job := model.AIJob{
+		ImageID:     image.ID,
+		UserID:      image.UserID,
+		Trigger:     trigger,
+		BatchID:     batchID,
+		VisionModel: aiService.VisionModel(),
+		Status:      model.AIJobRunning,
+	}
+	if err := h.DB.Create(&job).Error; err != nil {
+		log.Printf("Failed to record AI job for image %d: %v", image.ID, err)
+	}
+
+	// 审核、标签分析和文字识别都计入图片所有者的用量和预算
+	ctx := service.WithUserID(context.Background(), image.UserID)
+
+	// 内容审核在标签分析之前进行，标签分析失败时审核结果仍然保留
+	h.moderateImage(ctx, aiService, image)
+
+	// 分析图片（按图片所有者偏好的语言生成标签，开启描述模式时同时生成描述和替代文本）
+	// 用户经常拒绝的标签作为负反馈写入提示词；设置了受控词表时标签映射到词表
+	// 用户或管理员主动重新分析时不使用缓存的结果
+	prefs := h.getPreferences(image.UserID)
+	analysis, err := aiService.AnalyzeImage(ctx, image.FilePath, service.AnalysisOptions{
+		Language:   prefs.AILanguage,
+		Describe:   prefs.AIDescribe,
+		AvoidTags:  h.rejectedTagNames(image.UserID),
+		Vocabulary: h.userVocabulary(image.UserID),
+		NoCache:    trigger == "manual" || trigger == "admin",
+	})
+	if err != nil {
+		h.finishAIJob(&job, 0, err)
+		return nil, err
+	}
+	job.PromptVersion = analysis.PromptVersion
+
+	// 开启标签审核时 AI 标签只作为待审核的建议，不直接关联到图片
+	addedTags := make([]model.Tag, 0)
+	if prefs.AIReviewTags {
+		h.suggestAITags(image, analysis.TagDetails, job.ID)
+	} else {
+		addedTags = h.applyAITags(h.DB, image, analysis.TagDetails)
+	}
+	h.applyAIDescription(image, analysis)
+	h.extractImageText(ctx, aiService, image)
+	h.indexImageEmbeddingAsync(image.ID)
+	h.finishAIJob(&job, len(analysis.Tags), nil)
+	return addedTags, nil
+}
+
+// extractImageText 文字识别阶段：识别图片中的文字并保存，用于全文搜索
+// ctx 中的用户用于用量统计和预算检查；识别失败不影响标签分析的结果，只记录日志
+func (h *Handler) extractImageText(ctx context.Context, aiService *service.AIService, image *model.Image) {
+	if !aiService.OCREnabled() {
+		return
+	}
+
+	text, err := aiService.ExtractText(ctx, image.FilePath)
+	if err != nil {
+		log.Printf("Text extraction failed for image %d: %v", image.ID, err)
+		return
+	}
+
+	image.OCRText = text
+	if err := h.DB.Model(image).Update("ocr_text", text).Error; err != nil {
+		log.Printf("Failed to save extracted text for image %d: %v", image.ID, err)
+	}
+}
+
+// applyAIDescription 保存 AI 生成的描述和替代文本，用户手动编辑过的描述保持不变
+func (h *Handler) applyAIDescription(image *model.Image, analysis *service.ImageAnalysis) {
+	if analysis.Description == "" && analysis.AltText == "" {
+		return
+	}
+	if image.DescriptionSource == "user" {
+		log.Printf("Keeping user-edited description of image %d", image.ID)
+		return
+	}
+
+	updates := map[string]interface{}{
+		"description":        analysis.Description,
+		"alt_text":           analysis.AltText,
+		"description_source": "ai",
+	}
+	if err := h.DB.Model(image).Updates(updates).Error; err != nil {
+		log.Printf("Failed to save AI description for image %d: %v", image.ID, err)
+	}
+}
+
+// applyAITags 为图片添加 AI 标签，返回新关联的标签
+// db 可以是事务，审核建议时与建议状态的更新一起提交
+func (h *Handler) applyAITags(db *gorm.DB, image *model.Image, aiTags []service.AITag) []model.Tag {
+	addedTags := make([]model.Tag, 0)
+	for _, aiTag := range aiTags {
+		tagName := canonicalTagName(db, aiTag.Name)
+		// 查找或创建标签（来源为 AI，同义词解析为规范标签）
+		var tag model.Tag
+		result := db.Where("name = ?", tagName).First(&tag)
+
+		if result.Error != nil {
+			// 标签不存在，创建新标签
+			tag = model.Tag{
+				Name:     tagName,
+				Source:   "ai",
+				Category: aiTag.Category,
+			}
+			if err := db.Create(&tag).Error; err != nil {
+				log.Printf("Failed to create tag %s: %v", tagName, err)
+				continue
+			}
+		} else {
+			// 标签已存在，如果来源不是 AI，更新为 AI（允许用户标签转为 AI 标签）；还没有类别时记录 AI 给出的类别
+			if tag.Source != "ai" || (tag.Category == "" && aiTag.Category != "") {
+				tag.Source = "ai"
+				if tag.Category == "" {
+					tag.Category = aiTag.Category
 				}
+				db.Save(&tag)
 			}
+		}
 
-			// 检查并关联标签
-			var count int64
-			h.DB.Table("image_tags").Where("image_id = ? AND tag_id = ?", imageID, tag.ID).Count(&count)
-			if count == 0 {
-				h.DB.Model(&image).Association("Tags").Append(&tag)
+		// 检查图片是否已有此标签
+		var count int64
+		db.Table("image_tags").Where("image_id = ? AND tag_id = ?", image.ID, tag.ID).Count(&count)
+		if count == 0 {
+			// 关联标签到图片
+			if err := db.Model(image).Association("Tags").Append(&tag); err != nil {
+				log.Printf("Failed to associate tag %s with image: %v", tagName, err)
+				continue
 			}
+			updateTagCooccurrence(db, image.UserID, image.ID, []uint{tag.ID}, 1)
+			addedTags = append(addedTags, tag)
 		}
+	}
+	return addedTags
+}
 
-		log.Printf("Async AI analysis completed for image %d, added %d tags", imageID, len(tagNames))
-	}()
+// finishAIJob 更新 AI 任务的最终状态
+func (h *Handler) finishAIJob(job *model.AIJob, tagCount int, jobErr error) {
+	if job.ID == 0 {
+		return
+	}
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":         model.AIJobSucceeded,
+		"tag_count":      tagCount,
+		"prompt_version": job.PromptVersion,
+		"finished_at":    now,
+	}
+	if jobErr != nil {
+		updates["status"] = model.AIJobFailed
+		updates["error"] = jobErr.Error()
+	}
+	if err := h.DB.Model(job).Updates(updates).Error; err != nil {
+		log.Printf("Failed to update AI job %d: %v", job.ID, err)
+	}
 }
 
+// recordFailedAIJob 记录一条在开始分析前就失败的 AI 任务（例如 AI 服务未配置）
+func (h *Handler) recordFailedAIJob(image *model.Image, trigger string, jobErr error) {
+	now := time.Now()
+	job := model.AIJob{
+		ImageID:    image.ID,
+		UserID:     image.UserID,
+		Trigger:    trigger,
+		Status:     model.AIJobFailed,
+		Error:      jobErr.Error(),
+		FinishedAt: &now,
+	}
+	if err := h.DB.Create(&job).Error; err != nil {
+		log.Printf("Failed to record AI job for image %d: %v", image.ID, err)
+	}
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+)
+
+// 相册名称校验错误
+var (
+	errEmptyAlbumName   = errors.New("album name cannot be empty")
+	errAlbumNameTooLong = errors.New("album name must be at most 100 characters")
+)
+
+// AlbumWithCount 相册及其图片数量
+type AlbumWithCount struct {
+	model.Album
+	ImageCount int64 `json:"imageCount"`
+}
+
+// ListAlbums 列出当前用户的相册及每个相册的图片数量
+func (h *Handler) ListAlbums(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var albums []AlbumWithCount
+	err := h.DB.Model(&model.Album{}).
+		Select("albums.*, COUNT(images.id) AS image_count").
+		Joins("LEFT JOIN images ON images.album_id = albums.id AND images.deleted_at IS NULL").
+		Where("albums.user_id = ?", userID).
+		Group("albums.id").
+		Order("albums.name ASC").
+		Scan(&albums).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch albums"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"albums": albums})
+}
+
+// CreateAlbum 创建相册（同名相册已存在时返回已有的相册）
+func (h *Handler) CreateAlbum(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var input struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	album, err := findOrCreateAlbum(h.DB, userID, input.Name)
+	if errors.Is(err, errEmptyAlbumName) || errors.Is(err, errAlbumNameTooLong) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create album"})
+		return
+	}
+
+	c.JSON(http.StatusOK, album)
+}
+
+// DeleteAlbum 删除相册，相册中的图片保留但不再属于任何相册
+func (h *Handler) DeleteAlbum(c *gin.Context) {
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid album ID"})
+		return
+	}
+
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var album model.Album
+	if err := h.DB.Where("id = ? AND user_id = ?", albumID, userID).First(&album).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Image{}).Where("album_id = ?", album.ID).Update("album_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&album).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete album"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Album deleted", "albumID": album.ID})
+}
+
+// findOrCreateAlbum 按名称查找用户的相册，不存在时创建
+func findOrCreateAlbum(db *gorm.DB, userID uint, name string) (*model.Album, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errEmptyAlbumName
+	}
+	if len([]rune(name)) > 100 {
+		return nil, errAlbumNameTooLong
+	}
+
+	var album model.Album
+	if err := db.Where(model.Album{UserID: userID, Name: name}).FirstOrCreate(&album).Error; err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
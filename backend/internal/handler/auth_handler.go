@@ -1,18 +1,83 @@
 package handler
 
 import (
+	"fmt"
+	"math"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/mcp"
 	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
 	"github.com/Valkqs/image-management-app/backend/internal/utils"
 )
 
 // Handler 结构体，包含所有此包处理器需要的依赖
 type Handler struct {
-	DB *gorm.DB
+	DB   *gorm.DB
+	OIDC *service.OIDCProvider // 未配置单点登录时为 nil
+	AI   *service.AIService    // 启动时创建的 AI 服务；为 nil 时按需根据环境变量创建
+	MCP  *mcp.Server           // MCP 服务端（见 NewMCPServer）
+
+	// 语义搜索：未配置向量提供方时 Embedder 为 nil
+	Embedder service.EmbeddingProvider
+	Vectors  service.VectorIndex
+
+	// 批量分析对 AI 提供方的并发和速率限制；为 nil 时使用根据环境变量创建的全局调度器
+	Scheduler *service.AIScheduler
+
+	embeddings embeddingQueue // 后台的语义向量计算（见 indexEmbeddingsAsync）
+}
+
+// 登录失败锁定策略：连续失败达到阈值后按指数退避锁定账号
+const (
+	maxFailedLogins  = 5
+	baseLockDuration = time.Minute
+	maxLockDuration  = time.Hour
+)
+
+// recordFailedLogin 在数据库中原子地递增失败计数并返回递增后的值
+// 并发的错误密码请求不会因为读-改-写而互相覆盖计数
+func (h *Handler) recordFailedLogin(userID uint) (int, error) {
+	if err := h.DB.Model(&model.User{}).Where("id = ?", userID).
+		UpdateColumn("failed_login_attempts", gorm.Expr("failed_login_attempts + 1")).Error; err != nil {
+		return 0, err
+	}
+	var failedAttempts int
+	err := h.DB.Model(&model.User{}).Where("id = ?", userID).Pluck("failed_login_attempts", &failedAttempts).Error
+	return failedAttempts, err
+}
+
+// lockDuration 根据连续失败次数计算锁定时长（1分钟、2分钟、4分钟……最长1小时）
+func lockDuration(failedAttempts int) time.Duration {
+	if failedAttempts < maxFailedLogins {
+		return 0
+	}
+	exp := failedAttempts - maxFailedLogins
+	if exp > 10 {
+		return maxLockDuration
+	}
+	d := baseLockDuration * time.Duration(1<<exp)
+	if d > maxLockDuration {
+		return maxLockDuration
+	}
+	return d
+}
+
+// respondLocked 返回账号锁定的 429 响应
+func respondLocked(c *gin.Context, until time.Time) {
+	seconds := int(math.Ceil(time.Until(until).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", fmt.Sprintf("%d", seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      "Account temporarily locked due to too many failed login attempts",
+		"retryAfter": seconds,
+	})
 }
 
 // Register 成为 Handler 的一个方法，用于处理用户注册
@@ -82,12 +147,40 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
+	// 被管理员禁用的账号不允许登录
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	// 账号处于锁定期内，直接拒绝（不做密码校验，避免消耗 bcrypt 计算）
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		respondLocked(c, *user.LockedUntil)
+		return
+	}
+
 	// 校验密码
 	if !utils.CheckPasswordHash(input.Password, user.Password) {
+		failedAttempts, err := h.recordFailedLogin(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if d := lockDuration(failedAttempts); d > 0 {
+			lockedUntil := time.Now().Add(d)
+			h.DB.Model(&user).Update("locked_until", lockedUntil)
+			respondLocked(c, lockedUntil)
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	// 登录成功，重置失败计数
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		h.DB.Model(&user).Updates(map[string]interface{}{"failed_login_attempts": 0, "locked_until": nil})
+	}
+
 	// 生成 JWT
 	token, err := utils.GenerateJWT(user.ID)
 	if err != nil {
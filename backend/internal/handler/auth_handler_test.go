@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/utils"
+)
+
+func TestLoginCountsConcurrentFailures(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	// 真实的 bcrypt 校验耗时较长，读取用户和写回计数之间的窗口与线上一致
+	hashed, err := utils.HashPassword("correct-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.DB.Model(&alice).Update("password", hashed)
+
+	// 并发的错误密码请求都要计入失败次数，不能因为读-改-写互相覆盖
+	const attempts = maxFailedLogins - 1
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			performRequest(h.Login, http.MethodPost, "/login", "/login", 0,
+				gin.H{"email": alice.Email, "password": "wrong-password"})
+		}()
+	}
+	wg.Wait()
+
+	var user model.User
+	h.DB.First(&user, alice.ID)
+	if user.FailedLoginAttempts != attempts {
+		t.Fatalf("failed attempts %d, want %d", user.FailedLoginAttempts, attempts)
+	}
+	if user.LockedUntil != nil {
+		t.Fatalf("locked before reaching %d failures", maxFailedLogins)
+	}
+
+	w := performRequest(h.Login, http.MethodPost, "/login", "/login", 0,
+		gin.H{"email": alice.Email, "password": "wrong-password"})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429 after %d failures: %s", w.Code, maxFailedLogins, w.Body.String())
+	}
+	h.DB.First(&user, alice.ID)
+	if user.LockedUntil == nil {
+		t.Error("expected the account to be locked")
+	}
+}
@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+// 批量分析的限制
+const (
+	maxBatchAnalysisImages  = 5000            // 单个批量任务最多分析的图片数量，超出部分需要再次提交
+	batchCancelPollInterval = 2 * time.Second // 检查任务是否被取消的间隔
+)
+
+var (
+	defaultSchedulerOnce sync.Once
+	defaultScheduler     *service.AIScheduler
+)
+
+// scheduler 返回启动时注入的调度器；未注入时使用根据环境变量创建的全局调度器
+func (h *Handler) scheduler() *service.AIScheduler {
+	if h.Scheduler != nil {
+		return h.Scheduler
+	}
+	defaultSchedulerOnce.Do(func() {
+		defaultScheduler = service.NewAISchedulerFromEnv()
+	})
+	return defaultScheduler
+}
+
+// StartBatchAnalysis 对一批图片进行（重新）分析
+// 可以传入图片ID列表，或者按筛选方式选择图片：unanalyzed（默认，没有 AI 标签）、outdated（未被当前模型分析过）、all
+// 任务在后台按调度器的并发数和速率限制执行，立即返回 202 和任务信息
+func (h *Handler) StartBatchAnalysis(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var input struct {
+		ImageIDs []uint `json:"imageIDs"`
+		Filter   string `json:"filter"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := input.Filter
+	if len(input.ImageIDs) > 0 {
+		filter = model.BatchFilterIDs
+	} else if filter == "" {
+		filter = model.BatchFilterUnanalyzed
+	}
+	if filter != model.BatchFilterIDs && filter != model.BatchFilterUnanalyzed &&
+		filter != model.BatchFilterOutdated && filter != model.BatchFilterAll {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filter must be 'unanalyzed', 'outdated' or 'all'"})
+		return
+	}
+
+	aiService, err := h.aiService()
+	if err != nil {
+		log.Printf("Failed to create AI service: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "AI service is not available. Please check the AI provider configuration (AI_PROVIDER).",
+		})
+		return
+	}
+
+	// 每个用户同时只能有一个进行中的批量任务
+	var running model.AnalysisBatch
+	if err := h.DB.Where("user_id = ? AND status = ?", userID, model.BatchRunning).First(&running).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A batch analysis is already running", "batchID": running.ID})
+		return
+	}
+
+	query := h.batchImageQuery(userID, filter, aiService.VisionModel())
+	if filter == model.BatchFilterIDs {
+		query = query.Where("images.id IN ?", input.ImageIDs)
+	}
+	var imageIDs []uint
+	if err := query.Order("images.id ASC").Pluck("images.id", &imageIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to select images"})
+		return
+	}
+	if len(imageIDs) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "No images to analyze", "total": 0})
+		return
+	}
+
+	remaining := 0
+	if len(imageIDs) > maxBatchAnalysisImages {
+		remaining = len(imageIDs) - maxBatchAnalysisImages
+		imageIDs = imageIDs[:maxBatchAnalysisImages]
+	}
+
+	batch := model.AnalysisBatch{
+		UserID:      userID,
+		Filter:      filter,
+		VisionModel: aiService.VisionModel(),
+		Status:      model.BatchRunning,
+		ImageIDs:    imageIDs,
+		Total:       len(imageIDs),
+	}
+	if err := h.DB.Create(&batch).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create batch analysis"})
+		return
+	}
+
+	go h.runAnalysisBatch(aiService, &batch)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"batch":     batch,
+		"remaining": remaining, // 超出单批上限、未加入本次任务的图片数量
+	})
+}
+
+// batchImageQuery 返回按筛选方式选择用户图片的查询
+func (h *Handler) batchImageQuery(userID uint, filter, visionModel string) *gorm.DB {
+	query := h.DB.Model(&model.Image{}).Where("images.user_id = ?", userID)
+	switch filter {
+	case model.BatchFilterUnanalyzed:
+		// 没有 AI 标签，也没有待审核或已审核的 AI 标签建议
+		query = query.
+			Where("NOT EXISTS (SELECT 1 FROM image_tags JOIN tags ON tags.id = image_tags.tag_id WHERE image_tags.image_id = images.id AND tags.source = ?)", "ai").
+			Where("NOT EXISTS (SELECT 1 FROM tag_suggestions WHERE tag_suggestions.image_id = images.id)")
+	case model.BatchFilterOutdated:
+		query = query.Where("NOT EXISTS (SELECT 1 FROM ai_jobs WHERE ai_jobs.image_id = images.id AND ai_jobs.deleted_at IS NULL AND ai_jobs.status = ? AND ai_jobs.vision_model = ?)",
+			model.AIJobSucceeded, visionModel)
+	}
+	return query
+}
+
+// runAnalysisBatch 在后台执行批量分析，任务被取消后不再发起新的分析（进行中的分析会完成）
+func (h *Handler) runAnalysisBatch(aiService *service.AIService, batch *model.AnalysisBatch) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.watchBatchCancellation(ctx, cancel, batch.ID)
+
+	scheduler := h.scheduler()
+	var wg sync.WaitGroup
+	for _, imageID := range batch.ImageIDs {
+		release, err := scheduler.Acquire(ctx)
+		if err != nil {
+			break // 任务已取消
+		}
+
+		wg.Add(1)
+		go func(imageID uint) {
+			defer wg.Done()
+			defer release()
+			h.analyzeBatchImage(aiService, batch, imageID)
+		}(imageID)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	h.DB.Model(&model.AnalysisBatch{}).
+		Where("id = ? AND status = ?", batch.ID, model.BatchRunning).
+		Updates(map[string]interface{}{"status": model.BatchCompleted, "finished_at": now})
+	log.Printf("Batch analysis %d finished", batch.ID)
+}
+
+// watchBatchCancellation 定期检查任务状态，任务被取消（或不再是进行中）时取消 ctx
+func (h *Handler) watchBatchCancellation(ctx context.Context, cancel context.CancelFunc, batchID uint) {
+	ticker := time.NewTicker(batchCancelPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var status string
+			if err := h.DB.Model(&model.AnalysisBatch{}).Where("id = ?", batchID).Pluck("status", &status).Error; err != nil {
+				continue
+			}
+			if status != model.BatchRunning {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// analyzeBatchImage 分析批量任务中的一张图片并更新任务进度
+func (h *Handler) analyzeBatchImage(aiService *service.AIService, batch *model.AnalysisBatch, imageID uint) {
+	counter := "succeeded"
+	var image model.Image
+	if err := h.DB.Where("id = ? AND user_id = ?", imageID, batch.UserID).First(&image).Error; err != nil {
+		log.Printf("Image %d of batch %d not found: %v", imageID, batch.ID, err)
+		counter = "failed"
+	} else if _, err := h.analyzeImageInBatch(aiService, &image, "batch", batch.ID); err != nil {
+		log.Printf("Batch %d: analysis failed for image %d: %v", batch.ID, imageID, err)
+		counter = "failed"
+	}
+
+	err := h.DB.Model(&model.AnalysisBatch{}).Where("id = ?", batch.ID).Updates(map[string]interface{}{
+		"processed": gorm.Expr("processed + 1"),
+		counter:     gorm.Expr(counter + " + 1"),
+	}).Error
+	if err != nil {
+		log.Printf("Failed to update progress of batch %d: %v", batch.ID, err)
+	}
+}
+
+// ListBatchAnalyses 列出当前用户最近的批量分析任务
+func (h *Handler) ListBatchAnalyses(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var batches []model.AnalysisBatch
+	if err := h.DB.Where("user_id = ?", userID).Order("created_at DESC").Limit(20).Find(&batches).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch batch analyses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"batches": batches})
+}
+
+// GetBatchAnalysis 返回批量分析任务的进度，以及最近失败的图片和原因
+func (h *Handler) GetBatchAnalysis(c *gin.Context) {
+	batch, ok := h.findAnalysisBatch(c)
+	if !ok {
+		return
+	}
+
+	var failures []model.AIJob
+	h.DB.Where("batch_id = ? AND status = ?", batch.ID, model.AIJobFailed).
+		Order("created_at DESC").Limit(20).Find(&failures)
+
+	progress := 0.0
+	if batch.Total > 0 {
+		progress = float64(batch.Processed) / float64(batch.Total)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"batch":    batch,
+		"progress": progress,
+		"failures": failures,
+	})
+}
+
+// CancelBatchAnalysis 取消进行中的批量分析任务，已经开始的分析会完成，之后不再发起新的分析
+func (h *Handler) CancelBatchAnalysis(c *gin.Context) {
+	batch, ok := h.findAnalysisBatch(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	result := h.DB.Model(&model.AnalysisBatch{}).
+		Where("id = ? AND status = ?", batch.ID, model.BatchRunning).
+		Updates(map[string]interface{}{"status": model.BatchCancelled, "finished_at": now})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel batch analysis"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Batch analysis is not running", "status": batch.Status})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Batch analysis cancelled", "batchID": batch.ID})
+}
+
+// findAnalysisBatch 按路径参数查找当前用户的批量分析任务，找不到时写入错误响应
+func (h *Handler) findAnalysisBatch(c *gin.Context) (*model.AnalysisBatch, bool) {
+	batchID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch ID"})
+		return nil, false
+	}
+
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var batch model.AnalysisBatch
+	if err := h.DB.Where("id = ? AND user_id = ?", batchID, userID).First(&batch).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Batch analysis not found"})
+		return nil, false
+	}
+	return &batch, true
+}
+
+// RecoverAnalysisBatches 启动时将上次运行中断的批量任务标记为失败，用户可以重新提交
+func (h *Handler) RecoverAnalysisBatches() {
+	now := time.Now()
+	result := h.DB.Model(&model.AnalysisBatch{}).
+		Where("status = ?", model.BatchRunning).
+		Updates(map[string]interface{}{
+			"status":      model.BatchFailed,
+			"error":       "interrupted by server restart",
+			"finished_at": now,
+		})
+	if result.Error != nil {
+		log.Printf("Failed to recover batch analyses: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("Marked %d interrupted batch analyses as failed", result.RowsAffected)
+	}
+}
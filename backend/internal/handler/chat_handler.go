@@ -0,0 +1,470 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+// 对话助手参数
+const (
+	maxChatResults      = 200  // 每一轮最多返回的图片数量
+	chatResultBatch     = 20   // 流式输出时每批发送的图片数量
+	maxChatTitleRunes   = 50   // 会话标题默认取第一条消息的前 50 个字符
+	maxChatMessageRunes = 2000 // 单条消息的最大长度
+)
+
+// ChatMessageRequest 发送消息的请求结构
+type ChatMessageRequest struct {
+	Message string `json:"message" binding:"required"`
+}
+
+// ChatTurnResponse 非流式模式下一轮对话的响应
+type ChatTurnResponse struct {
+	Message *model.ChatMessage       `json:"message"` // 助手的回复
+	Intent  *service.AssistantIntent `json:"intent"`  // 解析出的意图
+	Images  []model.Image            `json:"images"`  // 本轮查询到的图片（search、refine）
+}
+
+// CreateChatSession 创建一个对话会话
+func (h *Handler) CreateChatSession(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var input struct {
+		Title string `json:"title"`
+	}
+	// 请求体可以为空
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	session := model.ChatSession{
+		UserID: userID,
+		Title:  truncateRunes(strings.TrimSpace(input.Title), 200),
+	}
+	if err := h.DB.Create(&session).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat session"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, session)
+}
+
+// ListChatSessions 列出当前用户的对话会话（最近更新的在前）
+func (h *Handler) ListChatSessions(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var sessions []model.ChatSession
+	if err := h.DB.Where("user_id = ?", userID).Order("updated_at DESC").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chat sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// GetChatSession 获取会话及其全部消息
+func (h *Handler) GetChatSession(c *gin.Context) {
+	session, ok := h.findChatSession(c)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.Where("session_id = ?", session.ID).Order("id ASC").Find(&session.Messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chat messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// DeleteChatSession 删除会话及其全部消息
+func (h *Handler) DeleteChatSession(c *gin.Context) {
+	session, ok := h.findChatSession(c)
+	if !ok {
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", session.ID).Delete(&model.ChatMessage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(session).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete chat session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Chat session deleted", "sessionID": session.ID})
+}
+
+// findChatSession 按 URL 中的 id 查找当前用户的会话，找不到时直接写入错误响应
+func (h *Handler) findChatSession(c *gin.Context) (*model.ChatSession, bool) {
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return nil, false
+	}
+
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var session model.ChatSession
+	if err := h.DB.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat session not found"})
+		return nil, false
+	}
+	return &session, true
+}
+
+// SendChatMessage 向会话发送一条消息：解析意图、执行查询或批量打标签，并生成回复
+// 默认以 Server-Sent Events 流式返回，事件依次为：
+//   - intent：解析出的意图和查询条件
+//   - results：查询到的图片（每批最多 20 张，包含 offset 和 total）
+//   - tagged：批量添加标签的结果
+//   - token：回复的增量文本
+//   - done：保存后的助手消息
+//   - error：出错信息（之后不再发送其他事件）
+//
+// 请求 ?stream=false 时改为一次性返回 JSON
+func (h *Handler) SendChatMessage(c *gin.Context) {
+	session, ok := h.findChatSession(c)
+	if !ok {
+		return
+	}
+	userID := session.UserID
+
+	var input ChatMessageRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.Message = strings.TrimSpace(input.Message)
+	if input.Message == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message cannot be empty"})
+		return
+	}
+	if len([]rune(input.Message)) > maxChatMessageRunes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Message must be at most %d characters", maxChatMessageRunes)})
+		return
+	}
+
+	aiService, err := h.aiService()
+	if err != nil {
+		log.Printf("Failed to create AI service: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "AI service is not available. Please check the AI provider configuration (AI_PROVIDER).",
+		})
+		return
+	}
+
+	// 会话上下文：历史消息、上一轮的查询条件和结果、可用标签
+	assistantContext, err := h.chatContext(session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load chat history"})
+		return
+	}
+
+	userMessage := model.ChatMessage{SessionID: session.ID, Role: "user", Content: input.Message}
+	if err := h.DB.Create(&userMessage).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save message"})
+		return
+	}
+
+	stream := c.Query("stream") != "false"
+	if stream {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no") // 禁止 Nginx 缓冲
+		c.Status(http.StatusOK)
+	}
+	ctx := service.WithUserID(c.Request.Context(), userID)
+	// emit 发送一个 SSE 事件；客户端断开后返回错误以便尽早停止
+	emit := func(event string, data interface{}) error {
+		if !stream {
+			return nil
+		}
+		c.SSEvent(event, data)
+		c.Writer.Flush()
+		return ctx.Err()
+	}
+	fail := func(status int, message string, err error) {
+		log.Printf("Chat session %d: %s: %v", session.ID, message, err)
+		if stream {
+			emit("error", gin.H{"error": message, "details": err.Error()})
+			return
+		}
+		c.JSON(status, gin.H{"error": message, "details": err.Error()})
+	}
+
+	// 1. 解析意图
+	intent, err := aiService.ParseAssistantIntent(ctx, input.Message, assistantContext)
+	if err != nil {
+		fail(aiErrorStatus(err), "Failed to understand the message", err)
+		return
+	}
+	if err := emit("intent", intent); err != nil {
+		return
+	}
+
+	// 2. 执行
+	reply := model.ChatMessage{SessionID: session.ID, Role: "assistant", Action: intent.Action}
+	var images []model.Image
+	var outcome string
+	switch intent.Action {
+	case service.AssistantSearch, service.AssistantRefine:
+		condition := &intent.QueryCondition
+		var restrictTo []uint
+		if intent.Action == service.AssistantRefine {
+			condition = mergeConditions(assistantContext.Previous, condition)
+			restrictTo = session.LastImageIDs
+		}
+
+		images, err = h.chatSearch(userID, condition, intent.Action == service.AssistantRefine, restrictTo)
+		if err != nil {
+			fail(http.StatusInternalServerError, "Failed to fetch images", err)
+			return
+		}
+		for offset := 0; offset < len(images) || offset == 0; offset += chatResultBatch {
+			end := min(offset+chatResultBatch, len(images))
+			if err := emit("results", gin.H{"images": images[offset:end], "offset": offset, "total": len(images)}); err != nil {
+				return
+			}
+		}
+
+		reply.Condition, _ = json.Marshal(condition)
+		reply.ImageIDs = imageIDs(images)
+		session.LastCondition = reply.Condition
+		session.LastImageIDs = reply.ImageIDs
+		outcome = fmt.Sprintf("找到 %d 张图片。查询条件：%s", len(images), reply.Condition)
+		if intent.Action == service.AssistantRefine {
+			outcome = fmt.Sprintf("在上一轮的 %d 张图片中筛选后剩下 %d 张。合并后的查询条件：%s", assistantContext.PreviousCount, len(images), reply.Condition)
+		}
+
+	case service.AssistantTag:
+		tagged := make([]uint, 0, len(session.LastImageIDs))
+		for _, imageID := range session.LastImageIDs {
+			if _, err := h.addTagToImage(userID, imageID, intent.Tag); err != nil {
+				log.Printf("Chat session %d: failed to tag image %d: %v", session.ID, imageID, err)
+				continue
+			}
+			tagged = append(tagged, imageID)
+		}
+		reply.ImageIDs = tagged
+		if err := emit("tagged", gin.H{"tag": intent.Tag, "imageIDs": tagged, "count": len(tagged)}); err != nil {
+			return
+		}
+		if len(session.LastImageIDs) == 0 {
+			outcome = "上一轮没有图片，没有添加标签。"
+		} else {
+			outcome = fmt.Sprintf("已为上一轮的 %d 张图片中的 %d 张添加标签「%s」。", len(session.LastImageIDs), len(tagged), intent.Tag)
+		}
+
+	default:
+		outcome = fmt.Sprintf("无需查询图片。上一轮共有 %d 张图片。", assistantContext.PreviousCount)
+	}
+	reply.ImageCount = len(reply.ImageIDs)
+
+	// 3. 生成回复（流式输出）；失败时用执行结果作为回复，不影响本轮已完成的操作
+	content, err := aiService.StreamAssistantReply(ctx, input.Message, assistantContext, outcome, func(delta string) error {
+		return emit("token", gin.H{"text": delta})
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Chat session %d: failed to generate reply, using outcome instead: %v", session.ID, err)
+		content = outcome
+		emit("token", gin.H{"text": content})
+	}
+	reply.Content = strings.TrimSpace(content)
+
+	// 4. 保存回复并更新会话
+	if err := h.DB.Create(&reply).Error; err != nil {
+		fail(http.StatusInternalServerError, "Failed to save reply", err)
+		return
+	}
+	if session.Title == "" {
+		session.Title = truncateRunes(input.Message, maxChatTitleRunes)
+	}
+	if err := h.DB.Model(session).Select("title", "last_condition", "last_image_ids", "updated_at").Updates(session).Error; err != nil {
+		log.Printf("Chat session %d: failed to update session: %v", session.ID, err)
+	}
+
+	if stream {
+		emit("done", gin.H{"message": reply})
+		return
+	}
+	if images == nil {
+		images = []model.Image{}
+	}
+	c.JSON(http.StatusOK, ChatTurnResponse{Message: &reply, Intent: intent, Images: images})
+}
+
+// chatContext 加载会话的上下文：最近的历史消息、上一轮的查询条件和可用标签
+func (h *Handler) chatContext(session *model.ChatSession) (service.AssistantContext, error) {
+	var messages []model.ChatMessage
+	if err := h.DB.Where("session_id = ?", session.ID).Order("id DESC").Limit(20).Find(&messages).Error; err != nil {
+		return service.AssistantContext{}, err
+	}
+
+	history := make([]service.ChatMessage, 0, len(messages))
+	for i := len(messages) - 1; i >= 0; i-- {
+		history = append(history, service.ChatMessage{Role: messages[i].Role, Content: messages[i].Content})
+	}
+
+	var previous *service.QueryCondition
+	if len(session.LastCondition) > 0 {
+		var condition service.QueryCondition
+		if err := json.Unmarshal(session.LastCondition, &condition); err == nil {
+			previous = &condition
+		}
+	}
+
+	tags, _ := h.usedTags(session.UserID)
+	availableTags := make([]string, len(tags))
+	for i, tag := range tags {
+		availableTags[i] = tag.Name
+	}
+
+	return service.AssistantContext{
+		History:       history,
+		Previous:      previous,
+		PreviousCount: len(session.LastImageIDs),
+		AvailableTags: availableTags,
+	}, nil
+}
+
+// chatSearch 按查询条件查找图片；refine 时只在 restrictTo 中的图片里筛选
+func (h *Handler) chatSearch(userID uint, condition *service.QueryCondition, refine bool, restrictTo []uint) ([]model.Image, error) {
+	var ids []uint
+	if err := h.conditionQuery(userID, condition).Pluck("images.id", &ids).Error; err != nil {
+		return nil, err
+	}
+	if refine {
+		allowed := make(map[uint]bool, len(restrictTo))
+		for _, id := range restrictTo {
+			allowed[id] = true
+		}
+		filtered := ids[:0]
+		for _, id := range ids {
+			if allowed[id] {
+				filtered = append(filtered, id)
+			}
+		}
+		ids = filtered
+	}
+
+	images := make([]model.Image, 0)
+	if len(ids) == 0 {
+		return images, nil
+	}
+	err := h.DB.Preload("Tags").
+		Where("id IN ? AND user_id = ?", ids, userID).
+		Order("created_at DESC").
+		Limit(maxChatResults).
+		Find(&images).Error
+	return images, err
+}
+
+// mergeConditions 合并上一轮和本轮的查询条件：标签和关键词取并集，其他条件以本轮为准
+func mergeConditions(previous, next *service.QueryCondition) *service.QueryCondition {
+	if previous == nil {
+		return next
+	}
+	merged := *previous
+	merged.Tags = appendUnique(append([]string{}, previous.Tags...), next.Tags...)
+	merged.Keywords = appendUnique(append([]string{}, previous.Keywords...), next.Keywords...)
+	merged.ExcludeTags = appendUnique(append([]string{}, previous.ExcludeTags...), next.ExcludeTags...)
+	merged.ExcludeKeywords = appendUnique(append([]string{}, previous.ExcludeKeywords...), next.ExcludeKeywords...)
+	merged.AnyOf = append(append([]service.QueryGroup{}, previous.AnyOf...), next.AnyOf...)
+	// 新的时间条件替换旧的时间条件
+	if next.Month != "" {
+		merged.Month = next.Month
+		merged.Year = ""
+		merged.DateFrom, merged.DateTo = "", ""
+	}
+	if next.Year != "" {
+		merged.Year = next.Year
+		merged.Month = ""
+		merged.DateFrom, merged.DateTo = "", ""
+	}
+	if next.DateFrom != "" || next.DateTo != "" {
+		merged.DateFrom, merged.DateTo = next.DateFrom, next.DateTo
+		merged.Month, merged.Year = "", ""
+	}
+	if next.Camera != "" {
+		merged.Camera = next.Camera
+	}
+	if next.UploadedFrom != "" || next.UploadedTo != "" {
+		merged.UploadedFrom, merged.UploadedTo = next.UploadedFrom, next.UploadedTo
+	}
+	if next.Location != nil {
+		merged.Location = next.Location
+	}
+	if next.Orientation != "" {
+		merged.Orientation = next.Orientation
+	}
+	if next.MinWidth > 0 {
+		merged.MinWidth = next.MinWidth
+	}
+	if next.MinHeight > 0 {
+		merged.MinHeight = next.MinHeight
+	}
+	if next.MaxWidth > 0 {
+		merged.MaxWidth = next.MaxWidth
+	}
+	if next.MaxHeight > 0 {
+		merged.MaxHeight = next.MaxHeight
+	}
+	merged.Reasoning = next.Reasoning
+	return &merged
+}
+
+// appendUnique 追加不重复的元素
+func appendUnique(values []string, more ...string) []string {
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		seen[value] = true
+	}
+	for _, value := range more {
+		if !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// imageIDs 返回图片的 ID 列表
+func imageIDs(images []model.Image) []uint {
+	ids := make([]uint, len(images))
+	for i, image := range images {
+		ids[i] = image.ID
+	}
+	return ids
+}
+
+// truncateRunes 按字符数截断字符串
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+func sortedImageIDs(t *testing.T, h *Handler, userID uint, condition *service.QueryCondition, refine bool, restrictTo []uint) []uint {
+	t.Helper()
+	images, err := h.chatSearch(userID, condition, refine, restrictTo)
+	if err != nil {
+		t.Fatalf("chatSearch: %v", err)
+	}
+	ids := imageIDs(images)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func equalIDs(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestChatSearch(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	bob := createTestUser(t, h, "bob")
+
+	beach := createTestImage(t, h, alice.ID, "beach.jpg", "beach", "sea")
+	sunset := createTestImage(t, h, alice.ID, "sunset.jpg", "beach", "sunset")
+	cat := createTestImage(t, h, alice.ID, "cat.jpg", "cat")
+	createTestImage(t, h, bob.ID, "bob-beach.jpg", "beach")
+
+	tests := []struct {
+		name      string
+		condition service.QueryCondition
+		want      []uint
+	}{
+		{"all images", service.QueryCondition{}, []uint{beach.ID, sunset.ID, cat.ID}},
+		{"tag", service.QueryCondition{Tags: []string{"beach"}}, []uint{beach.ID, sunset.ID}},
+		{"all tags", service.QueryCondition{Tags: []string{"beach", "sea"}}, []uint{beach.ID}},
+		{"keyword", service.QueryCondition{Keywords: []string{"sun"}}, []uint{sunset.ID}},
+		{"exclude tag", service.QueryCondition{ExcludeTags: []string{"sea"}}, []uint{sunset.ID, cat.ID}},
+		{"any of", service.QueryCondition{AnyOf: []service.QueryGroup{{Tags: []string{"sea", "cat"}}}}, []uint{beach.ID, cat.ID}},
+		{"unknown tag", service.QueryCondition{Tags: []string{"mountain"}}, []uint{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sortedImageIDs(t, h, alice.ID, &tt.condition, false, nil)
+			if !equalIDs(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatSearchRefine(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	beach := createTestImage(t, h, alice.ID, "beach.jpg", "beach", "sea")
+	createTestImage(t, h, alice.ID, "sunset.jpg", "beach", "sunset")
+	createTestImage(t, h, alice.ID, "sea.jpg", "sea")
+
+	// 上一轮找到了带 beach 标签的图片，本轮要求 sea：合并后的条件只在上一轮的结果中筛选
+	previous := &service.QueryCondition{Tags: []string{"beach"}}
+	first := sortedImageIDs(t, h, alice.ID, previous, false, nil)
+	merged := mergeConditions(previous, &service.QueryCondition{Tags: []string{"sea"}})
+
+	got := sortedImageIDs(t, h, alice.ID, merged, true, first)
+	if !equalIDs(got, []uint{beach.ID}) {
+		t.Errorf("got %v, want %v", got, []uint{beach.ID})
+	}
+}
+
+func TestSendChatMessageRefineUsesMergedCondition(t *testing.T) {
+	h := newTestHandler(t)
+	mock := service.NewMockProvider()
+	mock.ChatFunc = func(req service.ChatRequest) (string, error) {
+		if strings.Contains(req.Prompt, "用户的最新消息") {
+			return `{"action": "refine", "tags": ["sea"], "reasoning": "only sea"}`, nil
+		}
+		return "ok", nil
+	}
+	h.AI = service.NewAIServiceWithProviders(mock, mock)
+
+	alice := createTestUser(t, h, "alice")
+	beach := createTestImage(t, h, alice.ID, "beach.jpg", "beach", "sea")
+	// 上一轮按 beach 找到了这张图片，之后 beach 标签被移除：合并后的条件不应再匹配它
+	retagged := createTestImage(t, h, alice.ID, "retagged.jpg", "sea")
+	session := model.ChatSession{
+		UserID:        alice.ID,
+		LastCondition: json.RawMessage(`{"tags": ["beach"]}`),
+		LastImageIDs:  []uint{beach.ID, retagged.ID},
+	}
+	if err := h.DB.Create(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	w := performRequest(h.SendChatMessage, http.MethodPost, "/chat/sessions/:id/messages",
+		fmt.Sprintf("/chat/sessions/%d/messages?stream=false", session.ID), alice.ID, gin.H{"message": "只要有海的"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	var resp ChatTurnResponse
+	decodeJSON(t, w, &resp)
+	if got := imageIDs(resp.Images); !equalIDs(got, []uint{beach.ID}) {
+		t.Errorf("got images %v, want %v", got, []uint{beach.ID})
+	}
+	var condition service.QueryCondition
+	if err := json.Unmarshal(resp.Message.Condition, &condition); err != nil {
+		t.Fatal(err)
+	}
+	if len(condition.Tags) != 2 {
+		t.Errorf("saved condition tags %v, want beach and sea", condition.Tags)
+	}
+}
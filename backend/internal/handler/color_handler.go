@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+// 按颜色筛选的默认参数
+const (
+	defaultColorTolerance = 60.0 // 默认的 RGB 距离容差
+	maxColorTolerance     = 442  // RGB 空间中的最大距离（约 255·√3）
+	minColorPercentage    = 5.0  // 匹配的颜色至少占图片的百分比，避免很小的色块也算作匹配
+)
+
+// ColorFilter 按主色调筛选：调色板中有与目标颜色距离不超过 Tolerance 的颜色
+type ColorFilter struct {
+	R, G, B   int
+	Tolerance float64 // RGB 空间中的欧氏距离
+}
+
+// parseColorFilter 解析 #3366ff 格式的颜色和可选的容差，没有颜色时返回 nil
+func parseColorFilter(color string, tolerance *float64) (*ColorFilter, error) {
+	value := strings.TrimPrefix(strings.TrimSpace(color), "#")
+	if value == "" {
+		return nil, nil
+	}
+	if len(value) == 3 {
+		// #36f 简写
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+	rgb, err := strconv.ParseUint(value, 16, 32)
+	if err != nil || len(value) != 6 {
+		return nil, fmt.Errorf("color must be a hex color such as #3366ff")
+	}
+
+	filter := &ColorFilter{R: int(rgb >> 16 & 0xff), G: int(rgb >> 8 & 0xff), B: int(rgb & 0xff), Tolerance: defaultColorTolerance}
+	if tolerance != nil {
+		if *tolerance < 0 || *tolerance > maxColorTolerance {
+			return nil, fmt.Errorf("tolerance must be between 0 and %d", maxColorTolerance)
+		}
+		filter.Tolerance = *tolerance
+	}
+	return filter, nil
+}
+
+// colorQueryFilter 解析列表接口的 color=%233366ff&tolerance=60 查询参数
+func colorQueryFilter(c *gin.Context) (*ColorFilter, error) {
+	var tolerance *float64
+	if value := c.Query("tolerance"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("tolerance must be a number")
+		}
+		tolerance = &parsed
+	}
+	return parseColorFilter(c.Query("color"), tolerance)
+}
+
+// colorFilterQuery 为图片查询添加主色调和黑白筛选条件
+func colorFilterQuery(query *gorm.DB, color *ColorFilter, monochrome *bool) *gorm.DB {
+	if color != nil {
+		query = query.Where("EXISTS (SELECT 1 FROM image_colors WHERE image_colors.image_id = images.id AND image_colors.percentage >= ? AND "+
+			"POW(image_colors.r - ?, 2) + POW(image_colors.g - ?, 2) + POW(image_colors.b - ?, 2) <= ?)",
+			minColorPercentage, color.R, color.G, color.B, color.Tolerance*color.Tolerance)
+	}
+	if monochrome != nil {
+		query = query.Where("images.monochrome = ?", *monochrome)
+	}
+	return query
+}
+
+// indexImageColors 从缩略图中提取图片的主色调并保存（替换之前的调色板）
+func (h *Handler) indexImageColors(image *model.Image) error {
+	path := image.ThumbnailPath
+	if path == "" {
+		path = image.FilePath
+	}
+	img, err := decodeImageFile(path)
+	if err != nil {
+		return err
+	}
+	palette := service.ExtractPalette(img, service.PaletteSize)
+
+	colors := make([]model.ImageColor, len(palette.Colors))
+	for i, color := range palette.Colors {
+		colors[i] = model.ImageColor{
+			ImageID:    image.ID,
+			Hex:        color.Hex(),
+			R:          int(color.R),
+			G:          int(color.G),
+			B:          int(color.B),
+			Percentage: color.Percentage,
+		}
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("image_id = ?", image.ID).Delete(&model.ImageColor{}).Error; err != nil {
+			return err
+		}
+		if len(colors) > 0 {
+			if err := tx.Create(&colors).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&model.Image{}).Where("id = ?", image.ID).Update("monochrome", palette.Monochrome).Error
+	})
+	if err != nil {
+		return err
+	}
+	image.Colors = colors
+	image.Monochrome = palette.Monochrome
+	return nil
+}
+
+// orderByPercentage 预加载主色调时按占比从高到低排列
+func orderByPercentage(db *gorm.DB) *gorm.DB {
+	return db.Order("percentage DESC")
+}
+
+// decodeImageFile 读取并解码图片文件
+func decodeImageFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// ReindexColors 为当前用户还没有调色板的图片提取主色调（后台执行）：POST /images/colors/reindex
+func (h *Handler) ReindexColors(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var images []model.Image
+	if err := h.DB.Where("user_id = ?", userID).
+		Where("NOT EXISTS (SELECT 1 FROM image_colors WHERE image_colors.image_id = images.id)").
+		Order("id ASC").Find(&images).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+		return
+	}
+
+	go func() {
+		indexed, failed := 0, 0
+		for i := range images {
+			if err := h.indexImageColors(&images[i]); err != nil {
+				log.Printf("Failed to extract colors of image %d: %v", images[i].ID, err)
+				failed++
+				continue
+			}
+			indexed++
+		}
+		log.Printf("Reindexed colors for user %d: %d indexed, %d failed", userID, indexed, failed)
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Color extraction started",
+		"queued":  len(images),
+	})
+}
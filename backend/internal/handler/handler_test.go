@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"github.com/Valkqs/image-management-app/backend/internal/database"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestHandler 创建使用独立内存 SQLite 数据库的 Handler，表结构与 MySQL 一样通过 database.Migrate 创建
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(testDialector{sqlite.Open(dsn).(*sqlite.Dialector)}, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1) // 内存数据库只在同一个连接中可见
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return &Handler{DB: db}
+}
+
+// testDialector SQLite 没有 MySQL 的全文索引，迁移时跳过 FULLTEXT 索引，其余与 SQLite 驱动相同
+type testDialector struct {
+	*sqlite.Dialector
+}
+
+func (d testDialector) Migrator(db *gorm.DB) gorm.Migrator {
+	return testMigrator{d.Dialector.Migrator(db).(sqlite.Migrator)}
+}
+
+type testMigrator struct {
+	sqlite.Migrator
+}
+
+func (m testMigrator) CreateIndex(value interface{}, name string) error {
+	fulltext := false
+	m.RunWithValue(value, func(stmt *gorm.Statement) error {
+		if idx := stmt.Schema.LookIndex(name); idx != nil && idx.Class == "FULLTEXT" {
+			fulltext = true
+		}
+		return nil
+	})
+	if fulltext {
+		return nil
+	}
+	return m.Migrator.CreateIndex(value, name)
+}
+
+// createTestUser 创建一个普通用户
+func createTestUser(t *testing.T, h *Handler, username string) model.User {
+	t.Helper()
+	user := model.User{Username: username, Email: username + "@example.com", Password: "x"}
+	if err := h.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+// createTestImage 为用户创建一张带有指定标签的图片
+func createTestImage(t *testing.T, h *Handler, userID uint, filename string, tags ...string) model.Image {
+	t.Helper()
+	image := model.Image{
+		Filename:      filename,
+		FilePath:      "uploads/images/" + filename,
+		ThumbnailPath: "uploads/thumbnails/" + filename,
+		UserID:        userID,
+	}
+	if err := h.DB.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	for _, name := range tags {
+		var tag model.Tag
+		if err := h.DB.FirstOrCreate(&tag, model.Tag{Name: name}).Error; err != nil {
+			t.Fatalf("create tag: %v", err)
+		}
+		if err := h.DB.Model(&image).Association("Tags").Append(&tag); err != nil {
+			t.Fatalf("tag image: %v", err)
+		}
+	}
+	return image
+}
+
+// performRequest 以指定用户的身份调用处理函数，route 为注册的路由（例如 /images/:id），path 为实际请求的路径
+func performRequest(handler gin.HandlerFunc, method, route, path string, userID uint, body interface{}) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) {
+		c.Set("userID", userID)
+		handler(c)
+	})
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// decodeJSON 解析响应体
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+}
+
+// waitFor 等待后台任务完成
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -20,8 +20,10 @@ import (
 	exifcommon "github.com/dsoprea/go-exif/v3/common"
 	"github.com/gin-gonic/gin"
 	"github.com/nfnt/resize"
+	"gorm.io/gorm"
 
 	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
 )
 
 // cleanExifString 清理 EXIF 字符串，移除不可见字符和控制字符
@@ -59,18 +61,47 @@ func (h *Handler) UploadImage(c *gin.Context) {
 	userID_i, _ := c.Get("userID")
 	userID := userID_i.(uint)
 
+	// 读取用户配额和当前用量，用于在解析请求和保存文件前快速拒绝超限的上传
+	var user model.User
+	if err := h.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	quota := effectiveQuota(&user)
+	maxFileSize := maxUploadFileSize()
+	usedCount, usedBytes, err := h.userStorageUsage(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch storage usage"})
+		return
+	}
+
+	// 解析 multipart 表单时整个请求体会被读入内存或临时文件，先按单次请求上限和剩余配额限制请求体的大小
+	if limit := uploadRequestLimit(quota, usedBytes); limit > 0 {
+		if c.Request.ContentLength > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("上传请求超过 %d 字节的上限（单次请求上限或剩余存储配额）", limit), "code": "request_too_large"})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	}
+
 	form, err := c.MultipartForm()
 	if err != nil {
+		if isRequestTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "上传请求超过单次请求上限或剩余存储配额", "code": "request_too_large"})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get form"})
 		return
 	}
 	files := form.File["images"]
-	
-	// 获取 autoAnalyze 参数（是否上传后自动进行AI分析）
-	autoAnalyze := false
+
+	// 获取 autoAnalyze 参数（是否上传后自动进行AI分析），未指定时使用用户偏好
+	prefs := h.getPreferences(userID)
+	autoAnalyze := prefs.AutoAnalyze
 	if values, ok := form.Value["autoAnalyze"]; ok && len(values) > 0 {
 		autoAnalyze = values[0] == "true"
 	}
+	location := preferenceLocation(prefs)
 
 	originalPath := "uploads/images"
 	thumbPath := "uploads/thumbnails"
@@ -80,8 +111,36 @@ func (h *Handler) UploadImage(c *gin.Context) {
 	var successCount int
 	var failedFiles []string
 	var errors []string
+	var fileErrors []gin.H // 每个失败文件的详细信息（文件名、HTTP 状态码、错误码）
+	quotaFailures := 0
+
+	// rejectForQuota 记录因大小限制或配额被拒绝的文件（413）
+	rejectForQuota := func(filename string, quotaErr *QuotaError) {
+		quotaFailures++
+		failedFiles = append(failedFiles, filename)
+		errors = append(errors, fmt.Sprintf("文件 %s：%s", filename, quotaErr.Message))
+		fileErrors = append(fileErrors, gin.H{
+			"filename": filename,
+			"status":   http.StatusRequestEntityTooLarge,
+			"code":     quotaErr.Code,
+			"error":    quotaErr.Message,
+		})
+	}
 
 	for _, file := range files {
+		// 检查单文件大小限制和配额（此处为预检查，写库时会在事务中再次校验）
+		if maxFileSize > 0 && file.Size > maxFileSize {
+			rejectForQuota(file.Filename, &QuotaError{
+				Code:    "file_too_large",
+				Message: fmt.Sprintf("文件大小 %d 字节超过单文件上限 %d 字节", file.Size, maxFileSize),
+			})
+			continue
+		}
+		if quotaErr := checkQuota(quota, usedCount, usedBytes, file.Size); quotaErr != nil {
+			rejectForQuota(file.Filename, quotaErr)
+			continue
+		}
+
 		// 验证文件是否为真正的图片文件
 		// 先打开文件进行验证（不保存到磁盘）
 		openedFile, err := file.Open()
@@ -89,6 +148,7 @@ func (h *Handler) UploadImage(c *gin.Context) {
 			log.Printf("Error opening file %s: %v", file.Filename, err)
 			failedFiles = append(failedFiles, file.Filename)
 			errors = append(errors, fmt.Sprintf("无法打开文件 %s: %v", file.Filename, err))
+			fileErrors = append(fileErrors, gin.H{"filename": file.Filename, "status": http.StatusBadRequest, "code": "unreadable_file", "error": err.Error()})
 			continue
 		}
 
@@ -99,6 +159,7 @@ func (h *Handler) UploadImage(c *gin.Context) {
 			log.Printf("File %s is not a valid image file: %v", file.Filename, err)
 			failedFiles = append(failedFiles, file.Filename)
 			errors = append(errors, fmt.Sprintf("文件 %s 不是有效的图片文件", file.Filename))
+			fileErrors = append(fileErrors, gin.H{"filename": file.Filename, "status": http.StatusBadRequest, "code": "invalid_image", "error": "not a valid image file"})
 			continue
 		}
 
@@ -113,6 +174,7 @@ func (h *Handler) UploadImage(c *gin.Context) {
 			log.Printf("Unsupported image format %s for file %s", format, file.Filename)
 			failedFiles = append(failedFiles, file.Filename)
 			errors = append(errors, fmt.Sprintf("文件 %s 的格式 %s 不受支持，仅支持 JPEG、PNG、GIF 格式", file.Filename, format))
+			fileErrors = append(fileErrors, gin.H{"filename": file.Filename, "status": http.StatusUnsupportedMediaType, "code": "unsupported_format", "error": "unsupported image format: " + format})
 			continue
 		}
 
@@ -145,11 +207,12 @@ func (h *Handler) UploadImage(c *gin.Context) {
 			UserID:   userID,
 			Filename: file.Filename,
 			FilePath: filePath,
+			FileSize: file.Size,
 		}
 		
 		imageData, err := os.ReadFile(filePath)
 		if err == nil {
-			exifInfo, parseErr := parseExif(imageData)
+			exifInfo, parseErr := parseExif(imageData, location)
 			if parseErr == nil {
 				image.CameraMake = exifInfo.CameraMake
 				image.CameraModel = exifInfo.CameraModel
@@ -161,7 +224,7 @@ func (h *Handler) UploadImage(c *gin.Context) {
 			}
 		}
 
-		thumbnailPath, err := generateThumbnail(filePath, thumbPath, newFileName)
+		thumbnailPath, err := generateThumbnail(filePath, thumbPath, newFileName, uint(prefs.ThumbnailSize))
 		if err != nil {
 			log.Printf("Failed to generate thumbnail for %s: %v", file.Filename, err)
 			image.ThumbnailPath = filePath
@@ -170,30 +233,52 @@ func (h *Handler) UploadImage(c *gin.Context) {
 		}
 
 		// 提取图片分辨率
-		if resolution, err := getImageResolution(filePath); err == nil {
-			image.Resolution = resolution
+		if width, height, err := getImageResolution(filePath); err == nil {
+			image.Resolution = fmt.Sprintf("%dx%d", width, height)
+			image.Width, image.Height = width, height
 		} else {
 			log.Printf("Failed to get resolution for %s: %v", file.Filename, err)
 		}
 
-		if result := h.DB.Create(&image); result.Error != nil {
-			log.Printf("Failed to save image info to db for %s: %v", file.Filename, result.Error)
+		if err := h.createImageWithinQuota(&image); err != nil {
+			// 写库失败时清理已保存的文件，避免产生孤立文件占用空间
+			removeImageFiles(&image)
+			if quotaErr, ok := err.(*QuotaError); ok {
+				rejectForQuota(file.Filename, quotaErr)
+				continue
+			}
+			log.Printf("Failed to save image info to db for %s: %v", file.Filename, err)
 			continue
 		}
+		usedCount++
+		usedBytes += image.FileSize
 
-		// 如果启用了自动分析，异步触发 AI 分析（不阻塞上传响应）
+		// 从缩略图中提取主色调，用于按颜色搜索
+		if err := h.indexImageColors(&image); err != nil {
+			log.Printf("Failed to extract colors of %s: %v", file.Filename, err)
+		}
+
+		// 如果启用了自动分析，异步触发 AI 分析（不阻塞上传响应），分析之前会先审核
+		// 否则等待审核的图片单独进行内容审核
 		if autoAnalyze {
-			h.AnalyzeImageAsync(image.ID, filePath)
+			h.AnalyzeImageAsync(image.ID, "upload")
+		} else if image.ModerationStatus == model.ModerationQueued {
+			h.moderateImageAsync(image.ID)
 		}
 		successCount++
 	}
 
 	// 返回处理结果
 	if successCount == 0 && len(failedFiles) > 0 {
-		// 所有文件都失败了
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "所有文件都验证失败",
-			"details": errors,
+		// 所有文件都失败了；如果全部是因为配额或大小限制，返回 413
+		status := http.StatusBadRequest
+		if quotaFailures == len(failedFiles) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.JSON(status, gin.H{
+			"error":      "所有文件都验证失败",
+			"details":    errors,
+			"fileErrors": fileErrors,
 		})
 		return
 	}
@@ -206,32 +291,28 @@ func (h *Handler) UploadImage(c *gin.Context) {
 	if len(failedFiles) > 0 {
 		response["failed"] = failedFiles
 		response["errors"] = errors
+		response["fileErrors"] = fileErrors
 	}
 	c.JSON(http.StatusOK, response)
 }
 
-// getImageResolution 获取图片的分辨率（宽度x高度）
-func getImageResolution(imagePath string) (string, error) {
+// getImageResolution 获取图片的分辨率（宽度和高度，只读取文件头）
+func getImageResolution(imagePath string) (int, int, error) {
 	file, err := os.Open(imagePath)
 	if err != nil {
-		return "", err
+		return 0, 0, err
 	}
 	defer file.Close()
 
-	img, _, err := image.Decode(file)
+	config, _, err := image.DecodeConfig(file)
 	if err != nil {
-		return "", err
+		return 0, 0, err
 	}
-
-	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
-	
-	return fmt.Sprintf("%dx%d", width, height), nil
+	return config.Width, config.Height, nil
 }
 
-// generateThumbnail ... (这个函数保持不变) ...
-func generateThumbnail(srcPath, destDir, newFileName string) (string, error) {
+// generateThumbnail 生成指定宽度的缩略图（高度按比例缩放）
+func generateThumbnail(srcPath, destDir, newFileName string, width uint) (string, error) {
 	file, err := os.Open(srcPath)
 	if err != nil {
 		return "", err
@@ -243,7 +324,7 @@ func generateThumbnail(srcPath, destDir, newFileName string) (string, error) {
 		return "", err
 	}
 
-	thumb := resize.Resize(400, 0, img, resize.Lanczos3)
+	thumb := resize.Resize(width, 0, img, resize.Lanczos3)
 
 	destPath := filepath.Join(destDir, newFileName)
 	out, err := os.Create(destPath)
@@ -274,7 +355,8 @@ func generateThumbnail(srcPath, destDir, newFileName string) (string, error) {
 }
 
 // 【最终修正版】parseExif 函数
-func parseExif(data []byte) (*model.Image, error) {
+// EXIF 拍摄时间不含时区信息，按 loc（用户偏好时区）解释
+func parseExif(data []byte, loc *time.Location) (*model.Image, error) {
 	rawExif, err := exif.SearchAndExtractExif(data)
 	if err != nil {
 		return nil, err
@@ -316,7 +398,7 @@ func parseExif(data []byte) (*model.Image, error) {
 		dtStr = getStringVal("DateTime")
 	}
 	if dtStr != "" {
-		if t, err := time.Parse("2006:01:02 15:04:05", dtStr); err == nil {
+		if t, err := time.ParseInLocation("2006:01:02 15:04:05", dtStr, loc); err == nil {
 			info.TakenAt = &t
 		}
 	}
@@ -335,57 +417,166 @@ func parseExif(data []byte) (*model.Image, error) {
 	return info, nil
 }
 
-// GetUserImages 获取当前用户的图片列表，支持搜索和筛选
-func (h *Handler) GetUserImages(c *gin.Context) {
-	userID_i, _ := c.Get("userID")
-	userID := userID_i.(uint)
-
-	// 获取查询参数
-	tags := c.Query("tags")         // 例如: ?tags=风景,旅行
-	month := c.Query("month")       // 例如: ?month=2025-10
-	camera := c.Query("camera")     // 例如: ?camera=Canon
+// ImageFilter 图片列表的筛选条件，REST 接口和 MCP 工具共用
+type ImageFilter struct {
+	Tags       []string     // 必须同时包含的标签
+	Month      string       // 拍摄月份，格式：2025-10（按用户时区解析）
+	Camera     string       // 相机制造商（模糊匹配）
+	Text       string       // 全文搜索关键词（文件名、描述、替代文本和识别出的文字）
+	Album      *uint        // 所属相册
+	Color      *ColorFilter // 主色调接近指定颜色
+	Monochrome *bool        // 是否基本是黑白的
+}
 
+// filteredImagesQuery 构建当前用户按条件筛选图片的查询（已预加载标签和主色调）
+func (h *Handler) filteredImagesQuery(userID uint, filter ImageFilter) *gorm.DB {
 	// 构建基础查询
-	query := h.DB.Preload("Tags").Where("user_id = ?", userID)
+	// 设置 Model 后调用方既可以 Find 图片，也可以 Pluck 图片ID（全文搜索时见 lexicalImageIDs）
+	query := visibleImages(h.DB.Model(&model.Image{}).Preload("Tags").Preload("Colors", orderByPercentage).Where("user_id = ?", userID))
 
 	// 根据标签筛选
-	if tags != "" {
-		tagNames := strings.Split(tags, ",")
+	tagNames := make([]string, 0, len(filter.Tags))
+	for _, tagName := range filter.Tags {
 		// 清理标签名称（去除空格）
-		for i := range tagNames {
-			tagNames[i] = strings.TrimSpace(tagNames[i])
+		if tagName = strings.TrimSpace(tagName); tagName != "" {
+			tagNames = append(tagNames, tagName)
 		}
-		
-		// 使用子查询来查找包含指定标签的图片
-		// 这里使用 INNER JOIN 来实现多对多关系的查询
-		query = query.Joins("INNER JOIN image_tags ON image_tags.image_id = images.id").
-			Joins("INNER JOIN tags ON tags.id = image_tags.tag_id").
-			Where("tags.name IN ?", tagNames).
-			Group("images.id").
-			Having("COUNT(DISTINCT tags.id) >= ?", len(tagNames))
+	}
+	// 要求图片包含所有指定的标签；同义词解析为规范标签，父标签同时匹配所有子标签
+	for _, tagName := range tagNames {
+		clause, arg := tagMatchClause(h.DB, []string{tagName})
+		query = query.Where(clause, arg)
 	}
 
 	// 根据拍摄月份筛选
-	if month != "" {
+	if filter.Month != "" {
 		// month 格式: 2025-10
 		// 解析月份并构建日期范围查询
-		monthTime, err := time.Parse("2006-01", month)
+		monthTime, err := time.ParseInLocation("2006-01", filter.Month, preferenceLocation(h.getPreferences(userID)))
 		if err == nil {
 			// 计算月份的开始和结束时间
 			startOfMonth := monthTime
 			endOfMonth := monthTime.AddDate(0, 1, 0)
-			
+
 			query = query.Where("taken_at >= ? AND taken_at < ?", startOfMonth, endOfMonth)
 		}
 	}
 
 	// 根据相机制造商筛选
-	if camera != "" {
-		query = query.Where("camera_make LIKE ?", "%"+camera+"%")
+	if filter.Camera != "" {
+		query = query.Where("camera_make LIKE ?", "%"+filter.Camera+"%")
+	}
+
+	// 根据相册筛选
+	if filter.Album != nil {
+		query = query.Where("images.album_id = ?", *filter.Album)
+	}
+
+	// 根据主色调和黑白筛选
+	query = colorFilterQuery(query, filter.Color, filter.Monochrome)
+
+	// 全文搜索：文件名、描述、替代文本和图片中识别出的文字，结果按相关度排序
+	if text := strings.TrimSpace(filter.Text); text != "" {
+		if against, ok := fulltextQuery(text); ok {
+			// 相关度作为额外的查询列，调用方追加的排序条件作为次要排序
+			query = query.Select("images.*, "+fulltextMatch+" AGAINST (? IN BOOLEAN MODE) AS relevance", against).
+				Where(fulltextMatch+" AGAINST (? IN BOOLEAN MODE)", against).
+				Order("relevance DESC")
+		} else {
+			// 过短的关键词无法使用 ngram 全文索引，退回到模糊匹配
+			pattern := "%" + text + "%"
+			query = query.Where("(images.filename LIKE ? OR images.description LIKE ? OR images.alt_text LIKE ? OR images.ocr_text LIKE ?)",
+				pattern, pattern, pattern, pattern)
+		}
+	}
+
+	return query
+}
+
+// lexicalImageIDs 全文搜索命中的图片ID，按相关度从高到低排列
+// 全文搜索的查询带有相关度列（images.*, ... AS relevance），Pluck 会沿用这个 SELECT，因此扫描到只有 ID 的结构体中
+func (h *Handler) lexicalImageIDs(userID uint, filter ImageFilter, limit int) ([]uint, error) {
+	var rows []struct{ ID uint }
+	if err := h.filteredImagesQuery(userID, filter).Limit(limit).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	return ids, nil
+}
+
+// fulltextMatch 与 idx_images_fulltext 全文索引的列保持一致
+const fulltextMatch = "MATCH(images.filename, images.description, images.alt_text, images.ocr_text)"
+
+// fulltextQuery 将用户输入转换为 BOOLEAN MODE 查询：每个词都必须出现（按短语匹配）
+// ngram 解析器的最小词长为 2，包含单字关键词时返回 false
+func fulltextQuery(text string) (string, bool) {
+	terms := strings.Fields(text)
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		// 去掉 BOOLEAN MODE 的操作符，避免用户输入改变查询语义
+		term = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`+-<>()~*"@`, r) {
+				return -1
+			}
+			return r
+		}, term)
+		if term == "" {
+			continue
+		}
+		if len([]rune(term)) < 2 {
+			return "", false
+		}
+		parts = append(parts, `+"`+term+`"`)
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+	return strings.Join(parts, " "), true
+}
+
+// GetUserImages 获取当前用户的图片列表，支持搜索和筛选
+func (h *Handler) GetUserImages(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	// 获取查询参数
+	filter := ImageFilter{
+		Month:  c.Query("month"),  // 例如: ?month=2025-10
+		Camera: c.Query("camera"), // 例如: ?camera=Canon
+		Text:   c.Query("q"),      // 例如: ?q=雪山
+	}
+	if tags := c.Query("tags"); tags != "" { // 例如: ?tags=风景,旅行
+		filter.Tags = strings.Split(tags, ",")
+	}
+	if album := c.Query("album"); album != "" { // 例如: ?album=3
+		albumID, err := strconv.ParseUint(album, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid album ID"})
+			return
+		}
+		id := uint(albumID)
+		filter.Album = &id
+	}
+	color, err := colorQueryFilter(c) // 例如: ?color=%233366ff&tolerance=60
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.Color = color
+	if monochrome := c.Query("monochrome"); monochrome != "" { // 例如: ?monochrome=true（基本是黑白的图片）
+		value, err := strconv.ParseBool(monochrome)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "monochrome must be true or false"})
+			return
+		}
+		filter.Monochrome = &value
 	}
 
 	var images []model.Image
-	result := query.Order("created_at DESC").Find(&images)
+	result := h.filteredImagesQuery(userID, filter).Order("created_at DESC").Find(&images)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
 		return
@@ -397,6 +588,59 @@ func (h *Handler) GetUserImages(c *gin.Context) {
 	})
 }
 
+// UpdateImageDetails 编辑图片的描述和替代文本（只修改请求中提供的字段）
+// 用户编辑后的描述不会再被 AI 分析覆盖；两者都清空时恢复由 AI 生成
+func (h *Handler) UpdateImageDetails(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var input struct {
+		Description *string `json:"description"`
+		AltText     *string `json:"altText"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var image model.Image
+	if err := h.DB.Where("id = ? AND user_id = ?", imageID, userID).First(&image).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found or you don't have permission"})
+		return
+	}
+
+	if input.Description != nil {
+		image.Description = strings.TrimSpace(*input.Description)
+	}
+	if input.AltText != nil {
+		altText := strings.TrimSpace(*input.AltText)
+		if len([]rune(altText)) > service.MaxAltTextLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("altText must be at most %d characters", service.MaxAltTextLength)})
+			return
+		}
+		image.AltText = altText
+	}
+	image.DescriptionSource = "user"
+	if image.Description == "" && image.AltText == "" {
+		image.DescriptionSource = ""
+	}
+
+	if err := h.DB.Model(&image).Select("description", "alt_text", "description_source").Updates(&image).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image"})
+		return
+	}
+	h.indexImageEmbeddingAsync(image.ID)
+
+	h.DB.Preload("Tags").First(&image, image.ID)
+	c.JSON(http.StatusOK, image)
+}
+
 func (h *Handler) GetImageByID(c *gin.Context) {
     imageID_str := c.Param("id")
 	imageID, _ := strconv.Atoi(imageID_str)
@@ -405,7 +649,7 @@ func (h *Handler) GetImageByID(c *gin.Context) {
 
     var image model.Image
     // 【注意】查询时同时预加载标签
-    if err := h.DB.Preload("Tags").Where("id = ? AND user_id = ?", imageID, userID).First(&image).Error; err != nil {
+    if err := h.DB.Preload("Tags").Preload("Colors", orderByPercentage).Preload("Moderation").Where("id = ? AND user_id = ?", imageID, userID).First(&image).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
 		return
 	}
@@ -432,26 +676,17 @@ func (h *Handler) DeleteImage(c *gin.Context) {
 		return
 	}
 
-	// 2. 删除文件系统中的原始图片和缩略图
-	// 删除原始图片
-	if err := os.Remove(image.FilePath); err != nil {
-		log.Printf("Warning: Failed to delete original image file %s: %v", image.FilePath, err)
-		// 不中止操作，继续删除数据库记录
-	}
-
-	// 删除缩略图
-	if image.ThumbnailPath != "" && image.ThumbnailPath != image.FilePath {
-		if err := os.Remove(image.ThumbnailPath); err != nil {
-			log.Printf("Warning: Failed to delete thumbnail file %s: %v", image.ThumbnailPath, err)
-		}
-	}
+	// 2. 删除文件系统中的原始图片和缩略图（失败时不中止操作，继续删除数据库记录）
+	removeImageFiles(&image)
 
 	// 3. 删除数据库中的记录（GORM 会自动处理多对多关系的关联表）
-	// Select("Tags") 确保级联删除关联的标签关系
-	if err := h.DB.Select("Tags").Delete(&image).Error; err != nil {
+	// Select("Tags", "Colors", "Moderation") 确保级联删除关联的标签关系、主色调和审核结果
+	forgetImageCooccurrence(h.DB, &image)
+	if err := h.DB.Select("Tags", "Colors", "Moderation").Delete(&image).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image from database"})
 		return
 	}
+	h.deleteImageEmbeddings(userID, []uint{image.ID})
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Image deleted successfully",
@@ -459,6 +694,19 @@ func (h *Handler) DeleteImage(c *gin.Context) {
 	})
 }
 
+// removeImageFiles 删除图片的原始文件和缩略图，失败时只记录日志
+func removeImageFiles(image *model.Image) {
+	if err := os.Remove(image.FilePath); err != nil {
+		log.Printf("Warning: Failed to delete original image file %s: %v", image.FilePath, err)
+	}
+
+	if image.ThumbnailPath != "" && image.ThumbnailPath != image.FilePath {
+		if err := os.Remove(image.ThumbnailPath); err != nil {
+			log.Printf("Warning: Failed to delete thumbnail file %s: %v", image.ThumbnailPath, err)
+		}
+	}
+}
+
 // DeleteImagesBatch 批量删除图片
 func (h *Handler) DeleteImagesBatch(c *gin.Context) {
 	userID_i, _ := c.Get("userID")
@@ -498,27 +746,19 @@ func (h *Handler) DeleteImagesBatch(c *gin.Context) {
 
 	// 删除每个图片的文件和数据库记录
 	for _, image := range images {
-		// 删除文件系统中的原始图片
-		if err := os.Remove(image.FilePath); err != nil {
-			log.Printf("Warning: Failed to delete original image file %s: %v", image.FilePath, err)
-			// 继续删除，不中止操作
-		}
-
-		// 删除缩略图
-		if image.ThumbnailPath != "" && image.ThumbnailPath != image.FilePath {
-			if err := os.Remove(image.ThumbnailPath); err != nil {
-				log.Printf("Warning: Failed to delete thumbnail file %s: %v", image.ThumbnailPath, err)
-			}
-		}
+		// 删除文件系统中的原始图片和缩略图
+		removeImageFiles(&image)
 
 		// 删除数据库中的记录
-		if err := h.DB.Select("Tags").Delete(&image).Error; err != nil {
+		forgetImageCooccurrence(h.DB, &image)
+		if err := h.DB.Select("Tags", "Colors", "Moderation").Delete(&image).Error; err != nil {
 			log.Printf("Failed to delete image %d from database: %v", image.ID, err)
 			failedCount++
 			failedIDs = append(failedIDs, image.ID)
 			errors = append(errors, fmt.Sprintf("图片 %d 删除失败: %v", image.ID, err))
 		} else {
 			successCount++
+			h.deleteImageEmbeddings(userID, []uint{image.ID})
 		}
 	}
 
@@ -636,10 +876,11 @@ func (h *Handler) EditImage(c *gin.Context) {
 		UserID:   userID,
 		Filename: fmt.Sprintf("%s-edited%s", baseName, extension),
 		FilePath: newFilePath,
+		FileSize: int64(len(decoded)),
 	}
 
 	// 生成缩略图
-	thumbnailPath, err := generateThumbnail(newFilePath, thumbPath, newFileName)
+	thumbnailPath, err := generateThumbnail(newFilePath, thumbPath, newFileName, uint(h.getPreferences(userID).ThumbnailSize))
 	if err != nil {
 		log.Printf("Failed to generate thumbnail: %v", err)
 		newImage.ThumbnailPath = newFilePath
@@ -648,20 +889,30 @@ func (h *Handler) EditImage(c *gin.Context) {
 	}
 
 	// 提取图片分辨率
-	if resolution, err := getImageResolution(newFilePath); err == nil {
-		newImage.Resolution = resolution
+	if width, height, err := getImageResolution(newFilePath); err == nil {
+		newImage.Resolution = fmt.Sprintf("%dx%d", width, height)
+		newImage.Width, newImage.Height = width, height
 	} else {
 		log.Printf("Failed to get resolution: %v", err)
 	}
 
-	// 保存新图片到数据库
-	if err := h.DB.Create(&newImage).Error; err != nil {
+	// 保存新图片到数据库（编辑后的图片同样计入配额）
+	if err := h.createImageWithinQuota(&newImage); err != nil {
+		removeImageFiles(&newImage)
+		if quotaErr, ok := err.(*QuotaError); ok {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": quotaErr.Message, "code": quotaErr.Code})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save new image to database"})
 		return
 	}
 
+	if err := h.indexImageColors(&newImage); err != nil {
+		log.Printf("Failed to extract colors of edited image %d: %v", newImage.ID, err)
+	}
+
 	// 异步触发 AI 分析（不阻塞响应）
-	h.AnalyzeImageAsync(newImage.ID, newFilePath)
+	h.AnalyzeImageAsync(newImage.ID, "edit")
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Image saved as new file successfully",
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+func TestUpdateImageDetailsAltTextLength(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	image := createTestImage(t, h, alice.ID, "a.jpg")
+	path := fmt.Sprintf("/images/%d", image.ID)
+
+	tests := []struct {
+		name     string
+		altText  string
+		wantCode int
+	}{
+		{"at the limit", strings.Repeat("海", service.MaxAltTextLength), http.StatusOK},
+		{"over the limit", strings.Repeat("海", service.MaxAltTextLength+1), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := performRequest(h.UpdateImageDetails, http.MethodPatch, "/images/:id", path, alice.ID, gin.H{"altText": tt.altText})
+			if w.Code != tt.wantCode {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestFulltextQuery(t *testing.T) {
+	tests := []struct {
+		text   string
+		want   string
+		wantOK bool
+	}{
+		{"雪山", `+"雪山"`, true},
+		{"雪山  日落", `+"雪山" +"日落"`, true},
+		{`+sunset -beach "sky"*`, `+"sunset" +"beach" +"sky"`, true},
+		{"雪山 海", "", false},  // 单字关键词无法使用 ngram 全文索引
+		{"-雪 山", "", false},
+		{`"" ()`, "", false},
+		{"   ", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := fulltextQuery(tt.text)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("fulltextQuery(%q) = %q, %v; want %q, %v", tt.text, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestFilteredImagesQuery(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	bob := createTestUser(t, h, "bob")
+
+	taken := time.Date(2025, 10, 15, 12, 0, 0, 0, time.UTC)
+	album := model.Album{UserID: alice.ID, Name: "trip"}
+	h.DB.Create(&album)
+	canon := createTestImage(t, h, alice.ID, "sunset.jpg", "beach", "sea")
+	h.DB.Model(&canon).Updates(map[string]interface{}{"camera_make": "Canon", "taken_at": taken, "album_id": album.ID})
+	nikon := createTestImage(t, h, alice.ID, "mountain.jpg", "beach")
+	h.DB.Model(&nikon).Updates(map[string]interface{}{"camera_make": "NIKON", "taken_at": taken.AddDate(0, 1, 0), "monochrome": true, "description": "雪山日出"})
+	hidden := createTestImage(t, h, alice.ID, "hidden.jpg", "beach")
+	h.DB.Model(&hidden).Update("moderation_status", model.ModerationQuarantined)
+	createTestImage(t, h, bob.ID, "bob.jpg", "beach")
+
+	monochrome := true
+	tests := []struct {
+		name   string
+		filter ImageFilter
+		want   []uint
+	}{
+		{"all visible images", ImageFilter{}, []uint{canon.ID, nikon.ID}},
+		{"all tags", ImageFilter{Tags: []string{"beach", " sea "}}, []uint{canon.ID}},
+		{"month", ImageFilter{Month: "2025-10"}, []uint{canon.ID}},
+		{"invalid month is ignored", ImageFilter{Month: "October"}, []uint{canon.ID, nikon.ID}},
+		{"camera", ImageFilter{Camera: "nik"}, []uint{nikon.ID}},
+		{"album", ImageFilter{Album: &album.ID}, []uint{canon.ID}},
+		{"monochrome", ImageFilter{Monochrome: &monochrome}, []uint{nikon.ID}},
+		{"short keyword", ImageFilter{Text: "雪"}, []uint{nikon.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var images []model.Image
+			if err := h.filteredImagesQuery(alice.ID, tt.filter).Order("id ASC").Find(&images).Error; err != nil {
+				t.Fatal(err)
+			}
+			if got := imageIDs(images); !equalIDs(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// mysqlDryRun 返回只生成 SQL、不连接数据库的 MySQL 会话，用于检查 SQLite 不支持的查询（全文索引、POW）
+func mysqlDryRun(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "user:pass@tcp(127.0.0.1:1)/db", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestFilteredImagesQueryFulltextSQL(t *testing.T) {
+	h := &Handler{DB: mysqlDryRun(t)}
+	color := &ColorFilter{R: 255, G: 0, B: 0, Tolerance: 60}
+
+	stmt := h.filteredImagesQuery(1, ImageFilter{Text: "雪山 日落", Color: color}).Find(&[]model.Image{}).Statement
+	sql := stmt.SQL.String()
+	for _, want := range []string{
+		"SELECT images.*, " + fulltextMatch + " AGAINST (? IN BOOLEAN MODE) AS relevance",
+		"AND " + fulltextMatch + " AGAINST (? IN BOOLEAN MODE)",
+		"ORDER BY relevance DESC",
+		"POW(image_colors.r - ?, 2)",
+		"images.moderation_status NOT IN",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("SQL does not contain %q:\n%s", want, sql)
+		}
+	}
+	if !slices.Contains(stmt.Vars, interface{}(`+"雪山" +"日落"`)) {
+		t.Errorf("fulltext query not bound: %v", stmt.Vars)
+	}
+
+	// 只取 ID 时扫描到结构体，相关度列不会导致 Pluck 出错
+	stmt = h.filteredImagesQuery(1, ImageFilter{Text: "雪山"}).Limit(5).Scan(&[]struct{ ID uint }{}).Statement
+	if sql := stmt.SQL.String(); !strings.Contains(sql, "FROM `images`") || !strings.Contains(sql, "LIMIT ?") {
+		t.Errorf("unexpected lexical ID query:\n%s", sql)
+	}
+}
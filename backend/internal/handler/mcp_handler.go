@@ -2,11 +2,13 @@ package handler
 
 import (
 	"log"
+	"math"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"github.com/Valkqs/image-management-app/backend/internal/model"
 	"github.com/Valkqs/image-management-app/backend/internal/service"
 )
@@ -42,24 +44,17 @@ func (h *Handler) MCPQuery(c *gin.Context) {
 	}
 
 	// 创建 AI 服务
-	aiService, err := service.NewAIService()
+	aiService, err := h.aiService()
 	if err != nil {
 		log.Printf("Failed to create AI service: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "AI service is not available. Please check MODELSCOPE_ACCESS_TOKEN environment variable.",
+			"error": "AI service is not available. Please check the AI provider configuration (AI_PROVIDER).",
 		})
 		return
 	}
 
 	// 获取用户可用的标签列表（用于帮助AI理解上下文）
-	var tags []model.Tag
-	h.DB.
-		Joins("JOIN image_tags ON image_tags.tag_id = tags.id").
-		Joins("JOIN images ON images.id = image_tags.image_id").
-		Where("images.user_id = ?", userID).
-		Group("tags.id").
-		Order("tags.name ASC").
-		Find(&tags)
+	tags, _ := h.usedTags(userID)
 
 	availableTags := make([]string, len(tags))
 	for i, tag := range tags {
@@ -67,12 +62,12 @@ func (h *Handler) MCPQuery(c *gin.Context) {
 	}
 
 	// 使用AI解析自然语言查询
-	condition, err := aiService.ParseNaturalLanguageQuery(input.Query, availableTags)
+	condition, err := aiService.ParseNaturalLanguageQuery(service.WithUserID(c.Request.Context(), userID), input.Query, availableTags, h.getPreferences(userID).AILanguage)
 	if err != nil {
 		log.Printf("Failed to parse natural language query: %v", err)
 		// 返回详细的错误信息，帮助调试
 		errorMsg := err.Error()
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(aiErrorStatus(err), gin.H{
 			"error":   "Failed to parse query",
 			"details": errorMsg,
 			"message": "AI服务解析查询失败，请检查网络连接和API配置",
@@ -81,103 +76,187 @@ func (h *Handler) MCPQuery(c *gin.Context) {
 	}
 
 	// 根据解析的条件查询图片
-	query := h.DB.Preload("Tags").Where("user_id = ?", userID)
+	query := h.conditionQuery(userID, condition)
 
-	hasTagFilter := len(condition.Tags) > 0
-	hasKeywordFilter := len(condition.Keywords) > 0
-	needsTagJoin := hasTagFilter || hasKeywordFilter
+	var images []model.Image
+	result := query.Order("images.created_at DESC").Find(&images)
+	if result.Error != nil {
+		log.Printf("Failed to query images: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+		return
+	}
+	
+	log.Printf("Query result: Found %d images matching conditions (tags=%v, anyOf=%v, month=%s, camera=%s, keywords=%v)", 
+		len(images), condition.Tags, condition.AnyOf, condition.Month, condition.Camera, condition.Keywords)
 
-	// 如果需要标签相关的筛选，先JOIN tags表
-	if needsTagJoin {
-		query = query.Joins("INNER JOIN image_tags ON image_tags.image_id = images.id").
-			Joins("INNER JOIN tags ON tags.id = image_tags.tag_id")
+	// 构建响应消息
+	message := "查询完成"
+	if condition.Reasoning != "" {
+		message = condition.Reasoning
 	}
 
-	// 根据标签筛选
-	if hasTagFilter {
-		// 清理标签名称（去除空格，确保匹配）
-		tagNames := make([]string, len(condition.Tags))
-		for i, tag := range condition.Tags {
-			tagNames[i] = strings.TrimSpace(tag)
-		}
-		
-		log.Printf("Filtering images by tags: %v", tagNames)
-		
-		// 要求图片必须包含所有指定的标签
-		// 使用子查询来确保图片包含所有指定的标签
-		query = query.Where("tags.name IN ?", tagNames).
-			Group("images.id").
-			Having("COUNT(DISTINCT tags.id) >= ?", len(tagNames))
-		
-		log.Printf("Applied tag filter: tags must be in %v", tagNames)
-	}
-
-	// 根据关键词筛选（在标签中搜索）
-	if hasKeywordFilter {
-		// 构建关键词查询条件（在标签名称中搜索）
-		keywordConditions := make([]string, len(condition.Keywords))
-		args := make([]interface{}, 0)
-		for i, keyword := range condition.Keywords {
-			keywordConditions[i] = "tags.name LIKE ?"
-			args = append(args, "%"+keyword+"%")
+	response := MCPQueryResponse{
+		Images:    images,
+		Count:     len(images),
+		Condition: condition,
+		Message:   message,
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// conditionQuery 根据 AI 解析出的查询条件构建当前用户的图片查询（预加载标签）
+// 所有条件之间为"且"：tags 必须全部带有，keywords 匹配任意一个，anyOf 每组匹配任意一个；
+// 标签相关的条件都用 EXISTS 子查询表达，不需要 JOIN 和 GROUP BY，也不会和 user_id 条件混成"或"
+func (h *Handler) conditionQuery(userID uint, condition *service.QueryCondition) *gorm.DB {
+	// 设置 Model 后调用方既可以 Find 图片，也可以 Pluck 图片ID
+	query := visibleImages(h.DB.Model(&model.Image{}).Preload("Tags").Where("images.user_id = ?", userID))
+	loc := preferenceLocation(h.getPreferences(userID))
+
+	// 根据标签筛选：要求图片包含所有指定的标签
+	// 同义词解析为规范标签，父标签同时匹配所有子标签
+	for _, tag := range condition.Tags {
+		clause, arg := tagMatchClause(h.DB, []string{strings.TrimSpace(tag)})
+		query = query.Where(clause, arg)
+	}
+
+	// 根据关键词筛选（在标签中搜索），匹配任意一个关键词即可
+	if len(condition.Keywords) > 0 {
+		clause, args := keywordClause(condition.Keywords)
+		query = query.Where(tagExists(clause), args...)
+	}
+
+	// "或"条件组：每组中任意一个标签或关键词匹配即可
+	for _, group := range condition.AnyOf {
+		clauses := make([]string, 0, 2)
+		args := make([]interface{}, 0, len(group.Keywords)+1)
+		if len(group.Tags) > 0 {
+			clause, arg := tagMatchClause(h.DB, group.Tags)
+			clauses = append(clauses, clause)
+			args = append(args, arg)
 		}
-		
-		// 添加关键词条件（使用OR连接）
-		keywordQuery := "(" + strings.Join(keywordConditions, " OR ") + ")"
-		
-		if hasTagFilter {
-			// 如果同时有标签和关键词，使用OR连接（图片匹配标签或关键词）
-			query = query.Or(keywordQuery, args...)
-		} else {
-			// 如果只有关键词，直接添加WHERE条件
-			query = query.Where(keywordQuery, args...)
+		if len(group.Keywords) > 0 {
+			clause, keywordArgs := keywordClause(group.Keywords)
+			clauses = append(clauses, tagExists(clause))
+			args = append(args, keywordArgs...)
 		}
-		
-		// 确保去重
-		if !hasTagFilter {
-			query = query.Group("images.id")
+		if len(clauses) > 0 {
+			query = query.Where("("+strings.Join(clauses, " OR ")+")", args...)
 		}
 	}
 
+	// 排除带有指定标签或标签中包含指定关键词的图片
+	if len(condition.ExcludeTags) > 0 {
+		clause, arg := tagMatchClause(h.DB, condition.ExcludeTags)
+		query = query.Where("NOT "+clause, arg)
+	}
+	if len(condition.ExcludeKeywords) > 0 {
+		clause, args := keywordClause(condition.ExcludeKeywords)
+		query = query.Where("NOT "+tagExists(clause), args...)
+	}
+
 	// 根据月份筛选
 	if condition.Month != "" {
-		monthTime, err := time.Parse("2006-01", condition.Month)
+		monthTime, err := time.ParseInLocation("2006-01", condition.Month, loc)
 		if err == nil {
-			startOfMonth := monthTime
-			endOfMonth := monthTime.AddDate(0, 1, 0)
-			query = query.Where("taken_at >= ? AND taken_at < ?", startOfMonth, endOfMonth)
+			query = query.Where("images.taken_at >= ? AND images.taken_at < ?", monthTime, monthTime.AddDate(0, 1, 0))
 		}
 	}
 
+	// 只提到年份时按整年筛选
+	if condition.Month == "" && condition.Year != "" {
+		yearTime, err := time.ParseInLocation("2006", condition.Year, loc)
+		if err == nil {
+			query = query.Where("images.taken_at >= ? AND images.taken_at < ?", yearTime, yearTime.AddDate(1, 0, 0))
+		}
+	}
+
+	// 根据拍摄日期和上传日期范围筛选，只有一端时为开放范围
+	query = dateRangeQuery(query, "images.taken_at", condition.DateFrom, condition.DateTo, loc)
+	query = dateRangeQuery(query, "images.created_at", condition.UploadedFrom, condition.UploadedTo, loc)
+
 	// 根据相机制造商筛选
 	if condition.Camera != "" {
-		query = query.Where("camera_make LIKE ?", "%"+condition.Camera+"%")
+		query = query.Where("images.camera_make LIKE ?", "%"+condition.Camera+"%")
 	}
 
-	var images []model.Image
-	result := query.Order("created_at DESC").Find(&images)
-	if result.Error != nil {
-		log.Printf("Failed to query images: %v", result.Error)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
-		return
+	// 根据拍摄地点筛选：有范围框时按范围框，有坐标时按半径换算的经纬度范围，否则在描述和 OCR 文本中搜索地名
+	if location := condition.Location; location != nil {
+		switch {
+		case location.Bounds != nil:
+			bounds := location.Bounds
+			query = query.Where("images.latitude BETWEEN ? AND ?", bounds.South, bounds.North)
+			if bounds.West <= bounds.East {
+				query = query.Where("images.longitude BETWEEN ? AND ?", bounds.West, bounds.East)
+			} else {
+				// 跨越 180 度经线的范围
+				query = query.Where("(images.longitude >= ? OR images.longitude <= ?)", bounds.West, bounds.East)
+			}
+		case location.Latitude != nil && location.Longitude != nil:
+			lat, lon := *location.Latitude, *location.Longitude
+			latDelta := location.RadiusKm / 111.0
+			lonDelta := location.RadiusKm / (111.0 * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+			query = query.Where("images.latitude BETWEEN ? AND ? AND images.longitude BETWEEN ? AND ?",
+				lat-latDelta, lat+latDelta, lon-lonDelta, lon+lonDelta)
+		case location.Name != "":
+			pattern := "%" + location.Name + "%"
+			query = query.Where("(images.description LIKE ? OR images.alt_text LIKE ? OR images.ocr_text LIKE ?)", pattern, pattern, pattern)
+		}
 	}
-	
-	log.Printf("Query result: Found %d images matching conditions (tags=%v, month=%s, camera=%s, keywords=%v)", 
-		len(images), condition.Tags, condition.Month, condition.Camera, condition.Keywords)
 
-	// 构建响应消息
-	message := "查询完成"
-	if condition.Reasoning != "" {
-		message = condition.Reasoning
+	// 根据画面方向和尺寸筛选（宽高未知的图片不参与这些条件）
+	switch condition.Orientation {
+	case service.OrientationLandscape:
+		query = query.Where("images.width > images.height AND images.height > 0")
+	case service.OrientationPortrait:
+		query = query.Where("images.height > images.width AND images.width > 0")
+	case service.OrientationSquare:
+		query = query.Where("images.width = images.height AND images.width > 0")
 	}
-
-	response := MCPQueryResponse{
-		Images:    images,
-		Count:     len(images),
-		Condition: condition,
-		Message:   message,
+	if condition.MinWidth > 0 {
+		query = query.Where("images.width >= ?", condition.MinWidth)
+	}
+	if condition.MinHeight > 0 {
+		query = query.Where("images.height >= ?", condition.MinHeight)
+	}
+	if condition.MaxWidth > 0 {
+		query = query.Where("images.width > 0 AND images.width <= ?", condition.MaxWidth)
+	}
+	if condition.MaxHeight > 0 {
+		query = query.Where("images.height > 0 AND images.height <= ?", condition.MaxHeight)
 	}
 
-	c.JSON(http.StatusOK, response)
+	return query
+}
+
+// tagExists 图片带有满足 clause 条件的标签（clause 中用 tags 表示标签表）
+func tagExists(clause string) string {
+	return "EXISTS (SELECT 1 FROM image_tags JOIN tags ON tags.id = image_tags.tag_id AND tags.deleted_at IS NULL " +
+		"WHERE image_tags.image_id = images.id AND " + clause + ")"
 }
 
+// keywordClause 标签名称包含任意一个关键词的条件
+func keywordClause(keywords []string) (string, []interface{}) {
+	conditions := make([]string, len(keywords))
+	args := make([]interface{}, len(keywords))
+	for i, keyword := range keywords {
+		conditions[i] = "tags.name LIKE ?"
+		args[i] = "%" + keyword + "%"
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// dateRangeQuery 按 YYYY-MM-DD 日期范围筛选 column（to 当天包含在内），格式不对的一端忽略
+func dateRangeQuery(query *gorm.DB, column, from, to string, loc *time.Location) *gorm.DB {
+	if from != "" {
+		if fromDate, err := time.ParseInLocation("2006-01-02", from, loc); err == nil {
+			query = query.Where(column+" >= ?", fromDate)
+		}
+	}
+	if to != "" {
+		if toDate, err := time.ParseInLocation("2006-01-02", to, loc); err == nil {
+			query = query.Where(column+" < ?", toDate.AddDate(0, 0, 1))
+		}
+	}
+	return query
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+func TestConditionQuery(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	bob := createTestUser(t, h, "bob")
+
+	day := func(month time.Month, d int) time.Time { return time.Date(2025, month, d, 12, 0, 0, 0, time.Local) }
+	shanghaiLat, shanghaiLng := 31.23, 121.47
+	tokyoLat, tokyoLng := 35.68, 139.69
+
+	beach := createTestImage(t, h, alice.ID, "beach.jpg", "海滩", "日落")
+	h.DB.Model(&beach).Updates(map[string]interface{}{"taken_at": day(time.March, 10), "width": 4000, "height": 3000, "latitude": shanghaiLat, "longitude": shanghaiLng})
+	portrait := createTestImage(t, h, alice.ID, "portrait.jpg", "人物", "日落")
+	h.DB.Model(&portrait).Updates(map[string]interface{}{"taken_at": day(time.March, 20), "width": 1000, "height": 1500, "latitude": tokyoLat, "longitude": tokyoLng})
+	cat := createTestImage(t, h, alice.ID, "cat.jpg", "猫咪")
+	h.DB.Model(&cat).Updates(map[string]interface{}{"taken_at": day(time.April, 1), "width": 800, "height": 800, "description": "外滩边的猫"})
+	createTestImage(t, h, alice.ID, "unknown.jpg") // 没有拍摄时间和尺寸
+	createTestImage(t, h, bob.ID, "bob.jpg", "海滩", "日落")
+
+	tests := []struct {
+		name      string
+		condition service.QueryCondition
+		want      []uint
+	}{
+		{"all tags", service.QueryCondition{Tags: []string{"日落", "海滩"}}, []uint{beach.ID}},
+		{"exclude tags", service.QueryCondition{Tags: []string{"日落"}, ExcludeTags: []string{"人物"}}, []uint{beach.ID}},
+		{"keywords", service.QueryCondition{Keywords: []string{"猫"}}, []uint{cat.ID}},
+		{"exclude keywords", service.QueryCondition{Keywords: []string{"日", "猫"}, ExcludeKeywords: []string{"人"}}, []uint{beach.ID, cat.ID}},
+		{"any of", service.QueryCondition{AnyOf: []service.QueryGroup{{Tags: []string{"人物"}, Keywords: []string{"猫"}}}}, []uint{portrait.ID, cat.ID}},
+		{"month", service.QueryCondition{Month: "2025-03"}, []uint{beach.ID, portrait.ID}},
+		{"year", service.QueryCondition{Year: "2025"}, []uint{beach.ID, portrait.ID, cat.ID}},
+		{"date range includes the last day", service.QueryCondition{DateFrom: "2025-03-15", DateTo: "2025-04-01"}, []uint{portrait.ID, cat.ID}},
+		{"open date range", service.QueryCondition{DateTo: "2025-03-10"}, []uint{beach.ID}},
+		{"landscape", service.QueryCondition{Orientation: service.OrientationLandscape}, []uint{beach.ID}},
+		{"portrait", service.QueryCondition{Orientation: service.OrientationPortrait}, []uint{portrait.ID}},
+		{"square", service.QueryCondition{Orientation: service.OrientationSquare}, []uint{cat.ID}},
+		{"min width", service.QueryCondition{MinWidth: 1000}, []uint{beach.ID, portrait.ID}},
+		{"max height skips unknown sizes", service.QueryCondition{MaxHeight: 1000}, []uint{cat.ID}},
+		{"location radius", service.QueryCondition{Location: &service.QueryLocation{Latitude: &shanghaiLat, Longitude: &shanghaiLng, RadiusKm: 50}}, []uint{beach.ID}},
+		{"location bounds", service.QueryCondition{Location: &service.QueryLocation{Bounds: &service.QueryBounds{South: 30, West: 130, North: 40, East: 145}}}, []uint{portrait.ID}},
+		{"location bounds across 180", service.QueryCondition{Location: &service.QueryLocation{Bounds: &service.QueryBounds{South: 30, West: 139, North: 40, East: -170}}}, []uint{portrait.ID}},
+		{"location name", service.QueryCondition{Location: &service.QueryLocation{Name: "外滩"}}, []uint{cat.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var images []model.Image
+			if err := h.conditionQuery(alice.ID, &tt.condition).Order("images.id ASC").Find(&images).Error; err != nil {
+				t.Fatal(err)
+			}
+			if got := imageIDs(images); !equalIDs(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
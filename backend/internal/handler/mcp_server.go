@@ -0,0 +1,493 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/Valkqs/image-management-app/backend/internal/mcp"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+	"github.com/Valkqs/image-management-app/backend/internal/utils"
+)
+
+// MCP 工具和资源的限制
+const (
+	mcpDefaultSearchLimit = 20
+	mcpMaxSearchLimit     = 100
+	mcpResourcePageSize   = 50
+	mcpMaxRequestBytes    = 4 << 20
+)
+
+// mcpImage 返回给 MCP 客户端的图片摘要
+type mcpImage struct {
+	ID           uint       `json:"id"`
+	Filename     string     `json:"filename"`
+	TakenAt      *time.Time `json:"takenAt,omitempty"`
+	UploadedAt   time.Time  `json:"uploadedAt"`
+	CameraMake   string     `json:"cameraMake,omitempty"`
+	CameraModel  string     `json:"cameraModel,omitempty"`
+	Resolution   string     `json:"resolution,omitempty"`
+	Latitude     *float64   `json:"latitude,omitempty"`
+	Longitude    *float64   `json:"longitude,omitempty"`
+	Description  string     `json:"description,omitempty"`
+	AltText      string     `json:"altText,omitempty"`
+	Text         string     `json:"text,omitempty"` // 图片中识别出的文字
+	Tags         []string   `json:"tags"`
+	ThumbnailURI string     `json:"thumbnailURI"`
+}
+
+// thumbnailURI 返回图片缩略图的 MCP 资源地址
+func thumbnailURI(imageID uint) string {
+	return fmt.Sprintf("image://%d/thumbnail", imageID)
+}
+
+func toMCPImage(image *model.Image) mcpImage {
+	tags := make([]string, 0, len(image.Tags))
+	for _, tag := range image.Tags {
+		tags = append(tags, tag.Name)
+	}
+	return mcpImage{
+		ID:           image.ID,
+		Filename:     image.Filename,
+		TakenAt:      image.TakenAt,
+		UploadedAt:   image.CreatedAt,
+		CameraMake:   image.CameraMake,
+		CameraModel:  image.CameraModel,
+		Resolution:   image.Resolution,
+		Latitude:     image.Latitude,
+		Longitude:    image.Longitude,
+		Description:  image.Description,
+		AltText:      image.AltText,
+		Text:         image.OCRText,
+		Tags:         tags,
+		ThumbnailURI: thumbnailURI(image.ID),
+	}
+}
+
+// imageMIMEType 根据文件扩展名推断图片的 MIME 类型
+func imageMIMEType(path string) string {
+	if mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path))); strings.HasPrefix(mimeType, "image/") {
+		return mimeType
+	}
+	return "image/jpeg"
+}
+
+// decodeToolArgs 解析工具参数，拒绝未知字段，便于模型发现参数名写错
+func decodeToolArgs(args json.RawMessage, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(string(args)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+// NewMCPServer 创建暴露图片库的 MCP 服务端，HTTP 接口和 stdio 命令共用
+func (h *Handler) NewMCPServer() *mcp.Server {
+	server := mcp.NewServer("image-management-app", "1.0.0",
+		"Tools for browsing and organizing the user's personal photo library. "+
+			"Use search_images to find photos by tag, month, camera, dominant color or text (descriptions and text inside images such as screenshots or receipts), get_image for details, "+
+			"list_tags to see the tag vocabulary before tagging, add_tag to organize photos and "+
+			"analyze_image to let the vision model suggest tags. Thumbnails are available as image://{id}/thumbnail resources.")
+
+	server.AddTool(mcp.Tool{
+		Name:        "search_images",
+		Description: "Search the user's images. All filters are optional and combined with AND; tags must all be present on an image. Results are ordered by upload time, newest first.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tags":            map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": "Exact tag names the image must have (see list_tags)"},
+				"month":           map[string]interface{}{"type": "string", "description": "Month the photo was taken, formatted YYYY-MM"},
+				"camera":          map[string]interface{}{"type": "string", "description": "Camera manufacturer, partial match (e.g. Canon)"},
+				"text":            map[string]interface{}{"type": "string", "description": "Full-text search over filename, description, alt text and text extracted from the image (OCR)"},
+				"color":           map[string]interface{}{"type": "string", "description": "Hex color (e.g. #3366ff) that should be one of the image's dominant colors"},
+				"color_tolerance": map[string]interface{}{"type": "number", "minimum": 0, "maximum": maxColorTolerance, "description": "Maximum RGB distance from color (default 60)"},
+				"monochrome":      map[string]interface{}{"type": "boolean", "description": "Only mostly black-and-white images (true) or only color images (false)"},
+				"limit":           map[string]interface{}{"type": "integer", "minimum": 1, "maximum": mcpMaxSearchLimit, "description": "Maximum number of results (default 20)"},
+				"offset":          map[string]interface{}{"type": "integer", "minimum": 0, "description": "Number of results to skip, for paging"},
+			},
+			"additionalProperties": false,
+		},
+		Handler: h.mcpSearchImages,
+	})
+
+	server.AddTool(mcp.Tool{
+		Name:        "get_image",
+		Description: "Get the metadata and tags of one image. Set include_thumbnail to also receive the thumbnail picture.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"image_id":          map[string]interface{}{"type": "integer", "description": "Image ID"},
+				"include_thumbnail": map[string]interface{}{"type": "boolean", "description": "Include the thumbnail as image content"},
+			},
+			"required":             []string{"image_id"},
+			"additionalProperties": false,
+		},
+		Handler: h.mcpGetImage,
+	})
+
+	server.AddTool(mcp.Tool{
+		Name:        "add_tag",
+		Description: "Add a tag to one of the user's images. The tag is created if it does not exist yet; prefer existing tags from list_tags.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"image_id": map[string]interface{}{"type": "integer", "description": "Image ID"},
+				"tag":      map[string]interface{}{"type": "string", "description": "Tag name"},
+			},
+			"required":             []string{"image_id", "tag"},
+			"additionalProperties": false,
+		},
+		Handler: h.mcpAddTag,
+	})
+
+	server.AddTool(mcp.Tool{
+		Name:        "list_tags",
+		Description: "List all tags used on the user's images, with the number of images carrying each tag.",
+		InputSchema: map[string]interface{}{
+			"type":                 "object",
+			"properties":           map[string]interface{}{},
+			"additionalProperties": false,
+		},
+		Handler: h.mcpListTags,
+	})
+
+	server.AddTool(mcp.Tool{
+		Name:        "analyze_image",
+		Description: "Run the vision model on an image and attach the suggested tags to it. This can take up to a minute.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"image_id": map[string]interface{}{"type": "integer", "description": "Image ID"},
+			},
+			"required":             []string{"image_id"},
+			"additionalProperties": false,
+		},
+		Handler: h.mcpAnalyzeImage,
+	})
+
+	server.SetResources([]mcp.ResourceTemplate{{
+		URITemplate: "image://{id}/thumbnail",
+		Name:        "Image thumbnail",
+		Description: "Thumbnail picture of an image in the library",
+	}}, h.mcpListThumbnails, h.mcpReadThumbnail)
+
+	return server
+}
+
+func (h *Handler) mcpSearchImages(ctx context.Context, userID uint, args json.RawMessage) (*mcp.ToolResult, error) {
+	var input struct {
+		Tags           []string `json:"tags"`
+		Month          string   `json:"month"`
+		Camera         string   `json:"camera"`
+		Text           string   `json:"text"`
+		Color          string   `json:"color"`
+		ColorTolerance *float64 `json:"color_tolerance"`
+		Monochrome     *bool    `json:"monochrome"`
+		Limit          int      `json:"limit"`
+		Offset         int      `json:"offset"`
+	}
+	if err := decodeToolArgs(args, &input); err != nil {
+		return nil, err
+	}
+	if input.Month != "" {
+		if _, err := time.Parse("2006-01", input.Month); err != nil {
+			return nil, fmt.Errorf("month must be formatted YYYY-MM")
+		}
+	}
+	if input.Limit <= 0 {
+		input.Limit = mcpDefaultSearchLimit
+	}
+	if input.Limit > mcpMaxSearchLimit {
+		input.Limit = mcpMaxSearchLimit
+	}
+	if input.Offset < 0 {
+		input.Offset = 0
+	}
+
+	color, err := parseColorFilter(input.Color, input.ColorTolerance)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := ImageFilter{Tags: input.Tags, Month: input.Month, Camera: input.Camera, Text: input.Text, Color: color, Monochrome: input.Monochrome}
+
+	var images []model.Image
+	if err := h.filteredImagesQuery(userID, filter).WithContext(ctx).
+		Order("created_at DESC").
+		Limit(input.Limit).Offset(input.Offset).
+		Find(&images).Error; err != nil {
+		return nil, fmt.Errorf("failed to search images")
+	}
+
+	results := make([]mcpImage, 0, len(images))
+	for i := range images {
+		results = append(results, toMCPImage(&images[i]))
+	}
+	return mcp.JSONResult(map[string]interface{}{
+		"images": results,
+		"count":  len(results),
+		"offset": input.Offset,
+	})
+}
+
+func (h *Handler) mcpGetImage(ctx context.Context, userID uint, args json.RawMessage) (*mcp.ToolResult, error) {
+	var input struct {
+		ImageID          uint `json:"image_id"`
+		IncludeThumbnail bool `json:"include_thumbnail"`
+	}
+	if err := decodeToolArgs(args, &input); err != nil {
+		return nil, err
+	}
+
+	var image model.Image
+	if err := visibleImages(h.DB.WithContext(ctx)).Preload("Tags").Where("id = ? AND user_id = ?", input.ImageID, userID).First(&image).Error; err != nil {
+		return nil, errImageNotFound
+	}
+
+	result, err := mcp.JSONResult(toMCPImage(&image))
+	if err != nil {
+		return nil, err
+	}
+	if input.IncludeThumbnail {
+		data, err := os.ReadFile(image.ThumbnailPath)
+		if err != nil {
+			log.Printf("Failed to read thumbnail for image %d: %v", image.ID, err)
+		} else {
+			result.Content = append(result.Content, mcp.Content{
+				Type:     "image",
+				Data:     base64.StdEncoding.EncodeToString(data),
+				MimeType: imageMIMEType(image.ThumbnailPath),
+			})
+		}
+	}
+	return result, nil
+}
+
+func (h *Handler) mcpAddTag(ctx context.Context, userID uint, args json.RawMessage) (*mcp.ToolResult, error) {
+	var input struct {
+		ImageID uint   `json:"image_id"`
+		Tag     string `json:"tag"`
+	}
+	if err := decodeToolArgs(args, &input); err != nil {
+		return nil, err
+	}
+
+	image, err := h.addTagToImage(userID, input.ImageID, input.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.JSONResult(toMCPImage(image))
+}
+
+func (h *Handler) mcpListTags(ctx context.Context, userID uint, args json.RawMessage) (*mcp.ToolResult, error) {
+	var input struct{}
+	if err := decodeToolArgs(args, &input); err != nil {
+		return nil, err
+	}
+
+	type tagCount struct {
+		Name       string `json:"name"`
+		Source     string `json:"source"`
+		ImageCount int64  `json:"imageCount"`
+	}
+	tags := make([]tagCount, 0)
+	err := h.DB.WithContext(ctx).
+		Table("tags").
+		Select("tags.name, tags.source, COUNT(DISTINCT images.id) AS image_count").
+		Joins("JOIN image_tags ON image_tags.tag_id = tags.id").
+		Joins("JOIN images ON images.id = image_tags.image_id AND images.deleted_at IS NULL").
+		Where("images.user_id = ? AND tags.deleted_at IS NULL", userID).
+		Group("tags.id, tags.name, tags.source").
+		Order("tags.name ASC").
+		Scan(&tags).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tags")
+	}
+	return mcp.JSONResult(map[string]interface{}{"tags": tags})
+}
+
+func (h *Handler) mcpAnalyzeImage(ctx context.Context, userID uint, args json.RawMessage) (*mcp.ToolResult, error) {
+	var input struct {
+		ImageID uint `json:"image_id"`
+	}
+	if err := decodeToolArgs(args, &input); err != nil {
+		return nil, err
+	}
+
+	var image model.Image
+	if err := visibleImages(h.DB.WithContext(ctx)).Where("id = ? AND user_id = ?", input.ImageID, userID).First(&image).Error; err != nil {
+		return nil, errImageNotFound
+	}
+
+	aiService, err := h.aiService()
+	if err != nil {
+		h.recordFailedAIJob(&image, "mcp", err)
+		return nil, fmt.Errorf("AI service is not available")
+	}
+
+	addedTags, err := h.analyzeAndTagImage(aiService, &image, "mcp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze image: %w", err)
+	}
+
+	added := make([]string, 0, len(addedTags))
+	for _, tag := range addedTags {
+		added = append(added, tag.Name)
+	}
+	h.DB.Preload("Tags").First(&image, image.ID)
+	return mcp.JSONResult(map[string]interface{}{
+		"addedTags": added,
+		"image":     toMCPImage(&image),
+	})
+}
+
+// mcpListThumbnails 分页列出当前用户所有图片的缩略图资源，游标为偏移量
+func (h *Handler) mcpListThumbnails(ctx context.Context, userID uint, cursor string) ([]mcp.Resource, string, error) {
+	offset := 0
+	if cursor != "" {
+		parsed, err := strconv.Atoi(cursor)
+		if err != nil || parsed < 0 {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		offset = parsed
+	}
+
+	var images []model.Image
+	if err := visibleImages(h.DB.WithContext(ctx)).
+		Select("id", "filename", "thumbnail_path", "created_at").
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(mcpResourcePageSize + 1).Offset(offset).
+		Find(&images).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to list images")
+	}
+
+	next := ""
+	if len(images) > mcpResourcePageSize {
+		images = images[:mcpResourcePageSize]
+		next = strconv.Itoa(offset + mcpResourcePageSize)
+	}
+
+	resources := make([]mcp.Resource, 0, len(images))
+	for _, image := range images {
+		resources = append(resources, mcp.Resource{
+			URI:      thumbnailURI(image.ID),
+			Name:     image.Filename,
+			MimeType: imageMIMEType(image.ThumbnailPath),
+		})
+	}
+	return resources, next, nil
+}
+
+// mcpReadThumbnail 读取 image://{id}/thumbnail 资源
+func (h *Handler) mcpReadThumbnail(ctx context.Context, userID uint, uri string) ([]mcp.ResourceContents, error) {
+	idStr, ok := strings.CutPrefix(uri, "image://")
+	if ok {
+		idStr, ok = strings.CutSuffix(idStr, "/thumbnail")
+	}
+	imageID, err := strconv.ParseUint(idStr, 10, 64)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("resource not found: %s", uri)
+	}
+
+	var image model.Image
+	if err := visibleImages(h.DB.WithContext(ctx)).Where("id = ? AND user_id = ?", imageID, userID).First(&image).Error; err != nil {
+		return nil, fmt.Errorf("resource not found: %s", uri)
+	}
+
+	data, err := os.ReadFile(image.ThumbnailPath)
+	if err != nil {
+		log.Printf("Failed to read thumbnail for image %d: %v", image.ID, err)
+		return nil, fmt.Errorf("resource not found: %s", uri)
+	}
+	return []mcp.ResourceContents{{
+		URI:      uri,
+		MimeType: imageMIMEType(image.ThumbnailPath),
+		Blob:     base64.StdEncoding.EncodeToString(data),
+	}}, nil
+}
+
+// MCPTokenAuthenticator 返回 stdio 传输使用的认证函数：每条消息都重新校验 JWT 的有效期，
+// 并确认用户仍然存在且没有被禁用，令牌过期或账号被禁用后的请求会被拒绝
+func (h *Handler) MCPTokenAuthenticator(token string) mcp.Authenticator {
+	return func(ctx context.Context) (uint, error) {
+		claims, err := utils.ParseJWT(token)
+		if err != nil {
+			return 0, fmt.Errorf("token is no longer valid: %w", err)
+		}
+		var user model.User
+		if err := h.DB.WithContext(ctx).Select("id", "disabled").First(&user, claims.UserID).Error; err != nil {
+			return 0, fmt.Errorf("user %d not found", claims.UserID)
+		}
+		if user.Disabled {
+			return 0, fmt.Errorf("account is disabled")
+		}
+		return user.ID, nil
+	}
+}
+
+// MCPServe MCP streamable HTTP 传输：客户端 POST 一条 JSON-RPC 消息，服务端以 JSON 返回响应
+// 请求已通过 JWT 认证，所有工具都以当前用户的身份执行
+// 服务端不会主动推送消息，因此 GET（SSE 流）返回 405
+func (h *Handler) MCPServe(c *gin.Context) {
+	if c.Request.Method != http.MethodPost {
+		c.Header("Allow", http.MethodPost)
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Server-initiated streams are not supported, use POST"})
+		return
+	}
+	if h.MCP == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "MCP server is not enabled"})
+		return
+	}
+
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, mcpMaxRequestBytes+1))
+	if err != nil || len(body) > mcpMaxRequestBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+		return
+	}
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		c.JSON(http.StatusBadRequest, gin.H{"jsonrpc": "2.0", "id": nil, "error": gin.H{"code": mcp.ErrInvalidRequest, "message": "batch requests are not supported"}})
+		return
+	}
+
+	var envelope struct {
+		Method string `json:"method"`
+	}
+	json.Unmarshal(body, &envelope)
+
+	resp := h.MCP.HandleMessage(c.Request.Context(), userID, body)
+	if resp == nil {
+		// 通知或响应：没有需要返回的内容
+		c.Status(http.StatusAccepted)
+		return
+	}
+
+	if envelope.Method == "initialize" && resp.Error == nil {
+		// 本服务端是无状态的，会话 ID 仅用于满足客户端的协议要求
+		if sessionID, err := service.RandomURLString(16); err == nil {
+			c.Header("Mcp-Session-Id", sessionID)
+		}
+	}
+
+	status := http.StatusOK
+	if resp.Error != nil && resp.Error.Code == mcp.ErrParse {
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, resp)
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/Valkqs/image-management-app/backend/internal/utils"
+)
+
+func TestMCPTokenAuthenticator(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+
+	token, err := utils.GenerateJWT(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate := h.MCPTokenAuthenticator(token)
+	if userID, err := authenticate(context.Background()); err != nil || userID != alice.ID {
+		t.Fatalf("got user %d, err %v; want %d", userID, err, alice.ID)
+	}
+
+	// 启动之后被禁用的账号不能继续调用
+	h.DB.Model(&alice).Update("disabled", true)
+	if _, err := authenticate(context.Background()); err == nil {
+		t.Error("disabled account was authenticated")
+	}
+
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, utils.Claims{
+		UserID:           alice.ID,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))},
+	}).SignedString(utils.JwtKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.DB.Model(&alice).Update("disabled", false)
+	if _, err := h.MCPTokenAuthenticator(expired)(context.Background()); err == nil {
+		t.Error("expired token was authenticated")
+	}
+}
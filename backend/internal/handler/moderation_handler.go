@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+// moderateImage 内容审核阶段：给图片的安全类别打分，命中的图片按 MODERATION_ACTION 标记或隔离
+// ctx 中的用户（图片所有者）用于用量统计和预算检查；管理员复核过的图片不再审核；
+// 审核失败（包括超出预算）不影响标签分析的结果，只记录日志
+func (h *Handler) moderateImage(ctx context.Context, aiService *service.AIService, image *model.Image) {
+	if !aiService.ModerationEnabled() {
+		return
+	}
+	if image.ModerationStatus == model.ModerationApproved || image.ModerationStatus == model.ModerationBlocked {
+		log.Printf("Keeping reviewed moderation status %q of image %d", image.ModerationStatus, image.ID)
+		return
+	}
+
+	result, err := aiService.ModerateImage(ctx, image.FilePath)
+	if err != nil {
+		log.Printf("Moderation failed for image %d: %v", image.ID, err)
+		return
+	}
+
+	status := model.ModerationClean
+	if result.Quarantine {
+		status = model.ModerationQuarantined
+	} else if len(result.Flagged) > 0 {
+		status = model.ModerationFlagged
+	}
+	scores := make(map[string]float64, len(result.Labels))
+	for _, label := range result.Labels {
+		scores[label.Category] = label.Score
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		// 每张图片只保留最近一次的审核结果
+		var record model.ImageModeration
+		if err := tx.Where("image_id = ?", image.ID).Limit(1).Find(&record).Error; err != nil {
+			return err
+		}
+		record.ImageID = image.ID
+		record.UserID = image.UserID
+		record.Engine = result.Engine
+		record.Scores = scores
+		record.Flagged = result.Flagged
+		record.ReviewedBy, record.ReviewedAt, record.Note = nil, nil, ""
+		if err := tx.Save(&record).Error; err != nil {
+			return err
+		}
+		return tx.Model(image).Update("moderation_status", status).Error
+	})
+	if err != nil {
+		log.Printf("Failed to save moderation result for image %d: %v", image.ID, err)
+		return
+	}
+	image.ModerationStatus = status
+	if status != model.ModerationClean {
+		log.Printf("Image %d %s by moderation: %v", image.ID, status, result.Flagged)
+	}
+}
+
+// moderationEnabled 是否启用了内容审核（AI 服务不可用时视为未启用）
+func (h *Handler) moderationEnabled() bool {
+	aiService, err := h.aiService()
+	return err == nil && aiService.ModerationEnabled()
+}
+
+// moderateImageAsync 异步审核新图片，用于没有触发 AI 分析的上传（AI 分析会在标签分析之前审核）
+// 审核失败时图片保持 queued 状态，等待重新审核或管理员复核
+func (h *Handler) moderateImageAsync(imageID uint) {
+	go func() {
+		var image model.Image
+		if err := h.DB.First(&image, imageID).Error; err != nil {
+			log.Printf("Image %d not found for moderation: %v", imageID, err)
+			return
+		}
+		aiService, err := h.aiService()
+		if err != nil {
+			log.Printf("AI service not available for moderation of image %d: %v", imageID, err)
+			return
+		}
+		h.moderateImage(service.WithUserID(context.Background(), image.UserID), aiService, &image)
+	}()
+}
+
+// RecoverModeration 重新审核上次运行中断时仍在等待审核的图片（在后台逐张进行）
+func (h *Handler) RecoverModeration() {
+	var ids []uint
+	if err := h.DB.Model(&model.Image{}).Where("moderation_status = ?", model.ModerationQueued).Pluck("id", &ids).Error; err != nil {
+		log.Printf("Failed to query images waiting for moderation: %v", err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+	aiService, err := h.aiService()
+	if err != nil || !aiService.ModerationEnabled() {
+		log.Printf("%d images are waiting for moderation but moderation is not available; review them as admin", len(ids))
+		return
+	}
+	log.Printf("Resuming moderation of %d queued images", len(ids))
+	go func() {
+		for _, id := range ids {
+			var image model.Image
+			if err := h.DB.First(&image, id).Error; err != nil {
+				continue
+			}
+			h.moderateImage(service.WithUserID(context.Background(), image.UserID), aiService, &image)
+		}
+	}()
+}
+
+// visibleImages 排除等待审核、被隔离或被屏蔽的图片（图片列表、搜索和公开链接都不显示这些图片）
+func visibleImages(query *gorm.DB) *gorm.DB {
+	return query.Where("images.moderation_status NOT IN ?", model.HiddenModerationStatuses)
+}
+
+// ServeUpload 提供上传的图片和缩略图文件，被隔离或被屏蔽的图片返回 404
+func (h *Handler) ServeUpload(c *gin.Context) {
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Header("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept")
+
+	// c.Param("filepath") 返回的是 /images/xxx.jpg，需要加上 uploads 前缀
+	// 数据库中保存的路径为 uploads/images/xxx.jpg
+	filePath := "uploads" + path.Clean(c.Param("filepath"))
+	var hidden int64
+	if err := h.DB.Model(&model.Image{}).
+		Where("file_path = ? OR thumbnail_path = ?", filePath, filePath).
+		Where("moderation_status IN ?", model.HiddenModerationStatuses).
+		Count(&hidden).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check image"})
+		return
+	}
+	if hidden > 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	c.File("./" + filePath)
+}
+
+// AdminListModeration 查看内容审核结果，默认返回待复核的图片（被标记或被隔离）
+// 支持 ?status=pending|flagged|quarantined|approved|blocked|clean|all、?userID=、?limit=
+func (h *Handler) AdminListModeration(c *gin.Context) {
+	status := c.DefaultQuery("status", "pending")
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	query := h.DB.Preload("Tags").Preload("Moderation").Where("moderation_status <> ''")
+	switch status {
+	case "all":
+	case "pending":
+		query = query.Where("moderation_status IN ?", []string{model.ModerationQueued, model.ModerationFlagged, model.ModerationQuarantined})
+	default:
+		query = query.Where("moderation_status = ?", status)
+	}
+	if userID := c.Query("userID"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var images []model.Image
+	if err := query.Order("updated_at DESC").Limit(limit).Find(&images).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch moderation results"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"images": images,
+		"count":  len(images),
+	})
+}
+
+// AdminApproveImage 复核后放行图片：POST /admin/moderation/:id/approve，可选 {"note": "..."}
+func (h *Handler) AdminApproveImage(c *gin.Context) {
+	h.reviewModeration(c, model.ModerationApproved)
+}
+
+// AdminBlockImage 复核后屏蔽图片：POST /admin/moderation/:id/block，可选 {"note": "..."}
+func (h *Handler) AdminBlockImage(c *gin.Context) {
+	h.reviewModeration(c, model.ModerationBlocked)
+}
+
+// reviewModeration 保存管理员的复核结果；没有审核记录的图片（例如未启用审核时上传的）也可以直接屏蔽
+func (h *Handler) reviewModeration(c *gin.Context, status string) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+	var input struct {
+		Note string `json:"note"`
+	}
+	// 请求体可以为空
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var image model.Image
+	if err := h.DB.First(&image, imageID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	userID_i, _ := c.Get("userID")
+	adminID := userID_i.(uint)
+	now := time.Now()
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		var record model.ImageModeration
+		if err := tx.Where("image_id = ?", image.ID).Limit(1).Find(&record).Error; err != nil {
+			return err
+		}
+		if record.ID == 0 {
+			record = model.ImageModeration{ImageID: image.ID, UserID: image.UserID, Engine: "manual", Scores: map[string]float64{}, Flagged: []string{}}
+		}
+		record.ReviewedBy = &adminID
+		record.ReviewedAt = &now
+		record.Note = truncateRunes(strings.TrimSpace(input.Note), 500)
+		if err := tx.Save(&record).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Image{}).Where("id = ?", image.ID).Update("moderation_status", status).Error; err != nil {
+			return err
+		}
+		image.ModerationStatus = status
+		image.Moderation = &record
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save review"})
+		return
+	}
+	c.JSON(http.StatusOK, image)
+}
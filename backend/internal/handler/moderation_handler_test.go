@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+// stubModerator 对所有图片返回固定的类别得分
+type stubModerator struct {
+	score float64
+}
+
+func (m stubModerator) Name() string { return "stub" }
+
+func (m stubModerator) Moderate(ctx context.Context, image []byte, mimeType string) ([]service.ModerationLabel, error) {
+	return []service.ModerationLabel{{Category: "adult", Score: m.score}}, nil
+}
+
+func newModerationTestHandler(t *testing.T, score float64) *Handler {
+	t.Helper()
+	h := newTestHandler(t)
+	mock := service.NewMockProvider()
+	h.AI = service.NewAIServiceWithProviders(mock, mock)
+	h.AI.SetModerator(stubModerator{score: score})
+	return h
+}
+
+func uploadTestJPEG(t *testing.T, h *Handler, userID uint, autoAnalyze bool) *httptest.ResponseRecorder {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.RGBA{200, 100, 50, 255})
+		}
+	}
+	var data bytes.Buffer
+	if err := jpeg.Encode(&data, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("images", "photo.jpg")
+	part.Write(data.Bytes())
+	writer.WriteField("autoAnalyze", fmt.Sprint(autoAnalyze))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/images", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	router := gin.New()
+	router.POST("/images", func(c *gin.Context) {
+		c.Set("userID", userID)
+		h.UploadImage(c)
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUploadModeratesWithoutAutoAnalysis(t *testing.T) {
+	t.Chdir(t.TempDir()) // 上传的文件保存在工作目录下的 uploads 中
+
+	tests := []struct {
+		name  string
+		score float64
+		want  string
+	}{
+		{"clean image becomes visible", 0.1, model.ModerationClean},
+		{"unsafe image is quarantined", 0.9, model.ModerationQuarantined},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newModerationTestHandler(t, tt.score)
+			alice := createTestUser(t, h, "alice")
+
+			if w := uploadTestJPEG(t, h, alice.ID, false); w.Code != http.StatusOK {
+				t.Fatalf("upload status %d: %s", w.Code, w.Body.String())
+			}
+			var image model.Image
+			if err := h.DB.Where("user_id = ?", alice.ID).First(&image).Error; err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, func() bool {
+				h.DB.First(&image, image.ID)
+				return image.ModerationStatus != model.ModerationQueued
+			})
+			if image.ModerationStatus != tt.want {
+				t.Errorf("moderation status %q, want %q", image.ModerationStatus, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateImageWithinQuotaQueuesForModeration(t *testing.T) {
+	h := newModerationTestHandler(t, 0)
+	alice := createTestUser(t, h, "alice")
+	image := model.Image{UserID: alice.ID, Filename: "a.jpg", FilePath: "a.jpg", ThumbnailPath: "a.jpg"}
+	if err := h.createImageWithinQuota(&image); err != nil {
+		t.Fatal(err)
+	}
+	if image.ModerationStatus != model.ModerationQueued {
+		t.Fatalf("moderation status %q, want queued", image.ModerationStatus)
+	}
+
+	// 等待审核的图片不出现在列表中
+	var count int64
+	visibleImages(h.DB.Model(&model.Image{})).Where("user_id = ?", alice.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("%d queued images are visible, want 0", count)
+	}
+}
+
+func TestMCPHidesModeratedImages(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	hidden := createTestImage(t, h, alice.ID, "hidden.jpg")
+	h.DB.Model(&hidden).Update("moderation_status", model.ModerationQuarantined)
+
+	args := []byte(fmt.Sprintf(`{"image_id": %d}`, hidden.ID))
+	if _, err := h.mcpGetImage(context.Background(), alice.ID, args); err == nil {
+		t.Error("get_image returned a quarantined image")
+	}
+	if _, err := h.mcpAnalyzeImage(context.Background(), alice.ID, args); !errors.Is(err, errImageNotFound) {
+		t.Errorf("analyze_image on a quarantined image returned %v, want %v", err, errImageNotFound)
+	}
+	if _, err := h.mcpReadThumbnail(context.Background(), alice.ID, thumbnailURI(hidden.ID)); err == nil {
+		t.Error("thumbnail resource returned a quarantined image")
+	}
+}
@@ -0,0 +1,383 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+	"github.com/Valkqs/image-management-app/backend/internal/utils"
+)
+
+const (
+	oidcFlowCookie = "oidc_flow"
+	oidcCookiePath = "/api/v1/auth/oidc"
+	oidcFlowTTL    = 10 * time.Minute
+)
+
+// oidcFlowClaims 保存在签名 Cookie 中的登录流程状态（state、nonce、PKCE verifier）
+// 使用 Cookie 而不是服务端存储，多实例部署时回调可以落到任意实例
+type oidcFlowClaims struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"cv"`
+	LinkUserID   uint   `json:"link,omitempty"` // 已登录用户发起的关联流程：回调时将身份关联到该用户
+	jwt.RegisteredClaims
+}
+
+// oidcFlowKey 流程 Cookie 的签名密钥，与登录令牌的密钥（JWT_SECRET）分开，流程 Cookie 和登录令牌不能互相冒用
+// 设置了 OIDC_FLOW_SECRET 时直接使用，否则从 JWT_SECRET 派生，多实例部署时各实例一致
+func oidcFlowKey() []byte {
+	if secret := os.Getenv("OIDC_FLOW_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	mac := hmac.New(sha256.New, utils.JwtKey)
+	mac.Write([]byte("oidc-flow-cookie"))
+	return mac.Sum(nil)
+}
+
+// usernameSanitizer 去除用户名中不适合的字符
+var usernameSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// OIDCConfig 告知前端是否启用了单点登录
+func (h *Handler) OIDCConfig(c *gin.Context) {
+	if h.OIDC == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":  true,
+		"issuer":   h.OIDC.Issuer(),
+		"loginURL": "/api/v1/auth/oidc/login",
+	})
+}
+
+// OIDCLogin 发起 OIDC 授权码 + PKCE 登录流程，重定向到身份提供方
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	if h.OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	authURL, ok := h.startOIDCFlow(c, 0)
+	if !ok {
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCLink 已登录用户将单点登录身份关联到自己的账号：POST /auth/oidc/link
+// 返回身份提供方的授权地址，前端跳转过去完成认证；回调时身份关联到当前用户，之后可以通过单点登录登录
+// 同邮箱的已有账号只有邮箱经过验证时才会在登录时自动关联，其他情况需要通过这个接口关联
+func (h *Handler) OIDCLink(c *gin.Context) {
+	if h.OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	authURL, ok := h.startOIDCFlow(c, userID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authURL": authURL})
+}
+
+// startOIDCFlow 生成 state、nonce 和 PKCE verifier，写入签名的流程 Cookie，返回身份提供方的授权地址
+// linkUserID 不为 0 时回调将身份关联到该用户；失败时已写入错误响应
+func (h *Handler) startOIDCFlow(c *gin.Context, linkUserID uint) (string, bool) {
+	state, err1 := service.RandomURLString(24)
+	nonce, err2 := service.RandomURLString(24)
+	verifier, err3 := service.RandomURLString(48)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+
+	authURL, err := h.OIDC.AuthCodeURL(c.Request.Context(), state, nonce, service.PKCEChallenge(verifier))
+	if err != nil {
+		log.Printf("Failed to build OIDC authorization URL: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is not reachable"})
+		return "", false
+	}
+
+	flow := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcFlowClaims{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUserID:   linkUserID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcFlowTTL)),
+		},
+	})
+	flowCookie, err := flow.SignedString(oidcFlowKey())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcFlowCookie, flowCookie, int(oidcFlowTTL.Seconds()), oidcCookiePath, "", isSecureRequest(c), true)
+	return authURL, true
+}
+
+// OIDCCallback 处理身份提供方的回调：校验 state、换取令牌、验证 ID Token、关联或创建用户并签发本应用的 JWT
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	if h.OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	if idpErr := c.Query("error"); idpErr != "" {
+		h.finishOIDCLogin(c, "", fmt.Errorf("identity provider error: %s %s", idpErr, c.Query("error_description")))
+		return
+	}
+
+	// 读取并立即清除流程 Cookie，防止重放
+	flowCookie, err := c.Cookie(oidcFlowCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcFlowCookie, "", -1, oidcCookiePath, "", isSecureRequest(c), true)
+	if err != nil {
+		h.finishOIDCLogin(c, "", fmt.Errorf("login session expired, please try again"))
+		return
+	}
+
+	flow := &oidcFlowClaims{}
+	token, err := jwt.ParseWithClaims(flowCookie, flow, func(token *jwt.Token) (interface{}, error) {
+		return oidcFlowKey(), nil
+	}, jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil || !token.Valid {
+		h.finishOIDCLogin(c, "", fmt.Errorf("login session expired, please try again"))
+		return
+	}
+	if c.Query("state") == "" || c.Query("state") != flow.State {
+		h.finishOIDCLogin(c, "", fmt.Errorf("invalid state parameter"))
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		h.finishOIDCLogin(c, "", fmt.Errorf("missing authorization code"))
+		return
+	}
+
+	tokens, err := h.OIDC.Exchange(c.Request.Context(), code, flow.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		h.finishOIDCLogin(c, "", fmt.Errorf("failed to exchange authorization code"))
+		return
+	}
+
+	claims, err := h.OIDC.VerifyIDToken(c.Request.Context(), tokens.IDToken, flow.Nonce)
+	if err != nil {
+		log.Printf("OIDC id_token verification failed: %v", err)
+		h.finishOIDCLogin(c, "", fmt.Errorf("invalid identity token"))
+		return
+	}
+
+	var user *model.User
+	if flow.LinkUserID != 0 {
+		user, err = h.linkOIDCIdentity(flow.LinkUserID, h.OIDC.Issuer(), claims)
+	} else {
+		user, err = h.linkOrProvisionOIDCUser(h.OIDC.Issuer(), claims)
+	}
+	if err != nil {
+		log.Printf("Failed to link OIDC identity %s: %v", claims.Subject, err)
+		h.finishOIDCLogin(c, "", err)
+		return
+	}
+	if user.Disabled {
+		h.finishOIDCLogin(c, "", fmt.Errorf("account is disabled"))
+		return
+	}
+
+	appToken, err := utils.GenerateJWT(user.ID)
+	if err != nil {
+		h.finishOIDCLogin(c, "", fmt.Errorf("failed to generate token"))
+		return
+	}
+
+	h.finishOIDCLogin(c, appToken, nil)
+}
+
+// finishOIDCLogin 返回登录结果
+// 配置了 OIDC_FRONTEND_REDIRECT 时重定向到前端（token 或 error 放在 URL fragment 中，不会发送到服务器日志）
+// 否则以 JSON 返回，便于命令行和测试使用
+func (h *Handler) finishOIDCLogin(c *gin.Context, token string, loginErr error) {
+	frontend := os.Getenv("OIDC_FRONTEND_REDIRECT")
+	if frontend != "" {
+		fragment := url.Values{}
+		if loginErr != nil {
+			fragment.Set("error", loginErr.Error())
+		} else {
+			fragment.Set("token", token)
+		}
+		c.Redirect(http.StatusFound, frontend+"#"+fragment.Encode())
+		return
+	}
+
+	if loginErr != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": loginErr.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+// linkOrProvisionOIDCUser 根据 (issuer, subject) 查找已关联的用户
+// 未关联时，若身份提供方和本地账号的邮箱都经过验证且邮箱相同则关联该用户，否则创建新用户
+// 本地注册的邮箱没有经过验证：自动关联会让预先用他人邮箱注册的人在对方通过单点登录登录后仍然能用密码访问该账号，
+// 这种情况需要账号的所有者登录后通过 OIDCLink 关联
+func (h *Handler) linkOrProvisionOIDCUser(issuer string, claims *service.OIDCClaims) (*model.User, error) {
+	var user model.User
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var identity model.UserIdentity
+		err := tx.Where("issuer = ? AND subject = ?", issuer, claims.Subject).First(&identity).Error
+		if err == nil {
+			if err := tx.First(&user, identity.UserID).Error; err != nil {
+				return fmt.Errorf("linked user no longer exists")
+			}
+			if claims.Email != "" && identity.Email != claims.Email {
+				tx.Model(&identity).Update("email", claims.Email)
+			}
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if claims.Email == "" {
+			return fmt.Errorf("identity provider did not return an email address")
+		}
+
+		// 只有两边的邮箱都经过验证时才关联已有账号，防止通过未验证的邮箱接管他人账号
+		err = tx.Where("email = ?", claims.Email).First(&user).Error
+		switch {
+		case err == nil && (!claims.EmailVerified || !user.EmailVerified):
+			return fmt.Errorf("an account with this email already exists; sign in with your password and link single sign-on from your profile")
+		case err == nil:
+			// 关联到已有用户
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			username, err := uniqueUsername(tx, claims)
+			if err != nil {
+				return err
+			}
+			// 通过单点登录创建的用户没有本地密码，只能通过身份提供方登录
+			user = model.User{
+				Username:      username,
+				Email:         claims.Email,
+				EmailVerified: claims.EmailVerified,
+				Password:      "",
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			log.Printf("Provisioned user %d (%s) from OIDC subject %s", user.ID, username, claims.Subject)
+		default:
+			return err
+		}
+
+		return tx.Create(&model.UserIdentity{
+			UserID:  user.ID,
+			Issuer:  issuer,
+			Subject: claims.Subject,
+			Email:   claims.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// linkOIDCIdentity 将身份关联到发起关联流程的用户；身份已关联到其他用户时返回错误
+// 身份提供方验证过的邮箱与账号邮箱相同时，同时将账号邮箱标记为已验证
+func (h *Handler) linkOIDCIdentity(userID uint, issuer string, claims *service.OIDCClaims) (*model.User, error) {
+	var user model.User
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, userID).Error; err != nil {
+			return fmt.Errorf("account no longer exists")
+		}
+
+		var identity model.UserIdentity
+		err := tx.Where("issuer = ? AND subject = ?", issuer, claims.Subject).First(&identity).Error
+		switch {
+		case err == nil && identity.UserID != user.ID:
+			return fmt.Errorf("this single sign-on identity is already linked to another account")
+		case err == nil:
+			// 已经关联过
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := tx.Create(&model.UserIdentity{
+				UserID:  user.ID,
+				Issuer:  issuer,
+				Subject: claims.Subject,
+				Email:   claims.Email,
+			}).Error; err != nil {
+				return err
+			}
+			log.Printf("Linked OIDC subject %s to user %d", claims.Subject, user.ID)
+		default:
+			return err
+		}
+
+		if claims.EmailVerified && !user.EmailVerified && strings.EqualFold(claims.Email, user.Email) {
+			user.EmailVerified = true
+			return tx.Model(&user).Update("email_verified", true).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// uniqueUsername 根据身份提供方的用户名或邮箱生成一个未被占用的用户名
+func uniqueUsername(tx *gorm.DB, claims *service.OIDCClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.Split(claims.Email, "@")[0]
+	}
+	base = usernameSanitizer.ReplaceAllString(base, "")
+	if len(base) > 200 {
+		base = base[:200]
+	}
+	if len(base) < 4 {
+		base = "user_" + base
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		var count int64
+		if err := tx.Model(&model.User{}).Unscoped().Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		suffix, err := service.RandomURLString(3)
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "_" + strings.ToLower(usernameSanitizer.ReplaceAllString(suffix, ""))
+	}
+	return "", fmt.Errorf("failed to generate a unique username")
+}
+
+// isSecureRequest 判断请求是否通过 HTTPS 到达（包括反向代理转发的情况）
+func isSecureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+}
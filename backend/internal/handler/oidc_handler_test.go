@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+	"github.com/Valkqs/image-management-app/backend/internal/utils"
+)
+
+const testIssuer = "https://idp.example.com"
+
+func oidcClaims(subject, email string, verified bool) *service.OIDCClaims {
+	return &service.OIDCClaims{
+		Email:            email,
+		EmailVerified:    verified,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: subject},
+	}
+}
+
+func TestLinkOrProvisionOIDCUserDoesNotLinkUnverifiedLocalEmail(t *testing.T) {
+	h := newTestHandler(t)
+	// 有人预先用受害者的邮箱注册了本地账号（本地邮箱没有验证）
+	squatter := createTestUser(t, h, "squatter")
+
+	if _, err := h.linkOrProvisionOIDCUser(testIssuer, oidcClaims("victim", squatter.Email, true)); err == nil {
+		t.Fatal("expected linking to an unverified local account to fail")
+	}
+	var identities int64
+	h.DB.Model(&model.UserIdentity{}).Count(&identities)
+	if identities != 0 {
+		t.Errorf("created %d identities, want 0", identities)
+	}
+}
+
+func TestLinkOrProvisionOIDCUser(t *testing.T) {
+	h := newTestHandler(t)
+	verified := createTestUser(t, h, "verified")
+	h.DB.Model(&verified).Update("email_verified", true)
+
+	tests := []struct {
+		name     string
+		claims   *service.OIDCClaims
+		wantUser uint // 0 表示创建新用户
+		wantErr  bool
+	}{
+		{"verified emails on both sides are linked", oidcClaims("sub-1", verified.Email, true), verified.ID, false},
+		{"unverified identity provider email is not linked", oidcClaims("sub-2", verified.Email, false), 0, true},
+		{"new email is provisioned", oidcClaims("sub-3", "new@example.com", true), 0, false},
+		{"linked identity logs in again", oidcClaims("sub-1", verified.Email, true), verified.ID, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := h.linkOrProvisionOIDCUser(testIssuer, tt.claims)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got user %d", user.ID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantUser != 0 && user.ID != tt.wantUser {
+				t.Errorf("got user %d, want %d", user.ID, tt.wantUser)
+			}
+			if tt.wantUser == 0 && (user.ID == verified.ID || !user.EmailVerified) {
+				t.Errorf("expected a new verified user, got %+v", user)
+			}
+		})
+	}
+}
+
+func TestLinkOIDCIdentity(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	bob := createTestUser(t, h, "bob")
+
+	user, err := h.linkOIDCIdentity(alice.ID, testIssuer, oidcClaims("alice-sub", alice.Email, true))
+	if err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	if !user.EmailVerified {
+		t.Error("expected the matching verified email to mark the account as verified")
+	}
+
+	// 关联之后通过单点登录登录得到同一个账号
+	user, err = h.linkOrProvisionOIDCUser(testIssuer, oidcClaims("alice-sub", alice.Email, true))
+	if err != nil || user.ID != alice.ID {
+		t.Fatalf("login after link: user %v, err %v", user, err)
+	}
+
+	if _, err := h.linkOIDCIdentity(bob.ID, testIssuer, oidcClaims("alice-sub", alice.Email, true)); err == nil {
+		t.Error("expected linking an identity owned by another account to fail")
+	}
+}
+
+func TestOIDCFlowKeyIsSeparateFromJWTKey(t *testing.T) {
+	if bytes.Equal(oidcFlowKey(), utils.JwtKey) {
+		t.Fatal("flow cookie key must differ from the login token key")
+	}
+
+	// 流程 Cookie 不能被当作登录令牌使用
+	flow, err := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcFlowClaims{State: "s", LinkUserID: 1}).SignedString(oidcFlowKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.ParseJWT(flow); err == nil {
+		t.Error("flow cookie was accepted as a login token")
+	}
+}
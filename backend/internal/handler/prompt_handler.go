@@ -0,0 +1,337 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+// 受控词表的限制
+const (
+	maxVocabularyTerms   = 1000
+	maxVocabularyAliases = 20
+)
+
+// 可以在数据库中维护的提示词模板
+var promptTemplateNames = map[string]bool{
+	service.PromptTag:      true,
+	service.PromptDescribe: true,
+	service.PromptQuery:    true,
+}
+
+// promptLanguagePattern 模板语言代码，例如 zh、en、ja、pt-BR
+var promptLanguagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z]{2,4})?$`)
+
+// dbPromptStore 从数据库读取启用的提示词模板
+type dbPromptStore struct {
+	db *gorm.DB
+}
+
+// NewPromptStore 创建数据库提示词模板来源，优先级高于文件和内置模板
+func (h *Handler) NewPromptStore() service.PromptStore {
+	return &dbPromptStore{db: h.DB}
+}
+
+// Lookup 实现 service.PromptStore
+func (s *dbPromptStore) Lookup(name, language string) (*service.PromptTemplate, bool) {
+	var tmpl model.PromptTemplate
+	err := s.db.Where("name = ? AND language = ? AND active = ?", name, language, true).First(&tmpl).Error
+	if err != nil {
+		return nil, false
+	}
+	return &service.PromptTemplate{
+		Name:     tmpl.Name,
+		Language: tmpl.Language,
+		Version:  fmt.Sprintf("db-v%d", tmpl.Version),
+		Body:     tmpl.Body,
+	}, true
+}
+
+// Languages 实现 service.PromptStore
+func (s *dbPromptStore) Languages(name string) []string {
+	var languages []string
+	s.db.Model(&model.PromptTemplate{}).Where("name = ? AND active = ?", name, true).Distinct().Pluck("language", &languages)
+	return languages
+}
+
+// aiLanguages 返回可以选择的 AI 标签语言（有标签提示词模板的语言）
+// AI 服务不可用时返回内置模板支持的语言
+func (h *Handler) aiLanguages() []string {
+	aiService, err := h.aiService()
+	if err == nil {
+		return aiService.Prompts().Languages(service.PromptTag)
+	}
+	languages := make([]string, 0, len(supportedAILanguages))
+	for language := range supportedAILanguages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// containsString 检查切片中是否包含指定字符串
+func containsString(items []string, target string) bool {
+	for _, item := range items {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminListPrompts 列出数据库中的提示词模板（所有版本），以及每个模板当前可用的语言
+func (h *Handler) AdminListPrompts(c *gin.Context) {
+	query := h.DB.Model(&model.PromptTemplate{})
+	if name := c.Query("name"); name != "" {
+		query = query.Where("name = ?", name)
+	}
+	if language := c.Query("language"); language != "" {
+		query = query.Where("language = ?", language)
+	}
+
+	var templates []model.PromptTemplate
+	if err := query.Order("name ASC, language ASC, version DESC").Find(&templates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch prompt templates"})
+		return
+	}
+
+	languages := gin.H{}
+	if aiService, err := h.aiService(); err == nil {
+		for name := range promptTemplateNames {
+			languages[name] = aiService.Prompts().Languages(name)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"templates": templates,
+		"languages": languages,
+	})
+}
+
+// AdminCreatePrompt 保存提示词模板的新版本（版本号自动递增），activate 为 true 时立即启用
+func (h *Handler) AdminCreatePrompt(c *gin.Context) {
+	adminID_i, _ := c.Get("userID")
+	adminID := adminID_i.(uint)
+
+	var input struct {
+		Name     string `json:"name" binding:"required"`
+		Language string `json:"language" binding:"required"`
+		Body     string `json:"body" binding:"required"`
+		Activate bool   `json:"activate"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !promptTemplateNames[input.Name] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 'tag', 'describe' or 'query'"})
+		return
+	}
+	if !promptLanguagePattern.MatchString(input.Language) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid language code"})
+		return
+	}
+	// 保存前检查模板语法，并用示例数据试渲染，避免在分析时才发现错误
+	parsed, err := service.ParsePromptTemplate(input.Name, input.Body)
+	if err == nil {
+		err = parsed.Execute(&strings.Builder{}, service.PromptData{Language: input.Language, Query: "example"})
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template", "details": err.Error()})
+		return
+	}
+
+	var tmpl model.PromptTemplate
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		var latest int
+		if err := tx.Model(&model.PromptTemplate{}).
+			Where("name = ? AND language = ?", input.Name, input.Language).
+			Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+			return err
+		}
+
+		tmpl = model.PromptTemplate{
+			Name:      input.Name,
+			Language:  input.Language,
+			Version:   latest + 1,
+			Body:      input.Body,
+			CreatedBy: adminID,
+		}
+		if err := tx.Create(&tmpl).Error; err != nil {
+			return err
+		}
+		if input.Activate {
+			return activatePrompt(tx, &tmpl)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save prompt template"})
+		return
+	}
+
+	log.Printf("Admin %d saved prompt template %s/%s v%d (active: %v)", adminID, tmpl.Name, tmpl.Language, tmpl.Version, tmpl.Active)
+	c.JSON(http.StatusCreated, tmpl)
+}
+
+// AdminActivatePrompt 启用指定版本（同一名称和语言的其他版本自动停用），可用于回滚
+func (h *Handler) AdminActivatePrompt(c *gin.Context) {
+	tmpl, ok := h.findPromptTemplate(c)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error { return activatePrompt(tx, tmpl) }); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate prompt template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tmpl)
+}
+
+// AdminDeactivatePrompt 停用指定版本，之后该名称和语言使用文件或内置模板
+func (h *Handler) AdminDeactivatePrompt(c *gin.Context) {
+	tmpl, ok := h.findPromptTemplate(c)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.Model(tmpl).Update("active", false).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate prompt template"})
+		return
+	}
+	tmpl.Active = false
+
+	c.JSON(http.StatusOK, tmpl)
+}
+
+// findPromptTemplate 按路径参数查找提示词模板，找不到时写入错误响应
+func (h *Handler) findPromptTemplate(c *gin.Context) (*model.PromptTemplate, bool) {
+	templateID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return nil, false
+	}
+
+	var tmpl model.PromptTemplate
+	if err := h.DB.First(&tmpl, templateID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prompt template not found"})
+		return nil, false
+	}
+	return &tmpl, true
+}
+
+// activatePrompt 启用模板并停用同一名称和语言的其他版本
+func activatePrompt(tx *gorm.DB, tmpl *model.PromptTemplate) error {
+	if err := tx.Model(&model.PromptTemplate{}).
+		Where("name = ? AND language = ? AND id <> ?", tmpl.Name, tmpl.Language, tmpl.ID).
+		Update("active", false).Error; err != nil {
+		return err
+	}
+	tmpl.Active = true
+	return tx.Model(tmpl).Update("active", true).Error
+}
+
+// userVocabulary 返回用户的受控词表，未设置时返回 nil（不限制标签）
+func (h *Handler) userVocabulary(userID uint) []service.VocabularyTerm {
+	var terms []model.VocabularyTerm
+	if err := h.DB.Where("user_id = ?", userID).Order("name ASC").Find(&terms).Error; err != nil {
+		log.Printf("Failed to load vocabulary of user %d: %v", userID, err)
+		return nil
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	vocabulary := make([]service.VocabularyTerm, len(terms))
+	for i, term := range terms {
+		vocabulary[i] = service.VocabularyTerm{Name: term.Name, Aliases: term.Aliases}
+	}
+	return vocabulary
+}
+
+// GetVocabulary 返回当前用户的受控词表
+func (h *Handler) GetVocabulary(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var terms []model.VocabularyTerm
+	if err := h.DB.Where("user_id = ?", userID).Order("name ASC").Find(&terms).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vocabulary"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"terms": terms})
+}
+
+// ReplaceVocabulary 用请求中的词条替换当前用户的整个受控词表，传入空列表表示取消词表限制
+func (h *Handler) ReplaceVocabulary(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var input struct {
+		Terms []struct {
+			Name    string   `json:"name"`
+			Aliases []string `json:"aliases"`
+		} `json:"terms"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(input.Terms) > maxVocabularyTerms {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("vocabulary can have at most %d terms", maxVocabularyTerms)})
+		return
+	}
+
+	terms := make([]model.VocabularyTerm, 0, len(input.Terms))
+	seen := make(map[string]bool)
+	for _, term := range input.Terms {
+		name := strings.TrimSpace(term.Name)
+		if name == "" || len([]rune(name)) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "term name must be 1-100 characters"})
+			return
+		}
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		if len(term.Aliases) > maxVocabularyAliases {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("a term can have at most %d aliases", maxVocabularyAliases)})
+			return
+		}
+
+		aliases := make([]string, 0, len(term.Aliases))
+		for _, alias := range term.Aliases {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				aliases = append(aliases, alias)
+			}
+		}
+		terms = append(terms, model.VocabularyTerm{UserID: userID, Name: name, Aliases: aliases})
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&model.VocabularyTerm{}).Error; err != nil {
+			return err
+		}
+		if len(terms) == 0 {
+			return nil
+		}
+		return tx.Create(&terms).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save vocabulary"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"terms": terms})
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+)
+
+// Quota 用户配额，0 表示不限制
+type Quota struct {
+	StorageBytes int64 `json:"storageBytes"`
+	ImageCount   int64 `json:"imageCount"`
+}
+
+// QuotaError 上传超出配额或单文件大小限制时返回的错误
+type QuotaError struct {
+	Code    string // 'file_too_large'、'storage_quota_exceeded'、'image_quota_exceeded'
+	Message string
+}
+
+func (e *QuotaError) Error() string {
+	return e.Message
+}
+
+// envInt64 读取整数环境变量，未设置或格式错误时返回默认值
+func envInt64(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// defaultQuota 从环境变量读取默认配额（默认 1024MB、1000 张）
+func defaultQuota() Quota {
+	return Quota{
+		StorageBytes: envInt64("DEFAULT_STORAGE_QUOTA_MB", 1024) * 1024 * 1024,
+		ImageCount:   envInt64("DEFAULT_IMAGE_QUOTA", 1000),
+	}
+}
+
+// maxUploadFileSize 单个上传文件的大小上限（字节），默认 20MB
+func maxUploadFileSize() int64 {
+	return envInt64("MAX_UPLOAD_FILE_MB", 20) * 1024 * 1024
+}
+
+// maxUploadRequestSize 单次上传请求（可以包含多个文件）的大小上限（字节），默认 100MB
+func maxUploadRequestSize() int64 {
+	return envInt64("MAX_UPLOAD_REQUEST_MB", 100) * 1024 * 1024
+}
+
+// multipartOverhead 为 multipart 的分隔符、文件头和表单字段预留的字节数
+const multipartOverhead = 1 << 20
+
+// uploadRequestLimit 上传请求体的大小上限：不超过单次请求的上限，也不超过剩余的存储配额（加上 multipart 的额外开销）
+// 返回 0 表示不限制
+func uploadRequestLimit(quota Quota, usedBytes int64) int64 {
+	limit := max(maxUploadRequestSize(), 0)
+	if quota.StorageBytes > 0 {
+		remaining := max(quota.StorageBytes-usedBytes, 0) + multipartOverhead
+		if limit == 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	return limit
+}
+
+// isRequestTooLarge 判断读取请求体的错误是否由 http.MaxBytesReader 的大小限制引起
+func isRequestTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// effectiveQuota 计算用户实际生效的配额（管理员覆盖值优先）
+func effectiveQuota(user *model.User) Quota {
+	quota := defaultQuota()
+	if user.StorageQuotaBytes != nil {
+		quota.StorageBytes = *user.StorageQuotaBytes
+	}
+	if user.ImageQuota != nil {
+		quota.ImageCount = *user.ImageQuota
+	}
+	return quota
+}
+
+// checkQuota 检查在当前用量基础上再增加一个 fileSize 字节的文件是否超出配额
+func checkQuota(quota Quota, imageCount, storageBytes, fileSize int64) *QuotaError {
+	if quota.ImageCount > 0 && imageCount+1 > quota.ImageCount {
+		return &QuotaError{
+			Code:    "image_quota_exceeded",
+			Message: fmt.Sprintf("图片数量已达到上限（%d 张）", quota.ImageCount),
+		}
+	}
+	if quota.StorageBytes > 0 && storageBytes+fileSize > quota.StorageBytes {
+		return &QuotaError{
+			Code: "storage_quota_exceeded",
+			Message: fmt.Sprintf("存储空间不足：已使用 %d 字节，配额 %d 字节，本文件 %d 字节",
+				storageBytes, quota.StorageBytes, fileSize),
+		}
+	}
+	return nil
+}
+
+// createImageWithinQuota 在事务中锁定用户行、重新统计用量并写入图片记录
+// 保证同一用户的并发上传不会突破配额；超出配额时返回 *QuotaError
+// 启用内容审核时新图片以 queued 状态写入，审核完成之前不出现在列表、搜索和公开链接中
+func (h *Handler) createImageWithinQuota(image *model.Image) error {
+	if image.ModerationStatus == "" && h.moderationEnabled() {
+		image.ModerationStatus = model.ModerationQueued
+	}
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, image.UserID).Error; err != nil {
+			return err
+		}
+
+		var usage userUsageRow
+		if err := tx.Model(&model.Image{}).
+			Select("COUNT(*) AS image_count, " + storageBytesExpr).
+			Where("user_id = ?", image.UserID).
+			Scan(&usage).Error; err != nil {
+			return err
+		}
+
+		if quotaErr := checkQuota(effectiveQuota(&user), usage.ImageCount, usage.StorageBytes, image.FileSize); quotaErr != nil {
+			return quotaErr
+		}
+
+		return tx.Create(image).Error
+	})
+}
+
+// GetMyUsage 获取当前用户的存储用量和配额
+func (h *Handler) GetMyUsage(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var user model.User
+	if err := h.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	imageCount, storageBytes, err := h.userStorageUsage(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch storage usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"imageCount":        imageCount,
+		"storageBytes":      storageBytes,
+		"quota":             effectiveQuota(&user),
+		"maxUploadFileSize": maxUploadFileSize(),
+	})
+}
+
+// AdminSetUserQuota 为指定用户设置配额覆盖值，传 null 恢复为默认配额
+func (h *Handler) AdminSetUserQuota(c *gin.Context) {
+	targetID, ok := parseUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		StorageQuotaBytes *int64 `json:"storageQuotaBytes"`
+		ImageQuota        *int64 `json:"imageQuota"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (input.StorageQuotaBytes != nil && *input.StorageQuotaBytes < 0) ||
+		(input.ImageQuota != nil && *input.ImageQuota < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quota must not be negative (0 means unlimited)"})
+		return
+	}
+
+	var user model.User
+	if err := h.DB.First(&user, targetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// 使用 Select 确保 nil 值也会被写入（恢复默认配额）
+	user.StorageQuotaBytes = input.StorageQuotaBytes
+	user.ImageQuota = input.ImageQuota
+	if err := h.DB.Model(&user).Select("storage_quota_bytes", "image_quota").Updates(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update quota"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"userID": targetID,
+		"quota":  effectiveQuota(&user),
+	})
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+)
+
+func TestUploadRequestLimit(t *testing.T) {
+	t.Setenv("MAX_UPLOAD_REQUEST_MB", "100")
+	const mb = 1024 * 1024
+
+	tests := []struct {
+		name      string
+		quota     Quota
+		usedBytes int64
+		want      int64
+	}{
+		{"unlimited storage uses the request cap", Quota{}, 0, 100 * mb},
+		{"remaining quota below the request cap", Quota{StorageBytes: 10 * mb}, 4 * mb, 6*mb + multipartOverhead},
+		{"remaining quota above the request cap", Quota{StorageBytes: 1024 * mb}, 0, 100 * mb},
+		{"exhausted quota still allows the multipart overhead", Quota{StorageBytes: 10 * mb}, 12 * mb, multipartOverhead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uploadRequestLimit(tt.quota, tt.usedBytes); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Setenv("MAX_UPLOAD_REQUEST_MB", "0")
+	if got := uploadRequestLimit(Quota{}, 0); got != 0 {
+		t.Errorf("without cap and quota got %d, want 0 (unlimited)", got)
+	}
+}
+
+func TestUploadImageRejectsOversizedRequestBeforeParsing(t *testing.T) {
+	h := newTestHandler(t)
+	user := createTestUser(t, h, "alice")
+	quota := int64(1024) // 剩余配额 1KB，请求体上限为 1KB + multipart 开销
+	h.DB.Model(&user).Update("storage_quota_bytes", quota)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("images", "big.jpg")
+	part.Write(bytes.Repeat([]byte{0xff}, 2*multipartOverhead))
+	writer.Close()
+
+	for _, chunked := range []bool{false, true} {
+		var reader io.Reader = bytes.NewReader(body.Bytes())
+		if chunked {
+			// 没有 Content-Length 的请求在读取时由 MaxBytesReader 截断
+			reader = io.MultiReader(reader)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/images", reader)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		router := gin.New()
+		router.POST("/images", func(c *gin.Context) {
+			c.Set("userID", user.ID)
+			h.UploadImage(c)
+		})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("chunked=%v: status %d, want 413: %s", chunked, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestUserStorageUsageIgnoresMissingFiles(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	stored := createTestImage(t, h, alice.ID, "stored.jpg")
+	missing := createTestImage(t, h, alice.ID, "missing.jpg")
+	h.DB.Model(&stored).Update("file_size", 100)
+	h.DB.Model(&missing).Update("file_size", model.FileSizeMissing)
+
+	count, bytes, err := h.userStorageUsage(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || bytes != 100 {
+		t.Errorf("got %d images, %d bytes; want 2 images, 100 bytes", count, bytes)
+	}
+}
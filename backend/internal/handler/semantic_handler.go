@@ -0,0 +1,491 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+// 语义搜索参数
+const (
+	semanticDefaultLimit  = 20
+	semanticMaxLimit      = 100
+	semanticDefaultBlend  = 0.2  // 全文匹配在混合得分中的默认权重
+	semanticDefaultMinSim = 0.15 // 低于该相似度且没有全文匹配的结果不返回
+	maxDocumentOCRRunes   = 2000 // 计算向量时使用的识别文字长度上限
+)
+
+// SemanticResult 语义搜索结果中的一张图片
+type SemanticResult struct {
+	Image        model.Image `json:"image"`
+	Score        float64     `json:"score"`        // 混合得分
+	Similarity   float32     `json:"similarity"`   // 向量余弦相似度
+	LexicalMatch bool        `json:"lexicalMatch"` // 是否同时命中全文搜索
+}
+
+// NewImageVectorIndex 创建从 image_embeddings 表加载向量的暴力检索索引
+func (h *Handler) NewImageVectorIndex() service.VectorIndex {
+	return service.NewBruteForceIndex(h.loadImageVectors)
+}
+
+// loadImageVectors 加载用户所有未删除图片在当前向量模型下的向量
+func (h *Handler) loadImageVectors(userID uint) (map[uint][]float32, error) {
+	var rows []model.ImageEmbedding
+	err := h.DB.
+		Joins("JOIN images ON images.id = image_embeddings.image_id AND images.deleted_at IS NULL").
+		Where("image_embeddings.user_id = ? AND image_embeddings.model = ?", userID, h.Embedder.Model()).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make(map[uint][]float32, len(rows))
+	for _, row := range rows {
+		vector, err := service.DecodeVector(row.Vector)
+		if err != nil {
+			log.Printf("Skipping corrupted embedding of image %d: %v", row.ImageID, err)
+			continue
+		}
+		vectors[row.ImageID] = vector
+	}
+	return vectors, nil
+}
+
+// imageDocument 将图片的文本信息（文件名、标签、描述、替代文本、识别出的文字）拼接为用于计算向量的文档
+func imageDocument(image *model.Image) string {
+	parts := make([]string, 0, 5)
+	name := strings.TrimSuffix(image.Filename, filepath.Ext(image.Filename))
+	parts = append(parts, strings.NewReplacer("_", " ", "-", " ").Replace(name))
+
+	if len(image.Tags) > 0 {
+		tagNames := make([]string, 0, len(image.Tags))
+		for _, tag := range image.Tags {
+			tagNames = append(tagNames, tag.Name)
+		}
+		sort.Strings(tagNames) // 标签顺序不影响内容哈希
+		parts = append(parts, "Tags: "+strings.Join(tagNames, ", "))
+	}
+	if image.Description != "" {
+		parts = append(parts, image.Description)
+	}
+	if image.AltText != "" && image.AltText != image.Description {
+		parts = append(parts, image.AltText)
+	}
+	if image.OCRText != "" {
+		text := []rune(image.OCRText)
+		if len(text) > maxDocumentOCRRunes {
+			text = text[:maxDocumentOCRRunes]
+		}
+		parts = append(parts, "Text: "+string(text))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// indexImageEmbedding 计算并保存图片的语义向量，内容未变化时跳过
+// 提供方支持图片向量时直接对缩略图计算，否则对图片的文本文档计算
+func (h *Handler) indexImageEmbedding(ctx context.Context, imageID uint) error {
+	if h.Embedder == nil {
+		return nil
+	}
+
+	var image model.Image
+	if err := h.DB.Preload("Tags").First(&image, imageID).Error; err != nil {
+		return err
+	}
+
+	kind := "text"
+	content := []byte(imageDocument(&image))
+	imageEmbedder, isImageEmbedder := h.Embedder.(service.ImageEmbeddingProvider)
+	if isImageEmbedder {
+		data, err := os.ReadFile(image.ThumbnailPath)
+		if err != nil {
+			return fmt.Errorf("failed to read thumbnail: %w", err)
+		}
+		kind = "image"
+		content = data
+	}
+
+	sum := sha256.Sum256(append([]byte(h.Embedder.Model()+"\n"+kind+"\n"), content...))
+	contentHash := hex.EncodeToString(sum[:])
+
+	var existing model.ImageEmbedding
+	err := h.DB.Where("image_id = ?", image.ID).First(&existing).Error
+	if err == nil && existing.ContentHash == contentHash {
+		return nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	// 向量计算与其他 AI 调用一样计入图片所有者的用量和预算
+	ctx = service.WithUserID(ctx, image.UserID)
+	var vector []float32
+	if isImageEmbedder {
+		vector, err = service.EmbedImage(ctx, imageEmbedder, h.NewUsageTracker(), content, imageMIMEType(image.ThumbnailPath))
+	} else {
+		var vectors [][]float32
+		vectors, err = service.EmbedTexts(ctx, h.Embedder, h.NewUsageTracker(), []string{string(content)})
+		if err == nil {
+			vector = vectors[0]
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s embedding failed: %w", h.Embedder.Name(), err)
+	}
+
+	existing.ImageID = image.ID
+	existing.UserID = image.UserID
+	existing.Model = h.Embedder.Model()
+	existing.Kind = kind
+	existing.Dimensions = len(vector)
+	existing.Vector = service.EncodeVector(vector)
+	existing.ContentHash = contentHash
+	if err := h.DB.Save(&existing).Error; err != nil {
+		return err
+	}
+
+	h.Vectors.Upsert(image.UserID, image.ID, vector)
+	return nil
+}
+
+// embeddingQueue 记录等待计算语义向量的图片和正在重建向量的用户
+// 同一张图片在排队期间的重复请求只计算一次，同一用户同时只运行一个重建任务
+type embeddingQueue struct {
+	mu         sync.Mutex
+	pending    map[uint]bool
+	reindexing map[uint]bool
+}
+
+// enqueue 将图片加入等待队列，返回之前不在队列中的图片
+func (q *embeddingQueue) enqueue(imageIDs []uint) []uint {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == nil {
+		q.pending = make(map[uint]bool)
+	}
+	queued := make([]uint, 0, len(imageIDs))
+	for _, id := range imageIDs {
+		if !q.pending[id] {
+			q.pending[id] = true
+			queued = append(queued, id)
+		}
+	}
+	return queued
+}
+
+// dequeue 在开始计算之前移出队列，计算期间的新变化会重新排队
+func (q *embeddingQueue) dequeue(imageID uint) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.pending, imageID)
+}
+
+// startReindex 标记用户开始重建向量，已有重建任务在运行时返回 false
+func (q *embeddingQueue) startReindex(userID uint) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.reindexing == nil {
+		q.reindexing = make(map[uint]bool)
+	}
+	if q.reindexing[userID] {
+		return false
+	}
+	q.reindexing[userID] = true
+	return true
+}
+
+func (q *embeddingQueue) finishReindex(userID uint) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.reindexing, userID)
+}
+
+// indexQueuedEmbedding 等待 AI 调度器的名额后计算一张排队图片的语义向量
+func (h *Handler) indexQueuedEmbedding(imageID uint) error {
+	release, err := h.scheduler().Acquire(context.Background())
+	if err != nil {
+		h.embeddings.dequeue(imageID)
+		return err
+	}
+	defer release()
+	h.embeddings.dequeue(imageID)
+	return h.indexImageEmbedding(context.Background(), imageID)
+}
+
+// indexEmbeddingsAsync 在后台依次更新图片的语义向量（例如标签或描述变化后）
+// 已在队列中的图片会被跳过；向量计算与批量分析共用调度器，限制对提供方的并发和速率
+func (h *Handler) indexEmbeddingsAsync(imageIDs []uint) {
+	if h.Embedder == nil || len(imageIDs) == 0 {
+		return
+	}
+	queued := h.embeddings.enqueue(imageIDs)
+	if len(queued) == 0 {
+		return
+	}
+	go func() {
+		for _, imageID := range queued {
+			if err := h.indexQueuedEmbedding(imageID); err != nil {
+				log.Printf("Failed to index embedding of image %d: %v", imageID, err)
+			}
+		}
+	}()
+}
+
+// indexImageEmbeddingAsync 在后台更新一张图片的语义向量
+func (h *Handler) indexImageEmbeddingAsync(imageID uint) {
+	h.indexEmbeddingsAsync([]uint{imageID})
+}
+
+// deleteImageEmbeddings 删除图片的语义向量
+func (h *Handler) deleteImageEmbeddings(userID uint, imageIDs []uint) {
+	if len(imageIDs) == 0 {
+		return
+	}
+	if err := h.DB.Where("image_id IN ?", imageIDs).Delete(&model.ImageEmbedding{}).Error; err != nil {
+		log.Printf("Failed to delete embeddings of images %v: %v", imageIDs, err)
+	}
+	if h.Vectors != nil {
+		for _, imageID := range imageIDs {
+			h.Vectors.Delete(userID, imageID)
+		}
+	}
+}
+
+// SemanticSearch 语义搜索：GET /search/semantic?q=...
+// 先按结构化条件（tags、month、camera）筛选候选图片，再按向量相似度排序，
+// 并与全文搜索结果混合：score = (1-blend)·similarity + blend·lexical
+func (h *Handler) SemanticSearch(c *gin.Context) {
+	if h.Embedder == nil || h.Vectors == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Semantic search is not enabled. Please configure EMBEDDING_PROVIDER."})
+		return
+	}
+
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter q is required"})
+		return
+	}
+
+	limit := semanticDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(parsed, semanticMaxLimit)
+	}
+
+	blend := semanticDefaultBlend
+	if value := c.Query("blend"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "blend must be between 0 and 1"})
+			return
+		}
+		blend = parsed
+	}
+
+	// 结构化筛选条件
+	filter := ImageFilter{
+		Month:  c.Query("month"),
+		Camera: c.Query("camera"),
+	}
+	if tags := c.Query("tags"); tags != "" {
+		filter.Tags = strings.Split(tags, ",")
+	}
+	hasFilter := len(filter.Tags) > 0 || filter.Month != "" || filter.Camera != ""
+
+	var allow func(uint) bool
+	if hasFilter {
+		var candidateIDs []uint
+		if err := h.filteredImagesQuery(userID, filter).Pluck("images.id", &candidateIDs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+			return
+		}
+		allowed := make(map[uint]bool, len(candidateIDs))
+		for _, id := range candidateIDs {
+			allowed[id] = true
+		}
+		allow = func(id uint) bool { return allowed[id] }
+	}
+
+	ctx := service.WithUserID(c.Request.Context(), userID)
+	vectors, err := service.EmbedTexts(ctx, h.Embedder, h.NewUsageTracker(), []string{q})
+	if err != nil {
+		log.Printf("Failed to embed semantic query: %v", err)
+		status := http.StatusBadGateway
+		if errors.Is(err, service.ErrBudgetExceeded) {
+			status = http.StatusTooManyRequests
+		}
+		c.JSON(status, gin.H{"error": "Failed to embed query", "details": err.Error()})
+		return
+	}
+
+	candidateCount := limit * 3
+	matches, err := h.Vectors.Search(userID, vectors[0], candidateCount, allow)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search vectors"})
+		return
+	}
+
+	// 全文搜索命中的图片（同样受结构化条件约束），按相关度排名计算 lexical 得分
+	lexical := make(map[uint]float64)
+	if blend > 0 {
+		lexicalFilter := filter
+		lexicalFilter.Text = q
+		lexicalIDs, err := h.lexicalImageIDs(userID, lexicalFilter, candidateCount)
+		if err != nil {
+			log.Printf("Full-text part of semantic search failed: %v", err)
+		}
+		for rank, id := range lexicalIDs {
+			lexical[id] = 1 - float64(rank)/float64(len(lexicalIDs)+1)
+		}
+	}
+
+	similarity := make(map[uint]float32, len(matches))
+	for _, match := range matches {
+		similarity[match.ImageID] = match.Score
+	}
+
+	type scored struct {
+		imageID uint
+		score   float64
+	}
+	ranked := make([]scored, 0, len(similarity)+len(lexical))
+	seen := make(map[uint]bool)
+	for _, id := range append(imageIDsOf(matches), mapKeys(lexical)...) {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		sim, lex := similarity[id], lexical[id]
+		if lex == 0 && sim < semanticDefaultMinSim {
+			continue
+		}
+		ranked = append(ranked, scored{imageID: id, score: (1-blend)*float64(sim) + blend*lex})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score == ranked[j].score {
+			return ranked[i].imageID > ranked[j].imageID
+		}
+		return ranked[i].score > ranked[j].score
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	ids := make([]uint, len(ranked))
+	for i, r := range ranked {
+		ids[i] = r.imageID
+	}
+	var images []model.Image
+	if len(ids) > 0 {
+		if err := visibleImages(h.DB.Preload("Tags")).Where("id IN ? AND user_id = ?", ids, userID).Find(&images).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+			return
+		}
+	}
+	byID := make(map[uint]model.Image, len(images))
+	for _, image := range images {
+		byID[image.ID] = image
+	}
+
+	results := make([]SemanticResult, 0, len(ranked))
+	for _, r := range ranked {
+		image, ok := byID[r.imageID]
+		if !ok {
+			continue
+		}
+		results = append(results, SemanticResult{
+			Image:        image,
+			Score:        r.score,
+			Similarity:   similarity[r.imageID],
+			LexicalMatch: lexical[r.imageID] > 0,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":   q,
+		"model":   h.Embedder.Model(),
+		"results": results,
+		"count":   len(results),
+	})
+}
+
+// ReindexEmbeddings 在后台为当前用户的所有图片计算（或更新）语义向量，用于启用语义搜索前上传的图片
+// 同一用户已有重建任务在运行时返回 409
+func (h *Handler) ReindexEmbeddings(c *gin.Context) {
+	if h.Embedder == nil || h.Vectors == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Semantic search is not enabled. Please configure EMBEDDING_PROVIDER."})
+		return
+	}
+
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var imageIDs []uint
+	if err := h.DB.Model(&model.Image{}).Where("user_id = ?", userID).Order("id ASC").Pluck("id", &imageIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+		return
+	}
+
+	if !h.embeddings.startReindex(userID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reindexing is already running"})
+		return
+	}
+	queued := h.embeddings.enqueue(imageIDs)
+
+	go func() {
+		defer h.embeddings.finishReindex(userID)
+		indexed, failed := 0, 0
+		for _, imageID := range queued {
+			if err := h.indexQueuedEmbedding(imageID); err != nil {
+				log.Printf("Failed to index embedding of image %d: %v", imageID, err)
+				failed++
+				continue
+			}
+			indexed++
+		}
+		log.Printf("Reindexed embeddings for user %d: %d indexed, %d failed", userID, indexed, failed)
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Reindexing started",
+		"queued":  len(queued),
+	})
+}
+
+func imageIDsOf(matches []service.VectorMatch) []uint {
+	ids := make([]uint, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ImageID
+	}
+	return ids
+}
+
+func mapKeys(m map[uint]float64) []uint {
+	keys := make([]uint, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
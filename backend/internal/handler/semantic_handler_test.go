@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+// newSemanticTestHandler 使用模拟向量提供方和内存向量索引
+func newSemanticTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	h := newTestHandler(t)
+	h.Embedder = service.NewMockEmbeddingProvider()
+	h.Vectors = h.NewImageVectorIndex()
+	return h
+}
+
+func TestSemanticSearchWithStructuredFilter(t *testing.T) {
+	h := newSemanticTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	beach := createTestImage(t, h, alice.ID, "sunset_over_sea.jpg", "beach")
+	city := createTestImage(t, h, alice.ID, "sunset_in_city.jpg", "city")
+	for _, id := range []uint{beach.ID, city.ID} {
+		if err := h.indexImageEmbedding(context.Background(), id); err != nil {
+			t.Fatalf("index embedding: %v", err)
+		}
+	}
+
+	w := performRequest(h.SemanticSearch, http.MethodGet, "/search/semantic", "/search/semantic?q=sunset&tags=beach&blend=0", alice.ID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Results []SemanticResult `json:"results"`
+	}
+	decodeJSON(t, w, &resp)
+	if len(resp.Results) != 1 || resp.Results[0].Image.ID != beach.ID {
+		t.Fatalf("got %+v, want only image %d", resp.Results, beach.ID)
+	}
+}
+
+func TestSemanticSearchBlendsLexicalMatches(t *testing.T) {
+	h := newSemanticTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	// 没有向量的图片只能通过全文搜索找到（单字关键词使用模糊匹配）
+	lexicalOnly := createTestImage(t, h, alice.ID, "海.jpg")
+
+	w := performRequest(h.SemanticSearch, http.MethodGet, "/search/semantic", "/search/semantic?q=%E6%B5%B7&blend=0.5", alice.ID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Results []SemanticResult `json:"results"`
+	}
+	decodeJSON(t, w, &resp)
+	if len(resp.Results) != 1 || resp.Results[0].Image.ID != lexicalOnly.ID || !resp.Results[0].LexicalMatch {
+		t.Fatalf("got %+v, want lexical match on image %d", resp.Results, lexicalOnly.ID)
+	}
+}
+
+func TestLexicalImageIDs(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	match := createTestImage(t, h, alice.ID, "海边.jpg", "beach")
+	createTestImage(t, h, alice.ID, "城市.jpg", "beach")
+	createTestImage(t, h, alice.ID, "海上.jpg", "boat")
+
+	ids, err := h.lexicalImageIDs(alice.ID, ImageFilter{Text: "海", Tags: []string{"beach"}}, 10)
+	if err != nil {
+		t.Fatalf("lexicalImageIDs: %v", err)
+	}
+	if !equalIDs(ids, []uint{match.ID}) {
+		t.Errorf("got %v, want %v", ids, []uint{match.ID})
+	}
+}
+
+func TestEmbeddingQueueDeduplicates(t *testing.T) {
+	var q embeddingQueue
+	if got := q.enqueue([]uint{1, 2}); !equalIDs(got, []uint{1, 2}) {
+		t.Fatalf("first enqueue got %v", got)
+	}
+	if got := q.enqueue([]uint{2, 3}); !equalIDs(got, []uint{3}) {
+		t.Fatalf("pending image queued again: got %v, want [3]", got)
+	}
+	q.dequeue(2)
+	if got := q.enqueue([]uint{2}); !equalIDs(got, []uint{2}) {
+		t.Fatalf("dequeued image not queued again: got %v", got)
+	}
+
+	if !q.startReindex(7) || q.startReindex(7) {
+		t.Fatal("expected a single reindex per user")
+	}
+	if !q.startReindex(8) {
+		t.Fatal("reindex of another user was blocked")
+	}
+	q.finishReindex(7)
+	if !q.startReindex(7) {
+		t.Fatal("reindex not allowed after the previous one finished")
+	}
+}
+
+func TestReindexEmbeddingsSingleFlight(t *testing.T) {
+	h := newSemanticTestHandler(t)
+	h.Scheduler = service.NewAIScheduler(1, 0)
+	alice := createTestUser(t, h, "alice")
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		createTestImage(t, h, alice.ID, name)
+	}
+
+	// 占用调度器唯一的名额，让第一个重建任务停在队列中
+	release, err := h.Scheduler.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := performRequest(h.ReindexEmbeddings, http.MethodPost, "/search/semantic/reindex", "/search/semantic/reindex", alice.ID, nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	w = performRequest(h.ReindexEmbeddings, http.MethodPost, "/search/semantic/reindex", "/search/semantic/reindex", alice.ID, nil)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("second reindex status %d, want 409", w.Code)
+	}
+	release()
+
+	waitFor(t, func() bool {
+		var count int64
+		h.DB.Model(&model.ImageEmbedding{}).Where("user_id = ?", alice.ID).Count(&count)
+		return count == 3
+	})
+	waitFor(t, func() bool { return h.embeddings.startReindex(alice.ID) })
+}
+
+func TestEmbeddingsCountTowardsAIBudget(t *testing.T) {
+	h := newSemanticTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	image := createTestImage(t, h, alice.ID, "sunset_over_sea.jpg", "beach")
+	if err := h.indexImageEmbedding(context.Background(), image.ID); err != nil {
+		t.Fatalf("index embedding: %v", err)
+	}
+
+	var usage []model.AIUsage
+	h.DB.Where("user_id = ?", alice.ID).Find(&usage)
+	if len(usage) != 1 || usage[0].Operation != service.OperationEmbed || usage[0].TotalTokens == 0 {
+		t.Fatalf("usage = %+v, want one embed call for the image owner", usage)
+	}
+
+	// 用完预算后语义搜索不再调用向量提供方
+	h.DB.Model(&alice).Update("AIDailyTokenBudget", int64(1))
+	w := performRequest(h.SemanticSearch, http.MethodGet, "/search/semantic", "/search/semantic?q=sunset", alice.ID, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,298 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+// maxAvoidTags 作为负反馈写入提示词的被拒绝标签数量上限
+const maxAvoidTags = 20
+
+// SuggestionTagCount 按标签汇总的待审核建议数量，便于按标签批量接受或拒绝
+type SuggestionTagCount struct {
+	TagName string `json:"tagName"`
+	Count   int64  `json:"count"`
+}
+
+// suggestAITags 将 AI 标签保存为待审核的建议
+// 图片已有的标签、已经建议过（包括被拒绝过）的标签不会重复建议，返回新建议的数量
+func (h *Handler) suggestAITags(image *model.Image, aiTags []service.AITag, jobID uint) int {
+	var existing []string
+	h.DB.Table("image_tags").
+		Joins("JOIN tags ON tags.id = image_tags.tag_id").
+		Where("image_tags.image_id = ?", image.ID).
+		Pluck("tags.name", &existing)
+	var suggested []string
+	h.DB.Model(&model.TagSuggestion{}).Where("image_id = ?", image.ID).Pluck("tag_name", &suggested)
+
+	skip := make(map[string]bool, len(existing)+len(suggested))
+	for _, name := range append(existing, suggested...) {
+		skip[strings.ToLower(name)] = true
+	}
+
+	created := 0
+	for _, aiTag := range aiTags {
+		tagName := canonicalTagName(h.DB, aiTag.Name)
+		if skip[strings.ToLower(tagName)] {
+			continue
+		}
+		skip[strings.ToLower(tagName)] = true
+
+		suggestion := model.TagSuggestion{
+			ImageID:    image.ID,
+			UserID:     image.UserID,
+			TagName:    tagName,
+			Status:     model.SuggestionPending,
+			Category:   aiTag.Category,
+			Confidence: aiTag.Confidence,
+			JobID:      jobID,
+		}
+		if err := h.DB.Create(&suggestion).Error; err != nil {
+			log.Printf("Failed to save tag suggestion %s for image %d: %v", tagName, image.ID, err)
+			continue
+		}
+		created++
+	}
+	return created
+}
+
+// rejectedTagNames 返回用户拒绝次数多于接受次数的标签，按拒绝次数排序，作为 AI 分析的负反馈
+func (h *Handler) rejectedTagNames(userID uint) []string {
+	var names []string
+	err := h.DB.Model(&model.TagSuggestion{}).
+		Select("tag_name").
+		Where("user_id = ? AND status IN ?", userID, []string{model.SuggestionAccepted, model.SuggestionRejected}).
+		Group("tag_name").
+		Having("SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) > SUM(CASE WHEN status = ? THEN 1 ELSE 0 END)",
+			model.SuggestionRejected, model.SuggestionAccepted).
+		Order("SUM(CASE WHEN status = 'rejected' THEN 1 ELSE 0 END) DESC").
+		Limit(maxAvoidTags).
+		Pluck("tag_name", &names).Error
+	if err != nil {
+		log.Printf("Failed to load rejected tags of user %d: %v", userID, err)
+		return nil
+	}
+	return names
+}
+
+// ListSuggestions 列出整个图库中的 AI 标签建议（默认只返回待审核的）
+// 支持按 status、tag、imageID 筛选，以及 limit/offset 分页
+func (h *Handler) ListSuggestions(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	status := c.DefaultQuery("status", model.SuggestionPending)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	// 只返回未删除图片的建议
+	query := h.DB.Model(&model.TagSuggestion{}).
+		Joins("JOIN images ON images.id = tag_suggestions.image_id AND images.deleted_at IS NULL").
+		Where("tag_suggestions.user_id = ?", userID)
+	if status != "all" {
+		query = query.Where("tag_suggestions.status = ?", status)
+	}
+	if tag := c.Query("tag"); tag != "" {
+		query = query.Where("tag_suggestions.tag_name = ?", tag)
+	}
+	if imageID := c.Query("imageID"); imageID != "" {
+		query = query.Where("tag_suggestions.image_id = ?", imageID)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suggestions"})
+		return
+	}
+
+	var byTag []SuggestionTagCount
+	err = query.Session(&gorm.Session{}).
+		Select("tag_suggestions.tag_name AS tag_name, COUNT(*) AS count").
+		Group("tag_suggestions.tag_name").
+		Order("count DESC").
+		Scan(&byTag).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suggestions"})
+		return
+	}
+
+	var suggestions []model.TagSuggestion
+	err = query.Preload("Image").
+		Order("tag_suggestions.created_at DESC").
+		Limit(limit).Offset(offset).
+		Find(&suggestions).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suggestions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"suggestions": suggestions,
+		"total":       total,
+		"byTag":       byTag,
+	})
+}
+
+// AcceptSuggestion 接受单个标签建议，将标签关联到图片
+func (h *Handler) AcceptSuggestion(c *gin.Context) {
+	h.reviewSuggestion(c, true)
+}
+
+// RejectSuggestion 拒绝单个标签建议
+func (h *Handler) RejectSuggestion(c *gin.Context) {
+	h.reviewSuggestion(c, false)
+}
+
+// reviewSuggestion 审核路径参数指定的单个待审核建议
+func (h *Handler) reviewSuggestion(c *gin.Context, accept bool) {
+	suggestionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid suggestion ID"})
+		return
+	}
+
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var suggestion model.TagSuggestion
+	if err := h.DB.Where("id = ? AND user_id = ?", suggestionID, userID).First(&suggestion).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Suggestion not found"})
+		return
+	}
+	if suggestion.Status != model.SuggestionPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Suggestion has already been reviewed", "status": suggestion.Status})
+		return
+	}
+
+	reviewed, err := h.applySuggestionReview([]model.TagSuggestion{suggestion}, accept)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review suggestion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reviewed": reviewed, "status": reviewStatus(accept)})
+}
+
+// ReviewSuggestions 批量接受或拒绝待审核的建议
+// 可以按建议ID列表、标签名称或图片选择（多个条件同时生效），action 为 accept 或 reject
+func (h *Handler) ReviewSuggestions(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var input struct {
+		IDs     []uint `json:"ids"`
+		Tag     string `json:"tag"`
+		ImageID uint   `json:"imageID"`
+		Action  string `json:"action" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Action != "accept" && input.Action != "reject" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be 'accept' or 'reject'"})
+		return
+	}
+	if len(input.IDs) == 0 && input.Tag == "" && input.ImageID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids, tag or imageID is required"})
+		return
+	}
+
+	query := h.DB.Where("user_id = ? AND status = ?", userID, model.SuggestionPending)
+	if len(input.IDs) > 0 {
+		query = query.Where("id IN ?", input.IDs)
+	}
+	if input.Tag != "" {
+		query = query.Where("tag_name = ?", input.Tag)
+	}
+	if input.ImageID != 0 {
+		query = query.Where("image_id = ?", input.ImageID)
+	}
+
+	var suggestions []model.TagSuggestion
+	if err := query.Find(&suggestions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suggestions"})
+		return
+	}
+	if len(suggestions) == 0 {
+		c.JSON(http.StatusOK, gin.H{"reviewed": 0, "status": reviewStatus(input.Action == "accept")})
+		return
+	}
+
+	reviewed, err := h.applySuggestionReview(suggestions, input.Action == "accept")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review suggestions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reviewed": reviewed, "status": reviewStatus(input.Action == "accept")})
+}
+
+// applySuggestionReview 在事务中更新建议状态，接受时同时将标签关联到图片
+// 只更新仍处于待审核状态的建议，返回实际审核的数量
+func (h *Handler) applySuggestionReview(suggestions []model.TagSuggestion, accept bool) (int, error) {
+	status := reviewStatus(accept)
+	now := time.Now()
+	reviewed := 0
+	acceptedImages := make(map[uint]bool)
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		for _, suggestion := range suggestions {
+			result := tx.Model(&model.TagSuggestion{}).
+				Where("id = ? AND status = ?", suggestion.ID, model.SuggestionPending).
+				Updates(map[string]interface{}{"status": status, "reviewed_at": now})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue // 已被并发的请求审核
+			}
+			reviewed++
+
+			if !accept {
+				continue
+			}
+			var image model.Image
+			if err := tx.First(&image, suggestion.ImageID).Error; err != nil {
+				continue // 图片已删除
+			}
+			h.applyAITags(tx, &image, []service.AITag{{
+				Name:       suggestion.TagName,
+				Category:   suggestion.Category,
+				Confidence: suggestion.Confidence,
+			}})
+			acceptedImages[image.ID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for imageID := range acceptedImages {
+		h.indexImageEmbeddingAsync(imageID)
+	}
+	return reviewed, nil
+}
+
+// reviewStatus 返回审核动作对应的建议状态
+func reviewStatus(accept bool) string {
+	if accept {
+		return model.SuggestionAccepted
+	}
+	return model.SuggestionRejected
+}
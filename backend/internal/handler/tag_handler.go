@@ -1,13 +1,27 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"github.com/Valkqs/image-management-app/backend/internal/model" // ！！！替换为你的模块路径
 )
 
+// 标签管理接口的限制
+const (
+	maxBulkTagImages       = 1000 // 批量添加/移除标签时最多的图片数量
+	maxBulkTagNames        = 50   // 批量添加/移除标签时最多的标签数量
+	defaultTagSuggestLimit = 10   // 标签补全默认返回的数量
+	maxTagSuggestLimit     = 50
+)
+
 // AddTagToImage 为图片添加一个标签
 func (h *Handler) AddTagToImage(c *gin.Context) {
 	// 从 URL 中获取图片 ID
@@ -27,31 +41,60 @@ func (h *Handler) AddTagToImage(c *gin.Context) {
 		return
 	}
 
-	// 查找或创建标签。这可以避免在 tags 表中创建重复的标签
-	var tag model.Tag
-	if err := h.DB.FirstOrCreate(&tag, model.Tag{Name: input.Name}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error on tag"})
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	image, err := h.addTagToImage(userID, uint(imageID), input.Name)
+	if err != nil {
+		if errors.Is(err, errImageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found or you don't have permission"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.JSON(http.StatusOK, image)
+}
+
+// errImageNotFound 图片不存在或不属于当前用户
+var errImageNotFound = errors.New("image not found or you don't have permission")
+
+// addTagToImage 为当前用户的图片添加标签，返回更新后的图片信息（包含所有标签）
+func (h *Handler) addTagToImage(userID, imageID uint, name string) (*model.Image, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("tag name cannot be empty")
+	}
+
 	// 查找图片并验证所有权
 	var image model.Image
-	userID_i, _ := c.Get("userID")
-	userID := userID_i.(uint)
 	if err := h.DB.Where("id = ? AND user_id = ?", imageID, userID).First(&image).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found or you don't have permission"})
-		return
+		return nil, errImageNotFound
 	}
 
-	// 为图片关联标签
-	if err := h.DB.Model(&image).Association("Tags").Append(&tag); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to associate tag with image"})
-		return
+	// 查找或创建标签。同义词解析为规范标签，这可以避免在 tags 表中创建重复的标签
+	name = canonicalTagName(h.DB, name)
+	var tag model.Tag
+	if err := h.DB.FirstOrCreate(&tag, model.Tag{Name: name}).Error; err != nil {
+		return nil, fmt.Errorf("database error on tag")
+	}
+
+	// 为图片关联标签，已有此标签时不重复计入共现统计
+	var count int64
+	h.DB.Table("image_tags").Where("image_id = ? AND tag_id = ?", image.ID, tag.ID).Count(&count)
+	if count == 0 {
+		if err := h.DB.Model(&image).Association("Tags").Append(&tag); err != nil {
+			return nil, fmt.Errorf("failed to associate tag with image")
+		}
+		updateTagCooccurrence(h.DB, userID, image.ID, []uint{tag.ID}, 1)
 	}
 
+	h.indexImageEmbeddingAsync(image.ID)
+
 	// 返回更新后的图片信息（包含所有标签）
 	h.DB.Preload("Tags").First(&image, imageID)
-	c.JSON(http.StatusOK, image)
+	return &image, nil
 }
 
 // RemoveTagFromImage 从图片移除一个标签
@@ -70,33 +113,101 @@ func (h *Handler) RemoveTagFromImage(c *gin.Context) {
 		return
 	}
 
-	// 移除关联
+	// 移除关联，共现统计需要在移除之前更新
 	var tag model.Tag
 	tag.ID = uint(tagID)
+	var count int64
+	h.DB.Table("image_tags").Where("image_id = ? AND tag_id = ?", image.ID, tag.ID).Count(&count)
+	if count > 0 {
+		updateTagCooccurrence(h.DB, userID, image.ID, []uint{tag.ID}, -1)
+	}
 	if err := h.DB.Model(&image).Association("Tags").Delete(&tag); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove tag from image"})
 		return
 	}
+	h.indexImageEmbeddingAsync(image.ID)
 
 	c.JSON(http.StatusNoContent, nil)
 }
 
-// GetAllUsedTags 获取所有正在使用的标签（至少有一张图片关联的标签）
+// TagUsage 标签及其在当前用户图片中的使用情况
+type TagUsage struct {
+	model.Tag
+	Count      int64      `json:"count"`      // 带有该标签的图片数量
+	LastUsedAt *time.Time `json:"lastUsedAt"` // 最近一次关联到图片的时间
+}
+
+// GetAllUsedTags 获取所有正在使用的标签（至少有一张图片关联的标签）及其使用次数和最近使用时间
+// 支持 ?sort=name（默认）|count|recent、?limit=
 func (h *Handler) GetAllUsedTags(c *gin.Context) {
 	userID_i, _ := c.Get("userID")
 	userID := userID_i.(uint)
 
-	var tags []model.Tag
-	// 查询所有标签，这些标签至少关联了当前用户的一张图片
-	err := h.DB.
+	query := h.tagUsageQuery(userID)
+	switch c.DefaultQuery("sort", "name") {
+	case "name":
+		query = query.Order("tags.name ASC")
+	case "count":
+		query = query.Order("count DESC").Order("tags.name ASC")
+	case "recent":
+		query = query.Order("last_used_at DESC").Order("tags.name ASC")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be name, count or recent"})
+		return
+	}
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var tags []TagUsage
+	if err := query.Find(&tags).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tags": tags,
+	})
+}
+
+// tagUsageQuery 当前用户使用中的标签及其使用次数和最近使用时间（按标签分组）
+// 没有关联时间的旧关联以图片的上传时间作为使用时间
+func (h *Handler) tagUsageQuery(userID uint) *gorm.DB {
+	return h.DB.Model(&model.Tag{}).
+		Select("tags.*, COUNT(*) AS count, MAX(COALESCE(image_tags.created_at, images.created_at)) AS last_used_at").
 		Joins("JOIN image_tags ON image_tags.tag_id = tags.id").
-		Joins("JOIN images ON images.id = image_tags.image_id").
+		Joins("JOIN images ON images.id = image_tags.image_id AND images.deleted_at IS NULL").
 		Where("images.user_id = ?", userID).
-		Group("tags.id").
-		Order("tags.name ASC").
-		Find(&tags).Error
+		Group("tags.id")
+}
 
-	if err != nil {
+// likeEscaper 转义 LIKE 模式中的通配符
+var likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
+// AutocompleteTags 按前缀补全标签：GET /tags/autocomplete?prefix=be&limit=10
+// 只返回当前用户使用中的标签，名称或同义词以前缀开头即可匹配，使用次数多的排在前面
+func (h *Handler) AutocompleteTags(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	prefix := strings.TrimSpace(c.Query("prefix"))
+	if prefix == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prefix is required"})
+		return
+	}
+	limit := defaultTagSuggestLimit
+	if parsed, err := strconv.Atoi(c.Query("limit")); err == nil && parsed > 0 {
+		limit = min(parsed, maxTagSuggestLimit)
+	}
+
+	pattern := likeEscaper.Replace(prefix) + "%"
+	aliased := h.DB.Model(&model.TagAlias{}).Select("tag_id").Where("name LIKE ?", pattern)
+	var tags []TagUsage
+	if err := h.tagUsageQuery(userID).
+		Where("tags.name LIKE ? OR tags.id IN (?)", pattern, aliased).
+		Order("count DESC").Order("tags.name ASC").
+		Limit(limit).
+		Find(&tags).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
 		return
 	}
@@ -104,4 +215,212 @@ func (h *Handler) GetAllUsedTags(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"tags": tags,
 	})
-}
\ No newline at end of file
+}
+
+// DeleteTagEverywhere 从当前用户的所有图片上移除标签：DELETE /tags/:id
+// 标签在用户之间共享，其他用户的图片不受影响；该标签待审核的 AI 建议同时被拒绝，之后的分析会避免再次给出
+func (h *Handler) DeleteTagEverywhere(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	tagID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag ID"})
+		return
+	}
+	var tag model.Tag
+	if err := h.DB.First(&tag, tagID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	var imageIDs []uint
+	if err := h.DB.Table("image_tags").
+		Joins("JOIN images ON images.id = image_tags.image_id").
+		Where("image_tags.tag_id = ? AND images.user_id = ?", tag.ID, userID).
+		Pluck("image_tags.image_id", &imageIDs).Error; err != nil {
+		log.Printf("Failed to query images tagged %q for user %d: %v", tag.Name, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+		return
+	}
+
+	var removed int64
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if len(imageIDs) > 0 {
+			deleted := tx.Exec("DELETE FROM image_tags WHERE tag_id = ? AND image_id IN ?", tag.ID, imageIDs)
+			if deleted.Error != nil {
+				return deleted.Error
+			}
+			removed = deleted.RowsAffected
+		}
+		// 该标签不再出现在用户的任何图片上，与它相关的共现统计全部删除
+		if err := tx.Where("user_id = ? AND (tag_id = ? OR other_tag_id = ?)", userID, tag.ID, tag.ID).Delete(&model.TagCooccurrence{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.TagSuggestion{}).
+			Where("user_id = ? AND tag_name = ? AND status = ?", userID, tag.Name, model.SuggestionPending).
+			Updates(map[string]interface{}{"status": model.SuggestionRejected, "reviewed_at": time.Now()}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+		return
+	}
+	h.indexEmbeddingsAsync(imageIDs)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tag removed from all images",
+		"tag":     tag.Name,
+		"removed": removed,
+	})
+}
+
+// BulkTagResult 批量添加或移除一个标签的结果
+type BulkTagResult struct {
+	Tag      string `json:"tag"`      // 同义词解析后的标签名称
+	Affected int64  `json:"affected"` // 实际添加或移除的关联数量
+}
+
+// BulkUpdateTags 在一个事务中为多张图片添加和移除多个标签：POST /images/tags/bulk
+// {"imageIDs": [1, 2], "add": ["beach", "sea"], "remove": ["draft"]}；有图片不属于当前用户时整个请求失败
+func (h *Handler) BulkUpdateTags(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var input struct {
+		ImageIDs []uint   `json:"imageIDs" binding:"required"`
+		Add      []string `json:"add"`
+		Remove   []string `json:"remove"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(input.ImageIDs) == 0 || len(input.ImageIDs) > maxBulkTagImages {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("imageIDs must contain 1-%d images", maxBulkTagImages)})
+		return
+	}
+
+	// 同义词解析为规范标签并去重，同一个标签不能既添加又移除
+	add, remove := h.canonicalTagNames(input.Add), h.canonicalTagNames(input.Remove)
+	if len(add)+len(remove) == 0 || len(add)+len(remove) > maxBulkTagNames {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("add and remove must contain 1-%d tags in total", maxBulkTagNames)})
+		return
+	}
+	adding := make(map[string]bool, len(add))
+	for _, name := range add {
+		if len([]rune(name)) > maxTagNameLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Tag names must be at most %d characters", maxTagNameLength)})
+			return
+		}
+		adding[strings.ToLower(name)] = true
+	}
+	for _, name := range remove {
+		if adding[strings.ToLower(name)] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Tag %q cannot be both added and removed", name)})
+			return
+		}
+	}
+
+	var imageIDs []uint
+	if err := h.DB.Model(&model.Image{}).Where("id IN ? AND user_id = ?", input.ImageIDs, userID).Pluck("id", &imageIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query images"})
+		return
+	}
+	found := make(map[uint]bool, len(imageIDs))
+	for _, id := range imageIDs {
+		found[id] = true
+	}
+	missing := make([]uint, 0)
+	for _, id := range input.ImageIDs {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Some images were not found or you don't have permission", "missingIDs": missing})
+		return
+	}
+
+	added := make([]BulkTagResult, 0, len(add))
+	removed := make([]BulkTagResult, 0, len(remove))
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		for _, name := range add {
+			var tag model.Tag
+			if err := tx.FirstOrCreate(&tag, model.Tag{Name: name}).Error; err != nil {
+				return fmt.Errorf("add %q: %w", name, err)
+			}
+			inserted := tx.Exec("INSERT INTO image_tags (image_id, tag_id, created_at) "+
+				"SELECT images.id, ?, ? FROM images WHERE images.id IN ? "+
+				"AND NOT EXISTS (SELECT 1 FROM image_tags WHERE image_tags.image_id = images.id AND image_tags.tag_id = ?)",
+				tag.ID, now, imageIDs, tag.ID)
+			if inserted.Error != nil {
+				return fmt.Errorf("add %q: %w", name, inserted.Error)
+			}
+			added = append(added, BulkTagResult{Tag: tag.Name, Affected: inserted.RowsAffected})
+		}
+
+		for _, name := range remove {
+			var tag model.Tag
+			if err := tx.Where("name = ?", name).First(&tag).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					removed = append(removed, BulkTagResult{Tag: name})
+					continue // 标签不存在，没有需要移除的关联
+				}
+				return fmt.Errorf("remove %q: %w", name, err)
+			}
+			deleted := tx.Exec("DELETE FROM image_tags WHERE tag_id = ? AND image_id IN ?", tag.ID, imageIDs)
+			if deleted.Error != nil {
+				return fmt.Errorf("remove %q: %w", name, deleted.Error)
+			}
+			removed = append(removed, BulkTagResult{Tag: tag.Name, Affected: deleted.RowsAffected})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to bulk update tags for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tags"})
+		return
+	}
+	// 批量修改可能涉及上千张图片，直接重新计算共现统计比逐张图片增量更新的查询更少
+	if err := rebuildTagCooccurrence(h.DB, userID); err != nil {
+		log.Printf("Failed to rebuild tag co-occurrence for user %d: %v", userID, err)
+	}
+	h.indexEmbeddingsAsync(imageIDs)
+
+	c.JSON(http.StatusOK, gin.H{
+		"imageCount": len(imageIDs),
+		"added":      added,
+		"removed":    removed,
+	})
+}
+
+// canonicalTagNames 清理标签名称，将同义词解析为规范标签并去重（忽略大小写）
+func (h *Handler) canonicalTagNames(names []string) []string {
+	result := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		name = canonicalTagName(h.DB, name)
+		if key := strings.ToLower(name); !seen[key] {
+			seen[key] = true
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// usedTags 查询所有标签，这些标签至少关联了当前用户的一张图片
+func (h *Handler) usedTags(userID uint) ([]model.Tag, error) {
+	var tags []model.Tag
+	err := h.DB.
+		Joins("JOIN image_tags ON image_tags.tag_id = tags.id").
+		Joins("JOIN images ON images.id = image_tags.image_id").
+		Where("images.user_id = ?", userID).
+		Group("tags.id").
+		Order("tags.name ASC").
+		Find(&tags).Error
+	return tags, err
+}
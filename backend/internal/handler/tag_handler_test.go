@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+)
+
+func TestDeleteTagEverywhere(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	bob := createTestUser(t, h, "bob")
+	createTestImage(t, h, alice.ID, "a.jpg", "beach")
+	createTestImage(t, h, alice.ID, "b.jpg", "beach", "sea")
+	bobs := createTestImage(t, h, bob.ID, "c.jpg", "beach")
+	var tag model.Tag
+	h.DB.Where("name = ?", "beach").First(&tag)
+	path := fmt.Sprintf("/tags/%d", tag.ID)
+
+	w := performRequest(h.DeleteTagEverywhere, http.MethodDelete, "/tags/:id", path, alice.ID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Removed int64 `json:"removed"`
+	}
+	decodeJSON(t, w, &resp)
+	if resp.Removed != 2 {
+		t.Errorf("removed %d, want 2", resp.Removed)
+	}
+	// 其他用户的图片不受影响
+	var remaining []uint
+	h.DB.Table("image_tags").Where("tag_id = ?", tag.ID).Pluck("image_id", &remaining)
+	if !equalIDs(remaining, []uint{bobs.ID}) {
+		t.Errorf("remaining tagged images %v, want %v", remaining, []uint{bobs.ID})
+	}
+
+	// 查询失败时返回 500，而不是当作没有需要移除的图片
+	h.DB.Exec("DROP TABLE image_tags")
+	w = performRequest(h.DeleteTagEverywhere, http.MethodDelete, "/tags/:id", path, alice.ID, nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status %d after query failure, want 500", w.Code)
+	}
+}
+
+func TestBulkUpdateTagsHidesDatabaseErrors(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	image := createTestImage(t, h, alice.ID, "a.jpg")
+	h.DB.Exec("DROP TABLE image_tags")
+
+	w := performRequest(h.BulkUpdateTags, http.MethodPost, "/images/tags/bulk", "/images/tags/bulk", alice.ID,
+		gin.H{"imageIDs": []uint{image.ID}, "add": []string{"beach"}})
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Error string `json:"error"`
+	}
+	decodeJSON(t, w, &resp)
+	if resp.Error != "Failed to update tags" || strings.Contains(w.Body.String(), "image_tags") {
+		t.Errorf("response leaks database details: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+)
+
+// 标签推荐的参数：各个信号的权重和相似图片的范围
+const (
+	recommendCooccurrenceWeight = 0.5  // 与图片已有标签的共现
+	recommendTimeWeight         = 0.25 // 拍摄时间相近的图片（同一次出行或活动）
+	recommendLocationWeight     = 0.15 // 拍摄地点相近的图片
+	recommendCameraWeight       = 0.1  // 同一台相机拍摄的图片
+
+	recommendTimeWindow       = 3 * time.Hour
+	recommendLocationRadiusKm = 2.0
+	recommendNeighborLimit    = 200 // 每种相似图片最多统计的数量
+	minCooccurrenceCount      = 2   // 共现次数少于该值的标签对不参与推荐，避免偶然的组合
+	minRecommendScore         = 0.05
+	defaultRecommendLimit     = 10
+	maxRecommendLimit         = 50
+)
+
+// updateTagCooccurrence 增量更新标签共现统计：图片添加（delta=1，在添加之后调用）或移除（delta=-1，在移除之前调用）了 tagIDs 中的标签
+// 统计失败不影响标签操作，只记录日志；数据不一致时可以通过 POST /tags/cooccurrence/rebuild 重新计算
+func updateTagCooccurrence(db *gorm.DB, userID, imageID uint, tagIDs []uint, delta int64) {
+	if len(tagIDs) == 0 {
+		return
+	}
+	var current []uint
+	if err := db.Table("image_tags").Where("image_id = ?", imageID).Pluck("tag_id", &current).Error; err != nil {
+		log.Printf("Failed to update tag co-occurrence of image %d: %v", imageID, err)
+		return
+	}
+
+	changed := make(map[uint]bool, len(tagIDs))
+	for _, id := range tagIDs {
+		changed[id] = true
+	}
+	// 变化的标签自身（图片数量）、变化的标签两两之间、变化的标签与图片上其他标签之间
+	pairs := make([][2]uint, 0, len(tagIDs)*(len(current)+1))
+	for id := range changed {
+		pairs = append(pairs, [2]uint{id, id})
+		for _, other := range current {
+			if other == id {
+				continue
+			}
+			pairs = append(pairs, [2]uint{id, other})
+			if !changed[other] {
+				pairs = append(pairs, [2]uint{other, id})
+			}
+		}
+	}
+
+	var err error
+	if delta > 0 {
+		rows := make([]model.TagCooccurrence, len(pairs))
+		for i, pair := range pairs {
+			rows[i] = model.TagCooccurrence{UserID: userID, TagID: pair[0], OtherTagID: pair[1], Count: delta}
+		}
+		err = db.Clauses(clause.OnConflict{
+			DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("count + ?", delta)}),
+		}).Create(&rows).Error
+	} else {
+		keys := make([][]interface{}, len(pairs))
+		for i, pair := range pairs {
+			keys[i] = []interface{}{pair[0], pair[1]}
+		}
+		err = db.Model(&model.TagCooccurrence{}).
+			Where("user_id = ? AND (tag_id, other_tag_id) IN ?", userID, keys).
+			Update("count", gorm.Expr("GREATEST(count + ?, 0)", delta)).Error
+	}
+	if err != nil {
+		log.Printf("Failed to update tag co-occurrence of image %d: %v", imageID, err)
+	}
+}
+
+// forgetImageCooccurrence 删除图片之前从共现统计中减去它的所有标签
+func forgetImageCooccurrence(db *gorm.DB, image *model.Image) {
+	var tagIDs []uint
+	db.Table("image_tags").Where("image_id = ?", image.ID).Pluck("tag_id", &tagIDs)
+	updateTagCooccurrence(db, image.UserID, image.ID, tagIDs, -1)
+}
+
+// rebuildTagCooccurrence 根据当前的图片标签重新计算用户的共现统计
+func rebuildTagCooccurrence(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&model.TagCooccurrence{}).Error; err != nil {
+			return err
+		}
+		return tx.Exec("INSERT INTO tag_cooccurrences (user_id, tag_id, other_tag_id, count) "+
+			"SELECT ?, a.tag_id, b.tag_id, COUNT(*) FROM image_tags a "+
+			"JOIN image_tags b ON b.image_id = a.image_id "+
+			"JOIN images ON images.id = a.image_id AND images.user_id = ? AND images.deleted_at IS NULL "+
+			"GROUP BY a.tag_id, b.tag_id",
+			userID, userID).Error
+	})
+}
+
+// RebuildTagCooccurrence 重新计算当前用户的标签共现统计：POST /tags/cooccurrence/rebuild
+func (h *Handler) RebuildTagCooccurrence(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	if err := rebuildTagCooccurrence(h.DB, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild tag statistics"})
+		return
+	}
+
+	var pairs int64
+	h.DB.Model(&model.TagCooccurrence{}).Where("user_id = ? AND tag_id <> other_tag_id", userID).Count(&pairs)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tag statistics rebuilt",
+		"pairs":   pairs,
+	})
+}
+
+// TagRecommendation 为图片推荐的一个标签
+type TagRecommendation struct {
+	TagID   uint               `json:"tagID"`
+	Name    string             `json:"name"`
+	Score   float64            `json:"score"`   // 0-1
+	Signals map[string]float64 `json:"signals"` // 各个信号的得分：cooccurrence、time、location、camera
+}
+
+// GetTagRecommendations 根据图库中的标签共现、拍摄时间和地点相近的图片以及相机为图片推荐标签
+// GET /images/:id/tags/recommended?limit=10
+func (h *Handler) GetTagRecommendations(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+	limit := defaultRecommendLimit
+	if parsed, err := strconv.Atoi(c.Query("limit")); err == nil && parsed > 0 {
+		limit = min(parsed, maxRecommendLimit)
+	}
+
+	var image model.Image
+	if err := h.DB.Preload("Tags").Where("id = ? AND user_id = ?", imageID, userID).First(&image).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found or you don't have permission"})
+		return
+	}
+
+	// 已有的标签和在这张图片上拒绝过的 AI 建议不再推荐
+	exclude := make(map[uint]bool, len(image.Tags))
+	tagIDs := make([]uint, len(image.Tags))
+	for i, tag := range image.Tags {
+		exclude[tag.ID] = true
+		tagIDs[i] = tag.ID
+	}
+	var rejected []uint
+	h.DB.Model(&model.Tag{}).
+		Where("name IN (?)", h.DB.Model(&model.TagSuggestion{}).Select("tag_name").
+			Where("image_id = ? AND status = ?", image.ID, model.SuggestionRejected)).
+		Pluck("id", &rejected)
+	for _, id := range rejected {
+		exclude[id] = true
+	}
+
+	signals := make(map[string]map[uint]float64)
+	weights := make(map[string]float64)
+	if len(tagIDs) > 0 {
+		scores, err := h.cooccurrenceScores(userID, tagIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute tag statistics"})
+			return
+		}
+		signals["cooccurrence"], weights["cooccurrence"] = scores, recommendCooccurrenceWeight
+	}
+
+	// 拍摄时间相近的图片（没有拍摄时间时使用上传时间）
+	at := image.CreatedAt
+	if image.TakenAt != nil {
+		at = *image.TakenAt
+	}
+	neighbors := h.DB.Model(&model.Image{}).Where("user_id = ? AND id <> ?", userID, image.ID)
+	signals["time"] = h.neighborTagShares(neighbors.Session(&gorm.Session{}).
+		Where("COALESCE(taken_at, created_at) BETWEEN ? AND ?", at.Add(-recommendTimeWindow), at.Add(recommendTimeWindow)).
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "ABS(TIMESTAMPDIFF(SECOND, COALESCE(taken_at, created_at), ?))", Vars: []interface{}{at}}}))
+	weights["time"] = recommendTimeWeight
+
+	// 拍摄地点相近的图片
+	if image.Latitude != nil && image.Longitude != nil {
+		lat, lon := *image.Latitude, *image.Longitude
+		latDelta := recommendLocationRadiusKm / 111.0
+		lonDelta := recommendLocationRadiusKm / (111.0 * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+		signals["location"] = h.neighborTagShares(neighbors.Session(&gorm.Session{}).
+			Where("latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?", lat-latDelta, lat+latDelta, lon-lonDelta, lon+lonDelta).
+			Order("created_at DESC"))
+		weights["location"] = recommendLocationWeight
+	}
+
+	// 同一台相机拍摄的图片
+	if image.CameraModel != "" {
+		signals["camera"] = h.neighborTagShares(neighbors.Session(&gorm.Session{}).
+			Where("camera_make = ? AND camera_model = ?", image.CameraMake, image.CameraModel).
+			Order("created_at DESC"))
+		weights["camera"] = recommendCameraWeight
+	}
+
+	// 按可用信号的权重加权平均
+	totalWeight := 0.0
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	combined := make(map[uint]*TagRecommendation)
+	for signal, scores := range signals {
+		for tagID, score := range scores {
+			if exclude[tagID] {
+				continue
+			}
+			rec, ok := combined[tagID]
+			if !ok {
+				rec = &TagRecommendation{TagID: tagID, Signals: make(map[string]float64)}
+				combined[tagID] = rec
+			}
+			rec.Signals[signal] = math.Round(score*1000) / 1000
+			rec.Score += score * weights[signal] / totalWeight
+		}
+	}
+
+	candidates := make([]uint, 0, len(combined))
+	for tagID, rec := range combined {
+		if rec.Score >= minRecommendScore {
+			candidates = append(candidates, tagID)
+		}
+	}
+	recommendations := make([]TagRecommendation, 0, len(candidates))
+	if len(candidates) > 0 {
+		var tags []model.Tag
+		if err := h.DB.Where("id IN ?", candidates).Find(&tags).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+			return
+		}
+		for _, tag := range tags {
+			rec := combined[tag.ID]
+			rec.Name = tag.Name
+			rec.Score = math.Round(rec.Score*1000) / 1000
+			recommendations = append(recommendations, *rec)
+		}
+	}
+	sort.Slice(recommendations, func(i, j int) bool {
+		if recommendations[i].Score != recommendations[j].Score {
+			return recommendations[i].Score > recommendations[j].Score
+		}
+		return recommendations[i].Name < recommendations[j].Name
+	})
+	if len(recommendations) > limit {
+		recommendations = recommendations[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"imageID":         image.ID,
+		"recommendations": recommendations,
+	})
+}
+
+// cooccurrenceScores 根据共现统计计算候选标签的得分：
+// 对图片上的每个标签 T，P(B|T) = 共现次数(T,B) / 带有 T 的图片数，多个标签的概率按 noisy-OR 合并
+// 用户还没有统计数据时先根据现有的图片标签计算一次
+func (h *Handler) cooccurrenceScores(userID uint, tagIDs []uint) (map[uint]float64, error) {
+	var rows []model.TagCooccurrence
+	load := func() error {
+		return h.DB.Where("user_id = ? AND tag_id IN ? AND count > 0", userID, tagIDs).Find(&rows).Error
+	}
+	if err := load(); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		var exists int64
+		h.DB.Model(&model.TagCooccurrence{}).Where("user_id = ?", userID).Limit(1).Count(&exists)
+		if exists == 0 {
+			if err := rebuildTagCooccurrence(h.DB, userID); err != nil {
+				return nil, err
+			}
+			if err := load(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	totals := make(map[uint]int64, len(tagIDs))
+	for _, row := range rows {
+		if row.TagID == row.OtherTagID {
+			totals[row.TagID] = row.Count
+		}
+	}
+	missing := make(map[uint]float64)
+	for _, row := range rows {
+		total := totals[row.TagID]
+		if row.TagID == row.OtherTagID || total == 0 || row.Count < minCooccurrenceCount {
+			continue
+		}
+		p := math.Min(float64(row.Count)/float64(total), 1)
+		if _, ok := missing[row.OtherTagID]; !ok {
+			missing[row.OtherTagID] = 1
+		}
+		missing[row.OtherTagID] *= 1 - p
+	}
+
+	scores := make(map[uint]float64, len(missing))
+	for tagID, m := range missing {
+		scores[tagID] = 1 - m
+	}
+	return scores, nil
+}
+
+// neighborTagShares 统计一组相似图片（最多 recommendNeighborLimit 张）中每个标签出现的比例
+func (h *Handler) neighborTagShares(neighbors *gorm.DB) map[uint]float64 {
+	var imageIDs []uint
+	if err := neighbors.Limit(recommendNeighborLimit).Pluck("id", &imageIDs).Error; err != nil {
+		log.Printf("Failed to find similar images: %v", err)
+		return nil
+	}
+	if len(imageIDs) == 0 {
+		return nil
+	}
+
+	var counts []struct {
+		TagID uint
+		Count int64
+	}
+	if err := h.DB.Table("image_tags").Select("tag_id, COUNT(*) AS count").
+		Where("image_id IN ?", imageIDs).Group("tag_id").Scan(&counts).Error; err != nil {
+		log.Printf("Failed to count tags of similar images: %v", err)
+		return nil
+	}
+	shares := make(map[uint]float64, len(counts))
+	for _, row := range counts {
+		shares[row.TagID] = float64(row.Count) / float64(len(imageIDs))
+	}
+	return shares
+}
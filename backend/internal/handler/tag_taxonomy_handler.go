@@ -0,0 +1,424 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+)
+
+// 标签层级和名称的限制
+const (
+	maxTagDepth      = 10  // 标签层级的最大深度，查找子标签时也最多展开这么多层
+	maxTagNameLength = 100 // 与 tags.name 的列宽一致
+)
+
+// errTagConflict 名称已经被其他标签或同义词使用
+var errTagConflict = errors.New("name is already used by another tag or alias")
+
+// canonicalTagName 将同义词解析为规范标签的名称，不是同义词时原样返回
+func canonicalTagName(db *gorm.DB, name string) string {
+	var tag model.Tag
+	err := db.Joins("JOIN tag_aliases ON tag_aliases.tag_id = tags.id").
+		Where("tag_aliases.name = ?", name).
+		First(&tag).Error
+	if err != nil {
+		return name
+	}
+	return tag.Name
+}
+
+// tagMatchClause 按标签名称筛选的条件：匹配这些标签、它们的同义词和所有子标签
+// 名称都不存在时按名称匹配（不会匹配任何图片）
+func tagMatchClause(db *gorm.DB, names []string) (string, interface{}) {
+	ids, err := matchingTagIDs(db, names)
+	if err != nil {
+		log.Printf("Failed to expand tags %v: %v", names, err)
+	}
+	if len(ids) == 0 {
+		return tagExists("tags.name IN ?"), names
+	}
+	return tagExists("tags.id IN ?"), ids
+}
+
+// matchingTagIDs 名称或同义词对应的标签及其所有子标签的ID
+func matchingTagIDs(db *gorm.DB, names []string) ([]uint, error) {
+	var ids []uint
+	aliased := db.Model(&model.TagAlias{}).Select("tag_id").Where("name IN ?", names)
+	if err := db.Model(&model.Tag{}).Where("name IN ? OR id IN (?)", names, aliased).Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return withDescendants(db, ids)
+}
+
+// withDescendants 返回标签及其所有子标签的ID（最多展开 maxTagDepth 层）
+func withDescendants(db *gorm.DB, ids []uint) ([]uint, error) {
+	seen := make(map[uint]bool, len(ids))
+	all := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			all = append(all, id)
+		}
+	}
+
+	frontier := all
+	for depth := 0; len(frontier) > 0 && depth < maxTagDepth; depth++ {
+		var children []uint
+		if err := db.Model(&model.Tag{}).Where("parent_id IN ?", frontier).Pluck("id", &children).Error; err != nil {
+			return nil, err
+		}
+		frontier = make([]uint, 0, len(children))
+		for _, id := range children {
+			if !seen[id] {
+				seen[id] = true
+				all = append(all, id)
+				frontier = append(frontier, id)
+			}
+		}
+	}
+	return all, nil
+}
+
+// checkTagNameAvailable 检查名称没有被其他标签（包括已软删除的）或其他标签的同义词使用
+func checkTagNameAvailable(db *gorm.DB, name string, tagID uint) error {
+	var count int64
+	if err := db.Unscoped().Model(&model.Tag{}).Where("name = ? AND id <> ?", name, tagID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		if err := db.Model(&model.TagAlias{}).Where("name = ? AND tag_id <> ?", name, tagID).Count(&count).Error; err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return errTagConflict
+	}
+	return nil
+}
+
+// taggedImageIDs 带有这些标签的图片
+func taggedImageIDs(db *gorm.DB, tagIDs []uint) []uint {
+	var imageIDs []uint
+	db.Table("image_tags").Where("tag_id IN ?", tagIDs).Distinct().Pluck("image_id", &imageIDs)
+	return imageIDs
+}
+
+// findTag 按路径参数查找标签（预加载同义词），找不到时写入错误响应
+func (h *Handler) findTag(c *gin.Context) (*model.Tag, bool) {
+	tagID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag ID"})
+		return nil, false
+	}
+
+	var tag model.Tag
+	if err := h.DB.Preload("Aliases").First(&tag, tagID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return nil, false
+	}
+	return &tag, true
+}
+
+// AdminGetTag 查看标签的同义词、父标签和直接子标签：GET /admin/tags/:id
+func (h *Handler) AdminGetTag(c *gin.Context) {
+	tag, ok := h.findTag(c)
+	if !ok {
+		return
+	}
+
+	var children []model.Tag
+	if err := h.DB.Where("parent_id = ?", tag.ID).Order("name ASC").Find(&children).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch child tags"})
+		return
+	}
+	var parent *model.Tag
+	if tag.ParentID != nil {
+		var p model.Tag
+		if err := h.DB.First(&p, *tag.ParentID).Error; err == nil {
+			parent = &p
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tag":      tag,
+		"parent":   parent,
+		"children": children,
+	})
+}
+
+// AdminSetTagParent 设置或清除父标签：PUT /admin/tags/:id/parent，{"parentID": 3} 或 {"parentID": null}
+func (h *Handler) AdminSetTagParent(c *gin.Context) {
+	tag, ok := h.findTag(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		ParentID *uint `json:"parentID"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.ParentID != nil {
+		// 沿着新父标签向上查找，不能形成环，层级也不能超过上限
+		current := *input.ParentID
+		for depth := 0; ; depth++ {
+			if current == tag.ID {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "A tag cannot be its own ancestor"})
+				return
+			}
+			if depth >= maxTagDepth {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Tag hierarchy cannot be deeper than %d levels", maxTagDepth)})
+				return
+			}
+			var ancestor model.Tag
+			if err := h.DB.First(&ancestor, current).Error; err != nil {
+				if depth == 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Parent tag not found"})
+					return
+				}
+				break
+			}
+			if ancestor.ParentID == nil {
+				break
+			}
+			current = *ancestor.ParentID
+		}
+	}
+
+	if err := h.DB.Model(tag).Update("parent_id", input.ParentID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update parent tag"})
+		return
+	}
+	tag.ParentID = input.ParentID
+
+	c.JSON(http.StatusOK, tag)
+}
+
+// AdminAddTagAlias 为标签添加同义词：POST /admin/tags/:id/aliases，{"name": "puppy"}
+func (h *Handler) AdminAddTagAlias(c *gin.Context) {
+	tag, ok := h.findTag(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := strings.TrimSpace(input.Name)
+	if name == "" || len([]rune(name)) > maxTagNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Alias must be 1-%d characters", maxTagNameLength)})
+		return
+	}
+
+	// 已经是标签的名称不能再作为同义词，请使用合并
+	if err := checkTagNameAvailable(h.DB, name, 0); err != nil {
+		if errors.Is(err, errTagConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Name is already used by a tag or alias; merge the tags instead"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check alias"})
+		return
+	}
+
+	alias := model.TagAlias{Name: name, TagID: tag.ID}
+	if err := h.DB.Create(&alias).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create alias"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, alias)
+}
+
+// AdminDeleteTagAlias 删除标签的同义词：DELETE /admin/tags/:id/aliases/:aliasID
+func (h *Handler) AdminDeleteTagAlias(c *gin.Context) {
+	tag, ok := h.findTag(c)
+	if !ok {
+		return
+	}
+	aliasID, err := strconv.Atoi(c.Param("aliasID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alias ID"})
+		return
+	}
+
+	result := h.DB.Where("id = ? AND tag_id = ?", aliasID, tag.ID).Delete(&model.TagAlias{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alias"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alias not found"})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// AdminRenameTag 重命名标签：PATCH /admin/tags/:id，{"name": "dog"}
+// 旧名称保留为同义词，之后的 AI 标签和筛选仍然解析到该标签；新名称已经是其他标签时请使用合并
+func (h *Handler) AdminRenameTag(c *gin.Context) {
+	tag, ok := h.findTag(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := strings.TrimSpace(input.Name)
+	if name == "" || len([]rune(name)) > maxTagNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Tag name must be 1-%d characters", maxTagNameLength)})
+		return
+	}
+	if name == tag.Name {
+		c.JSON(http.StatusOK, tag)
+		return
+	}
+
+	oldName := tag.Name
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkTagNameAvailable(tx, name, tag.ID); err != nil {
+			return err
+		}
+		// 新名称原来是该标签的同义词时，与旧名称交换
+		if err := tx.Where("name = ? AND tag_id = ?", name, tag.ID).Delete(&model.TagAlias{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(tag).Update("name", name).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.TagAlias{Name: oldName, TagID: tag.ID}).Error
+	})
+	if err != nil {
+		if errors.Is(err, errTagConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Name is already used by another tag or alias; merge the tags instead"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename tag"})
+		return
+	}
+
+	h.indexEmbeddingsAsync(taggedImageIDs(h.DB, []uint{tag.ID}))
+	h.DB.Preload("Aliases").First(tag, tag.ID)
+	c.JSON(http.StatusOK, tag)
+}
+
+// AdminMergeTags 将其他标签合并到该标签：POST /admin/tags/:id/merge，{"sourceIDs": [4, 7]}
+// 被合并标签的图片关联改为该标签，名称和同义词成为该标签的同义词，子标签移到该标签下，然后删除被合并的标签
+func (h *Handler) AdminMergeTags(c *gin.Context) {
+	target, ok := h.findTag(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		SourceIDs []uint `json:"sourceIDs" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var sources []model.Tag
+	if err := h.DB.Where("id IN ? AND id <> ?", input.SourceIDs, target.ID).Find(&sources).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+	if len(sources) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No tags to merge"})
+		return
+	}
+	sourceIDs := make([]uint, len(sources))
+	isSource := make(map[uint]*model.Tag, len(sources))
+	for i := range sources {
+		sourceIDs[i] = sources[i].ID
+		isSource[sources[i].ID] = &sources[i]
+	}
+	affected := taggedImageIDs(h.DB, sourceIDs)
+
+	var moved int64
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// 被合并标签的图片关联改为目标标签（已经带有目标标签的图片不重复关联，保留关联时间）
+		inserted := tx.Exec("INSERT INTO image_tags (image_id, tag_id, created_at) "+
+			"SELECT it.image_id, ?, MAX(it.created_at) FROM image_tags it WHERE it.tag_id IN ? "+
+			"AND NOT EXISTS (SELECT 1 FROM image_tags existing WHERE existing.image_id = it.image_id AND existing.tag_id = ?) "+
+			"GROUP BY it.image_id",
+			target.ID, sourceIDs, target.ID)
+		if inserted.Error != nil {
+			return inserted.Error
+		}
+		moved = inserted.RowsAffected
+		if err := tx.Exec("DELETE FROM image_tags WHERE tag_id IN ?", sourceIDs).Error; err != nil {
+			return err
+		}
+
+		// 同义词和名称
+		if err := tx.Model(&model.TagAlias{}).Where("tag_id IN ?", sourceIDs).Update("tag_id", target.ID).Error; err != nil {
+			return err
+		}
+		for _, source := range sources {
+			if err := tx.Where(model.TagAlias{Name: source.Name}).Assign(model.TagAlias{TagID: target.ID}).
+				FirstOrCreate(&model.TagAlias{}).Error; err != nil {
+				return err
+			}
+		}
+
+		// 层级：子标签移到目标标签下；目标标签原来在被合并标签下时，改为挂在其上层
+		if err := tx.Model(&model.Tag{}).Where("parent_id IN ? AND id <> ?", sourceIDs, target.ID).
+			Update("parent_id", target.ID).Error; err != nil {
+			return err
+		}
+		if target.ParentID != nil && isSource[*target.ParentID] != nil {
+			parentID := isSource[*target.ParentID].ParentID
+			for depth := 0; parentID != nil && isSource[*parentID] != nil && depth < maxTagDepth; depth++ {
+				parentID = isSource[*parentID].ParentID
+			}
+			if parentID != nil && (*parentID == target.ID || isSource[*parentID] != nil) {
+				parentID = nil
+			}
+			if err := tx.Model(target).Update("parent_id", parentID).Error; err != nil {
+				return err
+			}
+			target.ParentID = parentID
+		}
+
+		// 彻底删除被合并的标签，释放名称供同义词使用
+		return tx.Unscoped().Where("id IN ?", sourceIDs).Delete(&model.Tag{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge tags"})
+		return
+	}
+
+	// 被合并的标签的共现统计按合并后的标签重新计算
+	if len(affected) > 0 {
+		var userIDs []uint
+		h.DB.Model(&model.Image{}).Where("id IN ?", affected).Distinct().Pluck("user_id", &userIDs)
+		for _, userID := range userIDs {
+			if err := rebuildTagCooccurrence(h.DB, userID); err != nil {
+				log.Printf("Failed to rebuild tag co-occurrence for user %d: %v", userID, err)
+			}
+		}
+	}
+	h.indexEmbeddingsAsync(affected)
+	h.DB.Preload("Aliases").First(target, target.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"tag":            target,
+		"merged":         len(sources),
+		"imagesRetagged": moved,
+	})
+}
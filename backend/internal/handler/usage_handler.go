@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/Valkqs/image-management-app/backend/internal/service"
+)
+
+// AIBudget 用户的 AI token 预算，0 表示不限制
+type AIBudget struct {
+	DailyTokens   int64 `json:"dailyTokens"`
+	MonthlyTokens int64 `json:"monthlyTokens"`
+}
+
+// defaultAIBudget 从环境变量读取默认预算（默认不限制）
+func defaultAIBudget() AIBudget {
+	return AIBudget{
+		DailyTokens:   envInt64("AI_DAILY_TOKEN_BUDGET", 0),
+		MonthlyTokens: envInt64("AI_MONTHLY_TOKEN_BUDGET", 0),
+	}
+}
+
+// effectiveAIBudget 计算用户实际生效的预算（管理员覆盖值优先）
+func effectiveAIBudget(user *model.User) AIBudget {
+	budget := defaultAIBudget()
+	if user.AIDailyTokenBudget != nil {
+		budget.DailyTokens = *user.AIDailyTokenBudget
+	}
+	if user.AIMonthlyTokenBudget != nil {
+		budget.MonthlyTokens = *user.AIMonthlyTokenBudget
+	}
+	return budget
+}
+
+// budgetPeriodStarts 返回当天和当月的开始时间（服务器时区）
+func budgetPeriodStarts(now time.Time) (day, month time.Time) {
+	day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	month = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return day, month
+}
+
+// dbUsageTracker 将 AI 用量写入数据库，并根据已用 token 数检查预算
+type dbUsageTracker struct {
+	db *gorm.DB
+}
+
+// NewUsageTracker 创建基于数据库的 AI 用量记录器
+func (h *Handler) NewUsageTracker() service.UsageTracker {
+	return &dbUsageTracker{db: h.DB}
+}
+
+// CheckBudget 实现 service.UsageTracker
+func (t *dbUsageTracker) CheckBudget(ctx context.Context, userID uint) error {
+	var user model.User
+	if err := t.db.First(&user, userID).Error; err != nil {
+		return nil // 用户不存在时不拦截，由调用方处理
+	}
+	budget := effectiveAIBudget(&user)
+	if budget.DailyTokens == 0 && budget.MonthlyTokens == 0 {
+		return nil
+	}
+
+	day, month := budgetPeriodStarts(time.Now())
+	used, err := tokensUsedSince(t.db, userID, day, month)
+	if err != nil {
+		log.Printf("Failed to check AI budget of user %d: %v", userID, err)
+		return nil
+	}
+	if budget.DailyTokens > 0 && used.Today >= budget.DailyTokens {
+		return fmt.Errorf("%w: daily budget of %d tokens used up", service.ErrBudgetExceeded, budget.DailyTokens)
+	}
+	if budget.MonthlyTokens > 0 && used.Month >= budget.MonthlyTokens {
+		return fmt.Errorf("%w: monthly budget of %d tokens used up", service.ErrBudgetExceeded, budget.MonthlyTokens)
+	}
+	return nil
+}
+
+// Record 实现 service.UsageTracker
+func (t *dbUsageTracker) Record(ctx context.Context, record service.UsageRecord) {
+	usage := model.AIUsage{
+		UserID:           record.UserID,
+		Operation:        record.Operation,
+		Provider:         record.Provider,
+		Model:            record.Model,
+		PromptVersion:    record.PromptVersion,
+		PromptTokens:     record.PromptTokens,
+		CompletionTokens: record.CompletionTokens,
+		TotalTokens:      record.PromptTokens + record.CompletionTokens,
+		Estimated:        record.Estimated,
+		LatencyMs:        record.Latency.Milliseconds(),
+		Outcome:          record.Outcome,
+		Error:            record.Error,
+	}
+	if err := t.db.Create(&usage).Error; err != nil {
+		log.Printf("Failed to record AI usage of user %d: %v", record.UserID, err)
+	}
+}
+
+// tokenTotals 当天和当月已用的 token 数
+type tokenTotals struct {
+	Today int64 `json:"today"`
+	Month int64 `json:"month"`
+}
+
+// tokensUsedSince 统计用户从 day 和 month 开始已用的 token 数
+func tokensUsedSince(db *gorm.DB, userID uint, day, month time.Time) (tokenTotals, error) {
+	var totals tokenTotals
+	err := db.Model(&model.AIUsage{}).
+		Select("COALESCE(SUM(CASE WHEN created_at >= ? THEN total_tokens ELSE 0 END), 0) AS today, COALESCE(SUM(total_tokens), 0) AS month", day).
+		Where("user_id = ? AND created_at >= ?", userID, month).
+		Scan(&totals).Error
+	return totals, err
+}
+
+// aiErrorStatus 返回 AI 调用失败时的 HTTP 状态码：超出预算为 429，提供方熔断为 503，其他为 500
+func aiErrorStatus(err error) int {
+	if errors.Is(err, service.ErrBudgetExceeded) {
+		return http.StatusTooManyRequests
+	}
+	if service.ClassifyError(err) == service.ErrorKindCircuitOpen {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// UsageBreakdown 按操作和模型汇总的用量
+type UsageBreakdown struct {
+	Operation        string  `json:"operation"`
+	Model            string  `json:"model"`
+	Calls            int64   `json:"calls"`
+	CachedCalls      int64   `json:"cachedCalls"`
+	FailedCalls      int64   `json:"failedCalls"`
+	PromptTokens     int64   `json:"promptTokens"`
+	CompletionTokens int64   `json:"completionTokens"`
+	TotalTokens      int64   `json:"totalTokens"`
+	AvgLatencyMs     float64 `json:"avgLatencyMs"`
+}
+
+// DailyUsage 每天的用量
+type DailyUsage struct {
+	Day         string `json:"day"`
+	Calls       int64  `json:"calls"`
+	TotalTokens int64  `json:"totalTokens"`
+}
+
+// usageReport 生成用户最近 days 天的用量报告；userID 为 0 时统计所有用户
+func (h *Handler) usageReport(userID uint, days int) (gin.H, error) {
+	since := time.Now().AddDate(0, 0, -days)
+	scoped := func() *gorm.DB {
+		query := h.DB.Model(&model.AIUsage{}).Where("created_at >= ?", since)
+		if userID != 0 {
+			query = query.Where("user_id = ?", userID)
+		}
+		return query
+	}
+
+	var breakdown []UsageBreakdown
+	err := scoped().
+		Select(`operation, model, COUNT(*) AS calls,
+			SUM(CASE WHEN outcome = ? THEN 1 ELSE 0 END) AS cached_calls,
+			SUM(CASE WHEN outcome IN ? THEN 1 ELSE 0 END) AS failed_calls,
+			COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens,
+			COALESCE(SUM(completion_tokens), 0) AS completion_tokens,
+			COALESCE(SUM(total_tokens), 0) AS total_tokens,
+			COALESCE(AVG(CASE WHEN outcome IN ? THEN latency_ms END), 0) AS avg_latency_ms`,
+			service.OutcomeCached,
+			[]string{service.OutcomeError, service.OutcomeBudgetExceeded},
+			[]string{service.OutcomeSuccess, service.OutcomeError}).
+		Group("operation, model").
+		Order("total_tokens DESC").
+		Scan(&breakdown).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var daily []DailyUsage
+	err = scoped().
+		Select("DATE_FORMAT(created_at, '%Y-%m-%d') AS day, COUNT(*) AS calls, COALESCE(SUM(total_tokens), 0) AS total_tokens").
+		Group("day").
+		Order("day ASC").
+		Scan(&daily).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"days":      days,
+		"breakdown": breakdown,
+		"daily":     daily,
+	}, nil
+}
+
+// parseUsageDays 读取 days 查询参数（默认 30，最多 366）
+func parseUsageDays(c *gin.Context) int {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days <= 0 || days > 366 {
+		days = 30
+	}
+	return days
+}
+
+// GetMyAIUsage 返回当前用户的 AI 用量报告：按操作和模型汇总、每日用量，以及预算和剩余额度
+func (h *Handler) GetMyAIUsage(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var user model.User
+	if err := h.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	report, err := h.usageReport(userID, parseUsageDays(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch AI usage"})
+		return
+	}
+	day, month := budgetPeriodStarts(time.Now())
+	used, err := tokensUsedSince(h.DB, userID, day, month)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch AI usage"})
+		return
+	}
+
+	report["budget"] = effectiveAIBudget(&user)
+	report["used"] = used
+	c.JSON(http.StatusOK, report)
+}
+
+// AdminGetAIUsage 返回所有用户的 AI 用量报告，以及按用户汇总的 token 数
+func (h *Handler) AdminGetAIUsage(c *gin.Context) {
+	days := parseUsageDays(c)
+	report, err := h.usageReport(0, days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch AI usage"})
+		return
+	}
+
+	var byUser []struct {
+		UserID      uint  `json:"userID"`
+		Calls       int64 `json:"calls"`
+		TotalTokens int64 `json:"totalTokens"`
+	}
+	err = h.DB.Model(&model.AIUsage{}).
+		Select("user_id, COUNT(*) AS calls, COALESCE(SUM(total_tokens), 0) AS total_tokens").
+		Where("created_at >= ?", time.Now().AddDate(0, 0, -days)).
+		Group("user_id").
+		Order("total_tokens DESC").
+		Scan(&byUser).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch AI usage"})
+		return
+	}
+
+	report["byUser"] = byUser
+	c.JSON(http.StatusOK, report)
+}
+
+// AdminSetUserAIBudget 为指定用户设置 AI token 预算覆盖值，传 null 恢复为默认预算
+func (h *Handler) AdminSetUserAIBudget(c *gin.Context) {
+	targetID, ok := parseUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		DailyTokens   *int64 `json:"dailyTokens"`
+		MonthlyTokens *int64 `json:"monthlyTokens"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (input.DailyTokens != nil && *input.DailyTokens < 0) ||
+		(input.MonthlyTokens != nil && *input.MonthlyTokens < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Budget must not be negative (0 means unlimited)"})
+		return
+	}
+
+	var user model.User
+	if err := h.DB.First(&user, targetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// 使用 Select 确保 nil 值也会被写入（恢复默认预算）
+	user.AIDailyTokenBudget = input.DailyTokens
+	user.AIMonthlyTokenBudget = input.MonthlyTokens
+	if err := h.DB.Model(&user).Select("ai_daily_token_budget", "ai_monthly_token_budget").Updates(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update AI budget"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"userID": targetID,
+		"budget": effectiveAIBudget(&user),
+	})
+}
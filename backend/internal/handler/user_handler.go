@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+	_ "time/tzdata" // 内嵌时区数据库，确保精简容器中也能解析用户时区
+
+	"github.com/gin-gonic/gin"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+)
+
+// 内置提示词模板支持的 AI 标签语言，管理员可以通过提示词模板添加其他语言（见 aiLanguages）
+var supportedAILanguages = map[string]bool{
+	"zh": true,
+	"en": true,
+}
+
+// 缩略图宽度的取值范围（像素）
+const (
+	minThumbnailSize = 100
+	maxThumbnailSize = 1600
+)
+
+// defaultPreferences 用户尚未保存偏好时使用的默认值
+func defaultPreferences(userID uint) model.UserPreference {
+	return model.UserPreference{
+		UserID:        userID,
+		AutoAnalyze:   false,
+		AILanguage:    "zh",
+		AIDescribe:    false,
+		AIReviewTags:  false,
+		ThumbnailSize: 400,
+		Timezone:      "Local",
+	}
+}
+
+// getPreferences 读取用户偏好，不存在时返回默认值
+func (h *Handler) getPreferences(userID uint) model.UserPreference {
+	var prefs []model.UserPreference
+	if err := h.DB.Where("user_id = ?", userID).Limit(1).Find(&prefs).Error; err != nil {
+		log.Printf("Failed to load preferences for user %d: %v", userID, err)
+		return defaultPreferences(userID)
+	}
+	if len(prefs) == 0 {
+		return defaultPreferences(userID)
+	}
+	return prefs[0]
+}
+
+// preferenceLocation 返回用户偏好中的时区，无效时使用服务器本地时区
+func preferenceLocation(prefs model.UserPreference) *time.Location {
+	if prefs.Timezone == "" || prefs.Timezone == "Local" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(prefs.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// UserProfile 个人资料响应结构
+type UserProfile struct {
+	ID            uint         `json:"id"`
+	Username      string       `json:"username"`
+	Email         string       `json:"email"`
+	EmailVerified bool         `json:"emailVerified"` // 未验证的邮箱不会在单点登录时自动关联
+	Role          string       `json:"role"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	Avatar        *model.Image `json:"avatar"`
+	Stats         UserStats    `json:"stats"`
+}
+
+// UserStats 用户的图片库统计信息
+type UserStats struct {
+	ImageCount      int64      `json:"imageCount"`
+	StorageBytes    int64      `json:"storageBytes"`
+	TagCount        int64      `json:"tagCount"`       // 用户图片上使用的不同标签数量
+	AITaggedImages  int64      `json:"aiTaggedImages"` // 至少有一个 AI 标签的图片数量
+	ImagesWithGPS   int64      `json:"imagesWithGPS"`  // 带有 GPS 信息的图片数量
+	FirstUploadedAt *time.Time `json:"firstUploadedAt"`
+	LastUploadedAt  *time.Time `json:"lastUploadedAt"`
+}
+
+// buildProfile 组装用户资料和统计信息
+func (h *Handler) buildProfile(user *model.User) (*UserProfile, error) {
+	profile := &UserProfile{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
+		CreatedAt:     user.CreatedAt,
+	}
+
+	if user.AvatarImageID != nil {
+		var avatar model.Image
+		if err := h.DB.Where("id = ? AND user_id = ?", *user.AvatarImageID, user.ID).First(&avatar).Error; err == nil {
+			profile.Avatar = &avatar
+		}
+	}
+
+	imageCount, storageBytes, err := h.userStorageUsage(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	profile.Stats.ImageCount = imageCount
+	profile.Stats.StorageBytes = storageBytes
+
+	h.DB.Table("image_tags").
+		Joins("JOIN images ON images.id = image_tags.image_id").
+		Where("images.user_id = ? AND images.deleted_at IS NULL", user.ID).
+		Distinct("image_tags.tag_id").
+		Count(&profile.Stats.TagCount)
+
+	h.DB.Table("image_tags").
+		Joins("JOIN images ON images.id = image_tags.image_id").
+		Joins("JOIN tags ON tags.id = image_tags.tag_id").
+		Where("images.user_id = ? AND images.deleted_at IS NULL AND tags.source = ?", user.ID, "ai").
+		Distinct("image_tags.image_id").
+		Count(&profile.Stats.AITaggedImages)
+
+	h.DB.Model(&model.Image{}).
+		Where("user_id = ? AND latitude IS NOT NULL AND longitude IS NOT NULL", user.ID).
+		Count(&profile.Stats.ImagesWithGPS)
+
+	var uploadRange struct {
+		First *time.Time
+		Last  *time.Time
+	}
+	h.DB.Model(&model.Image{}).
+		Select("MIN(created_at) AS first, MAX(created_at) AS last").
+		Where("user_id = ?", user.ID).
+		Scan(&uploadRange)
+	profile.Stats.FirstUploadedAt = uploadRange.First
+	profile.Stats.LastUploadedAt = uploadRange.Last
+
+	return profile, nil
+}
+
+// GetProfile 获取当前用户的个人资料和统计信息
+func (h *Handler) GetProfile(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var user model.User
+	if err := h.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	profile, err := h.buildProfile(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// minUsernameLength 用户名的最短长度（与注册时的校验一致）
+const minUsernameLength = 4
+
+// UpdateProfile 更新当前用户的用户名、邮箱或头像
+func (h *Handler) UpdateProfile(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var input struct {
+		Username      *string `json:"username"` // 去除首尾空白后至少 4 个字符
+		Email         *string `json:"email" binding:"omitempty,email"`
+		AvatarImageID *uint   `json:"avatarImageID"` // 传 0 清除头像
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user model.User
+	if err := h.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.Username != nil {
+		// 先去除空白再校验长度，避免全空格的用户名被保存为空字符串
+		username := strings.TrimSpace(*input.Username)
+		if utf8.RuneCountInString(username) < minUsernameLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username must be at least 4 characters"})
+			return
+		}
+		updates["username"] = username
+	}
+	if input.Email != nil {
+		email := strings.TrimSpace(*input.Email)
+		if !strings.EqualFold(email, user.Email) {
+			// 新邮箱未经验证，不能再用于单点登录的账号关联
+			updates["email_verified"] = false
+		}
+		updates["email"] = email
+	}
+	if input.AvatarImageID != nil {
+		if *input.AvatarImageID == 0 {
+			updates["avatar_image_id"] = nil
+		} else {
+			// 头像必须是用户自己的图片
+			var count int64
+			h.DB.Model(&model.Image{}).Where("id = ? AND user_id = ?", *input.AvatarImageID, userID).Count(&count)
+			if count == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Avatar image not found or you don't have permission"})
+				return
+			}
+			updates["avatar_image_id"] = *input.AvatarImageID
+		}
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	if err := h.DB.Model(&user).Updates(updates).Error; err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username or email already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	h.DB.First(&user, userID)
+	profile, err := h.buildProfile(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// GetPreferences 获取当前用户的偏好设置
+func (h *Handler) GetPreferences(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	c.JSON(http.StatusOK, h.getPreferences(userID))
+}
+
+// UpdatePreferences 更新当前用户的偏好设置（只修改请求中提供的字段）
+func (h *Handler) UpdatePreferences(c *gin.Context) {
+	userID_i, _ := c.Get("userID")
+	userID := userID_i.(uint)
+
+	var input struct {
+		AutoAnalyze   *bool   `json:"autoAnalyze"`
+		AILanguage    *string `json:"aiLanguage"`
+		AIDescribe    *bool   `json:"aiDescribe"`
+		AIReviewTags  *bool   `json:"aiReviewTags"`
+		ThumbnailSize *int    `json:"thumbnailSize"`
+		Timezone      *string `json:"timezone"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prefs := h.getPreferences(userID)
+
+	if input.AutoAnalyze != nil {
+		prefs.AutoAnalyze = *input.AutoAnalyze
+	}
+	if input.AILanguage != nil {
+		languages := h.aiLanguages()
+		if !containsString(languages, *input.AILanguage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "aiLanguage must be one of: " + strings.Join(languages, ", ")})
+			return
+		}
+		prefs.AILanguage = *input.AILanguage
+	}
+	if input.AIDescribe != nil {
+		prefs.AIDescribe = *input.AIDescribe
+	}
+	if input.AIReviewTags != nil {
+		prefs.AIReviewTags = *input.AIReviewTags
+	}
+	if input.ThumbnailSize != nil {
+		if *input.ThumbnailSize < minThumbnailSize || *input.ThumbnailSize > maxThumbnailSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "thumbnailSize must be between 100 and 1600"})
+			return
+		}
+		prefs.ThumbnailSize = *input.ThumbnailSize
+	}
+	if input.Timezone != nil {
+		if _, err := time.LoadLocation(*input.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone: " + *input.Timezone})
+			return
+		}
+		prefs.Timezone = *input.Timezone
+	}
+
+	if err := h.DB.Save(&prefs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
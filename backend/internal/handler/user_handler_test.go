@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+)
+
+func TestUpdateProfileUsername(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+
+	tests := []struct {
+		name     string
+		username string
+		wantCode int
+		want     string
+	}{
+		{"blank username is rejected", "    ", http.StatusBadRequest, "alice"},
+		{"short username after trimming is rejected", "  ab  ", http.StatusBadRequest, "alice"},
+		{"username is trimmed", "  alice2  ", http.StatusOK, "alice2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := performRequest(h.UpdateProfile, http.MethodPut, "/users/me", "/users/me", alice.ID, gin.H{"username": tt.username})
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			var user model.User
+			h.DB.First(&user, alice.ID)
+			if user.Username != tt.want {
+				t.Errorf("username %q, want %q", user.Username, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateProfileEmailResetsVerification(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	h.DB.Model(&alice).Update("email_verified", true)
+
+	// 提交相同的邮箱时验证状态保持不变
+	performRequest(h.UpdateProfile, http.MethodPut, "/users/me", "/users/me", alice.ID, gin.H{"email": alice.Email})
+	var user model.User
+	h.DB.First(&user, alice.ID)
+	if !user.EmailVerified {
+		t.Fatal("unchanged email lost its verification")
+	}
+
+	w := performRequest(h.UpdateProfile, http.MethodPut, "/users/me", "/users/me", alice.ID, gin.H{"email": "new@example.com"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	h.DB.First(&user, alice.ID)
+	if user.Email != "new@example.com" || user.EmailVerified {
+		t.Errorf("got email %q verified=%v, want new@example.com unverified", user.Email, user.EmailVerified)
+	}
+}
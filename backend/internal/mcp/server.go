@@ -0,0 +1,378 @@
+// Package mcp 实现 Model Context Protocol（MCP）服务端的协议层：
+// JSON-RPC 2.0 消息处理、工具（tools）和资源（resources）的注册与分发
+// 具体的工具和资源由调用方注册，传输层见 stdio.go 和 handler 包中的 HTTP 接口
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// LatestProtocolVersion 服务端支持的最新协议版本
+const LatestProtocolVersion = "2025-06-18"
+
+// supportedProtocolVersions 可协商的协议版本（客户端请求其中之一时原样返回）
+var supportedProtocolVersions = map[string]bool{
+	"2025-06-18": true,
+	"2025-03-26": true,
+	"2024-11-05": true,
+}
+
+// JSON-RPC 错误码
+const (
+	ErrParse          = -32700
+	ErrInvalidRequest = -32600
+	ErrMethodNotFound = -32601
+	ErrInvalidParams  = -32602
+	ErrInternal       = -32603
+
+	// ErrUnauthorized 服务端自定义错误码：调用者的凭证已失效（例如令牌过期或账号被禁用）
+	ErrUnauthorized = -32001
+)
+
+// Request JSON-RPC 请求或通知（通知没有 id）
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// IsNotification 判断是否为通知（不需要响应）
+func (r *Request) IsNotification() bool {
+	return len(r.ID) == 0 || string(r.ID) == "null"
+}
+
+// Response JSON-RPC 响应
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error JSON-RPC 错误对象
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// ToolHandler 工具的执行函数，userID 为已认证的当前用户
+// 返回 error 时按 MCP 规范转换为 isError 的工具结果，让模型看到失败原因
+type ToolHandler func(ctx context.Context, userID uint, args json.RawMessage) (*ToolResult, error)
+
+// Tool 工具定义
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"` // JSON Schema
+	Handler     ToolHandler            `json:"-"`
+}
+
+// Content 工具结果中的内容块（text、image 或 resource_link）
+type Content struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Data     string `json:"data,omitempty"` // image：base64 编码的数据
+	MimeType string `json:"mimeType,omitempty"`
+	URI      string `json:"uri,omitempty"`  // resource_link：资源地址
+	Name     string `json:"name,omitempty"` // resource_link：资源名称
+}
+
+// ToolResult 工具执行结果
+type ToolResult struct {
+	Content           []Content   `json:"content"`
+	StructuredContent interface{} `json:"structuredContent,omitempty"`
+	IsError           bool        `json:"isError,omitempty"`
+}
+
+// TextResult 创建只包含一段文本的工具结果
+func TextResult(text string) *ToolResult {
+	return &ToolResult{Content: []Content{{Type: "text", Text: text}}}
+}
+
+// JSONResult 创建结构化的工具结果，同时附带 JSON 文本，兼容不支持 structuredContent 的客户端
+func JSONResult(v interface{}) (*ToolResult, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &ToolResult{
+		Content:           []Content{{Type: "text", Text: string(data)}},
+		StructuredContent: v,
+	}, nil
+}
+
+// Resource 资源描述
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// ResourceTemplate 参数化的资源地址模板（RFC 6570）
+type ResourceTemplate struct {
+	URITemplate string `json:"uriTemplate"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// ResourceContents 资源内容，文本资源使用 Text，二进制资源使用 base64 编码的 Blob
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+// ResourceLister 列出当前用户可访问的资源，cursor 为分页游标，返回下一页游标（没有更多时为空）
+type ResourceLister func(ctx context.Context, userID uint, cursor string) ([]Resource, string, error)
+
+// ResourceReader 读取指定地址的资源
+type ResourceReader func(ctx context.Context, userID uint, uri string) ([]ResourceContents, error)
+
+// Server MCP 服务端，可同时被多个传输层和多个用户共享
+type Server struct {
+	name         string
+	version      string
+	instructions string
+
+	mu        sync.RWMutex
+	tools     map[string]Tool
+	templates []ResourceTemplate
+	lister    ResourceLister
+	reader    ResourceReader
+}
+
+// NewServer 创建 MCP 服务端，instructions 会在 initialize 时提供给客户端
+func NewServer(name, version, instructions string) *Server {
+	return &Server{
+		name:         name,
+		version:      version,
+		instructions: instructions,
+		tools:        make(map[string]Tool),
+	}
+}
+
+// AddTool 注册工具（同名工具会被覆盖）
+func (s *Server) AddTool(tool Tool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tools[tool.Name] = tool
+}
+
+// SetResources 注册资源模板以及资源的列举和读取函数
+func (s *Server) SetResources(templates []ResourceTemplate, lister ResourceLister, reader ResourceReader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.templates = templates
+	s.lister = lister
+	s.reader = reader
+}
+
+// HandleMessage 处理一条 JSON-RPC 消息，返回需要发送的响应
+// 通知和客户端发来的响应不需要回复，此时返回 nil
+func (s *Server) HandleMessage(ctx context.Context, userID uint, data []byte) *Response {
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errorResponse(nil, ErrParse, "parse error: "+err.Error())
+	}
+	if req.Method == "" {
+		// 客户端对服务端请求的响应（本服务端不会主动发起请求），忽略
+		return nil
+	}
+	if req.JSONRPC != "2.0" {
+		if req.IsNotification() {
+			return nil
+		}
+		return errorResponse(req.ID, ErrInvalidRequest, "jsonrpc must be \"2.0\"")
+	}
+
+	result, rpcErr := s.dispatch(ctx, userID, &req)
+	if req.IsNotification() {
+		return nil
+	}
+	if rpcErr != nil {
+		return errorResponse(req.ID, rpcErr.Code, rpcErr.Message)
+	}
+	return &Response{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+// dispatch 根据方法名分发请求
+func (s *Server) dispatch(ctx context.Context, userID uint, req *Request) (interface{}, *Error) {
+	switch req.Method {
+	case "initialize":
+		return s.initialize(req.Params)
+	case "notifications/initialized", "notifications/cancelled":
+		return nil, nil
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return s.listTools(), nil
+	case "tools/call":
+		return s.callTool(ctx, userID, req.Params)
+	case "resources/list":
+		return s.listResources(ctx, userID, req.Params)
+	case "resources/templates/list":
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		templates := s.templates
+		if templates == nil {
+			templates = []ResourceTemplate{}
+		}
+		return map[string]interface{}{"resourceTemplates": templates}, nil
+	case "resources/read":
+		return s.readResource(ctx, userID, req.Params)
+	default:
+		return nil, &Error{Code: ErrMethodNotFound, Message: "method not found: " + req.Method}
+	}
+}
+
+func (s *Server) initialize(params json.RawMessage) (interface{}, *Error) {
+	var input struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ClientInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"clientInfo"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &input); err != nil {
+			return nil, &Error{Code: ErrInvalidParams, Message: "invalid initialize params: " + err.Error()}
+		}
+	}
+
+	version := LatestProtocolVersion
+	if supportedProtocolVersions[input.ProtocolVersion] {
+		version = input.ProtocolVersion
+	}
+	log.Printf("MCP client connected: %s %s (protocol %s)", input.ClientInfo.Name, input.ClientInfo.Version, version)
+
+	result := map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities": map[string]interface{}{
+			"tools":     map[string]interface{}{"listChanged": false},
+			"resources": map[string]interface{}{"subscribe": false, "listChanged": false},
+		},
+		"serverInfo": map[string]string{
+			"name":    s.name,
+			"version": s.version,
+		},
+	}
+	if s.instructions != "" {
+		result["instructions"] = s.instructions
+	}
+	return result, nil
+}
+
+func (s *Server) listTools() interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tools := make([]Tool, 0, len(s.tools))
+	for _, tool := range s.tools {
+		tools = append(tools, tool)
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	return map[string]interface{}{"tools": tools}
+}
+
+func (s *Server) callTool(ctx context.Context, userID uint, params json.RawMessage) (interface{}, *Error) {
+	var input struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &input); err != nil {
+		return nil, &Error{Code: ErrInvalidParams, Message: "invalid tools/call params: " + err.Error()}
+	}
+
+	s.mu.RLock()
+	tool, ok := s.tools[input.Name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, &Error{Code: ErrInvalidParams, Message: "unknown tool: " + input.Name}
+	}
+
+	args := input.Arguments
+	if len(args) == 0 || string(args) == "null" {
+		args = json.RawMessage("{}")
+	}
+
+	result, err := tool.Handler(ctx, userID, args)
+	if err != nil {
+		log.Printf("MCP tool %s failed for user %d: %v", input.Name, userID, err)
+		return &ToolResult{Content: []Content{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+	}
+	if result.Content == nil {
+		result.Content = []Content{}
+	}
+	return result, nil
+}
+
+func (s *Server) listResources(ctx context.Context, userID uint, params json.RawMessage) (interface{}, *Error) {
+	var input struct {
+		Cursor string `json:"cursor"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &input); err != nil {
+			return nil, &Error{Code: ErrInvalidParams, Message: "invalid resources/list params: " + err.Error()}
+		}
+	}
+
+	s.mu.RLock()
+	lister := s.lister
+	s.mu.RUnlock()
+	if lister == nil {
+		return map[string]interface{}{"resources": []Resource{}}, nil
+	}
+
+	resources, next, err := lister(ctx, userID, input.Cursor)
+	if err != nil {
+		return nil, &Error{Code: ErrInternal, Message: err.Error()}
+	}
+	result := map[string]interface{}{"resources": resources}
+	if next != "" {
+		result["nextCursor"] = next
+	}
+	return result, nil
+}
+
+func (s *Server) readResource(ctx context.Context, userID uint, params json.RawMessage) (interface{}, *Error) {
+	var input struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(params, &input); err != nil || input.URI == "" {
+		return nil, &Error{Code: ErrInvalidParams, Message: "resources/read requires a uri"}
+	}
+
+	s.mu.RLock()
+	reader := s.reader
+	s.mu.RUnlock()
+	if reader == nil {
+		return nil, &Error{Code: ErrInvalidParams, Message: "resource not found: " + input.URI}
+	}
+
+	contents, err := reader(ctx, userID, input.URI)
+	if err != nil {
+		// 规范建议资源不存在时使用 -32002
+		return nil, &Error{Code: -32002, Message: err.Error()}
+	}
+	return map[string]interface{}{"contents": contents}, nil
+}
+
+func errorResponse(id json.RawMessage, code int, message string) *Response {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &Response{JSONRPC: "2.0", ID: id, Error: &Error{Code: code, Message: message}}
+}
@@ -0,0 +1,191 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func newTestServer() *Server {
+	s := NewServer("images", "1.0", "search your photos")
+	s.AddTool(Tool{
+		Name: "echo",
+		Handler: func(ctx context.Context, userID uint, args json.RawMessage) (*ToolResult, error) {
+			return JSONResult(map[string]interface{}{"user": userID, "args": args})
+		},
+	})
+	s.AddTool(Tool{
+		Name: "fail",
+		Handler: func(ctx context.Context, userID uint, args json.RawMessage) (*ToolResult, error) {
+			return nil, errors.New("image not found")
+		},
+	})
+	s.SetResources(
+		[]ResourceTemplate{{URITemplate: "image://{id}/thumbnail", Name: "thumbnail"}},
+		func(ctx context.Context, userID uint, cursor string) ([]Resource, string, error) {
+			if cursor == "" {
+				return []Resource{{URI: "image://1/thumbnail", Name: "a.jpg"}}, "1", nil
+			}
+			return []Resource{}, "", nil
+		},
+		func(ctx context.Context, userID uint, uri string) ([]ResourceContents, error) {
+			if uri != "image://1/thumbnail" {
+				return nil, errors.New("resource not found: " + uri)
+			}
+			return []ResourceContents{{URI: uri, MimeType: "image/jpeg", Blob: "AAAA"}}, nil
+		},
+	)
+	return s
+}
+
+// call 处理一条消息并把结果解码到 result（result 为 nil 时不解码）
+func call(t *testing.T, s *Server, message string, result interface{}) *Response {
+	t.Helper()
+	resp := s.HandleMessage(context.Background(), 7, []byte(message))
+	if resp != nil && resp.Error == nil && result != nil {
+		data, _ := json.Marshal(resp.Result)
+		if err := json.Unmarshal(data, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp
+}
+
+func TestHandleMessageErrors(t *testing.T) {
+	s := newTestServer()
+	tests := []struct {
+		name     string
+		message  string
+		wantCode int
+	}{
+		{"parse error", `{"jsonrpc": `, ErrParse},
+		{"wrong version", `{"jsonrpc": "1.0", "id": 1, "method": "ping"}`, ErrInvalidRequest},
+		{"unknown method", `{"jsonrpc": "2.0", "id": 1, "method": "prompts/list"}`, ErrMethodNotFound},
+		{"unknown tool", `{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "nope"}}`, ErrInvalidParams},
+		{"read without uri", `{"jsonrpc": "2.0", "id": 1, "method": "resources/read", "params": {}}`, ErrInvalidParams},
+		{"missing resource", `{"jsonrpc": "2.0", "id": 1, "method": "resources/read", "params": {"uri": "image://2/thumbnail"}}`, -32002},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := call(t, s, tt.message, nil)
+			if resp == nil || resp.Error == nil || resp.Error.Code != tt.wantCode {
+				t.Fatalf("got %+v, want error code %d", resp, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHandleMessageNotifications(t *testing.T) {
+	s := newTestServer()
+	for _, message := range []string{
+		`{"jsonrpc": "2.0", "method": "notifications/initialized"}`,
+		`{"jsonrpc": "2.0", "method": "prompts/list"}`,    // 未知方法的通知同样不回复
+		`{"jsonrpc": "2.0", "id": 5, "result": {}}`,       // 客户端发来的响应
+		`{"jsonrpc": "1.0", "method": "notifications/x"}`, // 版本错误的通知
+	} {
+		if resp := call(t, s, message, nil); resp != nil {
+			t.Errorf("%s: got response %+v, want none", message, resp)
+		}
+	}
+}
+
+func TestInitializeNegotiatesProtocolVersion(t *testing.T) {
+	s := newTestServer()
+	tests := []struct {
+		requested string
+		want      string
+	}{
+		{"2024-11-05", "2024-11-05"},
+		{"1999-01-01", LatestProtocolVersion},
+		{"", LatestProtocolVersion},
+	}
+	for _, tt := range tests {
+		var result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+			Instructions    string `json:"instructions"`
+		}
+		resp := call(t, s, `{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"protocolVersion": "`+tt.requested+`"}}`, &result)
+		if resp.Error != nil || result.ProtocolVersion != tt.want {
+			t.Errorf("requested %q: got %q (%+v), want %q", tt.requested, result.ProtocolVersion, resp.Error, tt.want)
+		}
+		if result.Instructions != "search your photos" {
+			t.Errorf("instructions %q", result.Instructions)
+		}
+	}
+}
+
+func TestToolsListAndCall(t *testing.T) {
+	s := newTestServer()
+
+	var list struct {
+		Tools []Tool `json:"tools"`
+	}
+	call(t, s, `{"jsonrpc": "2.0", "id": 1, "method": "tools/list"}`, &list)
+	if len(list.Tools) != 2 || list.Tools[0].Name != "echo" || list.Tools[1].Name != "fail" {
+		t.Fatalf("tools %+v, want echo and fail sorted by name", list.Tools)
+	}
+
+	var result struct {
+		StructuredContent struct {
+			User uint            `json:"user"`
+			Args json.RawMessage `json:"args"`
+		} `json:"structuredContent"`
+	}
+	call(t, s, `{"jsonrpc": "2.0", "id": 2, "method": "tools/call", "params": {"name": "echo"}}`, &result)
+	if result.StructuredContent.User != 7 || string(result.StructuredContent.Args) != "{}" {
+		t.Errorf("echo got %+v, want user 7 with empty arguments", result.StructuredContent)
+	}
+
+	// 工具失败时返回 isError 的结果而不是 JSON-RPC 错误
+	var failed ToolResult
+	resp := call(t, s, `{"jsonrpc": "2.0", "id": 3, "method": "tools/call", "params": {"name": "fail", "arguments": {}}}`, &failed)
+	if resp.Error != nil || !failed.IsError || len(failed.Content) != 1 || failed.Content[0].Text != "image not found" {
+		t.Errorf("got %+v / %+v, want an isError tool result", resp.Error, failed)
+	}
+}
+
+func TestResources(t *testing.T) {
+	s := newTestServer()
+
+	var page struct {
+		Resources  []Resource `json:"resources"`
+		NextCursor string     `json:"nextCursor"`
+	}
+	call(t, s, `{"jsonrpc": "2.0", "id": 1, "method": "resources/list"}`, &page)
+	if len(page.Resources) != 1 || page.NextCursor != "1" {
+		t.Fatalf("first page %+v", page)
+	}
+	page.NextCursor = ""
+	call(t, s, `{"jsonrpc": "2.0", "id": 2, "method": "resources/list", "params": {"cursor": "1"}}`, &page)
+	if len(page.Resources) != 0 || page.NextCursor != "" {
+		t.Errorf("last page %+v, want no resources and no cursor", page)
+	}
+
+	var templates struct {
+		ResourceTemplates []ResourceTemplate `json:"resourceTemplates"`
+	}
+	call(t, s, `{"jsonrpc": "2.0", "id": 3, "method": "resources/templates/list"}`, &templates)
+	if len(templates.ResourceTemplates) != 1 {
+		t.Errorf("templates %+v", templates)
+	}
+
+	var read struct {
+		Contents []ResourceContents `json:"contents"`
+	}
+	call(t, s, `{"jsonrpc": "2.0", "id": 4, "method": "resources/read", "params": {"uri": "image://1/thumbnail"}}`, &read)
+	if len(read.Contents) != 1 || read.Contents[0].Blob != "AAAA" {
+		t.Errorf("contents %+v", read.Contents)
+	}
+}
+
+func TestServerWithoutResources(t *testing.T) {
+	s := NewServer("images", "1.0", "")
+	var page struct {
+		Resources []Resource `json:"resources"`
+	}
+	resp := call(t, s, `{"jsonrpc": "2.0", "id": 1, "method": "resources/list"}`, &page)
+	if resp.Error != nil || page.Resources == nil || len(page.Resources) != 0 {
+		t.Errorf("got %+v / %+v, want an empty resource list", resp.Error, page)
+	}
+}
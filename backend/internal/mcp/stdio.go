@@ -0,0 +1,80 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// maxMessageSize 单条 stdio 消息的最大长度
+const maxMessageSize = 16 * 1024 * 1024
+
+// Authenticator 在处理每条消息之前确认调用者的身份，返回执行请求的用户 ID
+// 返回错误时请求被拒绝（以 ErrUnauthorized 回复）
+type Authenticator func(ctx context.Context) (uint, error)
+
+// ServeStdio 以 stdio 传输运行 MCP 服务：每行一条 JSON-RPC 消息，响应同样逐行写出
+// 每条消息都先经过 authenticate，再以返回的用户身份执行；日志必须写到 stderr，stdout 只能用于协议消息
+func ServeStdio(ctx context.Context, s *Server, authenticate Authenticator, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+
+	var writeMu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	write := func(resp *Response) error {
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err = out.Write(append(data, '\n'))
+		return err
+	}
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '[' {
+			// 当前协议版本已不再支持批量请求
+			if err := write(errorResponse(nil, ErrInvalidRequest, "batch requests are not supported")); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// 并发处理请求，避免耗时的工具（例如 AI 分析）阻塞 ping 等其他请求
+		msg := append([]byte(nil), line...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userID, err := authenticate(ctx)
+			if err != nil {
+				var req Request
+				if json.Unmarshal(msg, &req) == nil && req.Method != "" && !req.IsNotification() {
+					write(errorResponse(req.ID, ErrUnauthorized, err.Error()))
+				}
+				return
+			}
+			if resp := s.HandleMessage(ctx, userID, msg); resp != nil {
+				write(resp)
+			}
+		}()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read stdin: %w", err)
+	}
+	return nil
+}
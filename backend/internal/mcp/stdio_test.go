@@ -0,0 +1,68 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestServeStdioAuthenticatesEveryMessage(t *testing.T) {
+	s := NewServer("test", "1.0", "")
+	calls := 0
+	authenticate := func(ctx context.Context) (uint, error) {
+		calls++
+		if calls > 1 {
+			return 0, errors.New("token is no longer valid")
+		}
+		return 1, nil
+	}
+
+	// 同一次运行中的消息并发处理，分两次运行以固定认证的先后顺序
+	var out bytes.Buffer
+	in := `{"jsonrpc": "2.0", "id": 1, "method": "ping"}`
+	if err := ServeStdio(context.Background(), s, authenticate, strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	in = strings.Join([]string{
+		`{"jsonrpc": "2.0", "method": "notifications/initialized"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "ping"}`,
+	}, "\n")
+	if err := ServeStdio(context.Background(), s, authenticate, strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d responses, want 2 (notifications are not answered): %q", len(lines), lines)
+	}
+	var ok, denied Response
+	json.Unmarshal([]byte(lines[0]), &ok)
+	json.Unmarshal([]byte(lines[1]), &denied)
+	if ok.Error != nil || string(ok.ID) != "1" {
+		t.Errorf("first response %+v, want a successful ping", ok)
+	}
+	if denied.Error == nil || denied.Error.Code != ErrUnauthorized || string(denied.ID) != "2" {
+		t.Errorf("second response %+v, want ErrUnauthorized for id 2", denied)
+	}
+}
+
+func TestServeStdioRejectsBatches(t *testing.T) {
+	s := NewServer("test", "1.0", "")
+	authenticate := func(ctx context.Context) (uint, error) { return 1, nil }
+
+	var out bytes.Buffer
+	in := "\n" + `[{"jsonrpc": "2.0", "id": 1, "method": "ping"}]` + "\n"
+	if err := ServeStdio(context.Background(), s, authenticate, strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	var resp Response
+	if err := json.Unmarshal(bytes.TrimSpace(out.Bytes()), &resp); err != nil {
+		t.Fatalf("decode %q: %v", out.String(), err)
+	}
+	if resp.Error == nil || resp.Error.Code != ErrInvalidRequest {
+		t.Errorf("got %+v, want ErrInvalidRequest", resp)
+	}
+}
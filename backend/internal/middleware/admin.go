@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Valkqs/image-management-app/backend/internal/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ActiveUserMiddleware 在 JWT 校验之后加载当前用户，拒绝已删除或被禁用的账号
+// 并将用户角色存入上下文（userRole），供 AdminMiddleware 使用
+func ActiveUserMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var user model.User
+		if err := db.Select("id", "role", "disabled").First(&user, userID).Error; err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return
+		}
+
+		if user.Disabled {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+			return
+		}
+
+		c.Set("userRole", user.Role)
+		c.Next()
+	}
+}
+
+// AdminMiddleware 仅允许管理员访问，必须在 ActiveUserMiddleware 之后使用
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("userRole") != model.RoleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin privileges required"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -5,7 +5,6 @@ import (
     "strings"
     "github.com/Valkqs/image-management-app/backend/internal/utils" 
     "github.com/gin-gonic/gin"
-    "github.com/golang-jwt/jwt/v5"
 )
 
 func AuthMiddleware() gin.HandlerFunc {
@@ -22,17 +21,8 @@ func AuthMiddleware() gin.HandlerFunc {
             return
         }
 
-        claims := &utils.Claims{}
-
-        token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-            // 确保签名算法是我们期望的
-            if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-                return nil, http.ErrAbortHandler
-            }
-            return utils.JwtKey, nil // 在utils包中公开jwtKey或创建一个返回它的函数
-        })
-
-        if err != nil || !token.Valid {
+        claims, err := utils.ParseJWT(tokenString)
+        if err != nil {
             c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
             return
         }
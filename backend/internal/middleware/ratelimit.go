@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limiter 令牌桶限流器接口，可由内存或 Redis 实现
+// Allow 返回是否放行，以及被拒绝时建议的重试等待时间
+type Limiter interface {
+	Allow(key string) (bool, time.Duration, error)
+}
+
+// KeyFunc 从请求中提取限流键，返回空字符串表示不限流
+type KeyFunc func(c *gin.Context) string
+
+// tokenBucket 单个键的令牌桶状态
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// MemoryLimiter 进程内令牌桶限流器（单实例部署使用）
+type MemoryLimiter struct {
+	name    string
+	rate    float64 // 每秒补充的令牌数
+	burst   float64 // 桶容量
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	lastGC  time.Time
+}
+
+// NewMemoryLimiter 创建内存令牌桶限流器
+func NewMemoryLimiter(name string, rate float64, burst int) *MemoryLimiter {
+	return &MemoryLimiter{
+		name:    name,
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		lastGC:  time.Now(),
+	}
+}
+
+// Allow 尝试从令牌桶中取出一个令牌
+func (l *MemoryLimiter) Allow(key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.gc(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = b
+	}
+
+	// 按经过的时间补充令牌
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*l.rate)
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// gc 清理已经补满的桶，避免内存无限增长（调用方需持有锁）
+func (l *MemoryLimiter) gc(now time.Time) {
+	if now.Sub(l.lastGC) < time.Minute {
+		return
+	}
+	l.lastGC = now
+	fullAfter := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > fullAfter {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// NewLimiterFromEnv 根据环境变量选择限流后端
+// RATE_LIMIT_BACKEND=redis 时使用 REDIS_ADDR 指定的 Redis 兼容服务，否则使用内存实现
+func NewLimiterFromEnv(name string, rate float64, burst int) Limiter {
+	if strings.ToLower(os.Getenv("RATE_LIMIT_BACKEND")) == "redis" {
+		addr := os.Getenv("REDIS_ADDR")
+		if addr == "" {
+			addr = "127.0.0.1:6379"
+		}
+		db, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+		log.Printf("Rate limiter %s using Redis backend at %s", name, addr)
+		return NewRedisLimiter(name, rate, burst, addr, os.Getenv("REDIS_PASSWORD"), db)
+	}
+	return NewMemoryLimiter(name, rate, burst)
+}
+
+// RateLimit 限流中间件，超出限制时返回 429 并设置 Retry-After 头
+func RateLimit(limiter Limiter, keyFunc KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := keyFunc(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		allowed, retryAfter, err := limiter.Allow(key)
+		if err != nil {
+			// 限流后端不可用时放行，避免因 Redis 故障导致无法登录
+			log.Printf("Rate limiter error for key %s: %v", key, err)
+			c.Next()
+			return
+		}
+
+		if !allowed {
+			AbortTooManyRequests(c, retryAfter)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// AbortTooManyRequests 返回 429 响应，Retry-After 向上取整到秒
+func AbortTooManyRequests(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":      "Too many requests, please try again later",
+		"retryAfter": seconds,
+	})
+}
+
+// TrustedProxiesFromEnv 读取 TRUSTED_PROXIES（逗号分隔的 IP 或 CIDR）
+// 未设置时不信任任何代理，ClientIP 即连接的对端地址，伪造的 X-Forwarded-For 不会改变限流键
+func TrustedProxiesFromEnv() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// ClientIPKey 以客户端 IP 作为限流键
+// 只有来自受信任代理（见 TrustedProxiesFromEnv）的请求才会采用 X-Forwarded-For
+func ClientIPKey(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// JSONFieldKey 以请求体 JSON 中的指定字段（如 email）作为限流键
+// 读取后会还原请求体，不影响后续 Handler 绑定
+func JSONFieldKey(field string) KeyFunc {
+	return func(c *gin.Context) string {
+		if c.Request.Body == nil {
+			return ""
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+		c.Request.Body.Close()
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return ""
+		}
+
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return ""
+		}
+		value, _ := payload[field].(string)
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			return ""
+		}
+		return field + ":" + value
+	}
+}
@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucketScript 在 Redis 端原子地执行令牌桶计算
+// 返回 {是否放行, 需要等待的毫秒数}
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1]) or burst
+local ts = tonumber(data[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, wait}
+`
+
+// RedisLimiter 基于 Redis 兼容服务（Redis、Valkey、KeyDB 等）的令牌桶限流器
+// 多实例部署时共享限流状态
+type RedisLimiter struct {
+	name     string
+	rate     float64
+	burst    int
+	addr     string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// NewRedisLimiter 创建 Redis 令牌桶限流器，连接在首次使用时建立
+func NewRedisLimiter(name string, rate float64, burst int, addr, password string, db int) *RedisLimiter {
+	return &RedisLimiter{
+		name:     name,
+		rate:     rate,
+		burst:    burst,
+		addr:     addr,
+		password: password,
+		db:       db,
+	}
+}
+
+// Allow 通过 EVAL 执行令牌桶脚本
+func (l *RedisLimiter) Allow(key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reply, err := l.do("EVAL", tokenBucketScript, "1",
+		"ratelimit:"+l.name+":"+key,
+		strconv.FormatFloat(l.rate, 'f', -1, 64),
+		strconv.Itoa(l.burst),
+		strconv.FormatInt(time.Now().UnixMilli(), 10),
+	)
+	if err != nil {
+		return false, 0, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected redis reply: %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	waitMs, _ := values[1].(int64)
+	return allowed == 1, time.Duration(waitMs) * time.Millisecond, nil
+}
+
+// do 发送一条命令并读取回复，连接出错时丢弃连接以便下次重连（调用方需持有锁）
+func (l *RedisLimiter) do(args ...string) (interface{}, error) {
+	if l.conn == nil {
+		if err := l.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := l.roundTrip(args...)
+	if err != nil {
+		l.conn.Close()
+		l.conn = nil
+		return nil, err
+	}
+	return reply, nil
+}
+
+// connect 建立连接并完成认证与选库
+func (l *RedisLimiter) connect() error {
+	conn, err := net.DialTimeout("tcp", l.addr, 3*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect redis: %w", err)
+	}
+	l.conn = conn
+	l.rd = bufio.NewReader(conn)
+
+	if l.password != "" {
+		if _, err := l.roundTrip("AUTH", l.password); err != nil {
+			conn.Close()
+			l.conn = nil
+			return fmt.Errorf("redis auth failed: %w", err)
+		}
+	}
+	if l.db != 0 {
+		if _, err := l.roundTrip("SELECT", strconv.Itoa(l.db)); err != nil {
+			conn.Close()
+			l.conn = nil
+			return fmt.Errorf("redis select failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// roundTrip 以 RESP 协议编码命令并解析一条回复
+func (l *RedisLimiter) roundTrip(args ...string) (interface{}, error) {
+	l.conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	buf := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, arg := range args {
+		buf = fmt.Appendf(buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := l.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readRESP(l.rd)
+}
+
+// readRESP 解析一条 RESP 回复（支持简单字符串、错误、整数、批量字符串和数组）
+func readRESP(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("malformed redis reply: %q", line)
+	}
+	payload := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, fmt.Errorf("redis error: %s", payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readRESP(rd); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply type: %q", line[0])
+	}
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRESP(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    interface{}
+		wantErr bool
+	}{
+		{"simple string", "+OK\r\n", "OK", false},
+		{"error", "-ERR wrong password\r\n", nil, true},
+		{"integer", ":42\r\n", int64(42), false},
+		{"bulk string", "$5\r\nhello\r\n", "hello", false},
+		{"bulk string with CRLF inside", "$7\r\na\r\nb\r\nc\r\n", "a\r\nb\r\nc", false},
+		{"null bulk string", "$-1\r\n", nil, false},
+		{"array", "*2\r\n:1\r\n:250\r\n", []interface{}{int64(1), int64(250)}, false},
+		{"nested array", "*2\r\n*1\r\n+a\r\n$1\r\nb\r\n", []interface{}{[]interface{}{"a"}, "b"}, false},
+		{"error inside array", "*2\r\n:1\r\n-ERR script\r\n", nil, true},
+		{"unknown type", "!3\r\n", nil, true},
+		{"malformed line", "\r\n", nil, true},
+		{"truncated bulk string", "$10\r\nabc\r\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readRESP(bufio.NewReader(strings.NewReader(tt.input)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeRedis 按顺序记录收到的命令，并用 reply 生成每条命令的回复
+func fakeRedis(t *testing.T, reply func(args []string) string) (addr string, commands chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	commands = make(chan []string, 16)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rd := bufio.NewReader(conn)
+		for {
+			// 客户端以 RESP 数组发送命令，可以直接复用 readRESP 解析
+			value, err := readRESP(rd)
+			if err != nil {
+				return
+			}
+			items := value.([]interface{})
+			args := make([]string, len(items))
+			for i, item := range items {
+				args[i] = item.(string)
+			}
+			commands <- args
+			conn.Write([]byte(reply(args)))
+		}
+	}()
+	return ln.Addr().String(), commands
+}
+
+func TestRedisLimiter(t *testing.T) {
+	addr, commands := fakeRedis(t, func(args []string) string {
+		if args[0] == "EVAL" {
+			return "*2\r\n:0\r\n:1500\r\n"
+		}
+		return "+OK\r\n"
+	})
+	l := NewRedisLimiter("login", 0.5, 3, addr, "secret", 2)
+
+	allowed, wait, err := l.Allow("ip:1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed || wait != 1500*time.Millisecond {
+		t.Errorf("got allowed=%v wait=%v, want rejected with 1.5s", allowed, wait)
+	}
+
+	if auth := <-commands; !reflect.DeepEqual(auth, []string{"AUTH", "secret"}) {
+		t.Errorf("first command %v, want AUTH", auth)
+	}
+	if sel := <-commands; !reflect.DeepEqual(sel, []string{"SELECT", "2"}) {
+		t.Errorf("second command %v, want SELECT 2", sel)
+	}
+	eval := <-commands
+	if len(eval) != 7 || eval[0] != "EVAL" || eval[3] != "ratelimit:login:ip:1.2.3.4" || eval[4] != "0.5" || eval[5] != "3" {
+		t.Errorf("unexpected EVAL arguments %v", eval[:1])
+	}
+}
+
+func TestRedisLimiterUnexpectedReply(t *testing.T) {
+	addr, _ := fakeRedis(t, func(args []string) string { return "+OK\r\n" })
+	l := NewRedisLimiter("login", 1, 1, addr, "", 0)
+	if _, _, err := l.Allow("k"); err == nil {
+		t.Error("expected an error for a non-array reply")
+	}
+}
+
+func TestRedisLimiterUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	l := NewRedisLimiter("login", 1, 1, addr, "", 0)
+	if _, _, err := l.Allow("k"); err == nil {
+		t.Error("expected a connection error")
+	}
+	if l.conn != nil {
+		t.Error("failed connection was kept")
+	}
+}
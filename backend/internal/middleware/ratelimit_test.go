@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func clientIPKeyFor(t *testing.T, trustedProxies []string, remoteAddr, forwardedFor string) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	var key string
+	r.GET("/", func(c *gin.Context) { key = ClientIPKey(c) })
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	return key
+}
+
+func TestClientIPKeyIgnoresSpoofedForwardedFor(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	if got := clientIPKeyFor(t, TrustedProxiesFromEnv(), "203.0.113.7:5000", "198.51.100.1"); got != "ip:203.0.113.7" {
+		t.Errorf("untrusted peer: got %q, want ip:203.0.113.7", got)
+	}
+
+	// 受信任代理追加的地址才是客户端 IP，客户端自己伪造的前缀被忽略
+	t.Setenv("TRUSTED_PROXIES", "172.16.0.0/12, 10.0.0.1")
+	proxies := TrustedProxiesFromEnv()
+	if len(proxies) != 2 {
+		t.Fatalf("parsed %v, want 2 proxies", proxies)
+	}
+	if got := clientIPKeyFor(t, proxies, "172.18.0.5:5000", "198.51.100.1, 203.0.113.7"); got != "ip:203.0.113.7" {
+		t.Errorf("trusted proxy: got %q, want ip:203.0.113.7", got)
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	l := NewMemoryLimiter("test", 1, 2) // 每秒补充 1 个令牌，容量 2
+
+	for i := 0; i < 2; i++ {
+		if allowed, _, _ := l.Allow("a"); !allowed {
+			t.Fatalf("request %d within burst was rejected", i+1)
+		}
+	}
+	allowed, wait, err := l.Allow("a")
+	if err != nil || allowed {
+		t.Fatalf("request over burst: allowed=%v err=%v", allowed, err)
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("retry after %v, want (0, 1s]", wait)
+	}
+
+	// 不同的键使用各自的令牌桶
+	if allowed, _, _ := l.Allow("b"); !allowed {
+		t.Error("another key was rejected")
+	}
+
+	// 经过 1.5 秒补充一个令牌
+	l.buckets["a"].lastSeen = l.buckets["a"].lastSeen.Add(-1500 * time.Millisecond)
+	if allowed, _, _ := l.Allow("a"); !allowed {
+		t.Error("request after refill was rejected")
+	}
+	if allowed, _, _ := l.Allow("a"); allowed {
+		t.Error("refill exceeded the elapsed time")
+	}
+}
+
+func TestMemoryLimiterGC(t *testing.T) {
+	l := NewMemoryLimiter("test", 1, 2)
+	l.Allow("idle")
+	l.Allow("busy")
+	l.buckets["idle"].lastSeen = time.Now().Add(-time.Hour)
+	l.lastGC = time.Now().Add(-2 * time.Minute)
+
+	l.Allow("busy")
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("refilled bucket was not collected")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("active bucket was collected")
+	}
+}
+
+// stubLimiter 返回固定结果的限流器
+type stubLimiter struct {
+	allowed bool
+	wait    time.Duration
+	err     error
+}
+
+func (l stubLimiter) Allow(key string) (bool, time.Duration, error) {
+	return l.allowed, l.wait, l.err
+}
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name      string
+		limiter   Limiter
+		key       string
+		wantCode  int
+		wantRetry string
+	}{
+		{"allowed", stubLimiter{allowed: true}, "k", http.StatusOK, ""},
+		{"rejected rounds retry up", stubLimiter{wait: 1500 * time.Millisecond}, "k", http.StatusTooManyRequests, "2"},
+		{"backend error fails open", stubLimiter{err: errors.New("down")}, "k", http.StatusOK, ""},
+		{"empty key is not limited", stubLimiter{}, "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/", RateLimit(tt.limiter, func(c *gin.Context) string { return tt.key }), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.wantCode || w.Header().Get("Retry-After") != tt.wantRetry {
+				t.Errorf("got %d Retry-After %q, want %d %q", w.Code, w.Header().Get("Retry-After"), tt.wantCode, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestJSONFieldKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var key, body string
+	r := gin.New()
+	r.POST("/", func(c *gin.Context) {
+		key = JSONFieldKey("email")(c)
+		data, _ := io.ReadAll(c.Request.Body)
+		body = string(data)
+	})
+
+	payload := `{"email": "  Alice@Example.com ", "password": "x"}`
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(payload)))
+	if key != "email:alice@example.com" {
+		t.Errorf("key %q, want email:alice@example.com", key)
+	}
+	if body != payload {
+		t.Errorf("request body was not restored: %q", body)
+	}
+}
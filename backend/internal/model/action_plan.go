@@ -0,0 +1,31 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 批量操作计划状态
+const (
+	ActionPlanPending   = "pending"
+	ActionPlanExecuted  = "executed"
+	ActionPlanCancelled = "cancelled"
+	ActionPlanFailed    = "failed"
+)
+
+// ActionPlan AI 根据自然语言指令提出的批量操作计划，需要用户确认后才会执行
+type ActionPlan struct {
+	ID          uint            `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+	UserID      uint            `gorm:"index;not null" json:"userID"`
+	Instruction string          `gorm:"type:text" json:"instruction"`              // 用户的原始指令
+	Actions     json.RawMessage `gorm:"type:text" json:"actions"`                  // 计划执行的操作（JSON 数组）
+	ImageIDs    []uint          `gorm:"serializer:json;type:text" json:"imageIDs"` // 操作的目标图片
+	Status      string          `gorm:"size:20;index;not null" json:"status"`      // 'pending'、'executed'、'cancelled'、'failed'
+	Reasoning   string          `gorm:"type:text" json:"reasoning"`                // AI 对指令的理解
+	Result      json.RawMessage `gorm:"type:text" json:"result,omitempty"`         // 执行结果（JSON）
+	Error       string          `gorm:"type:text" json:"error,omitempty"`          // 执行失败时的错误信息
+	ExpiresAt   time.Time       `json:"expiresAt"`                                 // 超过该时间后不能再确认
+	ExecutedAt  *time.Time      `json:"executedAt"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AI 分析任务状态
+const (
+	AIJobRunning   = "running"
+	AIJobSucceeded = "succeeded"
+	AIJobFailed    = "failed"
+)
+
+// AIJob 记录每一次 AI 图片分析的执行情况，便于管理员排查失败原因
+type AIJob struct {
+	gorm.Model
+	ImageID       uint       `gorm:"index;not null" json:"imageID"`
+	UserID        uint       `gorm:"index;not null" json:"userID"`
+	Trigger       string     `gorm:"size:20" json:"trigger"`               // 'manual'、'upload'、'edit'、'admin'、'mcp'、'plan'、'batch'
+	BatchID       uint       `gorm:"index" json:"batchID,omitempty"`       // 批量分析任务ID，不属于批量任务时为 0
+	VisionModel   string     `gorm:"size:100;index" json:"visionModel"`    // 使用的视觉模型，用于找出由旧模型分析的图片
+	PromptVersion string     `gorm:"size:100" json:"promptVersion"`        // 使用的提示词模板，例如 'tag/zh@db-v3'
+	Status        string     `gorm:"size:20;index;not null" json:"status"` // 'running'、'succeeded'、'failed'
+	Error         string     `gorm:"type:text" json:"error"`               // 失败时的错误信息
+	TagCount      int        `json:"tagCount"`                             // 本次分析得到的标签数量
+	FinishedAt    *time.Time `json:"finishedAt"`
+}
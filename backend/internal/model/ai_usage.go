@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// AIUsage 一次 AI 模型调用的用量记录，用于用量报告和预算检查
+type AIUsage struct {
+	ID               uint      `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time `gorm:"index" json:"createdAt"`
+	UserID           uint      `gorm:"index;not null" json:"userID"`
+	Operation        string    `gorm:"size:20;not null" json:"operation"` // 'analyze'、'query'、'assistant'、'plan'、'repair'、'ocr'、'embed'、'moderate'
+	Provider         string    `gorm:"size:50" json:"provider"`
+	Model            string    `gorm:"size:100" json:"model"`
+	PromptVersion    string    `gorm:"size:100" json:"promptVersion"`
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	TotalTokens      int       `json:"totalTokens"`
+	Estimated        bool      `gorm:"not null;default:false" json:"estimated"` // 提供方没有返回 token 数，按文本长度估算
+	LatencyMs        int64     `json:"latencyMs"`
+	Outcome          string    `gorm:"size:20;index;not null" json:"outcome"` // 'success'、'error'、'cached'、'budget_exceeded'
+	Error            string    `gorm:"type:text" json:"error,omitempty"`
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// Album 相册，每张图片最多属于一个相册（images.album_id）
+type Album struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_album_user_name" json:"userID"`
+	Name      string    `gorm:"size:100;not null;uniqueIndex:idx_album_user_name" json:"name"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// 批量分析任务状态
+const (
+	BatchRunning   = "running"
+	BatchCompleted = "completed"
+	BatchCancelled = "cancelled"
+	BatchFailed    = "failed"
+)
+
+// 批量分析的图片筛选方式
+const (
+	BatchFilterIDs        = "ids"        // 指定的图片ID列表
+	BatchFilterUnanalyzed = "unanalyzed" // 没有 AI 标签的图片
+	BatchFilterOutdated   = "outdated"   // 没有被当前视觉模型成功分析过的图片
+	BatchFilterAll        = "all"        // 全部图片
+)
+
+// AnalysisBatch 一次图库范围的批量（重新）分析，每张图片的分析仍记录为一条 AIJob（trigger 为 'batch'）
+type AnalysisBatch struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	UserID      uint       `gorm:"index;not null" json:"userID"`
+	Filter      string     `gorm:"size:20;not null" json:"filter"`       // 'ids'、'unanalyzed'、'outdated'、'all'
+	VisionModel string     `gorm:"size:100" json:"visionModel"`          // 创建任务时的视觉模型
+	Status      string     `gorm:"size:20;index;not null" json:"status"` // 'running'、'completed'、'cancelled'、'failed'
+	ImageIDs    []uint     `gorm:"serializer:json;type:text" json:"-"`   // 待分析的图片ID
+	Total       int        `json:"total"`                                // 图片总数
+	Processed   int        `json:"processed"`                            // 已处理（成功或失败）的数量
+	Succeeded   int        `json:"succeeded"`
+	Failed      int        `json:"failed"`
+	Error       string     `gorm:"type:text" json:"error,omitempty"` // 任务整体失败的原因
+	FinishedAt  *time.Time `json:"finishedAt"`
+}
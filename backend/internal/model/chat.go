@@ -0,0 +1,31 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ChatSession 对话助手的一个会话，保存最近一轮的查询条件和结果，供后续消息继续筛选或批量操作
+type ChatSession struct {
+	ID            uint            `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+	UserID        uint            `gorm:"index;not null" json:"userID"`
+	Title         string          `gorm:"size:200" json:"title"`                         // 默认取第一条消息的开头
+	LastCondition json.RawMessage `gorm:"type:text" json:"lastCondition,omitempty"`      // 最近一轮的查询条件（JSON）
+	LastImageIDs  []uint          `gorm:"serializer:json;type:text" json:"lastImageIDs"` // 最近一轮结果中的图片ID
+	Messages      []ChatMessage   `gorm:"foreignKey:SessionID" json:"messages,omitempty"`
+}
+
+// ChatMessage 会话中的一条消息
+type ChatMessage struct {
+	ID         uint            `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	SessionID  uint            `gorm:"index;not null" json:"sessionID"`
+	Role       string          `gorm:"size:20;not null" json:"role"` // 'user' 或 'assistant'
+	Content    string          `gorm:"type:text" json:"content"`
+	Action     string          `gorm:"size:20" json:"action,omitempty"`                     // 助手消息的意图：'search'、'refine'、'tag'、'answer'
+	Condition  json.RawMessage `gorm:"type:text" json:"condition,omitempty"`                // 助手消息执行的查询条件（JSON）
+	ImageIDs   []uint          `gorm:"serializer:json;type:text" json:"imageIDs,omitempty"` // 助手消息返回或操作的图片ID
+	ImageCount int             `json:"imageCount"`
+}
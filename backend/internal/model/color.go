@@ -0,0 +1,13 @@
+package model
+
+// ImageColor 图片调色板中的一种主色调，上传时从缩略图中提取
+// R、G、B 单独存储，用于按颜色距离筛选
+type ImageColor struct {
+	ID         uint    `gorm:"primarykey" json:"-"`
+	ImageID    uint    `gorm:"index;not null" json:"-"`
+	Hex        string  `gorm:"size:7;not null" json:"hex"` // #rrggbb
+	R          int     `gorm:"not null" json:"-"`
+	G          int     `gorm:"not null" json:"-"`
+	B          int     `gorm:"not null" json:"-"`
+	Percentage float64 `gorm:"not null" json:"percentage"` // 占图片像素的比例（0-100）
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// ImageEmbedding 图片的语义向量，每张图片最多一条记录
+// 向量以小端序 float32 编码存储；更换向量模型后按 Model 区分，需要重新索引
+type ImageEmbedding struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	ImageID     uint      `gorm:"uniqueIndex;not null" json:"imageID"`
+	UserID      uint      `gorm:"index;not null" json:"userID"`
+	Model       string    `gorm:"size:100;not null" json:"model"` // 计算向量使用的模型
+	Kind        string    `gorm:"size:10;not null" json:"kind"`   // 'text'（由标签、描述和识别出的文字计算）或 'image'（多模态模型直接计算）
+	Dimensions  int       `gorm:"not null" json:"dimensions"`
+	Vector      []byte    `gorm:"type:blob;not null" json:"-"`
+	ContentHash string    `gorm:"size:64;not null" json:"contentHash"` // 输入内容的哈希，内容未变化时跳过重新计算
+}
@@ -0,0 +1,12 @@
+package model
+
+import "gorm.io/gorm"
+
+// UserIdentity 外部身份提供方（OIDC）账号与本地用户的关联
+type UserIdentity struct {
+	gorm.Model
+	UserID  uint   `gorm:"index;not null" json:"userID"`
+	Issuer  string `gorm:"size:255;not null;uniqueIndex:idx_identity_issuer_subject" json:"issuer"`
+	Subject string `gorm:"size:255;not null;uniqueIndex:idx_identity_issuer_subject" json:"subject"`
+	Email   string `gorm:"size:255" json:"email"` // 最近一次登录时身份提供方返回的邮箱
+}
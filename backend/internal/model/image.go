@@ -5,18 +5,33 @@ import (
 	"time"
 )
 
+// FileSizeMissing 标记补齐文件大小时原始文件已不存在的历史图片，避免每次启动重复检查
+const FileSizeMissing int64 = -1
+
 type Image struct {
 	gorm.Model
-	Filename      string `gorm:"size:255;not null" json:"filename"`
+	Filename      string `gorm:"size:255;not null;index:idx_images_fulltext,class:FULLTEXT,option:WITH PARSER ngram,priority:1" json:"filename"`
 	FilePath      string `gorm:"size:255;not null" json:"filePath"`
 	ThumbnailPath string `gorm:"size:255;not null" json:"thumbnailPath"`
+	FileSize      int64  `gorm:"not null;default:0" json:"fileSize"` // 原始文件大小（字节），FileSizeMissing 表示补齐时文件已不存在
 	UserID        uint   `json:"userID"`
 	User          User   `gorm:"foreignKey:UserID" json:"-"` // 定义外键关联
 	CameraMake    string     `gorm:"size:100" json:"cameraMake"`    // 相机制造商
 	CameraModel   string     `gorm:"size:100" json:"cameraModel"`   // 相机型号
 	Resolution    string     `gorm:"size:50" json:"resolution"`     // 分辨率
+	Width         int        `gorm:"not null;default:0" json:"width"`  // 宽度（像素），用于按方向和尺寸筛选
+	Height        int        `gorm:"not null;default:0" json:"height"` // 高度（像素）
 	TakenAt       *time.Time `json:"takenAt"`                     // 拍摄时间 (使用指针以允许为空)
 	Latitude      *float64   `json:"latitude"`                    // 纬度
 	Longitude     *float64   `json:"longitude"`                   // 经度
+	Description   string     `gorm:"type:text;index:idx_images_fulltext,priority:2" json:"description"` // 图片的自然语言描述
+	AltText       string     `gorm:"size:500;index:idx_images_fulltext,priority:3" json:"altText"` // 无障碍替代文本
+	DescriptionSource string `gorm:"size:20" json:"descriptionSource"`    // 'ai' 或 'user'；用户编辑过的描述不会被 AI 覆盖
+	OCRText       string     `gorm:"column:ocr_text;type:text;index:idx_images_fulltext,priority:4" json:"ocrText"` // 图片中识别出的文字
+	AlbumID       *uint      `gorm:"index" json:"albumID"`  // 所属相册（可为空）
+	Monochrome    bool       `gorm:"not null;default:false;index" json:"monochrome"` // 基本是黑白（灰度）的图片
+	Colors        []ImageColor `gorm:"foreignKey:ImageID" json:"colors,omitempty"` // 主色调，按占比从高到低
+	ModerationStatus string   `gorm:"size:20;not null;default:'';index" json:"moderationStatus"` // 内容审核状态，见 ModerationClean 等常量
+	Moderation    *ImageModeration `gorm:"foreignKey:ImageID" json:"moderation,omitempty"` // 最近一次的审核结果
 	Tags          []Tag      `gorm:"many2many:image_tags;" json:"Tags"`
 }
\ No newline at end of file
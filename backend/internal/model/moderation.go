@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// 图片的内容审核状态（Image.ModerationStatus），未审核的图片为空字符串
+const (
+	ModerationQueued      = "queued"      // 启用审核时新图片的初始状态，审核完成之前不可见
+	ModerationClean       = "clean"       // 没有命中任何类别
+	ModerationFlagged     = "flagged"     // 命中了类别但只做标记，图片仍然可见
+	ModerationQuarantined = "quarantined" // 命中了类别并被隔离，等待管理员复核
+	ModerationApproved    = "approved"    // 管理员复核后放行，之后的分析不会再次审核
+	ModerationBlocked     = "blocked"     // 管理员复核后确认屏蔽
+)
+
+// HiddenModerationStatuses 不出现在图片列表、搜索和公开链接中的审核状态
+var HiddenModerationStatuses = []string{ModerationQueued, ModerationQuarantined, ModerationBlocked}
+
+// ImageModeration 图片的内容审核结果，每张图片一条记录（重新审核时覆盖）
+type ImageModeration struct {
+	ID         uint               `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+	ImageID    uint               `gorm:"uniqueIndex;not null" json:"imageID"`
+	UserID     uint               `gorm:"index;not null" json:"userID"`
+	Engine     string             `gorm:"size:100" json:"engine"`                   // 审核引擎，例如 vision:openai、http
+	Scores     map[string]float64 `gorm:"serializer:json;type:text" json:"scores"`  // 每个安全类别的得分（0-1）
+	Flagged    []string           `gorm:"serializer:json;type:text" json:"flagged"` // 得分达到阈值的类别
+	ReviewedBy *uint              `json:"reviewedBy"`                               // 复核的管理员
+	ReviewedAt *time.Time         `json:"reviewedAt"`
+	Note       string             `gorm:"size:500" json:"note"` // 管理员复核时的备注
+}
@@ -0,0 +1,15 @@
+package model
+
+import "gorm.io/gorm"
+
+// UserPreference 用户偏好设置，每个用户最多一条记录
+type UserPreference struct {
+	gorm.Model
+	UserID        uint   `gorm:"uniqueIndex;not null" json:"userID"`
+	AutoAnalyze   bool   `gorm:"not null;default:false" json:"autoAnalyze"`        // 上传时未指定 autoAnalyze 参数时的默认值
+	AILanguage    string `gorm:"size:10;not null;default:'zh'" json:"aiLanguage"`  // AI 生成标签的语言：'zh' 或 'en'
+	AIDescribe    bool   `gorm:"not null;default:false" json:"aiDescribe"`         // AI 分析时是否同时生成图片描述和替代文本
+	AIReviewTags  bool   `gorm:"not null;default:false" json:"aiReviewTags"`       // AI 标签先进入待审核队列，由用户接受后才关联到图片
+	ThumbnailSize int    `gorm:"not null;default:400" json:"thumbnailSize"`        // 缩略图宽度（像素）
+	Timezone      string `gorm:"size:64;not null;default:'Local'" json:"timezone"` // IANA 时区名，用于解释 EXIF 拍摄时间和月份筛选
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// PromptTemplate 管理员在数据库中维护的提示词模板，每次修改保存为新版本
+// 同一名称和语言只有一个版本处于启用状态，没有启用的版本时使用文件或内置模板
+type PromptTemplate struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `gorm:"size:50;not null;uniqueIndex:idx_prompt_version" json:"name"`     // 'tag'、'describe'、'query'
+	Language  string    `gorm:"size:20;not null;uniqueIndex:idx_prompt_version" json:"language"` // 例如 'zh'、'en'、'ja'
+	Version   int       `gorm:"not null;uniqueIndex:idx_prompt_version" json:"version"`
+	Body      string    `gorm:"type:text;not null" json:"body"` // text/template 语法
+	Active    bool      `gorm:"not null;default:false;index" json:"active"`
+	CreatedBy uint      `json:"createdBy"`
+}
+
+// VocabularyTerm 用户的受控词表词条，设置了词表后 AI 标签必须映射到词条
+type VocabularyTerm struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_vocabulary_user_name" json:"userID"`
+	Name      string    `gorm:"size:100;not null;uniqueIndex:idx_vocabulary_user_name" json:"name"`
+	Aliases   []string  `gorm:"serializer:json;type:text" json:"aliases"` // 映射到该词条的其他说法（同义词、其他语言）
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// AI 建议标签的审核状态
+const (
+	SuggestionPending  = "pending"
+	SuggestionAccepted = "accepted"
+	SuggestionRejected = "rejected"
+)
+
+// TagSuggestion AI 为图片建议的标签，用户接受后才会关联到图片
+// 被拒绝的记录会保留，作为之后分析时的负反馈
+type TagSuggestion struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	ImageID    uint       `gorm:"not null;uniqueIndex:idx_suggestion_image_tag" json:"imageID"`
+	Image      *Image     `gorm:"foreignKey:ImageID" json:"image,omitempty"`
+	UserID     uint       `gorm:"index;not null" json:"userID"`
+	TagName    string     `gorm:"size:100;not null;uniqueIndex:idx_suggestion_image_tag;index" json:"tagName"`
+	Status     string     `gorm:"size:20;index;not null" json:"status"` // 'pending'、'accepted'、'rejected'
+	Category   string     `gorm:"size:20" json:"category"`              // AI 给出的标签类别
+	Confidence float64    `json:"confidence"`                           // AI 给出的置信度（0-1）
+	JobID      uint       `json:"jobID"`                                // 产生该建议的 AI 任务
+	ReviewedAt *time.Time `json:"reviewedAt"`
+}
@@ -1,10 +1,43 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Tag struct {
 	gorm.Model
-	Name   string  `gorm:"size:100;not null;unique" json:"name"`
-	Source string  `gorm:"size:20;default:'user'" json:"source"` // 'user' 或 'ai'，标识标签来源
-	Images []Image `gorm:"many2many:image_tags;" json:"-"` // 定义多对多关系
-}
\ No newline at end of file
+	Name     string     `gorm:"size:100;not null;unique" json:"name"`
+	Source   string     `gorm:"size:20;default:'user'" json:"source"` // 'user' 或 'ai'，标识标签来源
+	Category string     `gorm:"size:20" json:"category"`              // AI 给出的标签类别，例如 'scene'、'object'、'mood'
+	ParentID *uint      `gorm:"index" json:"parentID"`                // 父标签；按父标签筛选时同时匹配所有子标签
+	Aliases  []TagAlias `gorm:"foreignKey:TagID" json:"aliases,omitempty"`
+	Images   []Image    `gorm:"many2many:image_tags;" json:"-"` // 定义多对多关系
+}
+
+// TagAlias 标签的同义词（例如 "狗"、"puppy" 指向 "dog"）
+// 添加标签、AI 标签和按标签筛选时，同义词都解析为规范标签
+type TagAlias struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Name      string    `gorm:"size:100;not null;unique" json:"name"`
+	TagID     uint      `gorm:"index;not null" json:"tagID"` // 规范标签
+}
+
+// ImageTag 图片和标签的关联（image_tags 表），记录关联时间用于统计标签最近的使用时间
+// 添加该字段之前的关联没有时间，统计时使用图片的上传时间
+type ImageTag struct {
+	ImageID   uint       `gorm:"primaryKey"`
+	TagID     uint       `gorm:"primaryKey;index"`
+	CreatedAt *time.Time `json:"createdAt"`
+}
+
+// TagCooccurrence 用户图库中两个标签同时出现在同一张图片上的次数，随标签的添加和移除增量更新
+// TagID 与 OtherTagID 相同的记录为带有该标签的图片数量
+type TagCooccurrence struct {
+	UserID     uint  `gorm:"primaryKey;autoIncrement:false" json:"userID"`
+	TagID      uint  `gorm:"primaryKey;autoIncrement:false" json:"tagID"`
+	OtherTagID uint  `gorm:"primaryKey;autoIncrement:false;index" json:"otherTagID"`
+	Count      int64 `gorm:"not null;default:0" json:"count"`
+}
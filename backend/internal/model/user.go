@@ -1,10 +1,30 @@
 package model
 
-import "gorm.io/gorm"
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// 用户角色
+const (
+    RoleUser  = "user"
+    RoleAdmin = "admin"
+)
 
 type User struct {
-    gorm.Model        // 包含 ID, CreatedAt, UpdatedAt, DeletedAt 等字段
-    Username   string `gorm:"size:255;not null;unique" json:"username"`
-    Email      string `gorm:"size:255;not null;unique" json:"email"`
-    Password   string `gorm:"size:255;not null;" json:"-"` // json:"-" 表示这个字段在序列化为JSON时应被忽略
-}
\ No newline at end of file
+    gorm.Model                     // 包含 ID, CreatedAt, UpdatedAt, DeletedAt 等字段
+    Username            string     `gorm:"size:255;not null;unique" json:"username"`
+    Email               string     `gorm:"size:255;not null;unique" json:"email"`
+    EmailVerified       bool       `gorm:"not null;default:false" json:"emailVerified"` // 邮箱是否经过验证（目前只有身份提供方验证过的邮箱），修改邮箱后重置
+    Password            string     `gorm:"size:255;not null;" json:"-"` // json:"-" 表示这个字段在序列化为JSON时应被忽略
+    AvatarImageID       *uint      `json:"avatarImageID"` // 头像使用的图片（用户自己的图片）
+    Role                string     `gorm:"size:20;not null;default:'user'" json:"role"` // 'user' 或 'admin'
+    Disabled            bool       `gorm:"not null;default:false" json:"disabled"`      // 被管理员禁用的账号无法登录和访问 API
+    StorageQuotaBytes   *int64     `json:"storageQuotaBytes"` // 管理员设置的存储配额（字节），为空时使用默认值
+    ImageQuota          *int64     `json:"imageQuota"`        // 管理员设置的图片数量配额，为空时使用默认值
+    AIDailyTokenBudget  *int64     `json:"aiDailyTokenBudget"`   // 管理员设置的每日 AI token 预算，为空时使用默认值
+    AIMonthlyTokenBudget *int64    `json:"aiMonthlyTokenBudget"` // 管理员设置的每月 AI token 预算，为空时使用默认值
+    FailedLoginAttempts int        `gorm:"not null;default:0" json:"-"` // 连续登录失败次数
+    LockedUntil         *time.Time `json:"-"`                           // 账号锁定截止时间（为空表示未锁定）
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// 批量操作类型
+const (
+	ActionAddTag      = "add_tag"       // 添加标签（tag）
+	ActionRemoveTag   = "remove_tag"    // 移除标签（tag）
+	ActionMoveToAlbum = "move_to_album" // 移动到相册（album，不存在时创建）
+	ActionDelete      = "delete"        // 删除图片
+	ActionReanalyze   = "reanalyze"     // 重新进行 AI 分析
+)
+
+// PlannedAction 计划中的一个操作
+type PlannedAction struct {
+	Type  string `json:"type"`
+	Tag   string `json:"tag,omitempty"`
+	Album string `json:"album,omitempty"`
+}
+
+// ActionProposal 模型根据指令提出的操作计划：目标图片的查询条件和要执行的操作
+type ActionProposal struct {
+	Target    QueryCondition  `json:"target"`
+	Actions   []PlannedAction `json:"actions"`
+	Reasoning string          `json:"reasoning"`
+}
+
+// ProposeActions 将自然语言指令（如"把 2023 年的海滩照片都标记为旅行并移到夏天相册"）解析为操作计划
+// 操作只是提议，由调用方预览并在用户确认后执行
+func (s *AIService) ProposeActions(ctx context.Context, instruction string, availableTags []string, albums []string) (*ActionProposal, error) {
+	tagsInfo := "当前没有可用的标签。"
+	if len(availableTags) > 0 {
+		tagsInfo = "可用的标签列表：" + strings.Join(availableTags, "、")
+	}
+	albumsInfo := "当前没有相册。"
+	if len(albums) > 0 {
+		albumsInfo = "已有的相册：" + strings.Join(albums, "、")
+	}
+
+	prompt := fmt.Sprintf(`你是一个图片库管理助手。用户会用自然语言描述要对一批图片执行的操作，你需要把它转换为结构化的操作计划。
+
+用户指令：%s
+%s
+%s
+
+target 描述要操作哪些图片，规则与图片检索相同：
+- tags：必须严格从可用标签列表中选择，没有匹配时返回空数组 []
+- month："YYYY-MM"；只提到年份时 month 为空，year 为 "YYYY"
+- camera：相机品牌；keywords：其他关键词
+
+actions 是要执行的操作列表，type 取以下值之一：
+- "add_tag"：添加标签，tag 填写标签名称（可以是新标签）
+- "remove_tag"：移除标签，tag 必须从可用标签列表中选择
+- "move_to_album"：移动到相册，album 填写相册名称（优先使用已有的相册）
+- "delete"：删除图片
+- "reanalyze"：重新进行 AI 分析
+
+请以JSON格式返回，格式如下：
+{"target": {"tags": [], "month": "", "year": "2023", "camera": "", "keywords": []}, "actions": [{"type": "add_tag", "tag": "旅行"}], "reasoning": "你的推理过程"}
+
+只返回JSON，不要其他文字，不要使用markdown代码块。`, instruction, tagsInfo, albumsInfo)
+
+	call := modelCall{Operation: OperationPlan, Provider: s.chat.Name(), Model: s.chat.ChatModel(), Prompt: prompt}
+	content, err := s.callModel(ctx, call, func(ctx context.Context) (string, error) {
+		return s.chat.Chat(ctx, ChatRequest{
+			System: "You are a careful image library assistant. You only propose actions the user asked for.",
+			Prompt: prompt,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var proposal ActionProposal
+	if err := json.Unmarshal([]byte(extractJSON(content)), &proposal); err != nil {
+		log.Printf("Failed to parse action plan JSON: %v, content: %s", err, content)
+		return nil, fmt.Errorf("failed to parse action plan: %w", err)
+	}
+
+	proposal.Target.Tags = filterAvailableTags(proposal.Target.Tags, availableTags)
+	actions, err := NormalizeActions(proposal.Actions)
+	if err != nil {
+		return nil, err
+	}
+	proposal.Actions = actions
+
+	log.Printf("Proposed actions: %+v (target: tags=%v, month=%s, year=%s, camera=%s, keywords=%v)",
+		proposal.Actions, proposal.Target.Tags, proposal.Target.Month, proposal.Target.Year, proposal.Target.Camera, proposal.Target.Keywords)
+	return &proposal, nil
+}
+
+// NormalizeActions 校验并整理操作列表：去掉未知或缺少参数的操作和重复操作
+// 包含删除时只保留删除（对将要删除的图片执行其他操作没有意义）；没有有效操作时返回错误
+func NormalizeActions(actions []PlannedAction) ([]PlannedAction, error) {
+	normalized := make([]PlannedAction, 0, len(actions))
+	seen := make(map[PlannedAction]bool)
+	for _, action := range actions {
+		action.Type = strings.ToLower(strings.TrimSpace(action.Type))
+		action.Tag = strings.TrimSpace(action.Tag)
+		action.Album = strings.TrimSpace(action.Album)
+
+		switch action.Type {
+		case ActionAddTag, ActionRemoveTag:
+			if action.Tag == "" || len([]rune(action.Tag)) > 100 {
+				log.Printf("Skipping %s action with invalid tag %q", action.Type, action.Tag)
+				continue
+			}
+			action.Album = ""
+		case ActionMoveToAlbum:
+			if action.Album == "" || len([]rune(action.Album)) > 100 {
+				log.Printf("Skipping move_to_album action with invalid album %q", action.Album)
+				continue
+			}
+			action.Tag = ""
+		case ActionDelete:
+			return []PlannedAction{{Type: ActionDelete}}, nil
+		case ActionReanalyze:
+			action.Tag, action.Album = "", ""
+		default:
+			log.Printf("Skipping unknown action type %q", action.Type)
+			continue
+		}
+
+		if !seen[action] {
+			seen[action] = true
+			normalized = append(normalized, action)
+		}
+	}
+
+	if len(normalized) == 0 {
+		return nil, fmt.Errorf("no valid actions in the plan")
+	}
+	return normalized, nil
+}
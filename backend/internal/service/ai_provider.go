@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ChatMessage 多轮对话中的一条历史消息
+type ChatMessage struct {
+	Role    string // 'user' 或 'assistant'
+	Content string
+}
+
+// ChatRequest 文本对话请求
+type ChatRequest struct {
+	Model   string          // 为空时使用提供方配置的默认文本模型
+	System  string          // 系统提示词（可选）
+	History []ChatMessage   // 之前的对话（可选），按时间顺序排列在系统提示词和本次提示词之间
+	Prompt  string          // 用户提示词
+	Schema  *ResponseSchema // 要求按 JSON Schema 输出（可选）
+}
+
+// VisionRequest 图片理解请求
+type VisionRequest struct {
+	Model    string // 为空时使用提供方配置的默认视觉模型
+	System   string
+	Prompt   string
+	Image    []byte
+	MIMEType string          // 例如 image/jpeg
+	Schema   *ResponseSchema // 要求按 JSON Schema 输出（可选）
+}
+
+// ChatProvider 文本大模型提供方（用于自然语言查询解析等）
+type ChatProvider interface {
+	Name() string
+	ChatModel() string
+	Chat(ctx context.Context, req ChatRequest) (string, error)
+}
+
+// StreamingChatProvider 支持流式输出的文本提供方
+// onDelta 在收到每段增量文本时调用，返回错误时中止请求（例如客户端已断开）；返回完整的输出文本
+type StreamingChatProvider interface {
+	ChatProvider
+	ChatStream(ctx context.Context, req ChatRequest, onDelta func(delta string) error) (string, error)
+}
+
+// VisionProvider 视觉大模型提供方（用于图片标签分析等）
+type VisionProvider interface {
+	Name() string
+	VisionModel() string
+	Vision(ctx context.Context, req VisionRequest) (string, error)
+}
+
+// ProviderConfig 提供方的通用配置
+type ProviderConfig struct {
+	Provider    string // 'modelscope'、'openai'、'ollama'、'mock'
+	BaseURL     string
+	APIKey      string
+	VisionModel string
+	ChatModel   string
+	Timeout     time.Duration
+	// ResponseFormat 结构化输出的约束方式：json_schema、json_object 或 none
+	ResponseFormat string
+}
+
+// LoadProviderConfig 从环境变量读取 AI 提供方配置
+// AI_PROVIDER 选择后端（默认 modelscope，兼容原有 MODELSCOPE_* 环境变量）
+func LoadProviderConfig() ProviderConfig {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("AI_PROVIDER")))
+	if provider == "" {
+		provider = "modelscope"
+	}
+
+	cfg := defaultProviderConfig(provider)
+
+	// AI_VISION_MODEL / AI_CHAT_MODEL 可覆盖任意提供方的默认模型
+	if model := strings.TrimSpace(os.Getenv("AI_VISION_MODEL")); model != "" {
+		cfg.VisionModel = model
+	}
+	if model := strings.TrimSpace(os.Getenv("AI_CHAT_MODEL")); model != "" {
+		cfg.ChatModel = model
+	}
+	cfg.VisionModel = strings.TrimSpace(cfg.VisionModel)
+	cfg.ChatModel = strings.TrimSpace(cfg.ChatModel)
+
+	return cfg
+}
+
+// defaultProviderConfig 返回指定提供方的配置（地址、密钥和默认模型），也用于创建备用提供方
+func defaultProviderConfig(provider string) ProviderConfig {
+	cfg := ProviderConfig{
+		Provider: provider,
+		Timeout:  loadTimeout(),
+	}
+
+	switch provider {
+	case "modelscope":
+		cfg.BaseURL = envOr("MODELSCOPE_BASE_URL", "https://api-inference.modelscope.cn/v1")
+		cfg.APIKey = os.Getenv("MODELSCOPE_ACCESS_TOKEN")
+		// 原有行为：MODELSCOPE_MODEL 同时用于图片分析和查询解析
+		model := envOr("MODELSCOPE_MODEL", "Qwen/QVQ-72B-Preview")
+		cfg.VisionModel = model
+		cfg.ChatModel = model
+	case "openai":
+		cfg.BaseURL = envOr("OPENAI_BASE_URL", "https://api.openai.com/v1")
+		cfg.APIKey = os.Getenv("OPENAI_API_KEY")
+		cfg.VisionModel = "gpt-4o-mini"
+		cfg.ChatModel = "gpt-4o-mini"
+	case "ollama":
+		cfg.BaseURL = envOr("OLLAMA_BASE_URL", "http://127.0.0.1:11434")
+		cfg.VisionModel = "llava"
+		cfg.ChatModel = "llama3.1"
+	case "mock":
+		cfg.VisionModel = "mock-vision"
+		cfg.ChatModel = "mock-chat"
+	}
+
+	// 结构化输出：OpenAI 和 Ollama 默认按 JSON Schema 约束，ModelScope 的部分模型不支持，默认只在提示词中要求
+	cfg.ResponseFormat = ResponseFormatSchema
+	if provider == "modelscope" {
+		cfg.ResponseFormat = ResponseFormatNone
+	}
+	if format := strings.ToLower(strings.TrimSpace(os.Getenv("AI_RESPONSE_FORMAT"))); format != "" {
+		switch format {
+		case ResponseFormatSchema, ResponseFormatJSON, ResponseFormatNone:
+			cfg.ResponseFormat = format
+		default:
+			log.Printf("Invalid AI_RESPONSE_FORMAT value %q, using %s", format, cfg.ResponseFormat)
+		}
+	}
+	return cfg
+}
+
+// NewProviders 根据配置创建视觉和文本提供方
+func NewProviders(cfg ProviderConfig) (VisionProvider, ChatProvider, error) {
+	switch cfg.Provider {
+	case "modelscope", "openai":
+		if cfg.APIKey == "" {
+			if cfg.Provider == "modelscope" {
+				return nil, nil, fmt.Errorf("MODELSCOPE_ACCESS_TOKEN environment variable is not set")
+			}
+			return nil, nil, fmt.Errorf("OPENAI_API_KEY environment variable is not set")
+		}
+		p := NewOpenAICompatibleProvider(cfg)
+		return p, p, nil
+	case "ollama":
+		p := NewOllamaProvider(cfg)
+		return p, p, nil
+	case "mock":
+		p := NewMockProvider()
+		return p, p, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown AI_PROVIDER %q (supported: modelscope, openai, ollama, mock)", cfg.Provider)
+	}
+}
+
+// loadTimeout 读取 AI 请求超时时间，默认 60 秒
+func loadTimeout() time.Duration {
+	timeoutStr := os.Getenv("AI_TIMEOUT")
+	if timeoutStr == "" {
+		timeoutStr = os.Getenv("MODELSCOPE_TIMEOUT")
+	}
+	if timeoutStr == "" {
+		timeoutStr = os.Getenv("GEMINI_TIMEOUT") // 兼容旧的环境变量名
+	}
+	if timeoutStr != "" {
+		if parsedTimeout, err := time.ParseDuration(timeoutStr); err == nil {
+			return parsedTimeout
+		}
+	}
+	return 60 * time.Second
+}
+
+// newHTTPClient 创建支持代理的 HTTP 客户端
+func newHTTPClient(timeout time.Duration) *http.Client {
+	// 创建 HTTP Transport，支持代理
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment, // 自动从环境变量读取代理设置
+	}
+
+	// 如果设置了自定义代理 URL，使用它
+	proxyURL := os.Getenv("HTTP_PROXY")
+	if proxyURL == "" {
+		proxyURL = os.Getenv("HTTPS_PROXY")
+	}
+	if proxyURL == "" {
+		proxyURL = os.Getenv("http_proxy")
+	}
+	if proxyURL == "" {
+		proxyURL = os.Getenv("https_proxy")
+	}
+	if proxyURL != "" {
+		parsedProxyURL, err := url.Parse(proxyURL)
+		if err == nil {
+			transport.Proxy = http.ProxyURL(parsedProxyURL)
+			log.Printf("Using proxy: %s", parsedProxyURL.Host)
+		} else {
+			log.Printf("Invalid proxy URL %s: %v", proxyURL, err)
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+}
+
+// envOr 获取环境变量，如果不存在则返回默认值
+func envOr(key, defaultValue string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return defaultValue
+}
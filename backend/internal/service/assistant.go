@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// 对话助手每一轮的意图
+const (
+	AssistantSearch = "search" // 新的查询，替换上一轮的结果
+	AssistantRefine = "refine" // 在上一轮的结果中继续筛选
+	AssistantTag    = "tag"    // 为上一轮的结果批量添加标签
+	AssistantAnswer = "answer" // 只回答问题，不查询图片
+)
+
+// assistantIntentSystem / assistantReplySystem 两个阶段的系统提示词
+const (
+	assistantIntentSystem = "You are an image library assistant. You translate the user's latest message into a structured action and reply with JSON only."
+	assistantReplySystem  = "You are a friendly image library assistant. Reply briefly in the same language as the user."
+)
+
+// maxAssistantHistory 提供给模型的历史消息条数上限
+const maxAssistantHistory = 10
+
+// AssistantIntent 模型从用户消息中解析出的意图
+type AssistantIntent struct {
+	Action string `json:"action"` // search、refine、tag、answer
+	QueryCondition
+	Tag string `json:"tag"` // action 为 tag 时要添加的标签
+}
+
+// AssistantContext 对话的上下文：历史消息和上一轮的查询结果
+type AssistantContext struct {
+	History       []ChatMessage
+	Previous      *QueryCondition // 上一轮的查询条件，没有时为 nil
+	PreviousCount int             // 上一轮结果中的图片数量
+	AvailableTags []string
+}
+
+// ParseAssistantIntent 结合历史对话，将用户的最新消息解析为意图
+// 例如"只要 2023 年的"解析为 refine，"把它们都标记为旅行"解析为 tag
+func (s *AIService) ParseAssistantIntent(ctx context.Context, message string, ac AssistantContext) (*AssistantIntent, error) {
+	tagsInfo := "当前没有可用的标签。"
+	if len(ac.AvailableTags) > 0 {
+		tagsInfo = "可用的标签列表：" + strings.Join(ac.AvailableTags, "、")
+	}
+	previousInfo := "上一轮没有查询结果。"
+	if ac.Previous != nil {
+		previous, _ := json.Marshal(ac.Previous)
+		previousInfo = fmt.Sprintf("上一轮的查询条件：%s，共找到 %d 张图片。", previous, ac.PreviousCount)
+	}
+
+	schema := assistantSchema()
+	prompt := fmt.Sprintf(`用户的最新消息：%s
+
+今天是 %s。
+%s
+%s
+
+请判断用户想做什么，action 取以下值之一：
+- "search"：开始一个新的图片查询
+- "refine"：在上一轮的结果中继续筛选（如"只要 2023 年的"、"其中有猫的"），只填写新增的条件
+- "tag"：为上一轮结果中的所有图片添加一个标签（如"把它们都标记为旅行"），tag 填写标签名称
+- "answer"：不需要查询图片，只回答问题（如问候、询问上一轮结果的数量）
+
+查询条件的规则与图片检索相同：
+- tags / excludeTags：想要（必须全部带有）和明确不想要的标签，必须严格从可用标签列表中选择，没有匹配时返回空数组 []
+- anyOf："A 或 B"这样的条件放在同一组的 tags 或 keywords 中，匹配组内任意一个即可
+- month："YYYY-MM"；只提到年份时 month 为空，year 为 "YYYY"；日期范围用 dateFrom、dateTo（"YYYY-MM-DD"，包含在内，开放范围只填一端）；上传时间用 uploadedFrom、uploadedTo
+- camera：相机品牌；location：拍摄地点（name，城市或景点知道时填写 latitude、longitude、radiusKm，省份或国家填写 bounds 范围框），没有时为 null
+- orientation：landscape、portrait 或 square；minWidth / minHeight / maxWidth / maxHeight：像素尺寸限制，0 表示不限制
+- keywords / excludeKeywords：其他想要（匹配任意一个即可）和不想要的关键词
+- reasoning：简要说明你的理解
+
+只返回符合以下 JSON Schema 的 JSON 对象，不要其他文字，不要使用markdown代码块：
+%s
+
+例如：{"action": "refine", "tags": [], "anyOf": [], "excludeTags": [], "month": "", "year": "2023", "dateFrom": "", "dateTo": "", "uploadedFrom": "", "uploadedTo": "", "camera": "", "location": null, "orientation": "", "minWidth": 0, "minHeight": 0, "maxWidth": 0, "maxHeight": 0, "keywords": [], "excludeKeywords": [], "tag": "", "reasoning": "你的推理过程"}`,
+		message, time.Now().Format("2006-01-02"), tagsInfo, previousInfo, schema.String())
+
+	var intent AssistantIntent
+	decode := func(content string) error {
+		intent = AssistantIntent{}
+		if err := decodeJSONObject(content, &intent); err != nil {
+			return err
+		}
+		return validateQueryCondition(&intent.QueryCondition)
+	}
+	call := modelCall{Operation: OperationAssistant, Provider: s.chat.Name(), Model: s.chat.ChatModel(), Prompt: prompt}
+	content, err := s.callModel(ctx, call, func(ctx context.Context) (string, error) {
+		return s.chat.Chat(ctx, ChatRequest{
+			System:  assistantIntentSystem,
+			History: recentHistory(ac.History),
+			Prompt:  prompt,
+			Schema:  schema,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析并校验结构化输出，不符合 schema 时修复一次
+	if err := s.decodeStructured(ctx, content, schema, decode); err != nil {
+		return nil, fmt.Errorf("failed to parse assistant intent: %w", err)
+	}
+
+	filterConditionTags(&intent.QueryCondition, ac.AvailableTags)
+	intent.Tag = strings.TrimSpace(intent.Tag)
+	switch intent.Action {
+	case AssistantSearch, AssistantRefine, AssistantTag, AssistantAnswer:
+	default:
+		intent.Action = AssistantSearch
+	}
+	if intent.Action == AssistantRefine && ac.Previous == nil {
+		intent.Action = AssistantSearch // 没有上一轮结果时按新查询处理
+	}
+	if intent.Action == AssistantTag && intent.Tag == "" {
+		intent.Action = AssistantAnswer
+	}
+
+	log.Printf("Parsed assistant intent: action=%s, tags=%v, month=%s, year=%s, camera=%s, keywords=%v, tag=%s",
+		intent.Action, intent.Tags, intent.Month, intent.Year, intent.Camera, intent.Keywords, intent.Tag)
+	return &intent, nil
+}
+
+// StreamAssistantReply 根据本轮的执行结果生成给用户的回复，支持流式输出时逐段回调 onDelta
+// outcome 是本轮执行结果的简要说明（例如"找到 12 张图片"）
+func (s *AIService) StreamAssistantReply(ctx context.Context, message string, ac AssistantContext, outcome string, onDelta func(delta string) error) (string, error) {
+	req := ChatRequest{
+		System:  assistantReplySystem,
+		History: recentHistory(ac.History),
+		Prompt: fmt.Sprintf(`用户的最新消息：%s
+
+请用一两句话告诉用户本轮的执行结果，不要编造图片内容，也不要列出图片。
+执行结果：%s`, message, outcome),
+	}
+
+	if streaming, ok := s.chat.(StreamingChatProvider); ok {
+		return streaming.ChatStream(ctx, req, onDelta)
+	}
+
+	reply, err := s.chat.Chat(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if err := onDelta(reply); err != nil {
+		return reply, err
+	}
+	return reply, nil
+}
+
+// recentHistory 返回最近的若干条历史消息
+func recentHistory(history []ChatMessage) []ChatMessage {
+	if len(history) > maxAssistantHistory {
+		return history[len(history)-maxAssistantHistory:]
+	}
+	return history
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// EmbeddingProvider 文本向量（embedding）提供方
+type EmbeddingProvider interface {
+	Name() string
+	Model() string
+	// EmbedTexts 为每段文本返回一个向量，顺序与输入一致
+	EmbedTexts(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// ImageEmbeddingProvider 可以直接对图片计算向量的提供方（例如 CLIP 类多模态模型）
+// 图片向量和文本向量必须处于同一向量空间；不支持时图片以其文本描述（标签、描述、识别出的文字）计算向量
+type ImageEmbeddingProvider interface {
+	EmbeddingProvider
+	EmbedImage(ctx context.Context, image []byte, mimeType string) ([]float32, error)
+}
+
+// NewEmbeddingProviderFromEnv 根据环境变量创建向量提供方，未启用时返回 nil
+//   - EMBEDDING_PROVIDER：openai、ollama、mock 或 none；默认跟随 AI_PROVIDER（modelscope 需要显式配置）
+//   - EMBEDDING_BASE_URL / EMBEDDING_API_KEY / EMBEDDING_MODEL：覆盖默认的地址、密钥和模型
+func NewEmbeddingProviderFromEnv() (EmbeddingProvider, error) {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("EMBEDDING_PROVIDER")))
+	if provider == "" {
+		switch strings.ToLower(strings.TrimSpace(os.Getenv("AI_PROVIDER"))) {
+		case "openai":
+			provider = "openai"
+		case "ollama":
+			provider = "ollama"
+		case "mock":
+			provider = "mock"
+		default:
+			provider = "none"
+		}
+	}
+
+	timeout := loadTimeout()
+	switch provider {
+	case "openai":
+		apiKey := envOr("EMBEDDING_API_KEY", os.Getenv("OPENAI_API_KEY"))
+		if apiKey == "" {
+			return nil, fmt.Errorf("EMBEDDING_API_KEY (or OPENAI_API_KEY) is required for EMBEDDING_PROVIDER=openai")
+		}
+		return &OpenAIEmbeddingProvider{
+			baseURL: strings.TrimSuffix(envOr("EMBEDDING_BASE_URL", envOr("OPENAI_BASE_URL", "https://api.openai.com/v1")), "/"),
+			apiKey:  apiKey,
+			model:   envOr("EMBEDDING_MODEL", "text-embedding-3-small"),
+			timeout: timeout,
+			client:  newHTTPClient(timeout),
+		}, nil
+	case "ollama":
+		return &OllamaEmbeddingProvider{
+			baseURL: strings.TrimSuffix(envOr("EMBEDDING_BASE_URL", envOr("OLLAMA_BASE_URL", "http://127.0.0.1:11434")), "/"),
+			model:   envOr("EMBEDDING_MODEL", "nomic-embed-text"),
+			timeout: timeout,
+			client:  &http.Client{Timeout: timeout},
+		}, nil
+	case "mock":
+		return NewMockEmbeddingProvider(), nil
+	case "none", "off", "false":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown EMBEDDING_PROVIDER %q (supported: openai, ollama, mock, none)", provider)
+	}
+}
+
+// EmbedTexts 通过 provider 计算文本向量，与其他模型调用一样按 ctx 中的用户（WithUserID）检查预算并记录用量
+// tracker 为 nil 时不记录用量也不检查预算
+func EmbedTexts(ctx context.Context, provider EmbeddingProvider, tracker UsageTracker, texts []string) ([][]float32, error) {
+	meter := usageMeter{usage: tracker}
+	call := modelCall{Operation: OperationEmbed, Provider: provider.Name(), Model: provider.Model(), Prompt: strings.Join(texts, "\n")}
+	var vectors [][]float32
+	_, err := meter.callModel(ctx, call, func(ctx context.Context) (string, error) {
+		var err error
+		vectors, err = provider.EmbedTexts(ctx, texts)
+		return "", err
+	})
+	return vectors, err
+}
+
+// EmbedImage 通过 provider 计算图片向量，预算检查和用量记录与 EmbedTexts 相同
+func EmbedImage(ctx context.Context, provider ImageEmbeddingProvider, tracker UsageTracker, image []byte, mimeType string) ([]float32, error) {
+	meter := usageMeter{usage: tracker}
+	call := modelCall{Operation: OperationEmbed, Provider: provider.Name(), Model: provider.Model()}
+	var vector []float32
+	_, err := meter.callModel(ctx, call, func(ctx context.Context) (string, error) {
+		var err error
+		vector, err = provider.EmbedImage(ctx, image, mimeType)
+		return "", err
+	})
+	return vector, err
+}
+
+// OpenAIEmbeddingProvider OpenAI 兼容的 /embeddings 接口
+type OpenAIEmbeddingProvider struct {
+	baseURL string
+	apiKey  string
+	model   string
+	timeout time.Duration
+	client  *http.Client
+}
+
+// Name 返回提供方名称
+func (p *OpenAIEmbeddingProvider) Name() string { return "openai" }
+
+// Model 返回向量模型
+func (p *OpenAIEmbeddingProvider) Model() string { return p.model }
+
+// EmbedTexts 调用 /embeddings 批量计算向量
+func (p *OpenAIEmbeddingProvider) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	headers := map[string]string{"Authorization": "Bearer " + p.apiKey}
+	if err := postJSON(ctx, p.client, p.timeout, p.baseURL+"/embeddings", headers,
+		map[string]interface{}{"model": p.model, "input": texts}, &result); err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, item := range result.Data {
+		if item.Index >= 0 && item.Index < len(vectors) {
+			vectors[item.Index] = item.Embedding
+		}
+	}
+	for i, vector := range vectors {
+		if len(vector) == 0 {
+			return nil, fmt.Errorf("embedding response is missing input %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+// OllamaEmbeddingProvider 本地 Ollama 的 /api/embed 接口
+type OllamaEmbeddingProvider struct {
+	baseURL string
+	model   string
+	timeout time.Duration
+	client  *http.Client
+}
+
+// Name 返回提供方名称
+func (p *OllamaEmbeddingProvider) Name() string { return "ollama" }
+
+// Model 返回向量模型
+func (p *OllamaEmbeddingProvider) Model() string { return p.model }
+
+// EmbedTexts 调用 /api/embed 批量计算向量
+func (p *OllamaEmbeddingProvider) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	var result struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := postJSON(ctx, p.client, p.timeout, p.baseURL+"/api/embed", nil,
+		map[string]interface{}{"model": p.model, "input": texts}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("ollama returned %d embeddings for %d inputs", len(result.Embeddings), len(texts))
+	}
+	return result.Embeddings, nil
+}
+
+// postJSON 发送 JSON 请求并解析 JSON 响应
+func postJSON(ctx context.Context, client *http.Client, timeout time.Duration, url string, headers map[string]string, body interface{}, out interface{}) error {
+	requestBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	startTime := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("request timeout after %v", timeout)
+		}
+		return fmt.Errorf("failed to call %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	log.Printf("%s responded in %v", url, time.Since(startTime))
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d: %s", url, resp.StatusCode, string(responseBody))
+	}
+	if err := json.Unmarshal(responseBody, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// mockEmbeddingDimensions 模拟向量的维度
+const mockEmbeddingDimensions = 256
+
+// MockEmbeddingProvider 确定性的本地向量提供方，不访问网络
+// 使用特征哈希（英文单词、中文单字和双字）生成向量，词语重合越多相似度越高，用于开发和测试
+type MockEmbeddingProvider struct{}
+
+// NewMockEmbeddingProvider 创建模拟向量提供方
+func NewMockEmbeddingProvider() *MockEmbeddingProvider {
+	return &MockEmbeddingProvider{}
+}
+
+// Name 返回提供方名称
+func (p *MockEmbeddingProvider) Name() string { return "mock" }
+
+// Model 返回向量模型
+func (p *MockEmbeddingProvider) Model() string { return "mock-hash-256" }
+
+// EmbedTexts 计算特征哈希向量
+func (p *MockEmbeddingProvider) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, mockEmbeddingDimensions)
+		for _, feature := range textFeatures(text) {
+			h := fnv.New32a()
+			h.Write([]byte(feature))
+			sum := h.Sum32()
+			sign := float32(1)
+			if sum&1 == 1 {
+				sign = -1
+			}
+			vector[(sum>>1)%mockEmbeddingDimensions] += sign
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// textFeatures 将文本拆分为特征：英文和数字按单词，中文按单字和相邻双字
+func textFeatures(text string) []string {
+	features := make([]string, 0)
+	var word []rune
+	var prevHan rune
+	flush := func() {
+		if len(word) > 0 {
+			features = append(features, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush()
+			features = append(features, string(r))
+			if prevHan != 0 {
+				features = append(features, string([]rune{prevHan, r}))
+			}
+			prevHan = r
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+		prevHan = 0
+	}
+	flush()
+	return features
+}
+
+// NormalizeVector 将向量归一化为单位长度（零向量保持不变），归一化后余弦相似度等于点积
+func NormalizeVector(vector []float32) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	normalized := make([]float32, len(vector))
+	for i, v := range vector {
+		normalized[i] = float32(float64(v) / norm)
+	}
+	return normalized
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModerationCategories 内容审核的安全类别
+var ModerationCategories = []string{"adult", "suggestive", "violence", "gore", "weapons", "drugs", "hate", "self_harm"}
+
+// 审核结果的处理方式（MODERATION_ACTION）
+const (
+	ModerationActionQuarantine = "quarantine" // 命中的图片被隔离，不出现在列表、搜索和公开链接中
+	ModerationActionLabel      = "label"      // 只标记命中的类别，图片仍然可见
+)
+
+// ModerationLabel 一个安全类别及其得分
+type ModerationLabel struct {
+	Category string  `json:"category"`
+	Score    float64 `json:"score"` // 0-1
+}
+
+// Moderator 图片内容审核引擎
+type Moderator interface {
+	Name() string
+	// Moderate 返回每个安全类别的得分
+	Moderate(ctx context.Context, image []byte, mimeType string) ([]ModerationLabel, error)
+}
+
+// ModerationConfig 审核结果的判定配置
+type ModerationConfig struct {
+	Threshold  float64 // 得分达到该值的类别视为命中（MODERATION_THRESHOLD）
+	Quarantine bool    // 命中时是否隔离图片（MODERATION_ACTION）
+}
+
+// LoadModerationConfig 从环境变量读取审核判定配置
+func LoadModerationConfig() ModerationConfig {
+	cfg := ModerationConfig{Threshold: 0.7, Quarantine: true}
+	if value := os.Getenv("MODERATION_THRESHOLD"); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			log.Printf("Invalid MODERATION_THRESHOLD value %q, using 0.7", value)
+		} else {
+			cfg.Threshold = threshold
+		}
+	}
+	switch action := strings.ToLower(envOr("MODERATION_ACTION", ModerationActionQuarantine)); action {
+	case ModerationActionQuarantine:
+	case ModerationActionLabel:
+		cfg.Quarantine = false
+	default:
+		log.Printf("Unknown MODERATION_ACTION %q, using %s", action, ModerationActionQuarantine)
+	}
+	return cfg
+}
+
+// ModerationResult 一张图片的审核结果
+type ModerationResult struct {
+	Engine     string
+	Labels     []ModerationLabel // 所有类别的得分，按得分从高到低排列
+	Flagged    []string          // 得分达到阈值的类别
+	Quarantine bool              // 是否应当隔离图片
+}
+
+// NewModeratorFromEnv 根据 MODERATION_ENGINE 创建审核引擎
+//   - none（默认）：不进行内容审核，返回 nil
+//   - vision：使用当前的视觉模型提供方给各个类别打分
+//   - http：将图片发送给外部分类服务（MODERATION_URL、MODERATION_API_KEY）
+func NewModeratorFromEnv(vision VisionProvider) (Moderator, error) {
+	engine := strings.ToLower(strings.TrimSpace(os.Getenv("MODERATION_ENGINE")))
+	switch engine {
+	case "", "none", "off", "false":
+		return nil, nil
+	case "vision":
+		return NewVisionModerator(vision), nil
+	case "http":
+		endpoint := strings.TrimSpace(os.Getenv("MODERATION_URL"))
+		if endpoint == "" {
+			return nil, fmt.Errorf("MODERATION_URL is required when MODERATION_ENGINE=http")
+		}
+		return NewHTTPModerator(endpoint, os.Getenv("MODERATION_API_KEY"), envDuration("MODERATION_TIMEOUT", 30*time.Second)), nil
+	default:
+		return nil, fmt.Errorf("unknown MODERATION_ENGINE %q (supported: none, vision, http)", engine)
+	}
+}
+
+// moderationSchema 视觉模型审核输出的 JSON Schema：每个类别一个 0-1 的得分
+func moderationSchema() *ResponseSchema {
+	properties := make(map[string]interface{}, len(ModerationCategories))
+	for _, category := range ModerationCategories {
+		properties[category] = map[string]interface{}{"type": "number", "minimum": 0, "maximum": 1}
+	}
+	return &ResponseSchema{
+		Name: "image_moderation",
+		Schema: map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             ModerationCategories,
+			"additionalProperties": false,
+		},
+	}
+}
+
+// VisionModerator 使用视觉大模型给图片的安全类别打分
+type VisionModerator struct {
+	vision VisionProvider
+}
+
+// NewVisionModerator 创建基于视觉模型的审核引擎
+func NewVisionModerator(vision VisionProvider) *VisionModerator {
+	return &VisionModerator{vision: vision}
+}
+
+// Name 返回引擎名称
+func (m *VisionModerator) Name() string {
+	return "vision:" + m.vision.Name()
+}
+
+// Moderate 要求模型按 schema 输出每个类别的得分
+func (m *VisionModerator) Moderate(ctx context.Context, image []byte, mimeType string) ([]ModerationLabel, error) {
+	content, err := m.vision.Vision(ctx, m.request(image, mimeType))
+	if err != nil {
+		return nil, err
+	}
+	return decodeModeration(content)
+}
+
+// request 返回审核图片的视觉模型请求
+func (m *VisionModerator) request(image []byte, mimeType string) VisionRequest {
+	schema := moderationSchema()
+	return VisionRequest{
+		System:   "You are a content safety classifier. You rate images objectively and never refuse.",
+		Prompt:   moderationPrompt(schema),
+		Image:    image,
+		MIMEType: mimeType,
+		Schema:   schema,
+	}
+}
+
+// moderationPrompt 视觉模型审核图片的提示词
+func moderationPrompt(schema *ResponseSchema) string {
+	return `Rate how strongly this image contains each of the following categories, from 0 (not at all) to 1 (clearly and explicitly):
+adult (sexual content or nudity), suggestive (revealing or sexualized but not explicit), violence, gore (blood or injuries),
+weapons, drugs, hate (hateful symbols or gestures), self_harm.
+Reply only with a JSON object in this format:
+` + schema.String()
+}
+
+// decodeModeration 解析视觉模型输出的各类别得分
+func decodeModeration(content string) ([]ModerationLabel, error) {
+	var scores map[string]float64
+	if err := decodeJSONObject(content, &scores); err != nil {
+		return nil, err
+	}
+	return moderationLabels(scores), nil
+}
+
+// HTTPModerator 将图片发送给外部的图片分类服务
+// 请求体为图片的原始字节，响应格式为 {"categories": {"adult": 0.01, ...}}
+type HTTPModerator struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+// NewHTTPModerator 创建外部分类服务审核引擎，apiKey 不为空时以 Bearer 令牌发送
+func NewHTTPModerator(endpoint, apiKey string, timeout time.Duration) *HTTPModerator {
+	return &HTTPModerator{endpoint: endpoint, apiKey: apiKey, client: newHTTPClient(timeout)}
+}
+
+// Name 返回引擎名称
+func (m *HTTPModerator) Name() string {
+	return "http"
+}
+
+// Moderate 调用外部分类服务
+func (m *HTTPModerator) Moderate(ctx context.Context, image []byte, mimeType string) ([]ModerationLabel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.endpoint, bytes.NewReader(image))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create moderation request: %w", err)
+	}
+	req.Header.Set("Content-Type", mimeType)
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("moderation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read moderation response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation service returned status %d: %s", resp.StatusCode, truncateRunes(string(body), 200))
+	}
+
+	var result struct {
+		Categories map[string]float64 `json:"categories"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse moderation response: %w", err)
+	}
+	return moderationLabels(result.Categories), nil
+}
+
+// moderationLabels 将得分整理为已知类别的标签（缺少的类别得分为 0），按得分从高到低排列
+func moderationLabels(scores map[string]float64) []ModerationLabel {
+	normalized := make(map[string]float64, len(scores))
+	for category, score := range scores {
+		category = strings.ToLower(strings.TrimSpace(category))
+		if score > 1 && score <= 100 {
+			score /= 100 // 按百分比给出
+		}
+		normalized[category] = clamp(score, 0, 1)
+	}
+
+	labels := make([]ModerationLabel, len(ModerationCategories))
+	for i, category := range ModerationCategories {
+		labels[i] = ModerationLabel{Category: category, Score: normalized[category]}
+	}
+	sort.SliceStable(labels, func(i, j int) bool { return labels[i].Score > labels[j].Score })
+	return labels
+}
+
+// SetModerator 设置审核引擎，为 nil 时跳过审核阶段
+func (s *AIService) SetModerator(moderator Moderator) {
+	s.moderator = moderator
+}
+
+// ModerationEnabled 是否启用了内容审核
+func (s *AIService) ModerationEnabled() bool {
+	return s.moderator != nil
+}
+
+// ModerateImage 审核图片内容，未启用审核时返回 nil
+// 使用视觉模型审核时与标签分析一样按 ctx 中的用户（WithUserID）检查预算、记录用量，相同的图片命中缓存
+func (s *AIService) ModerateImage(ctx context.Context, imagePath string) (*ModerationResult, error) {
+	if s.moderator == nil {
+		return nil, nil
+	}
+
+	imageData, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image file: %w", err)
+	}
+
+	// 视觉模型审核时发送预处理后的图片；外部分类服务使用原图，也不计入用量
+	mimeType := detectMIMEType(imageData)
+	var labels []ModerationLabel
+	if visionModerator, ok := s.moderator.(*VisionModerator); ok {
+		var prepared *PreparedImage
+		if prepared, err = s.prepareImage(imageData); err != nil {
+			return nil, err
+		}
+		req := visionModerator.request(prepared.Data, prepared.MIMEType)
+		call := modelCall{
+			Operation: OperationModerate,
+			Provider:  visionModerator.vision.Name(),
+			Model:     visionModerator.vision.VisionModel(),
+			Prompt:    req.Prompt,
+			Validate: func(content string) error {
+				_, err := decodeModeration(content)
+				return err
+			},
+		}
+		call.CacheKey = cacheKey(call.Operation, call.Model, "", []byte(req.Prompt), prepared.Data)
+		var content string
+		content, err = s.callModel(ctx, call, func(ctx context.Context) (string, error) {
+			return visionModerator.vision.Vision(ctx, req)
+		})
+		if err == nil {
+			labels, err = decodeModeration(content)
+		}
+	} else {
+		labels, err = s.moderator.Moderate(ctx, imageData, mimeType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s moderation failed: %w", s.moderator.Name(), err)
+	}
+
+	result := &ModerationResult{Engine: s.moderator.Name(), Labels: labels, Flagged: []string{}}
+	for _, label := range labels {
+		if label.Score >= s.moderation.Threshold {
+			result.Flagged = append(result.Flagged, label.Category)
+		}
+	}
+	result.Quarantine = len(result.Flagged) > 0 && s.moderation.Quarantine
+
+	log.Printf("Moderation completed with %s, flagged categories: %v", result.Engine, result.Flagged)
+	return result, nil
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// OCREngine 图片文字识别引擎
+type OCREngine interface {
+	Name() string
+	// ExtractText 返回图片中的文字，图片中没有文字时返回空字符串
+	ExtractText(ctx context.Context, image []byte, mimeType string) (string, error)
+}
+
+// noTextMarker 视觉模型在图片中没有文字时返回的标记
+const noTextMarker = "NO_TEXT"
+
+// maxOCRTextLength 保存的识别文本最大长度（字符数），避免超长文本占用存储和全文索引
+const maxOCRTextLength = 20000
+
+// NewOCREngineFromEnv 根据 OCR_ENGINE 创建文字识别引擎
+//   - vision（默认）：使用当前的视觉模型提供方识别文字
+//   - tesseract：调用本地的 Tesseract 命令（OCR_COMMAND、OCR_LANGUAGES）
+//   - none：不进行文字识别，返回 nil
+func NewOCREngineFromEnv(vision VisionProvider) (OCREngine, error) {
+	engine := strings.ToLower(strings.TrimSpace(os.Getenv("OCR_ENGINE")))
+	switch engine {
+	case "", "vision":
+		return NewVisionOCR(vision), nil
+	case "tesseract":
+		return NewTesseractOCR(envOr("OCR_COMMAND", "tesseract"), envOr("OCR_LANGUAGES", "chi_sim+eng")), nil
+	case "none", "off", "false":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown OCR_ENGINE %q (supported: vision, tesseract, none)", engine)
+	}
+}
+
+// VisionOCR 使用视觉大模型识别图片中的文字
+type VisionOCR struct {
+	vision VisionProvider
+}
+
+// NewVisionOCR 创建基于视觉模型的文字识别引擎
+func NewVisionOCR(vision VisionProvider) *VisionOCR {
+	return &VisionOCR{vision: vision}
+}
+
+// Name 返回引擎名称
+func (o *VisionOCR) Name() string {
+	return "vision:" + o.vision.Name()
+}
+
+// ocrPrompt 视觉模型识别文字的提示词
+const ocrPrompt = `Transcribe all readable text in this image (signs, documents, screenshots, whiteboards, receipts, captions, etc.).
+Keep the original language and line breaks. Do not translate, summarize or explain.
+If the image contains no readable text, reply with exactly: ` + noTextMarker
+
+// ExtractText 要求模型逐字转写图片中的文字
+func (o *VisionOCR) ExtractText(ctx context.Context, image []byte, mimeType string) (string, error) {
+	content, err := o.vision.Vision(ctx, VisionRequest{
+		System:   "You are an OCR engine. You transcribe text exactly as it appears and never describe the image.",
+		Prompt:   ocrPrompt,
+		Image:    image,
+		MIMEType: mimeType,
+	})
+	if err != nil {
+		return "", err
+	}
+	return cleanOCRText(content), nil
+}
+
+// TesseractOCR 调用本地 Tesseract 命令识别文字，不依赖网络
+type TesseractOCR struct {
+	command   string
+	languages string
+}
+
+// NewTesseractOCR 创建本地 Tesseract 文字识别引擎，languages 例如 "chi_sim+eng"
+func NewTesseractOCR(command, languages string) *TesseractOCR {
+	return &TesseractOCR{command: command, languages: languages}
+}
+
+// Name 返回引擎名称
+func (o *TesseractOCR) Name() string {
+	return "tesseract"
+}
+
+// ExtractText 通过 stdin 传入图片，从 stdout 读取识别结果
+func (o *TesseractOCR) ExtractText(ctx context.Context, image []byte, mimeType string) (string, error) {
+	cmd := exec.CommandContext(ctx, o.command, "stdin", "stdout", "-l", o.languages)
+	cmd.Stdin = bytes.NewReader(image)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tesseract failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return cleanOCRText(stdout.String()), nil
+}
+
+// cleanOCRText 规范化识别结果：去掉代码块标记、空行和"没有文字"标记，并限制长度
+func cleanOCRText(content string) string {
+	content = strings.TrimSpace(content)
+	content = strings.TrimPrefix(content, "```text")
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimSuffix(content, "```")
+	content = strings.TrimSpace(content)
+	if strings.EqualFold(strings.Trim(content, ". "), noTextMarker) {
+		return ""
+	}
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return truncateRunes(strings.Join(lines, "\n"), maxOCRTextLength)
+}
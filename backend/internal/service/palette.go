@@ -0,0 +1,173 @@
+package service
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// 调色板提取参数
+const (
+	PaletteSize          = 5    // 最多提取的主色调数量
+	paletteSampleSize    = 128  // 采样网格的边长，大图只取约 128x128 个像素
+	paletteMergeDistance = 24.0 // RGB 距离小于该值的颜色合并为一种
+	paletteMinPercentage = 1.0  // 占比低于该值（百分比）的颜色不保留
+	// 饱和度（max-min）低于 monochromeChroma 的像素视为灰色，
+	// 灰色像素占比达到 monochromeShare 时图片视为"基本是黑白的"
+	monochromeChroma = 0.12
+	monochromeShare  = 0.9
+)
+
+// PaletteColor 调色板中的一种颜色
+type PaletteColor struct {
+	R, G, B    uint8
+	Percentage float64 // 占图片像素的比例（0-100）
+}
+
+// Hex 返回 #rrggbb 格式的颜色
+func (c PaletteColor) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// Palette 图片的主色调
+type Palette struct {
+	Colors     []PaletteColor // 按占比从高到低排列
+	Monochrome bool           // 基本是黑白（灰度）的图片
+}
+
+// colorBox 中位切分算法中的一个颜色盒子
+type colorBox struct {
+	pixels [][3]uint8
+}
+
+// channelRange 返回盒子中范围最大的通道及其范围
+func (b *colorBox) channelRange() (int, int) {
+	bestChannel, bestRange := 0, -1
+	for channel := 0; channel < 3; channel++ {
+		lo, hi := 255, 0
+		for _, p := range b.pixels {
+			v := int(p[channel])
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+		if hi-lo > bestRange {
+			bestChannel, bestRange = channel, hi-lo
+		}
+	}
+	return bestChannel, bestRange
+}
+
+// average 返回盒子中像素的平均颜色
+func (b *colorBox) average() [3]float64 {
+	var sum [3]float64
+	for _, p := range b.pixels {
+		sum[0] += float64(p[0])
+		sum[1] += float64(p[1])
+		sum[2] += float64(p[2])
+	}
+	n := float64(len(b.pixels))
+	return [3]float64{sum[0] / n, sum[1] / n, sum[2] / n}
+}
+
+// ExtractPalette 用中位切分（median cut）提取图片的主色调，并判断图片是否基本是黑白的
+// 大图按网格采样；透明像素不参与统计
+func ExtractPalette(img image.Image, size int) Palette {
+	bounds := img.Bounds()
+	stepX := bounds.Dx()/paletteSampleSize + 1
+	stepY := bounds.Dy()/paletteSampleSize + 1
+
+	pixels := make([][3]uint8, 0, paletteSampleSize*paletteSampleSize)
+	gray := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			p := [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+			pixels = append(pixels, p)
+			hi := math.Max(float64(p[0]), math.Max(float64(p[1]), float64(p[2])))
+			lo := math.Min(float64(p[0]), math.Min(float64(p[1]), float64(p[2])))
+			if (hi-lo)/255 < monochromeChroma {
+				gray++
+			}
+		}
+	}
+	if len(pixels) == 0 {
+		return Palette{}
+	}
+
+	// 反复切分范围最大的盒子，直到得到 size 个盒子或无法再切分
+	boxes := []*colorBox{{pixels: pixels}}
+	for len(boxes) < size {
+		index, channel, widest := -1, 0, 0
+		for i, box := range boxes {
+			if len(box.pixels) < 2 {
+				continue
+			}
+			if c, r := box.channelRange(); r > widest {
+				index, channel, widest = i, c, r
+			}
+		}
+		if index < 0 {
+			break
+		}
+		box := boxes[index]
+		sort.Slice(box.pixels, func(i, j int) bool { return box.pixels[i][channel] < box.pixels[j][channel] })
+		median := len(box.pixels) / 2
+		boxes[index] = &colorBox{pixels: box.pixels[:median]}
+		boxes = append(boxes, &colorBox{pixels: box.pixels[median:]})
+	}
+
+	// 合并相近的颜色（按像素数加权平均）
+	type weighted struct {
+		color [3]float64
+		count int
+	}
+	merged := make([]weighted, 0, len(boxes))
+	for _, box := range boxes {
+		avg, count := box.average(), len(box.pixels)
+		found := false
+		for i := range merged {
+			if colorDistance(merged[i].color, avg) < paletteMergeDistance {
+				total := float64(merged[i].count + count)
+				for c := 0; c < 3; c++ {
+					merged[i].color[c] = (merged[i].color[c]*float64(merged[i].count) + avg[c]*float64(count)) / total
+				}
+				merged[i].count += count
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, weighted{color: avg, count: count})
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].count > merged[j].count })
+
+	palette := Palette{Monochrome: float64(gray)/float64(len(pixels)) >= monochromeShare}
+	for _, m := range merged {
+		percentage := math.Round(float64(m.count)/float64(len(pixels))*1000) / 10
+		if percentage < paletteMinPercentage {
+			continue
+		}
+		palette.Colors = append(palette.Colors, PaletteColor{
+			R:          uint8(math.Round(m.color[0])),
+			G:          uint8(math.Round(m.color[1])),
+			B:          uint8(math.Round(m.color[2])),
+			Percentage: percentage,
+		})
+	}
+	return palette
+}
+
+// colorDistance RGB 空间中的欧氏距离
+func colorDistance(a, b [3]float64) float64 {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
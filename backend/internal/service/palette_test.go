@@ -0,0 +1,94 @@
+package service
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// fillRect 用纯色填充 img 中的矩形区域
+func fillRect(img *image.NRGBA, r image.Rectangle, c color.NRGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+}
+
+func TestExtractPalette(t *testing.T) {
+	// 左边 3/4 蓝色，右边 1/4 红色
+	img := image.NewNRGBA(image.Rect(0, 0, 400, 100))
+	fillRect(img, image.Rect(0, 0, 300, 100), color.NRGBA{20, 40, 200, 255})
+	fillRect(img, image.Rect(300, 0, 400, 100), color.NRGBA{220, 30, 30, 255})
+
+	palette := ExtractPalette(img, PaletteSize)
+	if palette.Monochrome {
+		t.Fatal("colorful image reported as monochrome")
+	}
+	if len(palette.Colors) != 2 {
+		t.Fatalf("got %d colors %+v, want 2", len(palette.Colors), palette.Colors)
+	}
+	if got := palette.Colors[0]; got.Hex() != "#1428c8" || got.Percentage < 70 || got.Percentage > 80 {
+		t.Fatalf("dominant color = %s %.1f%%, want #1428c8 about 75%%", got.Hex(), got.Percentage)
+	}
+	if got := palette.Colors[1]; got.Hex() != "#dc1e1e" || got.Percentage < 20 || got.Percentage > 30 {
+		t.Fatalf("second color = %s %.1f%%, want #dc1e1e about 25%%", got.Hex(), got.Percentage)
+	}
+}
+
+func TestExtractPaletteMergesSimilarColors(t *testing.T) {
+	// 两种非常接近的绿色合并为一种
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	fillRect(img, image.Rect(0, 0, 50, 100), color.NRGBA{30, 160, 40, 255})
+	fillRect(img, image.Rect(50, 0, 100, 100), color.NRGBA{34, 166, 44, 255})
+
+	palette := ExtractPalette(img, PaletteSize)
+	if len(palette.Colors) != 1 || palette.Colors[0].Percentage != 100 {
+		t.Fatalf("got %+v, want a single merged color", palette.Colors)
+	}
+}
+
+func TestExtractPaletteMonochrome(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	fillRect(img, image.Rect(0, 0, 100, 50), color.NRGBA{20, 20, 20, 255})
+	fillRect(img, image.Rect(0, 50, 100, 95), color.NRGBA{230, 230, 228, 255})
+	fillRect(img, image.Rect(0, 95, 100, 100), color.NRGBA{200, 30, 30, 255}) // 少量彩色不影响判断
+
+	palette := ExtractPalette(img, PaletteSize)
+	if !palette.Monochrome {
+		t.Fatalf("grayscale image not reported as monochrome: %+v", palette)
+	}
+}
+
+func TestExtractPaletteSkipsTransparentPixels(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100)) // 默认全透明
+	if palette := ExtractPalette(img, PaletteSize); len(palette.Colors) != 0 || palette.Monochrome {
+		t.Fatalf("got %+v for a fully transparent image", palette)
+	}
+
+	fillRect(img, image.Rect(0, 0, 10, 10), color.NRGBA{250, 200, 0, 255})
+	palette := ExtractPalette(img, PaletteSize)
+	if len(palette.Colors) != 1 || palette.Colors[0].Hex() != "#fac800" || palette.Colors[0].Percentage != 100 {
+		t.Fatalf("got %+v, want only the opaque color", palette.Colors)
+	}
+}
+
+func TestExtractPaletteDropsRareColors(t *testing.T) {
+	// 少于 1% 的颜色不保留，size 限制颜色数量
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	fillRect(img, image.Rect(0, 0, 100, 100), color.NRGBA{255, 255, 255, 255})
+	img.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 255})
+	for i, c := range []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}} {
+		fillRect(img, image.Rect(0, 10+i*10, 100, 20+i*10), c)
+	}
+
+	palette := ExtractPalette(img, 2)
+	if len(palette.Colors) > 2 {
+		t.Fatalf("got %d colors, want at most 2", len(palette.Colors))
+	}
+	for _, c := range ExtractPalette(img, PaletteSize).Colors {
+		if c.Hex() == "#000000" {
+			t.Fatalf("rare color kept: %+v", c)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+
+	"github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+	"github.com/nfnt/resize"
+)
+
+// 自适应压缩的下限：先逐步降低质量，质量到下限后再缩小尺寸
+const (
+	minPreprocessQuality   = 50
+	minPreprocessDimension = 512
+)
+
+// PreprocessConfig 发送给视觉模型之前的图片预处理配置
+type PreprocessConfig struct {
+	MaxDimension int // 长边的最大像素数，为 0 时不做预处理（AI_IMAGE_MAX_DIMENSION）
+	Quality      int // JPEG 编码质量 1-100（AI_IMAGE_QUALITY）
+	MaxBytes     int // 编码后的最大字节数，超出时降低质量和尺寸（AI_IMAGE_MAX_BYTES）
+}
+
+// LoadPreprocessConfig 从环境变量读取图片预处理配置
+func LoadPreprocessConfig() PreprocessConfig {
+	cfg := PreprocessConfig{
+		MaxDimension: envInt("AI_IMAGE_MAX_DIMENSION", 2048),
+		Quality:      envInt("AI_IMAGE_QUALITY", 85),
+		MaxBytes:     envInt("AI_IMAGE_MAX_BYTES", 4*1024*1024),
+	}
+	if cfg.Quality < 1 || cfg.Quality > 100 {
+		log.Printf("Invalid AI_IMAGE_QUALITY value %d, using 85", cfg.Quality)
+		cfg.Quality = 85
+	}
+	return cfg
+}
+
+// PreparedImage 预处理后发送给模型的图片；原图的尺寸和 EXIF 仍然用于图片元数据
+type PreparedImage struct {
+	Data           []byte
+	MIMEType       string
+	Width          int // 发送给模型的宽度
+	Height         int // 发送给模型的高度
+	OriginalWidth  int
+	OriginalHeight int
+	Processed      bool // 是否重新编码过（否则为原图）
+}
+
+// PrepareImage 按配置缩小图片并按 EXIF 方向摆正后重新编码为 JPEG
+// 图片已经足够小且方向正常时原样返回；无法解码的格式（例如 HEIC、WebP）也原样返回，由调用方检查大小
+func PrepareImage(data []byte, cfg PreprocessConfig) (*PreparedImage, error) {
+	original := &PreparedImage{Data: data, MIMEType: detectMIMEType(data)}
+	if cfg.MaxDimension <= 0 {
+		return original, nil
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Skipping image preprocessing, unsupported format: %v", err)
+		return original, nil
+	}
+	original.Width, original.Height = config.Width, config.Height
+	original.OriginalWidth, original.OriginalHeight = config.Width, config.Height
+
+	orientation := exifOrientation(data)
+	longSide := config.Width
+	if config.Height > longSide {
+		longSide = config.Height
+	}
+	if longSide <= cfg.MaxDimension && orientation <= 1 && (cfg.MaxBytes <= 0 || len(data) <= cfg.MaxBytes) {
+		return original, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	quality, dimension := cfg.Quality, cfg.MaxDimension
+	for {
+		encoded, bounds, err := encodeForModel(img, orientation, dimension, quality)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.MaxBytes <= 0 || len(encoded) <= cfg.MaxBytes || (quality <= minPreprocessQuality && dimension <= minPreprocessDimension) {
+			log.Printf("Preprocessed image for analysis: %dx%d %d bytes -> %dx%d %d bytes (quality %d)",
+				config.Width, config.Height, len(data), bounds.Dx(), bounds.Dy(), len(encoded), quality)
+			return &PreparedImage{
+				Data:           encoded,
+				MIMEType:       "image/jpeg",
+				Width:          bounds.Dx(),
+				Height:         bounds.Dy(),
+				OriginalWidth:  config.Width,
+				OriginalHeight: config.Height,
+				Processed:      true,
+			}, nil
+		}
+		if quality > minPreprocessQuality {
+			quality -= 10
+			if quality < minPreprocessQuality {
+				quality = minPreprocessQuality
+			}
+		} else {
+			dimension = dimension * 3 / 4
+			if dimension < minPreprocessDimension {
+				dimension = minPreprocessDimension
+			}
+		}
+	}
+}
+
+// encodeForModel 缩小到长边不超过 dimension、摆正方向，并在白色背景上编码为 JPEG（去掉透明通道）
+func encodeForModel(img image.Image, orientation, dimension, quality int) ([]byte, image.Rectangle, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() > dimension || bounds.Dy() > dimension {
+		img = resize.Thumbnail(uint(dimension), uint(dimension), img, resize.Lanczos3)
+	}
+	img = applyOrientation(img, orientation)
+
+	bounds = img.Bounds()
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(canvas, canvas.Bounds(), img, bounds.Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, canvas, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, bounds, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), canvas.Bounds(), nil
+}
+
+// exifOrientation 读取 EXIF 中的方向（1-8），没有 EXIF 或读取失败时返回 1
+func exifOrientation(data []byte) int {
+	rawExif, err := exif.SearchAndExtractExif(data)
+	if err != nil {
+		return 1
+	}
+	im, err := exifcommon.NewIfdMappingWithStandard()
+	if err != nil {
+		return 1
+	}
+	_, index, err := exif.Collect(im, exif.NewTagIndex(), rawExif)
+	if err != nil {
+		return 1
+	}
+	results, err := index.RootIfd.FindTagWithName("Orientation")
+	if err != nil || len(results) == 0 {
+		return 1
+	}
+	value, err := results[0].Value()
+	if err != nil {
+		return 1
+	}
+	if values, ok := value.([]uint16); ok && len(values) > 0 && values[0] >= 1 && values[0] <= 8 {
+		return int(values[0])
+	}
+	return 1
+}
+
+// applyOrientation 按 EXIF 方向翻转或旋转图片，使其按正常方向显示
+func applyOrientation(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// 5-8 需要旋转 90 度，宽高互换
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // 水平翻转
+				sx, sy = w-1-x, y
+			case 3: // 旋转 180 度
+				sx, sy = w-1-x, h-1-y
+			case 4: // 垂直翻转
+				sx, sy = x, h-1-y
+			case 5: // 沿左上-右下对角线翻转
+				sx, sy = y, x
+			case 6: // 顺时针旋转 90 度
+				sx, sy = y, h-1-x
+			case 7: // 沿右上-左下对角线翻转
+				sx, sy = w-1-y, h-1-x
+			case 8: // 逆时针旋转 90 度
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// encodeTestJPEG 编码一张纯色 JPEG
+func encodeTestJPEG(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withEXIFOrientation 在 JPEG 的 SOI 之后插入只包含 Orientation 标签的 APP1 段
+func withEXIFOrientation(data []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))      // IFD0 偏移
+	binary.Write(&tiff, binary.BigEndian, uint16(1))      // 条目数
+	binary.Write(&tiff, binary.BigEndian, uint16(0x0112)) // Orientation
+	binary.Write(&tiff, binary.BigEndian, uint16(3))      // SHORT
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, orientation)
+	binary.Write(&tiff, binary.BigEndian, uint16(0))
+	binary.Write(&tiff, binary.BigEndian, uint32(0)) // 没有下一个 IFD
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	result := append([]byte{}, data[:2]...)
+	result = append(result, segment...)
+	return append(result, data[2:]...)
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// 3x2 的图片，四个角各是一种颜色
+	tl, tr, bl, br := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}, color.RGBA{255, 255, 0, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, tl)
+	src.Set(2, 0, tr)
+	src.Set(0, 1, bl)
+	src.Set(2, 1, br)
+
+	tests := []struct {
+		orientation    int
+		w, h           int
+		tl, tr, bl, br color.RGBA
+	}{
+		{1, 3, 2, tl, tr, bl, br},
+		{2, 3, 2, tr, tl, br, bl}, // 水平翻转
+		{3, 3, 2, br, bl, tr, tl}, // 旋转 180 度
+		{4, 3, 2, bl, br, tl, tr}, // 垂直翻转
+		{5, 2, 3, tl, bl, tr, br}, // 转置
+		{6, 2, 3, bl, tl, br, tr}, // 顺时针 90 度
+		{7, 2, 3, br, tr, bl, tl}, // 反转置
+		{8, 2, 3, tr, br, tl, bl}, // 逆时针 90 度
+		{9, 3, 2, tl, tr, bl, br}, // 无效值保持不变
+	}
+	for _, tt := range tests {
+		dst := applyOrientation(src, tt.orientation)
+		b := dst.Bounds()
+		if b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		corners := []color.RGBA{
+			color.RGBAModel.Convert(dst.At(b.Min.X, b.Min.Y)).(color.RGBA),
+			color.RGBAModel.Convert(dst.At(b.Max.X-1, b.Min.Y)).(color.RGBA),
+			color.RGBAModel.Convert(dst.At(b.Min.X, b.Max.Y-1)).(color.RGBA),
+			color.RGBAModel.Convert(dst.At(b.Max.X-1, b.Max.Y-1)).(color.RGBA),
+		}
+		if want := []color.RGBA{tt.tl, tt.tr, tt.bl, tt.br}; corners[0] != want[0] || corners[1] != want[1] || corners[2] != want[2] || corners[3] != want[3] {
+			t.Errorf("orientation %d: corners %v, want %v", tt.orientation, corners, want)
+		}
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	plain := encodeTestJPEG(t, 8, 4, color.White)
+	if got := exifOrientation(plain); got != 1 {
+		t.Fatalf("orientation without EXIF = %d, want 1", got)
+	}
+	if got := exifOrientation(withEXIFOrientation(plain, 6)); got != 6 {
+		t.Fatalf("orientation = %d, want 6", got)
+	}
+	if got := exifOrientation(withEXIFOrientation(plain, 42)); got != 1 {
+		t.Fatalf("out-of-range orientation = %d, want 1", got)
+	}
+}
+
+func TestPrepareImage(t *testing.T) {
+	cfg := PreprocessConfig{MaxDimension: 100, Quality: 85}
+
+	small := encodeTestJPEG(t, 80, 40, color.White)
+	prepared, err := PrepareImage(small, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prepared.Processed || !bytes.Equal(prepared.Data, small) || prepared.Width != 80 || prepared.OriginalHeight != 40 {
+		t.Fatalf("got %+v, want a small upright image sent unchanged", prepared)
+	}
+
+	large := encodeTestJPEG(t, 400, 200, color.White)
+	prepared, err = PrepareImage(large, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prepared.Processed || prepared.MIMEType != "image/jpeg" || prepared.Width != 100 || prepared.Height != 50 || prepared.OriginalWidth != 400 || prepared.OriginalHeight != 200 {
+		t.Fatalf("got %+v, want a 100x50 JPEG", prepared)
+	}
+
+	// 方向不正常的小图也要重新编码以摆正方向
+	prepared, err = PrepareImage(withEXIFOrientation(small, 6), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prepared.Processed || prepared.Width != 40 || prepared.Height != 80 || prepared.OriginalWidth != 80 {
+		t.Fatalf("got %+v, want the image rotated to 40x80", prepared)
+	}
+
+	// MaxDimension 为 0 时不做预处理，无法解码的格式原样返回
+	if prepared, err := PrepareImage(large, PreprocessConfig{}); err != nil || prepared.Processed || !bytes.Equal(prepared.Data, large) {
+		t.Fatalf("got %+v, %v, want preprocessing disabled", prepared, err)
+	}
+	unknown := []byte("RIFF\x00\x00\x00\x00WEBPVP8 not really")
+	if prepared, err := PrepareImage(unknown, cfg); err != nil || prepared.Processed || !bytes.Equal(prepared.Data, unknown) {
+		t.Fatalf("got %+v, %v, want undecodable data returned unchanged", prepared, err)
+	}
+}
+
+func TestPrepareImageMaxBytes(t *testing.T) {
+	// 随机噪声难以压缩，迫使质量和尺寸逐步降低
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 1200, 900))
+	rng.Read(img.Pix)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := PreprocessConfig{MaxDimension: 1024, Quality: 90, MaxBytes: 200 * 1024}
+	prepared, err := PrepareImage(buf.Bytes(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prepared.Processed || prepared.MIMEType != "image/jpeg" {
+		t.Fatalf("got %+v, want a re-encoded JPEG", prepared)
+	}
+	long := prepared.Width
+	if prepared.Height > long {
+		long = prepared.Height
+	}
+	if len(prepared.Data) > cfg.MaxBytes && long > minPreprocessDimension {
+		t.Fatalf("got %d bytes at %dx%d, want at most %d bytes or the minimum dimension", len(prepared.Data), prepared.Width, prepared.Height, cfg.MaxBytes)
+	}
+	if long > cfg.MaxDimension {
+		t.Fatalf("long side %d exceeds MaxDimension %d", long, cfg.MaxDimension)
+	}
+}
+
+func TestPrepareImageFlattensTransparency(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 100)) // 完全透明
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	prepared, err := PrepareImage(buf.Bytes(), PreprocessConfig{MaxDimension: 50, Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(prepared.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := decoded.At(10, 10).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Fatalf("transparent pixel encoded as (%d,%d,%d), want white", r>>8, g>>8, b>>8)
+	}
+}
@@ -0,0 +1,383 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// 提示词模板名称
+const (
+	PromptTag      = "tag"      // 图片标签分析
+	PromptDescribe = "describe" // 同时生成标签、描述和替代文本
+	PromptQuery    = "query"    // 自然语言查询解析
+)
+
+// DefaultPromptLanguage 找不到指定语言的模板时使用的语言
+const DefaultPromptLanguage = "zh"
+
+// PromptTemplate 一个提示词模板，Body 使用 text/template 语法，可用变量见 PromptData
+type PromptTemplate struct {
+	Name     string
+	Language string
+	Version  string // 版本标识，例如 'builtin'、'file-v2'、'db-v3'，记录在 AI 任务中便于追溯
+	Body     string
+}
+
+// ID 返回模板的完整标识，例如 "tag/zh@db-v3"
+func (t *PromptTemplate) ID() string {
+	return fmt.Sprintf("%s/%s@%s", t.Name, t.Language, t.Version)
+}
+
+// PromptData 渲染模板时可用的变量
+type PromptData struct {
+	Language      string   // 模板语言
+	Query         string   // 用户的自然语言查询（query 模板）
+	AvailableTags []string // 用户已有的标签（query 模板）
+	Vocabulary    []string // 受控词表，不为空时标签必须从中选择
+	AvoidTags     []string // 用户拒绝过的标签
+	Schema        string   // 要求的输出格式（JSON Schema 文本）
+	Today         string   // 当天日期 YYYY-MM-DD，用于换算"去年夏天"等相对时间（query 模板）
+}
+
+// promptFuncs 模板中可用的函数
+var promptFuncs = template.FuncMap{
+	"join": func(items []string, sep string) string { return strings.Join(items, sep) },
+}
+
+// ParsePromptTemplate 检查模板语法，返回解析后的模板
+func ParsePromptTemplate(name, body string) (*template.Template, error) {
+	return template.New(name).Funcs(promptFuncs).Option("missingkey=error").Parse(body)
+}
+
+// PromptStore 提示词模板的来源（内置、文件或数据库）
+type PromptStore interface {
+	// Lookup 返回指定名称和语言当前生效的模板
+	Lookup(name, language string) (*PromptTemplate, bool)
+	// Languages 返回该名称有模板的语言
+	Languages(name string) []string
+}
+
+// PromptLibrary 按优先级依次查找多个来源的提示词模板，内置模板总是作为最后的后备
+type PromptLibrary struct {
+	mu     sync.RWMutex
+	stores []PromptStore
+}
+
+// NewPromptLibrary 创建模板库，stores 按优先级从高到低排列
+func NewPromptLibrary(stores ...PromptStore) *PromptLibrary {
+	return &PromptLibrary{stores: append(stores, builtinPrompts)}
+}
+
+// NewPromptLibraryFromEnv 创建模板库；设置了 AI_PROMPT_DIR 时目录中的模板优先于内置模板
+func NewPromptLibraryFromEnv() (*PromptLibrary, error) {
+	dir := strings.TrimSpace(os.Getenv("AI_PROMPT_DIR"))
+	if dir == "" {
+		return NewPromptLibrary(), nil
+	}
+	files, err := LoadPromptDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewPromptLibrary(files), nil
+}
+
+// Prepend 添加一个优先级最高的来源（例如数据库中的模板）
+func (l *PromptLibrary) Prepend(store PromptStore) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stores = append([]PromptStore{store}, l.stores...)
+}
+
+// Lookup 查找模板：先按指定语言在各来源中查找，找不到时使用默认语言
+func (l *PromptLibrary) Lookup(name, language string) (*PromptTemplate, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, lang := range []string{language, DefaultPromptLanguage} {
+		for _, store := range l.stores {
+			if tmpl, ok := store.Lookup(name, lang); ok {
+				return tmpl, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Languages 返回所有来源中该名称有模板的语言（排序后）
+func (l *PromptLibrary) Languages(name string) []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	seen := make(map[string]bool)
+	for _, store := range l.stores {
+		for _, lang := range store.Languages(name) {
+			seen[lang] = true
+		}
+	}
+	languages := make([]string, 0, len(seen))
+	for lang := range seen {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Render 渲染指定名称和语言的模板，返回提示词和使用的模板
+func (l *PromptLibrary) Render(name, language string, data PromptData) (string, *PromptTemplate, error) {
+	if language == "" {
+		language = DefaultPromptLanguage
+	}
+	tmpl, ok := l.Lookup(name, language)
+	if !ok {
+		return "", nil, fmt.Errorf("no prompt template %q for language %q", name, language)
+	}
+
+	parsed, err := ParsePromptTemplate(tmpl.ID(), tmpl.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid prompt template %s: %w", tmpl.ID(), err)
+	}
+	data.Language = tmpl.Language
+	var out strings.Builder
+	if err := parsed.Execute(&out, data); err != nil {
+		return "", nil, fmt.Errorf("failed to render prompt template %s: %w", tmpl.ID(), err)
+	}
+	return out.String(), tmpl, nil
+}
+
+// MapStore 内存中的模板集合，键为 "名称/语言"
+type MapStore map[string]*PromptTemplate
+
+// Lookup 实现 PromptStore
+func (m MapStore) Lookup(name, language string) (*PromptTemplate, bool) {
+	tmpl, ok := m[name+"/"+language]
+	return tmpl, ok
+}
+
+// Languages 实现 PromptStore
+func (m MapStore) Languages(name string) []string {
+	languages := make([]string, 0)
+	for _, tmpl := range m {
+		if tmpl.Name == name {
+			languages = append(languages, tmpl.Language)
+		}
+	}
+	return languages
+}
+
+// promptFileName 模板文件名格式：<名称>.<语言>.tmpl 或 <名称>.<语言>.v<版本>.tmpl
+var promptFileName = regexp.MustCompile(`^([a-z_]+)\.([A-Za-z-]+)(?:\.v(\d+))?\.tmpl$`)
+
+// LoadPromptDir 从目录加载模板文件，同一名称和语言有多个版本时使用版本号最大的文件
+// 语法错误的模板会导致加载失败，避免在分析时才发现问题
+func LoadPromptDir(dir string) (MapStore, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt directory: %w", err)
+	}
+
+	store := make(MapStore)
+	versions := make(map[string]int)
+	for _, entry := range entries {
+		match := promptFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version := 0
+		if match[3] != "" {
+			version, _ = strconv.Atoi(match[3])
+		}
+		key := match[1] + "/" + match[2]
+		if current, ok := versions[key]; ok && current >= version {
+			continue
+		}
+
+		body, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt template %s: %w", entry.Name(), err)
+		}
+		if _, err := ParsePromptTemplate(entry.Name(), string(body)); err != nil {
+			return nil, fmt.Errorf("invalid prompt template %s: %w", entry.Name(), err)
+		}
+		versions[key] = version
+		store[key] = &PromptTemplate{
+			Name:     match[1],
+			Language: match[2],
+			Version:  fmt.Sprintf("file-v%d", version),
+			Body:     string(body),
+		}
+	}
+
+	log.Printf("Loaded %d prompt templates from %s", len(store), dir)
+	return store, nil
+}
+
+// vocabularyHint 返回附加在提示词后的受控词表说明
+func vocabularyHint(language string, vocabulary []string) string {
+	if len(vocabulary) == 0 {
+		return ""
+	}
+	if language == "en" {
+		return "\n\nOnly use tags from this vocabulary (copy them exactly): " + strings.Join(vocabulary, ", ")
+	}
+	return "\n\n只能从以下词表中选择标签（保持完全一致）：" + strings.Join(vocabulary, "、")
+}
+
+// VocabularyTerm 受控词表中的一个词条，AI 给出的别名会被映射到词条名称
+type VocabularyTerm struct {
+	Name    string
+	Aliases []string
+}
+
+// MapToVocabulary 将标签映射到受控词表（按名称或别名，不区分大小写），无法映射的标签被丢弃
+// 词表为空时原样返回
+func MapToVocabulary(tags []string, vocabulary []VocabularyTerm) []string {
+	if len(vocabulary) == 0 {
+		return tags
+	}
+	lookup := make(map[string]string)
+	for _, term := range vocabulary {
+		for _, alias := range term.Aliases {
+			lookup[strings.ToLower(strings.TrimSpace(alias))] = term.Name
+		}
+	}
+	for _, term := range vocabulary {
+		lookup[strings.ToLower(term.Name)] = term.Name // 名称优先于其他词条的别名
+	}
+
+	mapped := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		name, ok := lookup[strings.ToLower(strings.TrimSpace(tag))]
+		if !ok {
+			log.Printf("Dropping tag %q outside the vocabulary", tag)
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			mapped = append(mapped, name)
+		}
+	}
+	return mapped
+}
+
+// vocabularyNames 返回词表中的词条名称
+func vocabularyNames(vocabulary []VocabularyTerm) []string {
+	names := make([]string, len(vocabulary))
+	for i, term := range vocabulary {
+		names[i] = term.Name
+	}
+	return names
+}
+
+// builtinPrompts 内置的提示词模板
+var builtinPrompts = MapStore{
+	"tag/en": {Name: PromptTag, Language: "en", Version: "builtin", Body: `Please analyze this image and return 5-10 English tags.
+For each tag give:
+- name: a short lowercase word or phrase, no numbering or explanation
+- category: one of scene (landscape, city, indoor, beach...), object (building, food, vehicle...), person, animal, activity, style (modern, abstract, photography...), mood (cozy, peaceful, romantic...), color, other (season, weather...)
+- confidence: how sure you are that the tag applies, a number between 0 and 1
+
+Return only a JSON object matching this JSON Schema, no other text, no markdown code blocks:
+{{.Schema}}
+
+Example: {"tags":[{"name":"landscape","category":"scene","confidence":0.95},{"name":"blue sky","category":"color","confidence":0.8}]}`},
+
+	"tag/zh": {Name: PromptTag, Language: "zh", Version: "builtin", Body: `请分析这张图片，返回5-10个中文标签。
+每个标签包含：
+- name：标签名称，简短的词语，不要编号，不要说明
+- category：标签类别，取以下值之一：scene（场景，如风景、城市、室内、海滩）、object（物品，如建筑、食物、车辆）、person（人物）、animal（动物）、activity（活动）、style（风格，如现代、抽象、摄影）、mood（情感氛围，如温馨、宁静、浪漫）、color（颜色）、other（其他，如季节、天气）
+- confidence：你对该标签的把握，0 到 1 之间的小数
+
+只返回符合以下 JSON Schema 的 JSON 对象，不要其他文字，不要使用markdown代码块：
+{{.Schema}}
+
+例如：{"tags":[{"name":"风景","category":"scene","confidence":0.95},{"name":"蓝天","category":"color","confidence":0.8}]}`},
+
+	"describe/en": {Name: PromptDescribe, Language: "en", Version: "builtin", Body: `Please analyze this image and answer in English with:
+- tags: 5-10 tags, each with a short lowercase name, a category (scene, object, person, animal, activity, style, mood, color, other) and a confidence between 0 and 1
+- description: 2-4 natural sentences describing the subject, setting, action and atmosphere of the image
+- altText: one concise sentence of at most 125 characters describing the image for screen reader users
+
+Return only a JSON object matching this JSON Schema, no other text, no markdown code blocks:
+{{.Schema}}
+
+Example:
+{"tags":[{"name":"mountain","category":"scene","confidence":0.95},{"name":"lake","category":"scene","confidence":0.9}],"description":"A calm alpine lake reflects snow-capped mountains under a clear blue sky. Pine trees line the shore in the foreground.","altText":"Snow-capped mountains reflected in a calm alpine lake"}`},
+
+	"describe/zh": {Name: PromptDescribe, Language: "zh", Version: "builtin", Body: `请分析这张图片，用中文给出：
+- tags：5-10个标签，每个标签包含简短的名称（name）、类别（category：scene、object、person、animal、activity、style、mood、color、other 之一）和 0 到 1 之间的置信度（confidence）
+- description：用2-4句话自然地描述图片的主体、场景、动作和氛围
+- altText：一句不超过60个字的简洁描述，供屏幕阅读器使用
+
+只返回符合以下 JSON Schema 的 JSON 对象，不要其他文字，不要使用markdown代码块：
+{{.Schema}}
+
+例如：
+{"tags":[{"name":"山脉","category":"scene","confidence":0.95},{"name":"湖泊","category":"scene","confidence":0.9}],"description":"晴朗的蓝天下，平静的高山湖泊倒映着雪山。前景的湖岸边长着一排松树。","altText":"雪山倒映在平静的高山湖泊中"}`},
+
+	"query/zh": {Name: PromptQuery, Language: "zh", Version: "builtin", Body: `你是一个图片检索助手。用户会用自然语言描述他们想要查找的图片，你需要将用户的查询转换为结构化的查询条件。
+
+用户查询：{{.Query}}
+{{if .Today}}
+今天是 {{.Today}}，"上个月"、"去年夏天"等相对时间按今天换算。
+{{end}}
+{{if .AvailableTags}}可用的标签列表：{{join .AvailableTags "、"}}{{else}}当前没有可用的标签。{{end}}
+
+重要规则：
+- 标签（tags、excludeTags）字段：必须严格从上面的"可用标签列表"中选择，完全匹配标签名称。如果用户查询的内容在可用标签列表中没有完全匹配的标签，则返回最符合的一个标签。绝对不要创建新的标签名称，不要使用相似但不完全相同的标签。
+- 如果可用标签列表为空，tags 和 excludeTags 字段必须返回空数组 []。
+
+请根据用户的查询，提取以下信息：
+1. 标签（tags）：从"可用标签列表"中选择完全匹配的标签名称，图片必须带有全部这些标签；如果没有匹配的标签则返回空数组 []
+2. "或"条件组（anyOf）：用户说"A 或 B"（如"猫或狗的照片"）时，把 A、B 放在同一组的 tags（或 keywords）中，图片匹配组内任意一个即可；多个组之间为"且"；没有时返回 []
+3. 排除的标签（excludeTags）：用户明确不想要的内容（如"没有人的"、"不要室内的"），同样从可用标签列表中选择
+4. 拍摄时间：提到某个月份（如"上个月"、"2025年1月"）时 month 为 "YYYY-MM"；只提到年份（如"2023年的"）时 year 为 "YYYY"；提到日期范围（如"去年夏天"、"3月1日到3月15日"）时用 dateFrom 和 dateTo 表示，格式 "YYYY-MM-DD"（都包含在内），"2024年以后"这样的开放范围只填写一端；没有提到的返回空字符串 ""
+5. 上传时间：用户说的是上传或导入的时间（如"上周上传的"）时用 uploadedFrom 和 uploadedTo，格式同上
+6. 相机制造商（camera）：如果用户提到了相机品牌（如"Canon"、"Nikon"、"iPhone"等），提取品牌名称，否则返回空字符串 ""
+7. 地点（location）：如果用户提到了拍摄地点，name 为地点名称；城市或景点等较小的地点知道大致坐标时填写 latitude、longitude 和合适的 radiusKm（城市约 25，景点约 2），省份、国家等较大的区域填写 bounds 范围框（south、west、north、east），都不知道时坐标为 null；没有提到地点时 location 为 null
+8. 画面方向（orientation）：横图为 "landscape"，竖图为 "portrait"，方图为 "square"，没有提到时为 ""
+9. 尺寸：用户提到分辨率（如"4K 以上"、"高清大图"、"小图"）时填写 minWidth、minHeight、maxWidth、maxHeight（像素，0 表示不限制）
+10. 关键词（keywords）：提取查询中的其他关键词（如"风景"、"人物"、"夜景"等），图片匹配任意一个即可；用户明确不想要的关键词放在 excludeKeywords
+11. 推理过程（reasoning）：简要说明你是如何理解用户查询的，以及为什么选择了这些标签
+
+只返回符合以下 JSON Schema 的 JSON 对象，不要其他文字，不要使用markdown代码块：
+{{.Schema}}
+
+例如：{"tags":["风景"],"anyOf":[],"excludeTags":["人物"],"month":"","year":"","dateFrom":"2024-06-01","dateTo":"2024-08-31","uploadedFrom":"","uploadedTo":"","camera":"","location":{"name":"杭州","latitude":30.27,"longitude":120.15,"radiusKm":25},"orientation":"landscape","minWidth":0,"minHeight":0,"maxWidth":0,"maxHeight":0,"keywords":[],"excludeKeywords":[],"reasoning":"你的推理过程"}`},
+
+	"query/en": {Name: PromptQuery, Language: "en", Version: "builtin", Body: `You are an image search assistant. The user describes the images they are looking for in natural language, and you convert the query into structured search conditions.
+
+User query: {{.Query}}
+{{if .Today}}
+Today is {{.Today}}; resolve relative times such as "last month" or "last summer" against it.
+{{end}}
+{{if .AvailableTags}}Available tags: {{join .AvailableTags ", "}}{{else}}There are no available tags.{{end}}
+
+Important rules:
+- tags and excludeTags: choose strictly from the available tags above, matching the names exactly. If nothing matches exactly, return the single closest tag. Never invent new tag names.
+- If there are no available tags, tags and excludeTags must be empty arrays [].
+
+Extract the following from the query:
+1. tags: exact tag names from the available tags that an image must all have, or [] if none match
+2. anyOf: when the user says "A or B" ("cats or dogs"), put A and B in the tags (or keywords) of one group; an image matches a group when it has any of them, and all groups must match; [] if not needed
+3. excludeTags: content the user explicitly does not want ("without people", "no indoor shots"), also from the available tags
+4. time taken: a single month ("last month", "January 2025") goes in month as "YYYY-MM"; only a year ("from 2023") goes in year as "YYYY"; a date range ("last summer", "March 1 to March 15") goes in dateFrom and dateTo as "YYYY-MM-DD" (both inclusive), and an open range ("after 2024") fills only one end; use "" for anything not mentioned
+5. upload time: when the user means when photos were uploaded or imported ("uploaded last week"), use uploadedFrom and uploadedTo in the same format
+6. camera: the camera brand if mentioned ("Canon", "Nikon", "iPhone"), otherwise ""
+7. location: if a place is mentioned, name is the place name; for a city or landmark whose approximate coordinates you know, fill latitude, longitude and a suitable radiusKm (about 25 for a city, 2 for a landmark); for a larger region such as a province or country fill bounds (south, west, north, east); otherwise null coordinates; location is null when no place is mentioned
+8. orientation: "landscape", "portrait" or "square" when mentioned, otherwise ""
+9. size: when the user mentions resolution ("4K or larger", "high resolution", "small images"), fill minWidth, minHeight, maxWidth and maxHeight in pixels (0 for no limit)
+10. keywords: other keywords in the query ("landscape", "people", "night"), an image matches any of them; keywords the user explicitly does not want go in excludeKeywords
+11. reasoning: briefly explain how you understood the query and why you chose these tags
+
+Return only a JSON object matching this JSON Schema, no other text, no markdown code blocks:
+{{.Schema}}
+
+Example: {"tags":["beach"],"anyOf":[],"excludeTags":["people"],"month":"","year":"","dateFrom":"2024-06-01","dateTo":"2024-08-31","uploadedFrom":"","uploadedTo":"","camera":"","location":{"name":"Barcelona","latitude":41.39,"longitude":2.17,"radiusKm":25},"orientation":"landscape","minWidth":0,"minHeight":0,"maxWidth":0,"maxHeight":0,"keywords":[],"excludeKeywords":[],"reasoning":"your reasoning"}`},
+}
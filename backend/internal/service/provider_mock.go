@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// mockTagVocabulary 模拟视觉模型使用的固定标签词表（中文、英文一一对应，以及标签类别）
+var mockTagVocabulary = [][3]string{
+	{"风景", "landscape", "scene"}, {"城市", "city", "scene"}, {"室内", "indoor", "scene"}, {"户外", "outdoor", "scene"},
+	{"人物", "people", "person"}, {"动物", "animal", "animal"}, {"建筑", "building", "object"}, {"食物", "food", "object"},
+	{"植物", "plant", "object"}, {"天空", "sky", "scene"}, {"海滩", "beach", "scene"}, {"山脉", "mountain", "scene"},
+	{"夜景", "night", "scene"}, {"温馨", "cozy", "mood"}, {"宁静", "peaceful", "mood"}, {"蓝色", "blue", "color"},
+}
+
+// MockProvider 确定性的进程内提供方，不访问网络
+// 用于离线开发和测试：相同的图片总是得到相同的标签
+// 可以通过 VisionFunc / ChatFunc 注入自定义响应
+type MockProvider struct {
+	VisionFunc func(req VisionRequest) (string, error)
+	ChatFunc   func(req ChatRequest) (string, error)
+
+	mu          sync.Mutex
+	visionCalls int
+	chatCalls   int
+}
+
+// NewMockProvider 创建模拟提供方
+func NewMockProvider() *MockProvider {
+	return &MockProvider{}
+}
+
+// Name 返回提供方名称
+func (p *MockProvider) Name() string { return "mock" }
+
+// VisionModel 返回默认视觉模型
+func (p *MockProvider) VisionModel() string { return "mock-vision" }
+
+// ChatModel 返回默认文本模型
+func (p *MockProvider) ChatModel() string { return "mock-chat" }
+
+// Calls 返回视觉和文本请求的调用次数
+func (p *MockProvider) Calls() (vision int, chat int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.visionCalls, p.chatCalls
+}
+
+// Vision 根据图片内容的哈希从词表中选出 5 个标签
+func (p *MockProvider) Vision(ctx context.Context, req VisionRequest) (string, error) {
+	p.mu.Lock()
+	p.visionCalls++
+	p.mu.Unlock()
+
+	if p.VisionFunc != nil {
+		return p.VisionFunc(req)
+	}
+
+	if strings.Contains(req.Prompt, noTextMarker) {
+		// 文字识别请求：模拟图片中没有文字
+		return noTextMarker, nil
+	}
+
+	if req.Schema != nil && req.Schema.Name == "image_moderation" {
+		// 内容审核请求：模拟所有类别都不命中
+		scores := make(map[string]float64, len(ModerationCategories))
+		for _, category := range ModerationCategories {
+			scores[category] = 0.01
+		}
+		content, err := json.Marshal(scores)
+		return string(content), err
+	}
+
+	langIndex := 0
+	if strings.Contains(req.Prompt, "English") {
+		langIndex = 1
+	}
+
+	sum := sha256.Sum256(req.Image)
+	seen := make(map[int]bool)
+	output := tagOutput{Tags: make([]AITag, 0, 5)}
+	names := make([]string, 0, 5)
+	for i, b := range sum {
+		idx := int(b) % len(mockTagVocabulary)
+		if seen[idx] {
+			continue
+		}
+		seen[idx] = true
+		names = append(names, mockTagVocabulary[idx][langIndex])
+		output.Tags = append(output.Tags, AITag{
+			Name:       mockTagVocabulary[idx][langIndex],
+			Category:   mockTagVocabulary[idx][2],
+			Confidence: float64(95-len(output.Tags)*10-i%3) / 100,
+		})
+		if len(output.Tags) == 5 {
+			break
+		}
+	}
+	if req.Schema != nil && req.Schema.Name == "image_analysis" {
+		// 描述模式：同时返回描述和替代文本
+		if langIndex == 1 {
+			output.Description = "A mock description of a photo showing " + strings.Join(names, ", ") + "."
+			output.AltText = "Photo of " + names[0]
+		} else {
+			output.Description = "这是一张包含" + strings.Join(names, "、") + "的模拟描述。"
+			output.AltText = names[0] + "照片"
+		}
+	}
+	content, err := json.Marshal(output)
+	return string(content), err
+}
+
+// Chat 默认返回一个空的查询条件 JSON；对话助手的回复请求返回提示词中的执行结果
+func (p *MockProvider) Chat(ctx context.Context, req ChatRequest) (string, error) {
+	p.mu.Lock()
+	p.chatCalls++
+	p.mu.Unlock()
+
+	if p.ChatFunc != nil {
+		return p.ChatFunc(req)
+	}
+	if req.System == assistantReplySystem {
+		lines := strings.Split(strings.TrimSpace(req.Prompt), "\n")
+		return strings.TrimPrefix(lines[len(lines)-1], "执行结果："), nil
+	}
+	return `{"tags": [], "month": "", "camera": "", "keywords": [], "reasoning": "mock provider"}`, nil
+}
+
+// ChatStream 将 Chat 的结果按单词（中文按单字）拆分后逐段回调，模拟流式输出
+func (p *MockProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(delta string) error) (string, error) {
+	content, err := p.Chat(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	var chunk strings.Builder
+	for _, r := range content {
+		chunk.WriteRune(r)
+		if r == ' ' || r > unicode.MaxASCII {
+			if err := onDelta(chunk.String()); err != nil {
+				return content, err
+			}
+			chunk.Reset()
+		}
+	}
+	if chunk.Len() > 0 {
+		if err := onDelta(chunk.String()); err != nil {
+			return content, err
+		}
+	}
+	return content, nil
+}
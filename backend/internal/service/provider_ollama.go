@@ -0,0 +1,265 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OllamaProvider 本地 Ollama 服务（/api/chat），可离线运行视觉和文本模型
+type OllamaProvider struct {
+	baseURL     string
+	visionModel string
+	chatModel   string
+	format      string // 结构化输出的约束方式
+	timeout     time.Duration
+	client      *http.Client
+}
+
+// ollamaMessage Ollama 消息结构，图片以 base64 字符串数组传递
+type ollamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+// ollamaChatRequest /api/chat 请求结构
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   interface{}     `json:"format,omitempty"` // "json" 或 JSON Schema 对象
+}
+
+// ollamaChatResponse /api/chat 非流式响应结构
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Error           string        `json:"error"`
+	PromptEvalCount int           `json:"prompt_eval_count"` // 输入 token 数
+	EvalCount       int           `json:"eval_count"`        // 输出 token 数
+}
+
+// NewOllamaProvider 创建 Ollama 提供方
+func NewOllamaProvider(cfg ProviderConfig) *OllamaProvider {
+	p := &OllamaProvider{
+		baseURL:     strings.TrimSuffix(cfg.BaseURL, "/"),
+		visionModel: cfg.VisionModel,
+		chatModel:   cfg.ChatModel,
+		format:      cfg.ResponseFormat,
+		timeout:     cfg.Timeout,
+		// 本地服务不走代理
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+	log.Printf("Initialized ollama AI provider at %s (vision model: %s, chat model: %s)", p.baseURL, p.visionModel, p.chatModel)
+	return p
+}
+
+// Name 返回提供方名称
+func (p *OllamaProvider) Name() string { return "ollama" }
+
+// VisionModel 返回默认视觉模型
+func (p *OllamaProvider) VisionModel() string { return p.visionModel }
+
+// ChatModel 返回默认文本模型
+func (p *OllamaProvider) ChatModel() string { return p.chatModel }
+
+// Vision 发送图片和提示词
+func (p *OllamaProvider) Vision(ctx context.Context, req VisionRequest) (string, error) {
+	model := req.Model
+	if model == "" {
+		model = p.visionModel
+	}
+
+	messages := make([]ollamaMessage, 0, 2)
+	if req.System != "" {
+		messages = append(messages, ollamaMessage{Role: "system", Content: req.System})
+	}
+	messages = append(messages, ollamaMessage{
+		Role:    "user",
+		Content: req.Prompt,
+		Images:  []string{base64.StdEncoding.EncodeToString(req.Image)},
+	})
+
+	return p.chat(ctx, model, messages, req.Schema)
+}
+
+// Chat 发送文本提示词
+func (p *OllamaProvider) Chat(ctx context.Context, req ChatRequest) (string, error) {
+	model := req.Model
+	if model == "" {
+		model = p.chatModel
+	}
+
+	return p.chat(ctx, model, ollamaChatMessages(req), req.Schema)
+}
+
+// responseFormat 按配置返回请求的 format，没有 schema 或配置为 none 时为 nil
+func (p *OllamaProvider) responseFormat(schema *ResponseSchema) interface{} {
+	if schema == nil {
+		return nil
+	}
+	switch p.format {
+	case ResponseFormatSchema:
+		return schema.Schema
+	case ResponseFormatJSON:
+		return "json"
+	default:
+		return nil
+	}
+}
+
+// ollamaChatMessages 将文本请求转换为消息列表：系统提示词、历史对话、本次提示词
+func ollamaChatMessages(req ChatRequest) []ollamaMessage {
+	messages := make([]ollamaMessage, 0, len(req.History)+2)
+	if req.System != "" {
+		messages = append(messages, ollamaMessage{Role: "system", Content: req.System})
+	}
+	for _, message := range req.History {
+		messages = append(messages, ollamaMessage{Role: message.Role, Content: message.Content})
+	}
+	return append(messages, ollamaMessage{Role: "user", Content: req.Prompt})
+}
+
+// ChatStream 以流式方式调用 /api/chat（每行一个 JSON 对象），逐段回调增量文本
+func (p *OllamaProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(delta string) error) (string, error) {
+	model := req.Model
+	if model == "" {
+		model = p.chatModel
+	}
+
+	requestBody, err := json.Marshal(ollamaChatRequest{
+		Model:    model,
+		Messages: ollamaChatMessages(req),
+		Stream:   true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/api/chat", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", timeoutError("ollama", model, fmt.Errorf("request timeout after %v. Local models may need a longer AI_TIMEOUT", p.timeout))
+		}
+		return "", fmt.Errorf("failed to call ollama at %s (is `ollama serve` running?): %w", p.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result ollamaChatResponse
+		responseBody, _ := io.ReadAll(resp.Body)
+		json.Unmarshal(responseBody, &result)
+		return "", ollamaStatusError(model, resp.StatusCode, result.Error)
+	}
+
+	var content strings.Builder
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk struct {
+			ollamaChatResponse
+			Done bool `json:"done"`
+		}
+		if err := decoder.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return content.String(), fmt.Errorf("failed to read ollama stream: %w", err)
+		}
+		if chunk.Error != "" {
+			return content.String(), fmt.Errorf("ollama stream error: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			if err := onDelta(chunk.Message.Content); err != nil {
+				return content.String(), err
+			}
+		}
+		if chunk.Done {
+			break
+		}
+	}
+	if strings.TrimSpace(content.String()) == "" {
+		return "", fmt.Errorf("empty text content from ollama")
+	}
+	return content.String(), nil
+}
+
+// chat 调用 /api/chat 并返回文本内容，schema 不为空时要求结构化输出
+func (p *OllamaProvider) chat(ctx context.Context, model string, messages []ollamaMessage, schema *ResponseSchema) (string, error) {
+	requestBody, err := json.Marshal(ollamaChatRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   false,
+		Format:   p.responseFormat(schema),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/api/chat", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	log.Printf("Calling ollama with model: %s (timeout: %v)", model, p.timeout)
+	startTime := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", timeoutError("ollama", model, fmt.Errorf("request timeout after %v. Local models may need a longer AI_TIMEOUT", p.timeout))
+		}
+		return "", fmt.Errorf("failed to call ollama at %s (is `ollama serve` running?): %w", p.baseURL, err)
+	}
+	defer resp.Body.Close()
+	log.Printf("ollama responded in %v", time.Since(startTime))
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var result ollamaChatResponse
+	if err := json.Unmarshal(responseBody, &result); err != nil {
+		return "", fmt.Errorf("failed to parse ollama response (status %d): %s", resp.StatusCode, string(responseBody))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", ollamaStatusError(model, resp.StatusCode, result.Error)
+	}
+
+	reportTokenUsage(ctx, result.PromptEvalCount, result.EvalCount)
+
+	content := strings.TrimSpace(result.Message.Content)
+	if content == "" {
+		return "", fmt.Errorf("empty text content from ollama")
+	}
+	return content, nil
+}
+
+// ollamaStatusError 返回按状态码分类的 ProviderError
+func ollamaStatusError(model string, statusCode int, message string) error {
+	err := fmt.Errorf("ollama 返回错误 (status %d)：%s", statusCode, message)
+	if statusCode == http.StatusNotFound {
+		err = fmt.Errorf("模型未找到 (404)：请先执行 `ollama pull %s`。%s", model, message)
+	}
+	return &ProviderError{Provider: "ollama", Model: model, Kind: errorKindForStatus(statusCode), StatusCode: statusCode, Err: err}
+}
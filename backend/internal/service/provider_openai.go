@@ -0,0 +1,501 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAICompatibleProvider 任意 OpenAI 兼容的 /chat/completions 接口（ModelScope、OpenAI、vLLM 等）
+type OpenAICompatibleProvider struct {
+	name        string
+	baseURL     string
+	apiKey      string
+	visionModel string
+	chatModel   string
+	format      string // 结构化输出的约束方式
+	timeout     time.Duration
+	client      *http.Client
+}
+
+// ChatCompletionRequest 请求结构（OpenAI 兼容格式）
+type ChatCompletionRequest struct {
+	Model          string                  `json:"model"`
+	Messages       []ChatCompletionMessage `json:"messages"`
+	Stream         bool                    `json:"stream,omitempty"`
+	ResponseFormat *ChatResponseFormat     `json:"response_format,omitempty"`
+}
+
+// ChatResponseFormat 结构化输出：json_object 或 json_schema
+type ChatResponseFormat struct {
+	Type       string              `json:"type"`
+	JSONSchema *ChatResponseSchema `json:"json_schema,omitempty"`
+}
+
+// ChatResponseSchema json_schema 格式的 schema
+type ChatResponseSchema struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+// ChatCompletionMessage 消息结构
+type ChatCompletionMessage struct {
+	Role    string                `json:"role"`
+	Content ChatCompletionContent `json:"content"` // 可以是字符串或数组
+}
+
+// ChatCompletionContent 内容类型，支持字符串或数组格式
+type ChatCompletionContent struct {
+	Items []ChatCompletionContentItem
+	Text  string
+}
+
+// MarshalJSON 自定义 JSON 序列化，始终序列化为数组格式（用于请求）
+func (c ChatCompletionContent) MarshalJSON() ([]byte, error) {
+	// 如果 Items 不为空，使用 Items
+	if len(c.Items) > 0 {
+		return json.Marshal(c.Items)
+	}
+	// 如果只有 Text，转换为 Items 格式
+	if c.Text != "" {
+		return json.Marshal([]ChatCompletionContentItem{
+			{
+				Type: "text",
+				Text: c.Text,
+			},
+		})
+	}
+	// 空内容返回空数组
+	return json.Marshal([]ChatCompletionContentItem{})
+}
+
+// UnmarshalJSON 自定义 JSON 解析，支持字符串和数组两种格式
+func (c *ChatCompletionContent) UnmarshalJSON(data []byte) error {
+	// 先尝试解析为字符串
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		c.Text = str
+		c.Items = []ChatCompletionContentItem{
+			{
+				Type: "text",
+				Text: str,
+			},
+		}
+		return nil
+	}
+
+	// 如果不是字符串，尝试解析为数组
+	var items []ChatCompletionContentItem
+	if err := json.Unmarshal(data, &items); err == nil {
+		c.Items = items
+		// 提取所有文本内容
+		for _, item := range items {
+			if item.Type == "text" {
+				c.Text += item.Text
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("content must be either string or array")
+}
+
+// ChatCompletionContentItem 内容项（文本或图片）
+type ChatCompletionContentItem struct {
+	Type     string                  `json:"type"` // "text" 或 "image_url"
+	Text     string                  `json:"text,omitempty"`
+	ImageURL *ChatCompletionImageURL `json:"image_url,omitempty"`
+}
+
+// ChatCompletionImageURL 图片URL结构
+type ChatCompletionImageURL struct {
+	URL string `json:"url"` // 支持 data URI 格式：data:image/jpeg;base64,{base64}
+}
+
+// ChatCompletionResponse 响应结构（OpenAI 兼容格式）
+type ChatCompletionResponse struct {
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   *ChatCompletionUsage   `json:"usage,omitempty"`
+}
+
+// ChatCompletionUsage 响应中的 token 用量
+type ChatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// ChatCompletionChoice 选择项
+type ChatCompletionChoice struct {
+	Message ChatCompletionMessage  `json:"message"`
+	Delta   *ChatCompletionMessage `json:"delta,omitempty"` // 用于流式响应
+}
+
+// ChatCompletionStreamChunk 流式响应块
+type ChatCompletionStreamChunk struct {
+	Choices []ChatCompletionChoice `json:"choices"`
+}
+
+// NewOpenAICompatibleProvider 创建 OpenAI 兼容提供方
+func NewOpenAICompatibleProvider(cfg ProviderConfig) *OpenAICompatibleProvider {
+	p := &OpenAICompatibleProvider{
+		name:        cfg.Provider,
+		baseURL:     strings.TrimSuffix(cfg.BaseURL, "/"),
+		apiKey:      cfg.APIKey,
+		visionModel: cfg.VisionModel,
+		chatModel:   cfg.ChatModel,
+		format:      cfg.ResponseFormat,
+		timeout:     cfg.Timeout,
+		client:      newHTTPClient(cfg.Timeout),
+	}
+	log.Printf("Initialized %s AI provider (vision model: %s, chat model: %s)", p.name, p.visionModel, p.chatModel)
+	return p
+}
+
+// Name 返回提供方名称
+func (p *OpenAICompatibleProvider) Name() string { return p.name }
+
+// VisionModel 返回默认视觉模型
+func (p *OpenAICompatibleProvider) VisionModel() string { return p.visionModel }
+
+// ChatModel 返回默认文本模型
+func (p *OpenAICompatibleProvider) ChatModel() string { return p.chatModel }
+
+// Vision 发送图片和提示词，返回模型输出的文本
+func (p *OpenAICompatibleProvider) Vision(ctx context.Context, req VisionRequest) (string, error) {
+	model := req.Model
+	if model == "" {
+		model = p.visionModel
+	}
+
+	// 构建 data URI 格式的图片 URL
+	imageDataURI := fmt.Sprintf("data:%s;base64,%s", req.MIMEType, base64.StdEncoding.EncodeToString(req.Image))
+
+	messages := make([]ChatCompletionMessage, 0, 2)
+	if req.System != "" {
+		messages = append(messages, textMessage("system", req.System))
+	}
+	messages = append(messages, ChatCompletionMessage{
+		Role: "user",
+		Content: ChatCompletionContent{
+			Items: []ChatCompletionContentItem{
+				{
+					Type:     "image_url",
+					ImageURL: &ChatCompletionImageURL{URL: imageDataURI},
+				},
+				{
+					Type: "text",
+					Text: req.Prompt,
+				},
+			},
+		},
+	})
+
+	return p.complete(ctx, model, messages, req.Schema)
+}
+
+// Chat 发送文本提示词，返回模型输出的文本
+func (p *OpenAICompatibleProvider) Chat(ctx context.Context, req ChatRequest) (string, error) {
+	model := req.Model
+	if model == "" {
+		model = p.chatModel
+	}
+
+	return p.complete(ctx, model, chatMessages(req), req.Schema)
+}
+
+// responseFormat 按配置返回请求的 response_format，没有 schema 或配置为 none 时为 nil
+func (p *OpenAICompatibleProvider) responseFormat(schema *ResponseSchema) *ChatResponseFormat {
+	if schema == nil {
+		return nil
+	}
+	switch p.format {
+	case ResponseFormatSchema:
+		return &ChatResponseFormat{Type: "json_schema", JSONSchema: &ChatResponseSchema{Name: schema.Name, Schema: schema.Schema}}
+	case ResponseFormatJSON:
+		return &ChatResponseFormat{Type: "json_object"}
+	default:
+		return nil
+	}
+}
+
+// chatMessages 将文本请求转换为消息列表：系统提示词、历史对话、本次提示词
+func chatMessages(req ChatRequest) []ChatCompletionMessage {
+	messages := make([]ChatCompletionMessage, 0, len(req.History)+2)
+	if req.System != "" {
+		messages = append(messages, textMessage("system", req.System))
+	}
+	for _, message := range req.History {
+		messages = append(messages, textMessage(message.Role, message.Content))
+	}
+	return append(messages, textMessage("user", req.Prompt))
+}
+
+// ChatStream 以流式方式调用 /chat/completions（Server-Sent Events），逐段回调增量文本
+func (p *OpenAICompatibleProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(delta string) error) (string, error) {
+	model := req.Model
+	if model == "" {
+		model = p.chatModel
+	}
+
+	requestBody, err := json.Marshal(ChatCompletionRequest{
+		Model:    model,
+		Messages: chatMessages(req),
+		Stream:   true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/chat/completions", p.baseURL), bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.apiKey))
+
+	log.Printf("Streaming from %s API with model: %s (timeout: %v)", p.name, model, p.timeout)
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", timeoutError(p.name, model, fmt.Errorf("request timeout after %v. Try increasing AI_TIMEOUT", p.timeout))
+		}
+		return "", fmt.Errorf("failed to call %s API (network error): %w", p.name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		log.Printf("%s API error - Status: %s, Body: %s", p.name, resp.Status, string(responseBody))
+		return "", handleProviderError(p.name, resp.StatusCode, responseBody, model)
+	}
+
+	// 每个事件形如 "data: {...}"，以 "data: [DONE]" 结束
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk ChatCompletionStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			log.Printf("Skipping malformed %s stream chunk: %s", p.name, data)
+			continue
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta == nil || choice.Delta.Content.Text == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content.Text)
+			if err := onDelta(choice.Delta.Content.Text); err != nil {
+				return content.String(), err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return content.String(), fmt.Errorf("failed to read %s stream: %w", p.name, err)
+	}
+	if strings.TrimSpace(content.String()) == "" {
+		return "", fmt.Errorf("empty text content from %s API", p.name)
+	}
+	return content.String(), nil
+}
+
+// textMessage 构造纯文本消息
+func textMessage(role, text string) ChatCompletionMessage {
+	return ChatCompletionMessage{
+		Role: role,
+		Content: ChatCompletionContent{
+			Items: []ChatCompletionContentItem{{Type: "text", Text: text}},
+		},
+	}
+}
+
+// complete 调用 /chat/completions 并提取文本内容，schema 不为空时要求结构化输出
+func (p *OpenAICompatibleProvider) complete(ctx context.Context, model string, messages []ChatCompletionMessage, schema *ResponseSchema) (string, error) {
+	request := ChatCompletionRequest{
+		Model:          model,
+		Messages:       messages,
+		Stream:         false,
+		ResponseFormat: p.responseFormat(schema),
+	}
+
+	// 序列化请求
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// 创建 HTTP 请求，使用与 client 相同的超时时间
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	apiURL := fmt.Sprintf("%s/chat/completions", p.baseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.apiKey))
+
+	// 发送请求
+	log.Printf("Calling %s API with model: %s (timeout: %v)", p.name, model, p.timeout)
+	startTime := time.Now()
+	resp, err := p.client.Do(req)
+	duration := time.Since(startTime)
+
+	if err != nil {
+		log.Printf("Failed to call %s API after %v: %v", p.name, duration, err)
+		// 检查是否是超时错误
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", timeoutError(p.name, model, fmt.Errorf("request timeout after %v. The request may be too large or network is slow. Try increasing AI_TIMEOUT", p.timeout))
+		}
+		// EOF 错误通常表示连接被关闭，可能是网络问题或代理配置问题
+		if strings.Contains(err.Error(), "EOF") {
+			return "", fmt.Errorf("connection closed unexpectedly (EOF) when calling model '%s'. This may be caused by: 1) Network connectivity issues, 2) Proxy configuration problems, 3) API rate limiting. Please check your network and proxy settings. Error: %w", model, err)
+		}
+		return "", fmt.Errorf("failed to call %s API (network error): %w", p.name, err)
+	}
+	log.Printf("%s API responded in %v", p.name, duration)
+	defer resp.Body.Close()
+
+	// 读取响应
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// 检查 HTTP 状态码
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("%s API error - Status: %s, Body: %s", p.name, resp.Status, string(responseBody))
+		return "", handleProviderError(p.name, resp.StatusCode, responseBody, model)
+	}
+
+	// 解析响应
+	var completion ChatCompletionResponse
+	if err := json.Unmarshal(responseBody, &completion); err != nil {
+		log.Printf("Failed to unmarshal %s response: %v, body: %s", p.name, err, string(responseBody))
+		return "", fmt.Errorf("failed to parse %s API response: %w", p.name, err)
+	}
+
+	if completion.Usage != nil {
+		reportTokenUsage(ctx, completion.Usage.PromptTokens, completion.Usage.CompletionTokens)
+	}
+
+	// 提取文本内容
+	if len(completion.Choices) == 0 {
+		log.Printf("No choices in %s response: %s", p.name, string(responseBody))
+		return "", fmt.Errorf("no choices in %s response", p.name)
+	}
+
+	// 获取内容（可能是字符串或数组格式）
+	content := completion.Choices[0].Message.Content.Text
+	if content == "" {
+		for _, item := range completion.Choices[0].Message.Content.Items {
+			if item.Type == "text" {
+				content += item.Text
+			}
+		}
+	}
+
+	content = strings.TrimSpace(content)
+	if content == "" {
+		log.Printf("No content in %s response: %s", p.name, string(responseBody))
+		return "", fmt.Errorf("empty text content from %s API", p.name)
+	}
+	return content, nil
+}
+
+// handleProviderError 处理 OpenAI 兼容接口的错误响应，返回按状态码分类的 ProviderError
+func handleProviderError(provider string, statusCode int, responseBody []byte, modelName string) error {
+	return &ProviderError{
+		Provider:   provider,
+		Model:      modelName,
+		Kind:       errorKindForStatus(statusCode),
+		StatusCode: statusCode,
+		Err:        providerErrorMessage(provider, statusCode, responseBody, modelName),
+	}
+}
+
+// providerErrorMessage 返回友好的错误信息，对 ModelScope 额外给出账号绑定、实名认证等提示
+func providerErrorMessage(provider string, statusCode int, responseBody []byte, modelName string) error {
+	// 尝试解析错误响应
+	var errorResp struct {
+		Errors struct {
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		} `json:"errors"`
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+		} `json:"error"`
+	}
+
+	errorMessage := ""
+	if err := json.Unmarshal(responseBody, &errorResp); err == nil {
+		if errorResp.Errors.Message != "" {
+			errorMessage = errorResp.Errors.Message
+		} else if errorResp.Error.Message != "" {
+			errorMessage = errorResp.Error.Message
+		}
+	}
+
+	isModelScope := provider == "modelscope"
+	tokenVar := "OPENAI_API_KEY"
+	if isModelScope {
+		tokenVar = "MODELSCOPE_ACCESS_TOKEN"
+	}
+
+	// 根据状态码提供更友好的错误信息
+	switch statusCode {
+	case http.StatusUnauthorized:
+		if isModelScope {
+			if errorMessage != "" {
+				if strings.Contains(errorMessage, "bind your Alibaba Cloud account") ||
+					strings.Contains(errorMessage, "绑定") ||
+					strings.Contains(errorMessage, "实名认证") {
+					return fmt.Errorf("认证失败：%s\n\n解决方案：\n1. 访问 https://modelscope.cn 登录账号\n2. 绑定阿里云账号\n3. 完成实名认证\n4. 重新获取 Access Token：https://modelscope.cn/my/myaccesstoken", errorMessage)
+				}
+				return fmt.Errorf("认证失败 (401)：%s\n\n请检查 MODELSCOPE_ACCESS_TOKEN 是否正确，或访问 https://modelscope.cn/my/myaccesstoken 重新获取", errorMessage)
+			}
+			return fmt.Errorf("认证失败 (401)：请检查 MODELSCOPE_ACCESS_TOKEN 是否正确，或访问 https://modelscope.cn/my/myaccesstoken 重新获取。响应：%s", string(responseBody))
+		}
+		return fmt.Errorf("认证失败 (401)：%s。请检查 %s 是否正确", errorMessage, tokenVar)
+	case http.StatusForbidden:
+		if isModelScope {
+			return fmt.Errorf("访问被拒绝 (403)：%s。请确认账号已完成实名认证并绑定了阿里云账号", errorMessage)
+		}
+		return fmt.Errorf("访问被拒绝 (403)：%s", errorMessage)
+	case http.StatusBadRequest:
+		return fmt.Errorf("请求错误 (400)：%s", errorMessage)
+	case http.StatusNotFound:
+		if isModelScope {
+			return fmt.Errorf("模型未找到 (404)：请检查 MODELSCOPE_MODEL 环境变量，当前模型：%s", modelName)
+		}
+		return fmt.Errorf("模型未找到 (404)：请检查 AI_VISION_MODEL / AI_CHAT_MODEL 配置，当前模型：%s", modelName)
+	default:
+		if errorMessage != "" {
+			return fmt.Errorf("%s API 错误 (status %d)：%s", provider, statusCode, errorMessage)
+		}
+		return fmt.Errorf("%s API 返回错误 (status %d)：%s", provider, statusCode, string(responseBody))
+	}
+}
@@ -0,0 +1,555 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 模型调用错误的分类
+const (
+	ErrorKindTransient   = "transient"    // 网络错误、超时、5xx，可以重试
+	ErrorKindRateLimited = "rate_limited" // 429，退避后可以重试
+	ErrorKindAuth        = "auth"         // 401/403，重试无意义
+	ErrorKindInvalid     = "invalid"      // 400/404 等请求或模型配置错误
+	ErrorKindCircuitOpen = "circuit_open" // 提供方熔断中，没有发起调用
+	ErrorKindCanceled    = "canceled"     // 调用方取消（例如客户端断开）
+	ErrorKindUnknown     = "unknown"      // 其他错误（例如响应为空）
+)
+
+// ProviderError 提供方返回的带分类的错误
+type ProviderError struct {
+	Provider   string
+	Model      string
+	Kind       string
+	StatusCode int // HTTP 状态码，网络错误时为 0
+	Err        error
+}
+
+func (e *ProviderError) Error() string { return e.Err.Error() }
+
+func (e *ProviderError) Unwrap() error { return e.Err }
+
+// errorKindForStatus 根据 HTTP 状态码对错误分类
+func errorKindForStatus(statusCode int) string {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorKindRateLimited
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrorKindAuth
+	case statusCode == http.StatusRequestTimeout || statusCode >= 500:
+		return ErrorKindTransient
+	default:
+		return ErrorKindInvalid
+	}
+}
+
+// timeoutError 提供方请求超时（可以重试）
+func timeoutError(provider, model string, err error) error {
+	return &ProviderError{Provider: provider, Model: model, Kind: ErrorKindTransient, Err: err}
+}
+
+// ClassifyError 返回错误的分类，没有分类信息时根据错误类型判断
+func ClassifyError(err error) string {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Kind
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrorKindCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorKindTransient
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorKindTransient
+	}
+	return ErrorKindUnknown
+}
+
+// retryable 该类错误是否值得在同一个模型上重试
+func retryable(kind string) bool {
+	return kind == ErrorKindTransient || kind == ErrorKindRateLimited
+}
+
+// RetryPolicy 同一个模型上的重试策略（指数退避加随机抖动）
+type RetryPolicy struct {
+	MaxAttempts int // 包括第一次调用
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// backoff 返回第 attempt 次失败后的等待时间：base*2^(attempt-1)，不超过 MaxDelay，在后一半范围内随机抖动
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 1 {
+		return delay
+	}
+	half := delay / 2
+	return half + rand.N(delay-half)
+}
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"    // 正常调用
+	BreakerOpen     = "open"      // 连续失败过多，冷却期内直接跳过该提供方
+	BreakerHalfOpen = "half_open" // 冷却期结束，放行一次试探调用
+)
+
+// CircuitBreaker 单个提供方的熔断器：连续 threshold 次可重试错误后熔断 cooldown 时间
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool // 半开状态下是否已有试探调用在进行
+	lastError string
+	lastKind  string
+}
+
+// NewCircuitBreaker 创建熔断器
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// Allow 是否可以调用提供方
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record 记录一次调用的结果：可重试的错误计入失败，其他结果说明提供方可以访问
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+
+	kind := ClassifyError(err)
+	b.lastError, b.lastKind = err.Error(), kind
+	switch {
+	case kind == ErrorKindCanceled:
+		// 调用方取消，不能说明提供方的状态
+		if b.state == BreakerHalfOpen {
+			b.state = BreakerOpen
+		}
+	case retryable(kind):
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+			if b.state != BreakerOpen {
+				log.Printf("Circuit breaker opened after %d consecutive failures: %v", b.failures, err)
+			}
+			b.state = BreakerOpen
+			b.openedAt = time.Now()
+		}
+	default:
+		b.state, b.failures = BreakerClosed, 0
+	}
+}
+
+// ProviderHealth 提供方的熔断状态
+type ProviderHealth struct {
+	Provider      string     `json:"provider"`
+	State         string     `json:"state"`
+	Failures      int        `json:"consecutiveFailures"`
+	OpenedAt      *time.Time `json:"openedAt,omitempty"`
+	RetryAt       *time.Time `json:"retryAt,omitempty"` // 熔断结束、允许试探调用的时间
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorKind string     `json:"lastErrorKind,omitempty"`
+}
+
+// snapshot 返回熔断器的当前状态
+func (b *CircuitBreaker) snapshot(provider string) ProviderHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	health := ProviderHealth{
+		Provider:      provider,
+		State:         b.state,
+		Failures:      b.failures,
+		LastError:     b.lastError,
+		LastErrorKind: b.lastKind,
+	}
+	if b.state != BreakerClosed {
+		openedAt, retryAt := b.openedAt, b.openedAt.Add(b.cooldown)
+		health.OpenedAt, health.RetryAt = &openedAt, &retryAt
+	}
+	return health
+}
+
+// FallbackTarget 降级链中的一个模型
+type FallbackTarget struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+func (t FallbackTarget) String() string { return t.Provider + ":" + t.Model }
+
+// providerPair 同一个提供方的视觉和文本接口
+type providerPair struct {
+	vision VisionProvider
+	chat   ChatProvider
+}
+
+// ResilientClient 在提供方之上增加重试、熔断和模型降级，本身也实现 VisionProvider 和 StreamingChatProvider
+// 每个请求依次尝试降级链中的模型：熔断中的提供方被跳过，可重试的错误在同一个模型上按退避策略重试，
+// 其他错误直接尝试下一个模型；调用方取消时立即返回
+type ResilientClient struct {
+	primary     string
+	providers   map[string]providerPair
+	visionChain []FallbackTarget
+	chatChain   []FallbackTarget
+	policy      RetryPolicy
+
+	mu        sync.Mutex
+	breakers  map[string]*CircuitBreaker
+	threshold int
+	cooldown  time.Duration
+}
+
+// NewResilientClient 创建客户端，降级链的第一个模型应为主提供方的默认模型
+func NewResilientClient(vision VisionProvider, chat ChatProvider, policy RetryPolicy, threshold int, cooldown time.Duration) *ResilientClient {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &ResilientClient{
+		primary:     vision.Name(),
+		providers:   map[string]providerPair{vision.Name(): {vision: vision, chat: chat}},
+		visionChain: []FallbackTarget{{Provider: vision.Name(), Model: vision.VisionModel()}},
+		chatChain:   []FallbackTarget{{Provider: chat.Name(), Model: chat.ChatModel()}},
+		policy:      policy,
+		breakers:    make(map[string]*CircuitBreaker),
+		threshold:   threshold,
+		cooldown:    cooldown,
+	}
+}
+
+// NewResilientClientFromEnv 根据环境变量创建客户端
+//   - AI_RETRY_MAX_ATTEMPTS（默认 3）、AI_RETRY_BASE_DELAY（默认 500ms）、AI_RETRY_MAX_DELAY（默认 8s）
+//   - AI_BREAKER_THRESHOLD（默认 5）、AI_BREAKER_COOLDOWN（默认 30s）
+//   - AI_VISION_FALLBACKS、AI_CHAT_FALLBACKS：逗号分隔的备用模型，格式为 "模型" 或 "提供方:模型"
+func NewResilientClientFromEnv(cfg ProviderConfig, vision VisionProvider, chat ChatProvider) *ResilientClient {
+	policy := RetryPolicy{
+		MaxAttempts: envInt("AI_RETRY_MAX_ATTEMPTS", 3),
+		BaseDelay:   envDuration("AI_RETRY_BASE_DELAY", 500*time.Millisecond),
+		MaxDelay:    envDuration("AI_RETRY_MAX_DELAY", 8*time.Second),
+	}
+	client := NewResilientClient(vision, chat, policy, envInt("AI_BREAKER_THRESHOLD", 5), envDuration("AI_BREAKER_COOLDOWN", 30*time.Second))
+
+	for _, target := range parseFallbackTargets(os.Getenv("AI_VISION_FALLBACKS"), cfg.Provider) {
+		if client.ensureProvider(target.Provider) {
+			client.visionChain = append(client.visionChain, target)
+		}
+	}
+	chatFallbacks := os.Getenv("AI_CHAT_FALLBACKS")
+	if chatFallbacks == "" {
+		chatFallbacks = os.Getenv("AI_CHAT_FALLBACK_MODELS") // 兼容旧的环境变量名
+	}
+	if chatFallbacks == "" && cfg.Provider == "modelscope" {
+		// 原有行为：ModelScope 的查询解析默认降级到 Qwen2.5 系列模型
+		chatFallbacks = "Qwen/Qwen2.5-7B-Instruct,Qwen/Qwen2.5-Coder-32B-Instruct,Qwen/Qwen2.5-14B-Instruct"
+	}
+	for _, target := range parseFallbackTargets(chatFallbacks, cfg.Provider) {
+		if client.ensureProvider(target.Provider) {
+			client.chatChain = append(client.chatChain, target)
+		}
+	}
+
+	log.Printf("AI resilience: %d attempts per model, breaker after %d failures; vision chain %v, chat chain %v",
+		policy.MaxAttempts, client.threshold, client.visionChain, client.chatChain)
+	return client
+}
+
+// knownProviders 降级链中可以使用的提供方名称
+var knownProviders = map[string]bool{"modelscope": true, "openai": true, "ollama": true, "mock": true}
+
+// parseFallbackTargets 解析逗号分隔的备用模型列表
+// "提供方:模型" 只在冒号前是已知的提供方时生效，因此 "llava:13b" 这样带冒号的模型名会被当作主提供方的模型
+func parseFallbackTargets(value, primary string) []FallbackTarget {
+	targets := make([]FallbackTarget, 0)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target := FallbackTarget{Provider: primary, Model: entry}
+		if provider, model, ok := strings.Cut(entry, ":"); ok && knownProviders[strings.ToLower(provider)] && model != "" {
+			target = FallbackTarget{Provider: strings.ToLower(provider), Model: model}
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// ensureProvider 按需创建降级链中用到的其他提供方，配置不完整时返回 false
+func (r *ResilientClient) ensureProvider(name string) bool {
+	if _, ok := r.providers[name]; ok {
+		return true
+	}
+	vision, chat, err := NewProviders(defaultProviderConfig(name))
+	if err != nil {
+		log.Printf("Skipping fallback provider %s: %v", name, err)
+		return false
+	}
+	r.providers[name] = providerPair{vision: vision, chat: chat}
+	return true
+}
+
+// Name 返回主提供方名称
+func (r *ResilientClient) Name() string { return r.primary }
+
+// VisionModel 返回主视觉模型
+func (r *ResilientClient) VisionModel() string { return r.visionChain[0].Model }
+
+// ChatModel 返回主文本模型
+func (r *ResilientClient) ChatModel() string { return r.chatChain[0].Model }
+
+// breaker 返回提供方的熔断器
+func (r *ResilientClient) breaker(provider string) *CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[provider]
+	if !ok {
+		b = NewCircuitBreaker(r.threshold, r.cooldown)
+		r.breakers[provider] = b
+	}
+	return b
+}
+
+// chain 返回请求使用的降级链：请求指定了模型时只使用主提供方上的该模型
+func (r *ResilientClient) chain(chain []FallbackTarget, model string) []FallbackTarget {
+	if model != "" {
+		return []FallbackTarget{{Provider: chain[0].Provider, Model: model}}
+	}
+	return chain
+}
+
+// Vision 依次尝试视觉降级链中的模型
+func (r *ResilientClient) Vision(ctx context.Context, req VisionRequest) (string, error) {
+	return r.call(ctx, "vision", r.chain(r.visionChain, req.Model), func(ctx context.Context, target FallbackTarget) (string, error) {
+		req.Model = target.Model
+		return r.providers[target.Provider].vision.Vision(ctx, req)
+	})
+}
+
+// Chat 依次尝试文本降级链中的模型
+func (r *ResilientClient) Chat(ctx context.Context, req ChatRequest) (string, error) {
+	return r.call(ctx, "chat", r.chain(r.chatChain, req.Model), func(ctx context.Context, target FallbackTarget) (string, error) {
+		req.Model = target.Model
+		return r.providers[target.Provider].chat.Chat(ctx, req)
+	})
+}
+
+// ChatStream 依次尝试文本降级链中的模型；已经输出部分内容后出错时不再重试或降级
+// 不支持流式输出的提供方一次性回调完整结果
+func (r *ResilientClient) ChatStream(ctx context.Context, req ChatRequest, onDelta func(delta string) error) (string, error) {
+	var lastErr error
+	for _, target := range r.chain(r.chatChain, req.Model) {
+		breaker := r.breaker(target.Provider)
+		if !breaker.Allow() {
+			lastErr = circuitOpenError(target)
+			continue
+		}
+
+		req.Model = target.Model
+		emitted := false
+		var content string
+		var err error
+		if streaming, ok := r.providers[target.Provider].chat.(StreamingChatProvider); ok {
+			content, err = streaming.ChatStream(ctx, req, func(delta string) error {
+				emitted = true
+				return onDelta(delta)
+			})
+		} else if content, err = r.providers[target.Provider].chat.Chat(ctx, req); err == nil {
+			emitted = true
+			err = onDelta(content)
+		}
+		breaker.Record(err)
+		if err == nil {
+			reportServedBy(ctx, target.Provider, target.Model)
+			return content, nil
+		}
+		if emitted || ClassifyError(err) == ErrorKindCanceled {
+			return content, err
+		}
+		log.Printf("AI chat stream with %s failed: %v", target, err)
+		lastErr = err
+	}
+	return "", chainError("chat", lastErr)
+}
+
+// call 依次尝试降级链中的模型，每个模型按重试策略重试
+func (r *ResilientClient) call(ctx context.Context, kind string, chain []FallbackTarget, fn func(ctx context.Context, target FallbackTarget) (string, error)) (string, error) {
+	var lastErr error
+	for i, target := range chain {
+		breaker := r.breaker(target.Provider)
+		if !breaker.Allow() {
+			log.Printf("Skipping %s for AI %s call: circuit open", target, kind)
+			lastErr = circuitOpenError(target)
+			continue
+		}
+
+		content, err := r.withRetry(ctx, target, fn)
+		breaker.Record(err)
+		if err == nil {
+			if i > 0 {
+				log.Printf("AI %s call served by fallback %s", kind, target)
+			}
+			reportServedBy(ctx, target.Provider, target.Model)
+			return content, nil
+		}
+		if ClassifyError(err) == ErrorKindCanceled {
+			return "", err
+		}
+		log.Printf("AI %s call with %s failed (%s): %v", kind, target, ClassifyError(err), err)
+		lastErr = err
+	}
+	return "", chainError(kind, lastErr)
+}
+
+// withRetry 在同一个模型上调用，可重试的错误按指数退避重试
+func (r *ResilientClient) withRetry(ctx context.Context, target FallbackTarget, fn func(ctx context.Context, target FallbackTarget) (string, error)) (string, error) {
+	for attempt := 1; ; attempt++ {
+		content, err := fn(ctx, target)
+		if err == nil || attempt >= r.policy.MaxAttempts || !retryable(ClassifyError(err)) {
+			return content, err
+		}
+
+		delay := r.policy.backoff(attempt)
+		log.Printf("AI call with %s failed (attempt %d/%d), retrying in %v: %v", target, attempt, r.policy.MaxAttempts, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// circuitOpenError 熔断中跳过提供方时的错误
+func circuitOpenError(target FallbackTarget) error {
+	return &ProviderError{
+		Provider: target.Provider,
+		Model:    target.Model,
+		Kind:     ErrorKindCircuitOpen,
+		Err:      fmt.Errorf("%s is temporarily unavailable (circuit open)", target.Provider),
+	}
+}
+
+// chainError 降级链中所有模型都失败时返回最后一个错误（保留分类）
+func chainError(kind string, lastErr error) error {
+	if lastErr == nil {
+		return fmt.Errorf("no AI %s model configured", kind)
+	}
+	return fmt.Errorf("all AI %s models failed, last error: %w", kind, lastErr)
+}
+
+// AIHealth AI 提供方的健康状态
+type AIHealth struct {
+	Status      string           `json:"status"` // ok、degraded（部分提供方熔断）或 unavailable（某条降级链全部熔断）
+	Providers   []ProviderHealth `json:"providers"`
+	VisionChain []FallbackTarget `json:"visionChain"`
+	ChatChain   []FallbackTarget `json:"chatChain"`
+	Retry       struct {
+		MaxAttempts int    `json:"maxAttempts"`
+		BaseDelay   string `json:"baseDelay"`
+		MaxDelay    string `json:"maxDelay"`
+	} `json:"retry"`
+}
+
+// Health 返回各提供方的熔断状态和降级链
+func (r *ResilientClient) Health() AIHealth {
+	health := AIHealth{
+		Status:      "ok",
+		VisionChain: r.visionChain,
+		ChatChain:   r.chatChain,
+	}
+	health.Retry.MaxAttempts = r.policy.MaxAttempts
+	health.Retry.BaseDelay, health.Retry.MaxDelay = r.policy.BaseDelay.String(), r.policy.MaxDelay.String()
+	states := make(map[string]string)
+	for _, name := range r.providerNames() {
+		provider := r.breaker(name).snapshot(name)
+		states[name] = provider.State
+		health.Providers = append(health.Providers, provider)
+		if provider.State != BreakerClosed {
+			health.Status = "degraded"
+		}
+	}
+	for _, chain := range [][]FallbackTarget{r.visionChain, r.chatChain} {
+		available := false
+		for _, target := range chain {
+			if states[target.Provider] != BreakerOpen {
+				available = true
+			}
+		}
+		if !available {
+			health.Status = "unavailable"
+		}
+	}
+	return health
+}
+
+// providerNames 按降级链中出现的顺序返回提供方名称
+func (r *ResilientClient) providerNames() []string {
+	names := make([]string, 0, len(r.providers))
+	seen := make(map[string]bool)
+	for _, target := range append(append([]FallbackTarget{}, r.visionChain...), r.chatChain...) {
+		if !seen[target.Provider] {
+			seen[target.Provider] = true
+			names = append(names, target.Provider)
+		}
+	}
+	return names
+}
+
+// envDuration 读取时长类型的环境变量，无效时使用默认值
+func envDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		log.Printf("Invalid %s value %q, using %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func transientError() error {
+	return &ProviderError{Provider: "mock", Kind: ErrorKindTransient, Err: errors.New("503 service unavailable")}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&ProviderError{Kind: ErrorKindAuth, Err: errors.New("401")}, ErrorKindAuth},
+		{fmt.Errorf("wrapped: %w", &ProviderError{Kind: ErrorKindRateLimited, Err: errors.New("429")}), ErrorKindRateLimited},
+		{context.Canceled, ErrorKindCanceled},
+		{fmt.Errorf("request: %w", context.DeadlineExceeded), ErrorKindTransient},
+		{io.ErrUnexpectedEOF, ErrorKindTransient},
+		{errors.New("empty response"), ErrorKindUnknown},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("ClassifyError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+
+	for status, want := range map[int]string{429: ErrorKindRateLimited, 401: ErrorKindAuth, 403: ErrorKindAuth, 500: ErrorKindTransient, 503: ErrorKindTransient, 400: ErrorKindInvalid, 404: ErrorKindInvalid} {
+		if got := errorKindForStatus(status); got != want {
+			t.Errorf("errorKindForStatus(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second},  // 1.6s 超过上限
+		{80, 500 * time.Millisecond, time.Second}, // 移位溢出时也使用上限
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if got := policy.backoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(2, 20*time.Millisecond)
+
+	b.Record(transientError())
+	if !b.Allow() || b.snapshot("mock").State != BreakerClosed {
+		t.Fatal("breaker opened before reaching the threshold")
+	}
+	b.Record(transientError())
+	if b.Allow() {
+		t.Fatal("open breaker allowed a call during the cooldown")
+	}
+	if health := b.snapshot("mock"); health.State != BreakerOpen || health.Failures != 2 || health.LastErrorKind != ErrorKindTransient || health.RetryAt == nil {
+		t.Fatalf("got %+v, want open breaker with 2 failures", health)
+	}
+
+	// 冷却期结束后只放行一次试探调用，试探失败时重新熔断
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("breaker did not allow a probe after the cooldown")
+	}
+	if b.Allow() {
+		t.Fatal("half-open breaker allowed a second concurrent probe")
+	}
+	b.Record(transientError())
+	if b.Allow() {
+		t.Fatal("failed probe did not reopen the breaker")
+	}
+
+	// 试探调用被取消时不能说明提供方已经恢复
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("breaker did not allow a probe after the cooldown")
+	}
+	b.Record(context.Canceled)
+	if state := b.snapshot("mock").State; state != BreakerOpen {
+		t.Fatalf("state after canceled probe = %q, want %q", state, BreakerOpen)
+	}
+
+	// 试探成功后恢复正常
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("breaker did not allow a probe after the cooldown")
+	}
+	b.Record(nil)
+	if health := b.snapshot("mock"); health.State != BreakerClosed || health.Failures != 0 || !b.Allow() {
+		t.Fatalf("got %+v, want closed breaker after a successful probe", health)
+	}
+
+	// 不可重试的错误说明提供方可以访问，连续失败计数清零
+	b.Record(transientError())
+	b.Record(&ProviderError{Kind: ErrorKindInvalid, Err: errors.New("400")})
+	b.Record(transientError())
+	if state := b.snapshot("mock").State; state != BreakerClosed {
+		t.Fatalf("state = %q, want non-retryable errors to reset the failure count", state)
+	}
+}
+
+func TestResilientClientRetriesTransientErrors(t *testing.T) {
+	provider := NewMockProvider()
+	failures := 2
+	provider.ChatFunc = func(req ChatRequest) (string, error) {
+		if failures > 0 {
+			failures--
+			return "", transientError()
+		}
+		return "ok", nil
+	}
+	client := NewResilientClient(provider, provider, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, 5, time.Minute)
+
+	content, err := client.Chat(context.Background(), ChatRequest{Prompt: "hi"})
+	if err != nil || content != "ok" {
+		t.Fatalf("Chat() = %q, %v, want ok", content, err)
+	}
+	if _, chat := provider.Calls(); chat != 3 {
+		t.Fatalf("chat calls = %d, want 3", chat)
+	}
+}
+
+func TestResilientClientDoesNotRetryPermanentErrors(t *testing.T) {
+	provider := NewMockProvider()
+	provider.ChatFunc = func(req ChatRequest) (string, error) {
+		return "", &ProviderError{Provider: "mock", Kind: ErrorKindAuth, Err: errors.New("401 unauthorized")}
+	}
+	client := NewResilientClient(provider, provider, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, 5, time.Minute)
+
+	_, err := client.Chat(context.Background(), ChatRequest{Prompt: "hi"})
+	if ClassifyError(err) != ErrorKindAuth {
+		t.Fatalf("error kind = %q, want %q (%v)", ClassifyError(err), ErrorKindAuth, err)
+	}
+	if _, chat := provider.Calls(); chat != 1 {
+		t.Fatalf("chat calls = %d, want 1", chat)
+	}
+}
+
+func TestResilientClientFallsBackAndOpensCircuit(t *testing.T) {
+	provider := NewMockProvider()
+	var models []string
+	provider.ChatFunc = func(req ChatRequest) (string, error) {
+		models = append(models, req.Model)
+		if req.Model == "backup" {
+			return "from backup", nil
+		}
+		return "", transientError()
+	}
+	client := NewResilientClient(provider, provider, RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, 3, time.Minute)
+	client.chatChain = append(client.chatChain, FallbackTarget{Provider: "mock", Model: "backup"})
+
+	content, err := client.Chat(context.Background(), ChatRequest{Prompt: "hi"})
+	if err != nil || content != "from backup" {
+		t.Fatalf("Chat() = %q, %v, want the fallback model's answer", content, err)
+	}
+	if want := []string{"mock-chat", "mock-chat", "backup"}; !reflect.DeepEqual(models, want) {
+		t.Fatalf("models called = %v, want %v", models, want)
+	}
+
+	// 连续失败达到阈值后提供方熔断，降级链中同一提供方的模型都被跳过，错误保留熔断分类
+	models = nil
+	for i := 0; i < 3; i++ {
+		client.breaker("mock").Record(transientError())
+	}
+	_, err = client.Chat(context.Background(), ChatRequest{Prompt: "hi"})
+	if ClassifyError(err) != ErrorKindCircuitOpen || len(models) != 0 {
+		t.Fatalf("got %v after %v calls, want circuit open without calling the provider", err, models)
+	}
+	if health := client.Health(); health.Status == "ok" {
+		t.Fatalf("health status = %q with an open circuit", health.Status)
+	}
+}
+
+func TestResilientClientStopsOnCancel(t *testing.T) {
+	provider := NewMockProvider()
+	provider.ChatFunc = func(req ChatRequest) (string, error) {
+		return "", transientError()
+	}
+	client := NewResilientClient(provider, provider, RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}, 5, time.Minute)
+	client.chatChain = append(client.chatChain, FallbackTarget{Provider: "mock", Model: "backup"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := client.Chat(ctx, ChatRequest{Prompt: "hi"})
+	if !errors.Is(err, context.Canceled) || time.Since(start) > time.Second {
+		t.Fatalf("Chat() = %v after %v, want context.Canceled as soon as the caller cancels", err, time.Since(start))
+	}
+	// 取消后不再尝试备用模型
+	if _, chat := provider.Calls(); chat != 1 {
+		t.Fatalf("chat calls = %d, want 1", chat)
+	}
+}
+
+func TestParseFallbackTargets(t *testing.T) {
+	got := parseFallbackTargets(" gpt-4o-mini , ollama:llava:13b,llava:13b,, OpenAI:gpt-4o,unknown:model", "modelscope")
+	want := []FallbackTarget{
+		{Provider: "modelscope", Model: "gpt-4o-mini"},
+		{Provider: "ollama", Model: "llava:13b"},
+		{Provider: "modelscope", Model: "llava:13b"},
+		{Provider: "openai", Model: "gpt-4o"},
+		{Provider: "modelscope", Model: "unknown:model"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := parseFallbackTargets("", "openai"); len(got) != 0 {
+		t.Fatalf("got %v for an empty list", got)
+	}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 批量分析调度的默认限制
+const (
+	defaultBatchConcurrency = 2  // 同时进行的 AI 请求数
+	defaultBatchRateLimit   = 30 // 每分钟最多发起的 AI 请求数
+)
+
+// AIScheduler 限制批量任务对 AI 提供方的并发数和请求速率
+// 所有批量任务共享同一个调度器，因此限制是针对 AI 提供方整体的，而不是针对单个批次
+type AIScheduler struct {
+	slots    chan struct{}
+	interval time.Duration // 相邻两次请求的最小间隔，为 0 时不限速
+
+	mu   sync.Mutex
+	next time.Time // 下一个请求最早可以开始的时间
+}
+
+// NewAIScheduler 创建调度器；concurrency 小于 1 时按 1 处理，ratePerMinute 为 0 时不限速
+func NewAIScheduler(concurrency, ratePerMinute int) *AIScheduler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var interval time.Duration
+	if ratePerMinute > 0 {
+		interval = time.Minute / time.Duration(ratePerMinute)
+	}
+	return &AIScheduler{
+		slots:    make(chan struct{}, concurrency),
+		interval: interval,
+	}
+}
+
+// NewAISchedulerFromEnv 根据 AI_BATCH_CONCURRENCY 和 AI_BATCH_RATE_LIMIT 创建调度器
+func NewAISchedulerFromEnv() *AIScheduler {
+	concurrency := envInt("AI_BATCH_CONCURRENCY", defaultBatchConcurrency)
+	rate := envInt("AI_BATCH_RATE_LIMIT", defaultBatchRateLimit)
+	return NewAIScheduler(concurrency, rate)
+}
+
+// Concurrency 返回最大并发数
+func (s *AIScheduler) Concurrency() int {
+	return cap(s.slots)
+}
+
+// Acquire 等待一个并发名额和速率许可，返回释放名额的函数
+// ctx 取消时放弃等待并返回 ctx 的错误
+func (s *AIScheduler) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-s.slots }
+
+	if s.interval > 0 {
+		s.mu.Lock()
+		now := time.Now()
+		start := s.next
+		if start.Before(now) {
+			start = now
+		}
+		s.next = start.Add(s.interval)
+		s.mu.Unlock()
+
+		if wait := time.Until(start); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				release()
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return release, nil
+}
+
+// envInt 读取非负整数环境变量，未设置或格式错误时返回默认值
+func envInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("Invalid %s value %q, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
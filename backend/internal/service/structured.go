@@ -0,0 +1,427 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 结构化输出的约束方式（AI_RESPONSE_FORMAT）
+const (
+	ResponseFormatSchema = "json_schema" // 按 JSON Schema 约束输出（OpenAI 结构化输出、Ollama format）
+	ResponseFormatJSON   = "json_object" // 只要求输出合法的 JSON
+	ResponseFormatNone   = "none"        // 提供方不支持时只在提示词中要求 JSON
+)
+
+// ResponseSchema 要求模型按 JSON Schema 输出，提供方支持时在请求中约束，否则只写在提示词中
+// 无论提供方是否支持，结果都会在 Go 中再次校验
+type ResponseSchema struct {
+	Name   string
+	Schema map[string]interface{}
+}
+
+// String 返回缩进的 JSON Schema 文本，用于提示词
+func (s *ResponseSchema) String() string {
+	data, _ := json.MarshalIndent(s.Schema, "", "  ")
+	return string(data)
+}
+
+// TagCategories AI 标签的类别
+var TagCategories = []string{"scene", "object", "person", "animal", "activity", "style", "mood", "color", "other"}
+
+// AITag AI 给出的一个标签
+type AITag struct {
+	Name       string  `json:"name"`
+	Category   string  `json:"category"`   // TagCategories 之一
+	Confidence float64 `json:"confidence"` // 0-1
+}
+
+// tagOutput 标签分析的结构化输出
+type tagOutput struct {
+	Tags        []AITag `json:"tags"`
+	Description string  `json:"description"`
+	AltText     string  `json:"altText"`
+}
+
+// maxTagNameLength 标签名称的最大长度（字符数）
+const maxTagNameLength = 50
+
+// tagSchema 标签分析输出的 JSON Schema，describe 为 true 时要求同时输出描述和替代文本
+func tagSchema(describe bool) *ResponseSchema {
+	properties := map[string]interface{}{
+		"tags": map[string]interface{}{
+			"type":     "array",
+			"minItems": 1,
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"name":       map[string]interface{}{"type": "string", "maxLength": maxTagNameLength},
+					"category":   map[string]interface{}{"type": "string", "enum": TagCategories},
+					"confidence": map[string]interface{}{"type": "number", "minimum": 0, "maximum": 1},
+				},
+				"required":             []string{"name", "category", "confidence"},
+				"additionalProperties": false,
+			},
+		},
+	}
+	required := []string{"tags"}
+	name := "image_tags"
+	if describe {
+		properties["description"] = map[string]interface{}{"type": "string"}
+		properties["altText"] = map[string]interface{}{"type": "string", "maxLength": MaxAltTextLength}
+		required = append(required, "description", "altText")
+		name = "image_analysis"
+	}
+	return &ResponseSchema{Name: name, Schema: map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}}
+}
+
+// queryConditionProperties 查询条件的 JSON Schema 属性（查询解析和对话助手共用）
+func queryConditionProperties() map[string]interface{} {
+	stringArray := map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+	return map[string]interface{}{
+		"tags":            stringArray,
+		"excludeTags":     stringArray,
+		"keywords":        stringArray,
+		"excludeKeywords": stringArray,
+		"month":           map[string]interface{}{"type": "string", "description": "YYYY-MM or empty"},
+		"year":            map[string]interface{}{"type": "string", "description": "YYYY or empty"},
+		"dateFrom":        map[string]interface{}{"type": "string", "description": "YYYY-MM-DD or empty, inclusive"},
+		"dateTo":          map[string]interface{}{"type": "string", "description": "YYYY-MM-DD or empty, inclusive"},
+		"uploadedFrom":    map[string]interface{}{"type": "string", "description": "YYYY-MM-DD or empty, inclusive"},
+		"uploadedTo":      map[string]interface{}{"type": "string", "description": "YYYY-MM-DD or empty, inclusive"},
+		"camera":          map[string]interface{}{"type": "string"},
+		"location": map[string]interface{}{
+			"type": []string{"object", "null"},
+			"properties": map[string]interface{}{
+				"name":      map[string]interface{}{"type": "string"},
+				"latitude":  map[string]interface{}{"type": []string{"number", "null"}, "minimum": -90, "maximum": 90},
+				"longitude": map[string]interface{}{"type": []string{"number", "null"}, "minimum": -180, "maximum": 180},
+				"radiusKm":  map[string]interface{}{"type": "number", "minimum": 0},
+				"bounds": map[string]interface{}{
+					"type":        []string{"object", "null"},
+					"description": "bounding box for a region such as a province or country",
+					"properties": map[string]interface{}{
+						"south": map[string]interface{}{"type": "number", "minimum": -90, "maximum": 90},
+						"west":  map[string]interface{}{"type": "number", "minimum": -180, "maximum": 180},
+						"north": map[string]interface{}{"type": "number", "minimum": -90, "maximum": 90},
+						"east":  map[string]interface{}{"type": "number", "minimum": -180, "maximum": 180},
+					},
+					"required": []string{"south", "west", "north", "east"},
+				},
+			},
+		},
+		"orientation": map[string]interface{}{"type": "string", "enum": []string{"", OrientationLandscape, OrientationPortrait, OrientationSquare}},
+		"minWidth":    map[string]interface{}{"type": "integer", "minimum": 0, "description": "pixels, 0 for no limit"},
+		"minHeight":   map[string]interface{}{"type": "integer", "minimum": 0, "description": "pixels, 0 for no limit"},
+		"maxWidth":    map[string]interface{}{"type": "integer", "minimum": 0, "description": "pixels, 0 for no limit"},
+		"maxHeight":   map[string]interface{}{"type": "integer", "minimum": 0, "description": "pixels, 0 for no limit"},
+		"anyOf": map[string]interface{}{
+			"type":        "array",
+			"description": "OR groups: an image matches a group when it has any of its tags or keywords; all groups must match",
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"tags":     stringArray,
+					"keywords": stringArray,
+				},
+			},
+		},
+		"reasoning": map[string]interface{}{"type": "string"},
+	}
+}
+
+// querySchema 查询解析输出的 JSON Schema
+func querySchema() *ResponseSchema {
+	return &ResponseSchema{Name: "query_condition", Schema: map[string]interface{}{
+		"type":       "object",
+		"properties": queryConditionProperties(),
+		"required":   []string{"tags", "keywords", "reasoning"},
+	}}
+}
+
+// assistantSchema 对话助手意图的 JSON Schema
+func assistantSchema() *ResponseSchema {
+	properties := queryConditionProperties()
+	properties["action"] = map[string]interface{}{"type": "string", "enum": []string{AssistantSearch, AssistantRefine, AssistantTag, AssistantAnswer}}
+	properties["tag"] = map[string]interface{}{"type": "string"}
+	return &ResponseSchema{Name: "assistant_intent", Schema: map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   []string{"action", "reasoning"},
+	}}
+}
+
+// schemaHint 模板没有使用 .Schema 变量时附加在提示词后的输出格式说明
+func schemaHint(language string, schema *ResponseSchema) string {
+	if language == "en" {
+		return "\n\nReturn only a JSON object matching this JSON Schema, no other text, no markdown code blocks:\n" + schema.String()
+	}
+	return "\n\n只返回符合以下 JSON Schema 的 JSON 对象，不要其他文字，不要使用markdown代码块：\n" + schema.String()
+}
+
+// trailingComma JSON 中对象或数组末尾多余的逗号
+var trailingComma = regexp.MustCompile(`,\s*([}\]])`)
+
+// decodeJSONObject 从模型输出中取出 JSON 对象并解析：去掉 markdown 代码块和前后的说明文字，
+// 解析失败时再去掉末尾多余的逗号重试一次
+func decodeJSONObject(content string, out interface{}) error {
+	jsonContent := extractJSON(content)
+	if start, end := strings.Index(jsonContent, "{"), strings.LastIndex(jsonContent, "}"); start >= 0 && end > start {
+		jsonContent = jsonContent[start : end+1]
+	}
+	err := json.Unmarshal([]byte(jsonContent), out)
+	if err != nil {
+		if fixed := trailingComma.ReplaceAllString(jsonContent, "$1"); fixed != jsonContent {
+			if json.Unmarshal([]byte(fixed), out) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("output is not a valid JSON object: %w", err)
+	}
+	return nil
+}
+
+// decodeTagOutput 解析并校验标签分析的输出：修正可以修正的小问题（类别、置信度范围、重复标签），
+// 没有有效标签或描述模式缺少描述时返回错误
+func decodeTagOutput(content string, describe bool) (*tagOutput, error) {
+	var output tagOutput
+	if err := decodeJSONObject(content, &output); err != nil {
+		return nil, err
+	}
+
+	tags := make([]AITag, 0, len(output.Tags))
+	seen := make(map[string]bool)
+	for _, tag := range output.Tags {
+		tag.Name = strings.TrimSpace(strings.Trim(strings.TrimSpace(tag.Name), "，。、；：！？,.;:!?#"))
+		if tag.Name == "" || len([]rune(tag.Name)) > maxTagNameLength || seen[strings.ToLower(tag.Name)] {
+			continue
+		}
+		seen[strings.ToLower(tag.Name)] = true
+		tag.Category = strings.ToLower(strings.TrimSpace(tag.Category))
+		if !containsCategory(tag.Category) {
+			tag.Category = "other"
+		}
+		if tag.Confidence > 1 && tag.Confidence <= 100 {
+			tag.Confidence /= 100 // 模型按百分比给出
+		}
+		tag.Confidence = clamp(tag.Confidence, 0, 1)
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("tags must contain at least one tag with a non-empty name of at most %d characters", maxTagNameLength)
+	}
+	output.Tags = tags
+
+	if describe {
+		output.Description = strings.TrimSpace(output.Description)
+		output.AltText = strings.TrimSpace(output.AltText)
+		if output.Description == "" {
+			return nil, fmt.Errorf("description is required")
+		}
+		if output.AltText == "" {
+			output.AltText = output.Description
+		}
+		output.AltText = truncateRunes(output.AltText, MaxAltTextLength)
+	} else {
+		output.Description, output.AltText = "", ""
+	}
+	return &output, nil
+}
+
+// containsCategory 是否为有效的标签类别
+func containsCategory(category string) bool {
+	for _, c := range TagCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// clamp 将 v 限制在 [min, max] 范围内
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// defaultLocationRadiusKm 查询地点只有坐标没有半径时使用的半径
+const defaultLocationRadiusKm = 25
+
+// validateQueryCondition 校验并规范化查询条件：日期格式、日期范围、地点坐标、方向和尺寸
+func validateQueryCondition(condition *QueryCondition) error {
+	condition.Tags = trimStrings(condition.Tags)
+	condition.ExcludeTags = trimStrings(condition.ExcludeTags)
+	condition.Keywords = trimStrings(condition.Keywords)
+	condition.ExcludeKeywords = trimStrings(condition.ExcludeKeywords)
+	condition.Month = strings.TrimSpace(condition.Month)
+	condition.Year = strings.TrimSpace(condition.Year)
+	condition.DateFrom = strings.TrimSpace(condition.DateFrom)
+	condition.DateTo = strings.TrimSpace(condition.DateTo)
+	condition.UploadedFrom = strings.TrimSpace(condition.UploadedFrom)
+	condition.UploadedTo = strings.TrimSpace(condition.UploadedTo)
+	condition.Camera = strings.TrimSpace(condition.Camera)
+	condition.Orientation = strings.ToLower(strings.TrimSpace(condition.Orientation))
+	condition.AnyOf = normalizeGroups(condition.AnyOf)
+
+	if condition.Month != "" {
+		if _, err := time.Parse("2006-01", condition.Month); err != nil {
+			return fmt.Errorf("month must be YYYY-MM or empty, got %q", condition.Month)
+		}
+	}
+	if condition.Year != "" {
+		if _, err := time.Parse("2006", condition.Year); err != nil {
+			return fmt.Errorf("year must be YYYY or empty, got %q", condition.Year)
+		}
+	}
+	if err := validateDateRange("dateFrom", condition.DateFrom, "dateTo", condition.DateTo); err != nil {
+		return err
+	}
+	if err := validateDateRange("uploadedFrom", condition.UploadedFrom, "uploadedTo", condition.UploadedTo); err != nil {
+		return err
+	}
+
+	switch condition.Orientation {
+	case "", OrientationLandscape, OrientationPortrait, OrientationSquare:
+	default:
+		return fmt.Errorf("orientation must be landscape, portrait, square or empty, got %q", condition.Orientation)
+	}
+	if condition.MinWidth < 0 || condition.MinHeight < 0 || condition.MaxWidth < 0 || condition.MaxHeight < 0 {
+		return fmt.Errorf("image size limits must not be negative")
+	}
+	if condition.MaxWidth > 0 && condition.MinWidth > condition.MaxWidth {
+		return fmt.Errorf("minWidth %d is larger than maxWidth %d", condition.MinWidth, condition.MaxWidth)
+	}
+	if condition.MaxHeight > 0 && condition.MinHeight > condition.MaxHeight {
+		return fmt.Errorf("minHeight %d is larger than maxHeight %d", condition.MinHeight, condition.MaxHeight)
+	}
+
+	if location := condition.Location; location != nil {
+		location.Name = strings.TrimSpace(location.Name)
+		if (location.Latitude == nil) != (location.Longitude == nil) {
+			return fmt.Errorf("location latitude and longitude must be given together")
+		}
+		if location.Latitude != nil {
+			if *location.Latitude < -90 || *location.Latitude > 90 || *location.Longitude < -180 || *location.Longitude > 180 {
+				return fmt.Errorf("location coordinates out of range")
+			}
+			if location.RadiusKm <= 0 {
+				location.RadiusKm = defaultLocationRadiusKm
+			}
+		}
+		if bounds := location.Bounds; bounds != nil {
+			if bounds.South < -90 || bounds.North > 90 || bounds.West < -180 || bounds.West > 180 || bounds.East < -180 || bounds.East > 180 {
+				return fmt.Errorf("location bounds out of range")
+			}
+			if bounds.South > bounds.North {
+				return fmt.Errorf("location bounds south %v is north of north %v", bounds.South, bounds.North)
+			}
+		}
+		if location.Name == "" && location.Latitude == nil && location.Bounds == nil {
+			condition.Location = nil
+		}
+	}
+	return nil
+}
+
+// validateDateRange 校验 YYYY-MM-DD 格式的日期范围，两端都可以为空（开区间）
+func validateDateRange(fromField, from, toField, to string) error {
+	var fromDate, toDate time.Time
+	var err error
+	if from != "" {
+		if fromDate, err = time.Parse("2006-01-02", from); err != nil {
+			return fmt.Errorf("%s must be YYYY-MM-DD or empty, got %q", fromField, from)
+		}
+	}
+	if to != "" {
+		if toDate, err = time.Parse("2006-01-02", to); err != nil {
+			return fmt.Errorf("%s must be YYYY-MM-DD or empty, got %q", toField, to)
+		}
+	}
+	if !fromDate.IsZero() && !toDate.IsZero() && fromDate.After(toDate) {
+		return fmt.Errorf("%s %s is after %s %s", fromField, from, toField, to)
+	}
+	return nil
+}
+
+// normalizeGroups 去掉条件组中的空白项和空组
+func normalizeGroups(groups []QueryGroup) []QueryGroup {
+	result := make([]QueryGroup, 0, len(groups))
+	for _, group := range groups {
+		group.Tags = trimStrings(group.Tags)
+		group.Keywords = trimStrings(group.Keywords)
+		if len(group.Tags) > 0 || len(group.Keywords) > 0 {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// filterConditionTags 只保留条件中存在于可用标签列表的标签；过滤后为空的"或"条件组会被去掉
+func filterConditionTags(condition *QueryCondition, availableTags []string) {
+	condition.Tags = filterAvailableTags(condition.Tags, availableTags)
+	condition.ExcludeTags = filterAvailableTags(condition.ExcludeTags, availableTags)
+	for i := range condition.AnyOf {
+		condition.AnyOf[i].Tags = filterAvailableTags(condition.AnyOf[i].Tags, availableTags)
+	}
+	condition.AnyOf = normalizeGroups(condition.AnyOf)
+}
+
+// trimStrings 去掉字符串两端的空白和空字符串
+func trimStrings(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// repairSystem 修复结构化输出时的系统提示词
+const repairSystem = "You repair model outputs that do not match a required JSON Schema. Reply with the corrected JSON object only."
+
+// decodeStructured 用 decode 解析并校验模型输出；失败时把输出、错误和 schema 交给文本模型修复一次
+func (s *AIService) decodeStructured(ctx context.Context, content string, schema *ResponseSchema, decode func(content string) error) error {
+	err := decode(content)
+	if err == nil {
+		return nil
+	}
+	log.Printf("Model output does not match %s (%v), asking for a repair", schema.Name, err)
+
+	prompt := fmt.Sprintf(`The following model output must be a JSON object matching this JSON Schema:
+%s
+
+It failed validation: %v
+
+Output:
+%s
+
+Return the corrected JSON object only. Keep the original content where possible. No markdown, no explanation.`, schema.String(), err, content)
+	call := modelCall{Operation: OperationRepair, Provider: s.chat.Name(), Model: s.chat.ChatModel(), Prompt: prompt}
+	repaired, repairErr := s.callModel(ctx, call, func(ctx context.Context) (string, error) {
+		return s.chat.Chat(ctx, ChatRequest{System: repairSystem, Prompt: prompt, Schema: schema})
+	})
+	if repairErr != nil {
+		return fmt.Errorf("invalid model output (%v) and repair failed: %w", err, repairErr)
+	}
+	if err := decode(repaired); err != nil {
+		log.Printf("Repaired output still invalid: %v, content: %s", err, repaired)
+		return fmt.Errorf("invalid model output after repair: %w", err)
+	}
+	log.Printf("Model output for %s repaired", schema.Name)
+	return nil
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONObject(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"plain", `{"name":"cat"}`, false},
+		{"markdown code block", "```json\n{\"name\":\"cat\"}\n```", false},
+		{"surrounding text", `Here is the result: {"name":"cat"} Hope this helps.`, false},
+		{"trailing commas", `{"name":"cat","tags":["a","b",],}`, false},
+		{"not json", "I cannot help with that.", true},
+		{"truncated", `{"name":"ca`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out struct {
+				Name string `json:"name"`
+			}
+			err := decodeJSONObject(tt.content, &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && out.Name != "cat" {
+				t.Fatalf("name = %q, want cat", out.Name)
+			}
+		})
+	}
+}
+
+func TestDecodeTagOutput(t *testing.T) {
+	content := `{"tags":[
+		{"name":" 猫。","category":"Animal","confidence":95},
+		{"name":"猫","category":"animal","confidence":0.5},
+		{"name":"Sunset","category":"lighting","confidence":1.7e3},
+		{"name":"  ","category":"scene","confidence":0.9},
+		{"name":"` + strings.Repeat("长", maxTagNameLength+1) + `","category":"scene","confidence":0.9},
+		{"name":"sky","category":"scene","confidence":-0.2}
+	],"description":" 一只猫在看日落 ","altText":""}`
+
+	output, err := decodeTagOutput(content, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AITag{
+		{Name: "猫", Category: "animal", Confidence: 0.95},
+		{Name: "Sunset", Category: "other", Confidence: 1},
+		{Name: "sky", Category: "scene", Confidence: 0},
+	}
+	if !reflect.DeepEqual(output.Tags, want) {
+		t.Fatalf("tags = %+v, want %+v", output.Tags, want)
+	}
+	if output.Description != "一只猫在看日落" || output.AltText != output.Description {
+		t.Fatalf("description = %q, altText = %q, want the description to fill in the missing alt text", output.Description, output.AltText)
+	}
+
+	longAlt := `{"tags":[{"name":"猫","category":"animal","confidence":0.9}],"description":"猫","altText":"` + strings.Repeat("猫", MaxAltTextLength+10) + `"}`
+	if output, err := decodeTagOutput(longAlt, true); err != nil || len([]rune(output.AltText)) > MaxAltTextLength {
+		t.Fatalf("got %v, %v, want alt text truncated to %d characters", output, err, MaxAltTextLength)
+	}
+
+	// 只要求标签时丢弃描述
+	if output, err := decodeTagOutput(content, false); err != nil || output.Description != "" || output.AltText != "" {
+		t.Fatalf("got %+v, %v, want no description in tag-only mode", output, err)
+	}
+
+	for name, content := range map[string]string{
+		"no valid tags":       `{"tags":[{"name":" ","category":"scene","confidence":0.9}],"description":"x"}`,
+		"missing description": `{"tags":[{"name":"猫","category":"animal","confidence":0.9}],"description":"  "}`,
+		"not json":            `tags: 猫`,
+	} {
+		if _, err := decodeTagOutput(content, true); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestValidateQueryCondition(t *testing.T) {
+	lat, lng := 31.2, 121.5
+	condition := &QueryCondition{
+		Tags:        []string{" 猫 ", ""},
+		Month:       " 2025-01 ",
+		Orientation: " Landscape ",
+		AnyOf:       []QueryGroup{{Tags: []string{" "}}, {Keywords: []string{" 海边 "}}},
+		Location:    &QueryLocation{Name: " 上海 ", Latitude: &lat, Longitude: &lng},
+	}
+	if err := validateQueryCondition(condition); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(condition.Tags, []string{"猫"}) || condition.Month != "2025-01" || condition.Orientation != OrientationLandscape {
+		t.Fatalf("condition not normalized: %+v", condition)
+	}
+	if len(condition.AnyOf) != 1 || !reflect.DeepEqual(condition.AnyOf[0].Keywords, []string{"海边"}) {
+		t.Fatalf("anyOf = %+v, want the empty group removed", condition.AnyOf)
+	}
+	if condition.Location.Name != "上海" || condition.Location.RadiusKm != defaultLocationRadiusKm {
+		t.Fatalf("location = %+v, want the default radius", condition.Location)
+	}
+
+	// 只有空白名称的地点被去掉
+	condition = &QueryCondition{Location: &QueryLocation{Name: "  "}}
+	if err := validateQueryCondition(condition); err != nil || condition.Location != nil {
+		t.Fatalf("got %+v, %v, want an empty location dropped", condition.Location, err)
+	}
+
+	bad := 200.0
+	invalid := map[string]QueryCondition{
+		"month":               {Month: "2025/01"},
+		"year":                {Year: "25"},
+		"date format":         {DateFrom: "2025-1-1"},
+		"date range":          {DateFrom: "2025-02-01", DateTo: "2025-01-01"},
+		"upload range":        {UploadedFrom: "2025-02-01", UploadedTo: "2025-01-01"},
+		"orientation":         {Orientation: "diagonal"},
+		"negative size":       {MinWidth: -1},
+		"width range":         {MinWidth: 2000, MaxWidth: 1000},
+		"height range":        {MinHeight: 2000, MaxHeight: 1000},
+		"latitude only":       {Location: &QueryLocation{Latitude: &lat}},
+		"coordinates":         {Location: &QueryLocation{Latitude: &lat, Longitude: &bad}},
+		"bounds out of range": {Location: &QueryLocation{Bounds: &QueryBounds{South: -100, North: 10}}},
+		"bounds inverted":     {Location: &QueryLocation{Bounds: &QueryBounds{South: 40, North: 30}}},
+	}
+	for name, condition := range invalid {
+		if err := validateQueryCondition(&condition); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestFilterConditionTags(t *testing.T) {
+	condition := &QueryCondition{
+		Tags:        []string{"猫", "独角兽"},
+		ExcludeTags: []string{"人物", "幽灵"},
+		AnyOf:       []QueryGroup{{Tags: []string{"龙"}}, {Tags: []string{"狗", "龙"}}},
+	}
+	filterConditionTags(condition, []string{"猫", "狗", "人物"})
+	if !reflect.DeepEqual(condition.Tags, []string{"猫"}) || !reflect.DeepEqual(condition.ExcludeTags, []string{"人物"}) {
+		t.Fatalf("tags = %v, excludeTags = %v", condition.Tags, condition.ExcludeTags)
+	}
+	if len(condition.AnyOf) != 1 || !reflect.DeepEqual(condition.AnyOf[0].Tags, []string{"狗"}) {
+		t.Fatalf("anyOf = %+v, want only the group with a known tag", condition.AnyOf)
+	}
+}
+
+func TestDecodeStructuredRepair(t *testing.T) {
+	provider := NewMockProvider()
+	var repairPrompt string
+	provider.ChatFunc = func(req ChatRequest) (string, error) {
+		repairPrompt = req.Prompt
+		return `{"tags":[{"name":"猫","category":"animal","confidence":0.9}]}`, nil
+	}
+	s := NewAIServiceWithProviders(provider, provider)
+
+	var output *tagOutput
+	decode := func(content string) error {
+		var err error
+		output, err = decodeTagOutput(content, false)
+		return err
+	}
+	if err := s.decodeStructured(context.Background(), `{"tags":[]}`, tagSchema(false), decode); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.Tags) != 1 || output.Tags[0].Name != "猫" {
+		t.Fatalf("tags = %+v, want the repaired output", output.Tags)
+	}
+	if !strings.Contains(repairPrompt, `{"tags":[]}`) || !strings.Contains(repairPrompt, `"minItems"`) {
+		t.Fatalf("repair prompt does not include the invalid output and schema:\n%s", repairPrompt)
+	}
+
+	if _, chat := provider.Calls(); chat != 1 {
+		t.Fatalf("chat calls = %d, want 1", chat)
+	}
+
+	// 已经有效的输出不调用修复
+	if err := s.decodeStructured(context.Background(), `{"tags":[{"name":"狗","category":"animal","confidence":1}]}`, tagSchema(false), decode); err != nil {
+		t.Fatal(err)
+	}
+	if _, chat := provider.Calls(); chat != 1 {
+		t.Fatalf("chat calls = %d, want valid output to skip the repair", chat)
+	}
+
+	// 修复后仍然无效或修复调用失败时返回错误
+	provider.ChatFunc = func(req ChatRequest) (string, error) { return "still not json", nil }
+	if err := s.decodeStructured(context.Background(), "nope", tagSchema(false), decode); err == nil {
+		t.Fatal("expected an error when the repaired output is still invalid")
+	}
+	provider.ChatFunc = func(req ChatRequest) (string, error) { return "", errors.New("provider down") }
+	if err := s.decodeStructured(context.Background(), "nope", tagSchema(false), decode); err == nil || !strings.Contains(err.Error(), "repair failed") {
+		t.Fatalf("err = %v, want the repair failure", err)
+	}
+}
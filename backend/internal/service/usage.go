@@ -0,0 +1,306 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AI 调用的操作类型
+const (
+	OperationAnalyze   = "analyze"   // 图片标签分析
+	OperationQuery     = "query"     // 自然语言查询解析
+	OperationAssistant = "assistant" // 对话助手意图解析
+	OperationPlan      = "plan"      // 批量操作计划
+	OperationRepair    = "repair"    // 修复不符合 schema 的结构化输出
+	OperationOCR       = "ocr"       // 视觉模型识别图片中的文字
+	OperationEmbed     = "embed"     // 计算语义向量
+	OperationModerate  = "moderate"  // 视觉模型内容审核
+)
+
+// AI 调用的结果
+const (
+	OutcomeSuccess        = "success"
+	OutcomeError          = "error"
+	OutcomeCached         = "cached"          // 命中缓存，没有调用模型
+	OutcomeBudgetExceeded = "budget_exceeded" // 超出预算，没有调用模型
+)
+
+// ErrBudgetExceeded 用户的 AI 用量超出预算
+var ErrBudgetExceeded = errors.New("AI usage budget exceeded")
+
+// UsageRecord 一次 AI 调用的用量记录
+type UsageRecord struct {
+	UserID           uint
+	Operation        string
+	Provider         string
+	Model            string
+	PromptVersion    string
+	PromptTokens     int
+	CompletionTokens int
+	Estimated        bool // 提供方没有返回 token 数，按文本长度估算
+	Latency          time.Duration
+	Outcome          string
+	Error            string
+}
+
+// UsageTracker 记录 AI 调用的用量并检查预算（由 handler 基于数据库实现）
+type UsageTracker interface {
+	// CheckBudget 调用模型前检查用户预算，超出时返回包装了 ErrBudgetExceeded 的错误
+	CheckBudget(ctx context.Context, userID uint) error
+	// Record 保存一次调用的用量
+	Record(ctx context.Context, record UsageRecord)
+}
+
+// SetUsageTracker 设置用量记录器，为 nil 时不记录用量也不检查预算
+func (s *AIService) SetUsageTracker(tracker UsageTracker) {
+	s.usage = tracker
+}
+
+// SetResponseCache 设置响应缓存，为 nil 时不缓存
+func (s *AIService) SetResponseCache(cache ResponseCache) {
+	s.cache = cache
+}
+
+type userIDKey struct{}
+
+// WithUserID 在 ctx 中记录发起 AI 调用的用户，用于用量统计和预算检查
+func WithUserID(ctx context.Context, userID uint) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+// UserIDFromContext 返回 ctx 中记录的用户，没有时返回 0
+func UserIDFromContext(ctx context.Context) uint {
+	userID, _ := ctx.Value(userIDKey{}).(uint)
+	return userID
+}
+
+// TokenUsage 一次模型调用的 token 数，以及实际响应的提供方和模型（发生降级时与默认模型不同）
+type TokenUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+	Provider         string
+	Model            string
+}
+
+type tokenUsageKey struct{}
+
+// withTokenUsage 返回可以收集 token 数的 ctx，提供方通过 reportTokenUsage 写入
+func withTokenUsage(ctx context.Context) (context.Context, *TokenUsage) {
+	usage := &TokenUsage{}
+	return context.WithValue(ctx, tokenUsageKey{}, usage), usage
+}
+
+// reportTokenUsage 提供方在解析到响应中的 token 数后调用
+func reportTokenUsage(ctx context.Context, promptTokens, completionTokens int) {
+	if usage, ok := ctx.Value(tokenUsageKey{}).(*TokenUsage); ok {
+		usage.PromptTokens += promptTokens
+		usage.CompletionTokens += completionTokens
+	}
+}
+
+// reportServedBy ResilientClient 在调用成功后写入实际响应的提供方和模型
+func reportServedBy(ctx context.Context, provider, model string) {
+	if usage, ok := ctx.Value(tokenUsageKey{}).(*TokenUsage); ok {
+		usage.Provider, usage.Model = provider, model
+	}
+}
+
+// estimateTokens 按文本长度粗略估算 token 数（约 4 个字节一个 token，中文约每字一个 token）
+func estimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < 128 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return ascii/4 + other + 1
+}
+
+// modelCall 一次需要统计用量的模型调用
+type modelCall struct {
+	Operation     string
+	Provider      string
+	Model         string
+	PromptVersion string
+	Prompt        string // 用于估算 token 数和计算缓存键
+	CacheKey      string // 为空时不使用缓存
+	// Validate 校验模型输出，不通过的输出不写入缓存（可选）
+	Validate func(content string) error
+}
+
+// usageMeter 模型调用的用量记录、预算检查和响应缓存，AIService 和向量计算共用
+type usageMeter struct {
+	usage UsageTracker  // 用量记录和预算检查，为 nil 时不记录
+	cache ResponseCache // 模型响应缓存，为 nil 时不缓存
+}
+
+// callModel 执行模型调用：先查缓存，再检查预算，调用后记录用量并写入缓存
+func (s *usageMeter) callModel(ctx context.Context, call modelCall, fn func(ctx context.Context) (string, error)) (string, error) {
+	userID := UserIDFromContext(ctx)
+	record := UsageRecord{
+		UserID:        userID,
+		Operation:     call.Operation,
+		Provider:      call.Provider,
+		Model:         call.Model,
+		PromptVersion: call.PromptVersion,
+	}
+
+	if s.cache != nil && call.CacheKey != "" {
+		if content, ok := s.cache.Get(call.CacheKey); ok {
+			log.Printf("AI %s response served from cache", call.Operation)
+			record.Outcome = OutcomeCached
+			s.recordUsage(ctx, record)
+			return content, nil
+		}
+	}
+
+	if s.usage != nil && userID != 0 {
+		if err := s.usage.CheckBudget(ctx, userID); err != nil {
+			record.Outcome = OutcomeBudgetExceeded
+			record.Error = err.Error()
+			s.recordUsage(ctx, record)
+			return "", err
+		}
+	}
+
+	callCtx, tokens := withTokenUsage(ctx)
+	start := time.Now()
+	content, err := fn(callCtx)
+	record.Latency = time.Since(start)
+	record.PromptTokens, record.CompletionTokens = tokens.PromptTokens, tokens.CompletionTokens
+	fallback := tokens.Model != "" && (tokens.Provider != call.Provider || tokens.Model != call.Model)
+	if fallback {
+		record.Provider, record.Model = tokens.Provider, tokens.Model
+	}
+
+	if err != nil {
+		record.Outcome = OutcomeError
+		record.Error = err.Error()
+	} else {
+		record.Outcome = OutcomeSuccess
+		if record.PromptTokens == 0 && record.CompletionTokens == 0 {
+			record.PromptTokens, record.CompletionTokens = estimateTokens(call.Prompt), estimateTokens(content)
+			record.Estimated = true
+		}
+		// 降级模型的结果不写入缓存，避免主模型恢复后仍然返回降级结果
+		if s.cache != nil && call.CacheKey != "" && !fallback && (call.Validate == nil || call.Validate(content) == nil) {
+			s.cache.Set(call.CacheKey, content)
+		}
+	}
+	s.recordUsage(ctx, record)
+	return content, err
+}
+
+// recordUsage 保存用量记录（没有设置记录器或没有用户时跳过）
+func (s *usageMeter) recordUsage(ctx context.Context, record UsageRecord) {
+	if s.usage == nil || record.UserID == 0 {
+		return
+	}
+	s.usage.Record(ctx, record)
+}
+
+// cacheKey 由操作、模型、提示词版本和内容（渲染后的提示词、图片数据等）的哈希组成
+func cacheKey(operation, model, promptVersion string, contents ...[]byte) string {
+	hash := sha256.New()
+	for _, content := range contents {
+		hash.Write(content)
+		hash.Write([]byte{0})
+	}
+	return strings.Join([]string{operation, model, promptVersion, hex.EncodeToString(hash.Sum(nil))}, "|")
+}
+
+// ResponseCache 模型响应缓存
+type ResponseCache interface {
+	Get(key string) (string, bool)
+	Set(key, value string)
+}
+
+// MemoryCache 进程内的 LRU 响应缓存，条目在 ttl 后过期
+type MemoryCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // 最近使用的在前
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key       string
+	value     string
+	expiresAt time.Time
+}
+
+// NewMemoryCache 创建最多保存 size 条、每条保存 ttl 时间的缓存
+func NewMemoryCache(size int, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// NewResponseCacheFromEnv 根据 AI_CACHE_SIZE（默认 1000 条，0 表示关闭）和 AI_CACHE_TTL（默认 24h）创建缓存
+func NewResponseCacheFromEnv() ResponseCache {
+	size := envInt("AI_CACHE_SIZE", 1000)
+	if size == 0 {
+		return nil
+	}
+	ttl := 24 * time.Hour
+	if value := os.Getenv("AI_CACHE_TTL"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil && parsed > 0 {
+			ttl = parsed
+		} else {
+			log.Printf("Invalid AI_CACHE_TTL value %q, using %v", value, ttl)
+		}
+	}
+	return NewMemoryCache(size, ttl)
+}
+
+// Get 返回未过期的缓存值
+func (c *MemoryCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// Set 写入缓存，超出容量时淘汰最久未使用的条目
+func (c *MemoryCache) Set(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt := time.Now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubTracker 内存中的用量记录器，blocked 中的用户超出预算
+type stubTracker struct {
+	mu      sync.Mutex
+	blocked map[uint]bool
+	records []UsageRecord
+}
+
+func (t *stubTracker) CheckBudget(ctx context.Context, userID uint) error {
+	if t.blocked[userID] {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+func (t *stubTracker) Record(ctx context.Context, record UsageRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.records = append(t.records, record)
+}
+
+// writeTestJPEG 在临时目录中写入一张 JPEG 并返回路径
+func writeTestJPEG(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 32, 32)), nil); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "test.jpg")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractTextRecordsUsage(t *testing.T) {
+	provider := NewMockProvider()
+	provider.VisionFunc = func(req VisionRequest) (string, error) { return "营业时间 9:00-18:00", nil }
+	tracker := &stubTracker{blocked: map[uint]bool{2: true}}
+	s := NewAIServiceWithProviders(provider, provider)
+	s.SetUsageTracker(tracker)
+	s.SetResponseCache(NewMemoryCache(10, time.Hour))
+	path := writeTestJPEG(t)
+
+	text, err := s.ExtractText(WithUserID(context.Background(), 1), path)
+	if err != nil || text != "营业时间 9:00-18:00" {
+		t.Fatalf("ExtractText() = %q, %v", text, err)
+	}
+	if len(tracker.records) != 1 || tracker.records[0].Operation != OperationOCR || tracker.records[0].UserID != 1 || tracker.records[0].Outcome != OutcomeSuccess {
+		t.Fatalf("records = %+v, want one successful OCR call for user 1", tracker.records)
+	}
+
+	// 相同的图片命中缓存
+	if text, err := s.ExtractText(WithUserID(context.Background(), 1), path); err != nil || text != "营业时间 9:00-18:00" {
+		t.Fatalf("cached ExtractText() = %q, %v", text, err)
+	}
+	if len(tracker.records) != 2 || tracker.records[1].Outcome != OutcomeCached {
+		t.Fatalf("records = %+v, want the second call served from cache", tracker.records)
+	}
+
+	// 超出预算的用户不调用视觉模型
+	s.SetResponseCache(nil)
+	if _, err := s.ExtractText(WithUserID(context.Background(), 2), path); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("err = %v, want ErrBudgetExceeded", err)
+	}
+	if vision, _ := provider.Calls(); vision != 1 {
+		t.Fatalf("vision calls = %d, want 1", vision)
+	}
+}
+
+func TestEmbedTextsRecordsUsage(t *testing.T) {
+	tracker := &stubTracker{blocked: map[uint]bool{2: true}}
+	provider := NewMockEmbeddingProvider()
+
+	vectors, err := EmbedTexts(WithUserID(context.Background(), 1), provider, tracker, []string{"海边的日落"})
+	if err != nil || len(vectors) != 1 || len(vectors[0]) == 0 {
+		t.Fatalf("EmbedTexts() = %v, %v", vectors, err)
+	}
+	if len(tracker.records) != 1 || tracker.records[0].Operation != OperationEmbed || tracker.records[0].Model != provider.Model() || tracker.records[0].PromptTokens == 0 {
+		t.Fatalf("records = %+v, want one embed call with estimated tokens", tracker.records)
+	}
+
+	if _, err := EmbedTexts(WithUserID(context.Background(), 2), provider, tracker, []string{"海边的日落"}); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("err = %v, want ErrBudgetExceeded", err)
+	}
+
+	// 没有用量记录器时直接调用
+	if _, err := EmbedTexts(context.Background(), provider, nil, []string{"海边的日落"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModerateImageRecordsUsage(t *testing.T) {
+	provider := NewMockProvider()
+	tracker := &stubTracker{blocked: map[uint]bool{2: true}}
+	s := NewAIServiceWithProviders(provider, provider)
+	s.SetUsageTracker(tracker)
+	s.SetModerator(NewVisionModerator(provider))
+	path := writeTestJPEG(t)
+
+	result, err := s.ModerateImage(WithUserID(context.Background(), 1), path)
+	if err != nil || len(result.Labels) != len(ModerationCategories) || len(result.Flagged) != 0 {
+		t.Fatalf("ModerateImage() = %+v, %v", result, err)
+	}
+	if len(tracker.records) != 1 || tracker.records[0].Operation != OperationModerate || tracker.records[0].UserID != 1 || tracker.records[0].Outcome != OutcomeSuccess {
+		t.Fatalf("records = %+v, want one successful moderation call for user 1", tracker.records)
+	}
+
+	// 超出预算的用户不调用视觉模型
+	if _, err := s.ModerateImage(WithUserID(context.Background(), 2), path); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("err = %v, want ErrBudgetExceeded", err)
+	}
+	if vision, _ := provider.Calls(); vision != 1 {
+		t.Fatalf("vision calls = %d, want 1", vision)
+	}
+}
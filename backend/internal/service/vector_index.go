@@ -0,0 +1,160 @@
+package service
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// VectorMatch 向量检索结果
+type VectorMatch struct {
+	ImageID uint    `json:"imageID"`
+	Score   float32 `json:"score"` // 余弦相似度，范围 [-1, 1]
+}
+
+// VectorIndex 按用户隔离的图片向量索引
+// 当前实现是内存中的暴力余弦检索（BruteForceIndex），需要时可以替换为 ANN 索引或向量数据库
+type VectorIndex interface {
+	// Upsert 写入或替换图片的向量
+	Upsert(userID, imageID uint, vector []float32)
+	// Delete 删除图片的向量
+	Delete(userID, imageID uint)
+	// Search 返回与 query 最相似的 k 个图片；allow 不为 nil 时只返回 allow 返回 true 的图片
+	Search(userID uint, query []float32, k int, allow func(imageID uint) bool) ([]VectorMatch, error)
+}
+
+// VectorLoader 从持久化存储加载用户的全部图片向量
+type VectorLoader func(userID uint) (map[uint][]float32, error)
+
+// BruteForceIndex 内存中的暴力余弦检索索引
+// 每个用户的向量在第一次检索时从 loader 加载，之后由 Upsert / Delete 增量维护
+// 多实例部署时其他实例的写入不会同步到本实例的缓存，可以调用 Invalidate 强制重新加载
+type BruteForceIndex struct {
+	loader VectorLoader
+
+	mu    sync.RWMutex
+	users map[uint]map[uint][]float32 // userID -> imageID -> 归一化后的向量
+}
+
+// NewBruteForceIndex 创建暴力检索索引
+func NewBruteForceIndex(loader VectorLoader) *BruteForceIndex {
+	return &BruteForceIndex{
+		loader: loader,
+		users:  make(map[uint]map[uint][]float32),
+	}
+}
+
+// Upsert 写入或替换图片的向量（用户的向量尚未加载时跳过，下次检索时会从存储加载）
+func (idx *BruteForceIndex) Upsert(userID, imageID uint, vector []float32) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if vectors, ok := idx.users[userID]; ok {
+		vectors[imageID] = NormalizeVector(vector)
+	}
+}
+
+// Delete 删除图片的向量
+func (idx *BruteForceIndex) Delete(userID, imageID uint) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if vectors, ok := idx.users[userID]; ok {
+		delete(vectors, imageID)
+	}
+}
+
+// Invalidate 丢弃用户的缓存向量，下次检索时重新加载
+func (idx *BruteForceIndex) Invalidate(userID uint) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.users, userID)
+}
+
+// Search 计算查询向量与用户所有图片向量的余弦相似度，返回得分最高的 k 个
+func (idx *BruteForceIndex) Search(userID uint, query []float32, k int, allow func(imageID uint) bool) ([]VectorMatch, error) {
+	vectors, err := idx.userVectors(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = NormalizeVector(query)
+	matches := make([]VectorMatch, 0, len(vectors))
+	idx.mu.RLock()
+	for imageID, vector := range vectors {
+		if len(vector) != len(query) {
+			continue // 向量模型更换后维度不一致，等待重新索引
+		}
+		if allow != nil && !allow(imageID) {
+			continue
+		}
+		matches = append(matches, VectorMatch{ImageID: imageID, Score: dot(query, vector)})
+	}
+	idx.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score == matches[j].Score {
+			return matches[i].ImageID > matches[j].ImageID
+		}
+		return matches[i].Score > matches[j].Score
+	})
+	if k > 0 && len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches, nil
+}
+
+// userVectors 返回用户的向量，未加载时从 loader 加载
+func (idx *BruteForceIndex) userVectors(userID uint) (map[uint][]float32, error) {
+	idx.mu.RLock()
+	vectors, ok := idx.users[userID]
+	idx.mu.RUnlock()
+	if ok {
+		return vectors, nil
+	}
+
+	loaded, err := idx.loader(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vectors: %w", err)
+	}
+	for imageID, vector := range loaded {
+		loaded[imageID] = NormalizeVector(vector)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if existing, ok := idx.users[userID]; ok {
+		return existing, nil // 并发加载时保留先完成的一份
+	}
+	idx.users[userID] = loaded
+	return loaded, nil
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// EncodeVector 将向量编码为小端序 float32 字节，用于存储到数据库
+func EncodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	return data
+}
+
+// DecodeVector 解码 EncodeVector 编码的向量
+func DecodeVector(data []byte) ([]float32, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("invalid vector length %d", len(data))
+	}
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector, nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestBruteForceIndexSearch(t *testing.T) {
+	loads := 0
+	idx := NewBruteForceIndex(func(userID uint) (map[uint][]float32, error) {
+		loads++
+		if userID != 1 {
+			return map[uint][]float32{}, nil
+		}
+		return map[uint][]float32{
+			1: {1, 0},
+			2: {3, 3}, // 未归一化的向量在加载时归一化
+			3: {0, 1},
+			4: {1, 0, 0}, // 维度不一致的旧向量被跳过
+		}, nil
+	})
+
+	matches, err := idx.Search(1, []float32{2, 0}, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 2 || matches[0].ImageID != 1 || matches[1].ImageID != 2 {
+		t.Fatalf("got %+v, want images 1 and 2", matches)
+	}
+	if math.Abs(float64(matches[0].Score)-1) > 1e-6 || math.Abs(float64(matches[1].Score)-math.Sqrt2/2) > 1e-6 {
+		t.Errorf("scores %v, %v; want 1 and √2/2", matches[0].Score, matches[1].Score)
+	}
+
+	// allow 过滤候选图片；k <= 0 时返回所有结果
+	matches, _ = idx.Search(1, []float32{1, 0}, 0, func(imageID uint) bool { return imageID != 1 })
+	if len(matches) != 2 || matches[0].ImageID != 2 || matches[1].ImageID != 3 {
+		t.Errorf("filtered search got %+v, want images 2 and 3", matches)
+	}
+
+	// 其他用户的向量互不可见
+	if matches, _ := idx.Search(2, []float32{1, 0}, 10, nil); len(matches) != 0 {
+		t.Errorf("user 2 got %+v, want nothing", matches)
+	}
+	if loads != 2 {
+		t.Errorf("loader called %d times, want once per user", loads)
+	}
+}
+
+func TestBruteForceIndexUpsertAndDelete(t *testing.T) {
+	idx := NewBruteForceIndex(func(userID uint) (map[uint][]float32, error) {
+		return map[uint][]float32{1: {1, 0}}, nil
+	})
+
+	// 用户的向量尚未加载时 Upsert 被忽略，加载时从存储读取
+	idx.Upsert(1, 9, []float32{0, 1})
+	matches, _ := idx.Search(1, []float32{0, 1}, 10, nil)
+	if len(matches) != 1 || matches[0].ImageID != 1 {
+		t.Fatalf("got %+v, want only the loaded image", matches)
+	}
+
+	idx.Upsert(1, 2, []float32{0, 5})
+	idx.Delete(1, 1)
+	matches, _ = idx.Search(1, []float32{0, 1}, 10, nil)
+	if len(matches) != 1 || matches[0].ImageID != 2 || math.Abs(float64(matches[0].Score)-1) > 1e-6 {
+		t.Fatalf("got %+v, want image 2 with score 1", matches)
+	}
+
+	// Invalidate 之后重新从存储加载
+	idx.Invalidate(1)
+	matches, _ = idx.Search(1, []float32{1, 0}, 10, nil)
+	if len(matches) != 1 || matches[0].ImageID != 1 {
+		t.Errorf("after invalidate got %+v, want the stored image", matches)
+	}
+}
+
+func TestBruteForceIndexLoaderError(t *testing.T) {
+	idx := NewBruteForceIndex(func(userID uint) (map[uint][]float32, error) {
+		return nil, errors.New("db down")
+	})
+	if _, err := idx.Search(1, []float32{1}, 1, nil); err == nil {
+		t.Error("expected the loader error")
+	}
+}
+
+func TestEncodeDecodeVector(t *testing.T) {
+	vector := []float32{0, -1.5, 3.25, float32(math.Inf(1))}
+	got, err := DecodeVector(EncodeVector(vector))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(vector) {
+		t.Fatalf("got %v, want %v", got, vector)
+	}
+	for i := range vector {
+		if got[i] != vector[i] {
+			t.Errorf("got %v, want %v", got, vector)
+		}
+	}
+	if _, err := DecodeVector([]byte{1, 2, 3}); err == nil {
+		t.Error("expected an error for a truncated vector")
+	}
+}
@@ -16,7 +16,8 @@ func getJwtKey() []byte {
     if key == "" {
         // 开发环境默认密钥，生产环境必须设置JWT_SECRET环境变量
         key = "dev_default_secret_key_change_in_production"
-        fmt.Println("⚠️  Warning: Using default JWT secret. Please set JWT_SECRET environment variable in production!")
+        // 输出到 stderr，避免干扰 stdio 模式的 MCP 服务（stdout 只能用于协议消息）
+        fmt.Fprintln(os.Stderr, "⚠️  Warning: Using default JWT secret. Please set JWT_SECRET environment variable in production!")
     }
     
     // 确保密钥长度足够安全（至少32字节）
@@ -55,4 +56,25 @@ func GenerateJWT(userID uint) (string, error) {
     }
 
     return tokenString, nil
-}
\ No newline at end of file
+}
+
+// ParseJWT 校验 JWT 的签名和有效期，返回其中的声明
+func ParseJWT(tokenString string) (*Claims, error) {
+    claims := &Claims{}
+
+    token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+        // 确保签名算法是我们期望的
+        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+            return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+        }
+        return JwtKey, nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    if !token.Valid {
+        return nil, fmt.Errorf("invalid token")
+    }
+
+    return claims, nil
+}